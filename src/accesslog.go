@@ -0,0 +1,232 @@
+package git_pages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+var (
+	accessLogMutex  sync.Mutex
+	accessLogWriter io.Writer = os.Stdout
+	accessLogFile   *os.File
+)
+
+// Opens the access log file configured in `accessLogConfig`, replacing the previously opened
+// one (if any).
+func openAccessLog(accessLogConfig *AccessLogConfig) (err error) {
+	switch accessLogConfig.Format {
+	case "", "common", "combined", "json":
+	default:
+		return fmt.Errorf("unknown [access-log].format value %q", accessLogConfig.Format)
+	}
+
+	var writer io.Writer = os.Stdout
+	var file *os.File
+	if accessLogConfig.Format != "" && accessLogConfig.File != "" {
+		file, err = os.OpenFile(accessLogConfig.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("access log: %w", err)
+		}
+		writer = file
+	}
+
+	accessLogMutex.Lock()
+	defer accessLogMutex.Unlock()
+	if accessLogFile != nil {
+		accessLogFile.Close()
+	}
+	accessLogWriter, accessLogFile = writer, file
+	return nil
+}
+
+type accessInfoKey struct{}
+
+// Information about a request that is only known to the handler serving it.
+type accessInfo struct {
+	site string
+}
+
+// Records the site (as a web root, e.g. `example.org/.index`) that served the request.
+func setAccessSite(ctx context.Context, webRoot string) {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		info.site = webRoot
+	}
+}
+
+type accessResponseWriter struct {
+	inner  http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessResponseWriter) Unwrap() http.ResponseWriter {
+	return w.inner
+}
+
+func (w *accessResponseWriter) Header() http.Header {
+	return w.inner.Header()
+}
+
+func (w *accessResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	count, err := w.inner.Write(data)
+	w.bytes += int64(count)
+	return count, err
+}
+
+func (w *accessResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.inner.WriteHeader(statusCode)
+}
+
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Host       string    `json:"host"`
+	Site       string    `json:"site,omitempty"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Duration   float64   `json:"duration"` // in seconds
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Cache      string    `json:"cache"`
+}
+
+// Truncates a client address so that it identifies a network rather than a host.
+func anonymizeRemoteAddr(remoteAddr string) string {
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		return "-"
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.Addr().String()
+}
+
+// Describes how the response relates to cached content: "stale" if it was served from
+// the cache because the backend is unavailable, "revalidated" if the client's cached copy
+// is still current, "bypass" if the client requested the cache to be bypassed, and "fresh"
+// otherwise.
+func accessCacheOutcome(w *accessResponseWriter, r *http.Request) string {
+	switch {
+	case w.Header().Get("Warning") != "":
+		return "stale"
+	case w.status == http.StatusNotModified:
+		return "revalidated"
+	}
+	cacheControl, err := cacheobject.ParseRequestCacheControl(r.Header.Get("Cache-Control"))
+	if err == nil && (cacheControl.NoCache || cacheControl.MaxAge == 0) {
+		return "bypass"
+	}
+	return "fresh"
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func formatAccessLogEntry(format string, entry *accessLogEntry) []byte {
+	if format == "json" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			panic(err)
+		}
+		return append(data, '\n')
+	}
+
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = strconv.FormatInt(entry.Bytes, 10)
+	}
+	line := fmt.Sprintf("%s - - [%s] %s %d %s",
+		entry.RemoteAddr,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(fmt.Sprintf("%s %s %s", entry.Method, entry.URI, entry.Protocol)),
+		entry.Status,
+		bytes,
+	)
+	if format == "combined" {
+		line += fmt.Sprintf(" %s %s",
+			strconv.Quote(orDash(entry.Referer)), strconv.Quote(orDash(entry.UserAgent)))
+	}
+	// The site and the cache outcome follow the standard fields, where most log processors
+	// can be configured to parse them.
+	line += fmt.Sprintf(" %s %s\n", strconv.Quote(orDash(entry.Site)), entry.Cache)
+	return []byte(line)
+}
+
+func writeAccessLogEntry(ctx context.Context, format string, entry *accessLogEntry) {
+	line := formatAccessLogEntry(format, entry)
+
+	accessLogMutex.Lock()
+	defer accessLogMutex.Unlock()
+	if _, err := accessLogWriter.Write(line); err != nil {
+		logc.Printf(ctx, "access log err: %s\n", err)
+	}
+}
+
+// Writes an access log entry and collects traffic statistics for every request. Must be used
+// after `remoteAddrMiddleware` so that the client address is known.
+func AccessLogHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := currentConfig(r.Context())
+		format, collectStats := config.AccessLog.Format, config.Stats.Collect
+		if format == "" && !collectStats {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		info := &accessInfo{}
+		aw := &accessResponseWriter{inner: w}
+		start := time.Now()
+		handler.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info)))
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+
+		if collectStats && info.site != "" && (r.Method == "GET" || r.Method == "HEAD") {
+			recordSiteStats(info.site, r.URL.Path, aw.status, aw.bytes, start)
+		}
+		if format != "" {
+			writeAccessLogEntry(r.Context(), format, &accessLogEntry{
+				Time:       start,
+				RemoteAddr: anonymizeRemoteAddr(r.RemoteAddr),
+				Host:       r.Host,
+				Site:       info.site,
+				Method:     r.Method,
+				URI:        r.RequestURI,
+				Protocol:   r.Proto,
+				Status:     aw.status,
+				Bytes:      aw.bytes,
+				Duration:   time.Since(start).Seconds(),
+				Referer:    r.Referer(),
+				UserAgent:  r.UserAgent(),
+				Cache:      accessCacheOutcome(aw, r),
+			})
+		}
+	})
+}
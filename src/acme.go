@@ -0,0 +1,94 @@
+package git_pages
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Stores ACME account keys, certificates, and pending challenge responses in the backend,
+// which makes them shared between every node using the same storage.
+type backendCertCache struct{}
+
+var _ autocert.Cache = backendCertCache{}
+
+func (backendCertCache) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := backend.GetTLSData(ctx, name)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, autocert.ErrCacheMiss
+	} else if errors.Is(err, ErrDecryptionFailed) {
+		// TLS data that was not re-encrypted before the key it was encrypted with was removed
+		// is obtained from the ACME server again.
+		logc.Printf(ctx, "acme err: %s: %s\n", name, err)
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (backendCertCache) Put(ctx context.Context, name string, data []byte) error {
+	return backend.PutTLSData(ctx, name, data)
+}
+
+func (backendCertCache) Delete(ctx context.Context, name string) error {
+	return backend.DeleteTLSData(ctx, name)
+}
+
+// Only request certificates for domains that would be approved by the Caddy on-demand TLS
+// endpoint, since the ACME server rate limits failed requests.
+func acmeHostPolicy(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return fmt.Errorf("acme: %s: bare IP", host)
+	}
+
+	found, err := checkCertificateDomain(ctx, host)
+	if err != nil {
+		logc.Printf(ctx, "acme err: %s: %s\n", host, err)
+		return fmt.Errorf("acme: %s: %w", host, err)
+	} else if !found {
+		logc.Printf(ctx, "acme: %s: not served\n", host)
+		return fmt.Errorf("acme: %s: not served", host)
+	}
+	logc.Printf(ctx, "acme: %s: allowed\n", host)
+	return nil
+}
+
+// Creates a manager that obtains TLS certificates on demand from the ACME server in `[acme]`,
+// answering HTTP-01 challenges via `HTTPHandler()` and TLS-ALPN-01 challenges via the TLS
+// configuration returned by `TLSConfig()`.
+func CreateACMEManager(ctx context.Context) (*autocert.Manager, error) {
+	config := currentConfig(ctx)
+	client := &acme.Client{DirectoryURL: config.ACME.DirectoryURL}
+	if config.ACME.CACert != "" {
+		caData, err := os.ReadFile(config.ACME.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("acme: %w", err)
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("acme: %s: no certificates found", config.ACME.CACert)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	logc.Printf(ctx, "acme: using %s\n", config.ACME.DirectoryURL)
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      backendCertCache{},
+		HostPolicy: acmeHostPolicy,
+		Email:      config.ACME.Email,
+		Client:     client,
+	}, nil
+}
@@ -0,0 +1,306 @@
+package git_pages
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Restores the site (or sites) in an audit record to the manifest snapshot it contains.
+func RollbackToAuditRecord(ctx context.Context, id AuditID) error {
+	record, err := backend.QueryAuditLog(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if record.GetEvent() == AuditEvent_CommitManifests {
+		if len(record.GetManifests()) == 0 || record.GetDomain() == "" {
+			return fmt.Errorf("no manifests in audit record")
+		}
+
+		commits := []ManifestCommit{}
+		for _, project := range record.GetProjects() {
+			manifest := record.GetManifests()[project]
+			if err = backend.StageManifest(ctx, manifest); err != nil {
+				return err
+			}
+			commits = append(commits, ManifestCommit{
+				Name:     path.Join(record.GetDomain(), project),
+				Manifest: manifest,
+			})
+		}
+		return backend.CommitManifests(ctx, commits)
+	}
+
+	if record.GetManifest() == nil || record.GetDomain() == "" || record.GetProject() == "" {
+		return fmt.Errorf("no manifest in audit record")
+	}
+
+	webRoot := path.Join(record.GetDomain(), record.GetProject())
+	if err = backend.StageManifest(ctx, record.GetManifest()); err != nil {
+		return err
+	}
+	return backend.CommitManifest(ctx, webRoot, record.GetManifest(), ModifyManifestOptions{})
+}
+
+type ExpireSitesResult struct {
+	Expired   []string `json:"expired"`
+	Transient int      `json:"transient"`
+	DryRun    bool     `json:"dryRun"`
+}
+
+// Deletes every site whose manifest has an expiration time in the past.
+func ExpireSites(ctx context.Context, dryRun bool) (*ExpireSitesResult, error) {
+	config := currentConfig(ctx)
+	if !config.Feature("expiration") {
+		return nil, fmt.Errorf("expire: feature disabled")
+	}
+
+	result := &ExpireSitesResult{Expired: []string{}, DryRun: dryRun}
+	expire := func(item tuple[*ManifestMetadata, *Manifest]) error {
+		metadata, manifest := item.Splat()
+		if manifest.ExpiresAt != nil {
+			result.Transient += 1
+			if manifest.ExpiresAt.AsTime().Before(time.Now()) {
+				if !dryRun {
+					// Staged versions are discarded rather than expired, so that the audit log
+					// does not record them as expired sites.
+					var err error
+					domain, project, _ := strings.Cut(metadata.Name, "/")
+					if isStagedProjectName(project) {
+						err = Discard(ctx, path.Join(domain, strings.TrimPrefix(project, ".staged.")))
+					} else {
+						err = backend.ExpireManifest(ctx, metadata.Name)
+					}
+					if err != nil {
+						return err
+					}
+				}
+				logc.Printf(ctx, "expire: site %s expired at %s",
+					metadata.Name, manifest.ExpiresAt.AsTime())
+				result.Expired = append(result.Expired, metadata.Name)
+			}
+		}
+		return nil
+	}
+	// Staged versions of transient sites expire on their own, since they would otherwise
+	// keep their blobs alive indefinitely if they are never promoted.
+	for _, manifests := range []iter.Seq2[tuple[*ManifestMetadata, *Manifest], error]{
+		backend.GetAllManifests(ctx),
+		GetAllStagedManifests(ctx),
+	} {
+		for item, err := range manifests {
+			if err == nil {
+				err = expire(item)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if dryRun {
+		logc.Printf(ctx, "expire: would expire %d out of %d transient sites (dry run)\n",
+			len(result.Expired), result.Transient)
+	} else {
+		logc.Printf(ctx, "expire: expired %d out of %d transient sites\n",
+			len(result.Expired), result.Transient)
+	}
+	return result, nil
+}
+
+// Returns the TLS configuration of the admin API, or nil if it is served over plain HTTP.
+func AdminTLSConfig() (*tls.Config, error) {
+	config := loadConfig()
+	if config.Admin.TLSCert == "" && config.Admin.TLSKey == "" {
+		if config.Admin.ClientCA != "" {
+			return nil, fmt.Errorf("admin: client-ca requires tls-cert and tls-key")
+		}
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(config.Admin.TLSCert, config.Admin.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if config.Admin.ClientCA != "" {
+		caData, err := os.ReadFile(config.Admin.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("admin: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("admin: %s: no certificates found", config.Admin.ClientCA)
+		}
+		// Token authentication remains available to clients without a certificate.
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// Returns the name of the credential the request is authenticated with, if any.
+func adminCredential(r *http.Request) (string, bool) {
+	config := currentConfig(r.Context())
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.String(), true
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", false
+	}
+	for _, credential := range config.Admin.Tokens {
+		name, expected, found := strings.Cut(credential, ":")
+		if found && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeAdminError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	logc.Printf(r.Context(), "admin err: %s %s: %s", r.Method, r.URL.Path, err)
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+var errAdminMalformedRequest = errors.New("malformed request")
+
+func adminErrorStatus(err error) int {
+	if errors.Is(err, errAdminMalformedRequest) {
+		return http.StatusBadRequest
+	} else if errors.Is(err, ErrObjectNotFound) {
+		return http.StatusNotFound
+	} else {
+		return updateErrorStatus(err)
+	}
+}
+
+// Wraps an admin API action, recording an audit event for actions that do not produce one
+// by modifying the store.
+func adminAction(
+	action string, handler func(r *http.Request) (any, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if action != "" {
+			if err := AuditAdminAction(r.Context(), action); err != nil {
+				writeAdminError(w, r, http.StatusServiceUnavailable, err)
+				return
+			}
+		}
+		result, err := handler(r)
+		if err != nil {
+			writeAdminError(w, r, adminErrorStatus(err), err)
+		} else {
+			writeAdminJSON(w, http.StatusOK, result)
+		}
+	}
+}
+
+func adminWebRoot(r *http.Request) (string, error) {
+	domain, project := strings.ToLower(r.PathValue("domain")), r.PathValue("project")
+	if project == "" {
+		project = ".index"
+	} else if err := ValidateProjectName(project); err != nil {
+		return "", fmt.Errorf("%w: %w", errAdminMalformedRequest, err)
+	}
+	return path.Join(domain, project), nil
+}
+
+// Serves the admin API. Every request must be authenticated with either a bearer token
+// from `[admin].tokens` or a client certificate issued by `[admin].client-ca`; the name
+// of the credential is recorded as the principal of the resulting audit records.
+func AdminHandler() http.Handler {
+	router := http.NewServeMux()
+
+	setDomainFrozen := func(freeze bool) func(r *http.Request) (any, error) {
+		return func(r *http.Request) (any, error) {
+			domain := strings.ToLower(r.PathValue("domain"))
+			if freeze {
+				return map[string]bool{"frozen": true}, backend.FreezeDomain(r.Context(), domain)
+			} else {
+				return map[string]bool{"frozen": false}, backend.UnfreezeDomain(r.Context(), domain)
+			}
+		}
+	}
+	router.Handle("POST /domain/{domain}/freeze", adminAction("", setDomainFrozen(true)))
+	router.Handle("POST /domain/{domain}/unfreeze", adminAction("", setDomainFrozen(false)))
+
+	deleteSite := func(r *http.Request) (any, error) {
+		webRoot, err := adminWebRoot(r)
+		if err != nil {
+			return nil, err
+		}
+		err = backend.DeleteManifest(r.Context(), webRoot, ModifyManifestOptions{})
+		return map[string]string{"deleted": webRoot}, err
+	}
+	router.Handle("DELETE /site/{domain}", adminAction("", deleteSite))
+	router.Handle("DELETE /site/{domain}/{project}", adminAction("", deleteSite))
+
+	router.Handle("POST /audit/{id}/rollback", adminAction("",
+		func(r *http.Request) (any, error) {
+			id, err := ParseAuditID(r.PathValue("id"))
+			if err != nil {
+				return nil, fmt.Errorf("%w: audit ID: %w", errAdminMalformedRequest, err)
+			}
+			return map[string]string{"restored": id.String()}, RollbackToAuditRecord(r.Context(), id)
+		}))
+
+	router.Handle("POST /expire-sites", adminAction("expire-sites",
+		func(r *http.Request) (any, error) {
+			return ExpireSites(r.Context(), r.URL.Query().Has("dry-run"))
+		}))
+
+	router.Handle("POST /reload-config", adminAction("reload-config",
+		func(r *http.Request) (any, error) {
+			changedKeys, err := ReloadConfig(r.Context())
+			return map[string][]string{"changed": changedKeys}, err
+		}))
+
+	router.Handle("GET /analyze-storage", adminAction("analyze-storage",
+		func(r *http.Request) (any, error) {
+			return AnalyzeStorage(r.Context())
+		}))
+
+	router.Handle("GET /trace-garbage", adminAction("trace-garbage",
+		func(r *http.Request) (any, error) {
+			return TraceGarbage(r.Context())
+		}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential, ok := adminCredential(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		logc.Println(r.Context(), "admin:", credential, r.Method, r.URL.Path)
+
+		r = r.WithContext(WithPrincipal(r.Context()))
+		GetPrincipal(r.Context()).AdminCredential = proto.String(credential)
+		GetPrincipal(r.Context()).IpAddress = auditIPAddress(r)
+		router.ServeHTTP(w, r)
+	})
+}
@@ -51,12 +51,13 @@ func AnalyzeStorage(ctx context.Context) ([]*StorageSize, error) {
 		stats := getStats(domain)
 		stats.siteManifests += metadata.Size
 		totalStats.siteManifests += metadata.Size
-		for _, entry := range manifest.GetContents() {
-			if entry.GetType() == Type_ExternalFile {
-				blobName, blobSize := string(entry.Data), entry.GetCompressedSize()
-				stats.siteBlobs[blobName] = blobSize
-				totalStats.siteBlobs[blobName] = blobSize
-			}
+		blobs, err := ManifestBlobs(ctx, manifest)
+		if err != nil {
+			return nil, fmt.Errorf("analyze err: %s: %w", metadata.Name, err)
+		}
+		for blobName, blobSize := range blobs {
+			stats.siteBlobs[blobName] = blobSize
+			totalStats.siteBlobs[blobName] = blobSize
 		}
 	}
 
@@ -66,21 +67,27 @@ func AnalyzeStorage(ctx context.Context) ([]*StorageSize, error) {
 		if err != nil {
 			return nil, fmt.Errorf("analyze err: %w", err)
 		}
+		recordSize := int64(len(EncodeAuditRecord(record)))
+		totalStats.auditRecords += recordSize
 		domain := record.GetDomain()
+		if domain == "" {
+			continue // e.g. admin actions
+		}
 		stats := getStats(domain)
-		recordSize := int64(len(EncodeAuditRecord(record)))
 		stats.auditRecords += recordSize
-		totalStats.auditRecords += recordSize
-		if record.Manifest == nil || record.IsDetached() {
+		if record.IsDetached() {
 			continue
 		}
-		for _, entry := range record.Manifest.GetContents() {
-			if entry.GetType() == Type_ExternalFile {
-				blobName, blobSize := string(entry.Data), entry.GetCompressedSize()
+		for _, manifest := range record.ManifestsByProject() {
+			blobs, err := ManifestBlobs(ctx, manifest)
+			if err != nil {
+				return nil, fmt.Errorf("analyze err: %s: %w", record.GetAuditID(), err)
+			}
+			for blobName, blobSize := range blobs {
 				if _, found := stats.siteBlobs[blobName]; found {
 					continue // already accounted for
 				}
-				stats.auditBlobs[blobName] = entry.GetCompressedSize()
+				stats.auditBlobs[blobName] = blobSize
 				totalStats.auditBlobs[blobName] = blobSize
 			}
 		}
@@ -3,13 +3,19 @@ package git_pages
 import (
 	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -20,7 +26,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
-	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
@@ -32,6 +37,10 @@ var (
 		Name: "git_pages_audit_notify_error",
 		Help: "Count of failed audit notifications",
 	})
+	auditIndexErrorCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_audit_index_error",
+		Help: "Count of audit records that could not be added to the audit log index",
+	})
 )
 
 type principalKey struct{}
@@ -50,6 +59,22 @@ func GetPrincipal(ctx context.Context) *Principal {
 	return nil
 }
 
+type commitDiffKey struct{}
+
+type commitDiff struct {
+	name string
+	diff func(manifest *Manifest) *ManifestDiff
+}
+
+// Returns a context in which committing a manifest to the site `name` through the audited backend
+// determines the changes it makes with `diff` (see `DiffManifests`), without retrieving
+// the manifest being replaced. `diff` is only called if audit records are collected.
+func WithCommitDiff(
+	ctx context.Context, name string, diff func(manifest *Manifest) *ManifestDiff,
+) context.Context {
+	return context.WithValue(ctx, commitDiffKey{}, &commitDiff{name, diff})
+}
+
 var AuditSnowflakeStartTime = time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)
 
 type AuditID int64
@@ -117,6 +142,9 @@ func (record *AuditRecord) DescribePrincipal() string {
 		if record.Principal.GetCliAdmin() {
 			items = append(items, "<cli-admin>")
 		}
+		if record.Principal.GetAdminCredential() != "" {
+			items = append(items, fmt.Sprintf("<admin:%s>", record.Principal.GetAdminCredential()))
+		}
 	}
 	if len(items) > 0 {
 		return strings.Join(items, ",")
@@ -129,18 +157,152 @@ func (record *AuditRecord) DescribeResource() string {
 	desc := "<unknown>"
 	if record.Domain != nil && record.Project != nil {
 		desc = path.Join(*record.Domain, *record.Project)
+	} else if record.Domain != nil && len(record.Manifests) > 0 {
+		desc = fmt.Sprintf("%s/{%s}", *record.Domain, strings.Join(record.GetProjects(), ","))
 	} else if record.Domain != nil {
 		desc = *record.Domain
+	} else if record.Action != nil {
+		desc = *record.Action
+	} else if record.GetEvent() == AuditEvent_ChainCheckpoint {
+		desc = fmt.Sprintf("<node %d>", record.GetAuditID().Node())
 	}
 	return desc
 }
 
+// Returns the names of all projects affected by the audited event, in sorted order.
+func (record *AuditRecord) GetProjects() []string {
+	if record.Project != nil {
+		return []string{*record.Project}
+	} else {
+		return slices.Sorted(maps.Keys(record.Manifests))
+	}
+}
+
+// Returns all manifest snapshots in the audit record, by project.
+func (record *AuditRecord) ManifestsByProject() map[string]*Manifest {
+	if record.Manifest != nil {
+		return map[string]*Manifest{record.GetProject(): record.Manifest}
+	} else {
+		return record.Manifests
+	}
+}
+
 func (record *AuditRecord) IsDetachable() bool {
-	return record.GetEvent() == AuditEvent_CommitManifest
+	return record.GetEvent() == AuditEvent_CommitManifest ||
+		record.GetEvent() == AuditEvent_CommitManifests
 }
 
 func (record *AuditRecord) IsDetached() bool {
-	return record.IsDetachable() && record.Manifest == nil
+	return record.IsDetachable() && record.Manifest == nil && len(record.Manifests) == 0
+}
+
+// Removes manifest snapshots from the audit record, e.g. after it has been detached.
+func (record *AuditRecord) Detach() {
+	record.Manifest = nil
+	record.Manifests = nil
+}
+
+// Returns the terms under which an audit record can be found in the secondary index of
+// the audit log.
+func AuditIndexTerms(record *AuditRecord) (terms []string) {
+	terms = append(terms, "event:"+record.GetEvent().String())
+	if record.GetDomain() != "" {
+		terms = append(terms, "domain:"+record.GetDomain())
+	}
+	for _, project := range record.GetProjects() {
+		terms = append(terms, "project:"+project)
+	}
+	if forgeUser := record.GetPrincipal().GetForgeUser(); forgeUser != nil {
+		terms = append(terms, fmt.Sprintf("forge-user:%s/%s",
+			forgeUser.GetOrigin(), forgeUser.GetName()))
+	}
+	if repoURL := record.GetPrincipal().GetRepoUrl(); repoURL != "" {
+		terms = append(terms, "repo-url:"+repoURL)
+	}
+	return
+}
+
+// Returns the index terms that an audit record must have to match the search criteria other
+// than time range.
+func (opts *SearchAuditLogOptions) indexTerms() (terms []string) {
+	if opts.Event != AuditEvent_InvalidEvent {
+		terms = append(terms, "event:"+opts.Event.String())
+	}
+	if opts.Domain != "" {
+		terms = append(terms, "domain:"+opts.Domain)
+	}
+	if opts.Project != "" {
+		terms = append(terms, "project:"+opts.Project)
+	}
+	if opts.ForgeUser != "" {
+		terms = append(terms, "forge-user:"+opts.ForgeUser)
+	}
+	if opts.RepoURL != "" {
+		terms = append(terms, "repo-url:"+opts.RepoURL)
+	}
+	return
+}
+
+func (opts *SearchAuditLogOptions) inTimeRange(id AuditID) bool {
+	return (opts.Since.IsZero() || id.CompareTime(opts.Since) >= 0) &&
+		(opts.Until.IsZero() || id.CompareTime(opts.Until) <= 0)
+}
+
+// Index terms may contain characters that are not allowed in object names.
+func auditIndexTermName(term string) string {
+	hash := sha256.Sum256([]byte(term))
+	return hex.EncodeToString(hash[:])
+}
+
+// Searches the secondary index of the audit log, where `list` enumerates the IDs of audit
+// records indexed under a term (as returned by `auditIndexTermName`).
+func searchAuditIndex(
+	opts SearchAuditLogOptions, list func(termName string) iter.Seq2[AuditID, error],
+) iter.Seq2[AuditID, error] {
+	return func(yield func(AuditID, error) bool) {
+		var found map[AuditID]bool
+		for _, term := range opts.indexTerms() {
+			indexed := map[AuditID]bool{}
+			for id, err := range list(auditIndexTermName(term)) {
+				if err != nil {
+					yield(0, err)
+					return
+				}
+				if opts.inTimeRange(id) && (found == nil || found[id]) {
+					indexed[id] = true
+				}
+			}
+			found = indexed
+		}
+		for _, id := range slices.Sorted(maps.Keys(found)) {
+			if !yield(id, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Searches the audit log without using the secondary index, by retrieving each of the audit
+// records in `ids` and checking whether it matches the search criteria.
+func searchAuditLogByDecoding(
+	ctx context.Context, backend Backend, ids iter.Seq2[AuditID, error], opts SearchAuditLogOptions,
+) iter.Seq2[AuditID, error] {
+	return func(yield func(AuditID, error) bool) {
+		terms := opts.indexTerms()
+		for record, err := range backend.GetAuditLogRecords(ctx, ids) {
+			if err == nil {
+				recordTerms := AuditIndexTerms(record)
+				if slices.ContainsFunc(terms, func(term string) bool {
+					return !slices.Contains(recordTerms, term)
+				}) {
+					continue
+				}
+			}
+			if !yield(record.GetAuditID(), err) {
+				return
+			}
+		}
+	}
 }
 
 type AuditRecordScope int
@@ -158,7 +320,7 @@ func AuditRecordJSON(record *AuditRecord, scope AuditRecordScope) []byte {
 		// trim the manifest
 		newRecord := &AuditRecord{}
 		proto.Merge(newRecord, record)
-		newRecord.Manifest = nil
+		newRecord.Detach()
 		record = newRecord
 	}
 
@@ -185,20 +347,15 @@ func ExtractAuditRecord(ctx context.Context, id AuditID, record *AuditRecord, de
 	}
 
 	if record.Manifest != nil {
-		err = os.WriteFile(filepath.Join(dest, fmt.Sprintf("%s-manifest.json", id)),
-			ManifestJSON(record.Manifest), mode)
-		if err != nil {
-			return err
-		}
-
-		archive, err := os.OpenFile(filepath.Join(dest, fmt.Sprintf("%s-archive.tar", id)),
-			os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		err = extractAuditManifest(ctx, record.Manifest, filepath.Join(dest, id.String()))
 		if err != nil {
 			return err
 		}
-		defer archive.Close()
+	}
 
-		err = CollectTar(ctx, archive, record.Manifest, ManifestMetadata{})
+	for project, manifest := range record.Manifests {
+		err = extractAuditManifest(ctx, manifest,
+			filepath.Join(dest, fmt.Sprintf("%s-%s", id, project)))
 		if err != nil {
 			return err
 		}
@@ -207,6 +364,28 @@ func ExtractAuditRecord(ctx context.Context, id AuditID, record *AuditRecord, de
 	return nil
 }
 
+func extractAuditManifest(ctx context.Context, manifest *Manifest, prefix string) error {
+	const mode = 0o400 // readable by current user, not writable
+
+	manifest, err := UnshardManifest(ctx, manifest)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(prefix+"-manifest.json", ManifestJSON(manifest), mode)
+	if err != nil {
+		return err
+	}
+
+	archive, err := os.OpenFile(prefix+"-archive.tar", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return CollectTar(ctx, archive, manifest, ManifestMetadata{})
+}
+
 func AuditEventProcessor(command string, args []string) (http.Handler, error) {
 	var err error
 
@@ -295,92 +474,236 @@ func NewAuditedBackend(backend Backend) Backend {
 // to be a 100% accurate reflection of performed actions. When in doubt, the audit records
 // should be examined together with the application logs.
 func (audited *auditedBackend) appendNewAuditRecord(ctx context.Context, record *AuditRecord) (err error) {
+	config := currentConfig(ctx)
 	if config.Audit.Collect {
-		id := GenerateAuditID()
-		record.Id = proto.Int64(int64(id))
-		record.Timestamp = timestamppb.Now()
 		record.Principal = GetPrincipal(ctx)
 
-		err = audited.Backend.AppendAuditLog(ctx, id, record)
+		err = audited.appendChainedAuditRecord(ctx, record)
+		id := record.GetAuditID()
+		if err == nil {
+			audited.indexAuditRecord(ctx, id, record)
+		}
 		if err != nil {
 			err = fmt.Errorf("audit: %w", err)
 		} else {
-			var subject string
-			if record.Project == nil {
-				subject = *record.Domain
-			} else {
-				subject = path.Join(*record.Domain, *record.Project)
-			}
-			logc.Printf(ctx, "audit %s ok: %s %s\n", subject, id, record.Event.String())
+			logc.Printf(ctx, "audit %s ok: %s %s\n",
+				record.DescribeResource(), id, record.Event.String())
 
-			// Send a notification to the audit server, if configured, and try to make sure
-			// it is delivered by retrying with exponential backoff on errors.
+			// Send a notification to the audit server and subscribers, if configured, and try
+			// to make sure it is delivered by retrying with exponential backoff on errors.
 			notifyAudit(context.WithoutCancel(ctx), id)
+			notifyAuditSubscribers(context.WithoutCancel(ctx), id, record)
 		}
 	}
 	return
 }
 
+// Once appended, an audit record is a part of the audit log whether or not it could be indexed,
+// so indexing failures do not interrupt the audited operation. Instead, the index is marked
+// as incomplete, which makes searches decode every record until the index is repaired by
+// running the `index-audit-log` migration.
+func (audited *auditedBackend) indexAuditRecord(ctx context.Context, id AuditID, record *AuditRecord) {
+	err := audited.Backend.IndexAuditRecord(ctx, id, AuditIndexTerms(record))
+	if err == nil {
+		return
+	}
+	auditIndexErrorCount.Inc()
+	logc.Printf(ctx, "audit %s index err: %s\n", id, err)
+	// The rebuild marker is removed first; see `indexAuditLog`.
+	for _, feature := range []BackendFeature{FeatureAuditIndexRebuild, FeatureAuditIndex} {
+		if err = audited.Backend.DisableFeature(ctx, feature); err != nil {
+			logc.Printf(ctx, "audit %s index err: disable %s: %s\n", id, feature, err)
+		}
+	}
+}
+
+// Appends an audit record for an operator action that does not otherwise produce one.
+func AuditAdminAction(ctx context.Context, action string) error {
+	if audited, ok := unwrapBackend[*auditedBackend](backend); ok {
+		return audited.appendNewAuditRecord(ctx, &AuditRecord{
+			Event:  AuditEvent_AdminAction.Enum(),
+			Action: proto.String(action),
+		})
+	}
+	return nil
+}
+
 func notifyAudit(ctx context.Context, id AuditID) {
+	config := currentConfig(ctx)
 	if config.Audit.NotifyURL != nil {
 		notifyURL := config.Audit.NotifyURL.URL
 		notifyURL.RawQuery = id.String()
 
-		// See also the explanation in `AuditEventProcessor` above.
-		go func() {
-			backoff := exponential.Backoff{
-				Jitter: true,
-				Min:    time.Second * 1,
-				Max:    time.Second * 60,
+		pendingAuditNotifications.Lock()
+		pendingAuditNotifications.ids[id] = struct{}{}
+		pendingAuditNotifications.Unlock()
+
+		// See also the explanation in `AuditEventProcessor` above. If the notification is not
+		// delivered by the end of a graceful shutdown, it is saved to be delivered after restart.
+		goBackground(func() {
+			delivered := deliverAuditNotification(ctx, id.String(), func() (*http.Request, error) {
+				return http.NewRequestWithContext(abandonCtx, "GET", notifyURL.String(), nil)
+			})
+			if delivered {
+				pendingAuditNotifications.Lock()
+				delete(pendingAuditNotifications.ids, id)
+				pendingAuditNotifications.Unlock()
 			}
-			for {
-				resp, err := http.Get(notifyURL.String())
-				var body []byte
-				if err == nil {
-					body, _ = io.ReadAll(resp.Body)
-					resp.Body.Close()
-				}
-				if err == nil && resp.StatusCode == http.StatusOK {
-					logc.Printf(ctx, "audit notify %s ok: %s\n", id, string(body))
-					auditNotifyOkCount.Inc()
-					break
-				} else {
-					sleepFor := backoff.Duration()
-					if err != nil {
-						logc.Printf(ctx, "audit notify %s err: %s (retry in %s)",
-							id, err, sleepFor)
-					} else {
-						logc.Printf(ctx, "audit notify %s fail: %s (retry in %s); %s",
-							id, resp.Status, sleepFor, string(body))
-					}
-					auditNotifyErrorCount.Inc()
-					time.Sleep(sleepFor)
-				}
+		})
+	}
+}
+
+// Sends requests made by `newRequest` until one of them succeeds (with a 2xx status), retrying
+// with exponential backoff. Returns false if the graceful shutdown was abandoned before that.
+func deliverAuditNotification(
+	ctx context.Context, desc string, newRequest func() (*http.Request, error),
+) bool {
+	backoff := exponential.Backoff{
+		Jitter: true,
+		Min:    time.Second * 1,
+		Max:    time.Second * 60,
+	}
+	for {
+		var resp *http.Response
+		req, err := newRequest()
+		if err == nil {
+			resp, err = http.DefaultClient.Do(req)
+		}
+		var body []byte
+		if err == nil {
+			body, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if err == nil && resp.StatusCode/100 == 2 {
+			logc.Printf(ctx, "audit notify %s ok: %s\n", desc, string(body))
+			auditNotifyOkCount.Inc()
+			return true
+		} else {
+			sleepFor := backoff.Duration()
+			if err != nil {
+				logc.Printf(ctx, "audit notify %s err: %s (retry in %s)",
+					desc, err, sleepFor)
+			} else {
+				logc.Printf(ctx, "audit notify %s fail: %s (retry in %s); %s",
+					desc, resp.Status, sleepFor, string(body))
+			}
+			auditNotifyErrorCount.Inc()
+			select {
+			case <-time.After(sleepFor):
+			case <-abandonCtx.Done():
+				return false
 			}
-		}()
+		}
+	}
+}
+
+// Returns the changes made by committing `manifest` to the site `name`, or nil if they could not
+// be determined. The audit record is appended regardless, since the changes can also be found by
+// comparing the manifest snapshots (until they are detached).
+func (audited *auditedBackend) diffCommit(
+	ctx context.Context, name string, manifest *Manifest,
+) *ManifestDiff {
+	if commitDiff, ok := ctx.Value(commitDiffKey{}).(*commitDiff); ok && commitDiff.name == name {
+		return commitDiff.diff(manifest)
+	}
+	oldManifest, _, err := audited.Backend.GetManifest(ctx, name,
+		GetManifestOptions{BypassCache: true})
+	if errors.Is(err, ErrObjectNotFound) {
+		oldManifest, err = nil, nil
 	}
+	var diff *ManifestDiff
+	if err == nil {
+		diff, err = DiffManifests(ctx, oldManifest, manifest)
+	}
+	if err != nil {
+		logc.Printf(ctx, "audit diff %s err: %s\n", name, err)
+	}
+	return diff
 }
 
 func (audited *auditedBackend) CommitManifest(
 	ctx context.Context, name string, manifest *Manifest, opts ModifyManifestOptions,
 ) error {
+	config := currentConfig(ctx)
 	domain, project, ok := strings.Cut(name, "/")
 	if !ok {
 		panic("malformed manifest name")
 	}
 
-	if err := audited.appendNewAuditRecord(ctx, &AuditRecord{
+	record := &AuditRecord{
 		Event:    AuditEvent_CommitManifest.Enum(),
 		Domain:   proto.String(domain),
 		Project:  proto.String(project),
 		Manifest: manifest,
-	}); err != nil {
+	}
+	if config.Audit.Collect {
+		record.Diff = audited.diffCommit(ctx, name, manifest)
+	}
+	if err := audited.appendNewAuditRecord(ctx, record); err != nil {
 		return err
 	}
 
 	return audited.Backend.CommitManifest(ctx, name, manifest, opts)
 }
 
+// Unlike the other operations, the transaction is audited after it is performed, since it may
+// be only partially committed (see `PartialCommitError`), and the audit record must list only
+// the sites that were actually changed. If appending the audit record fails, the error is
+// returned even though the transaction was committed.
+func (audited *auditedBackend) CommitManifests(ctx context.Context, commits []ManifestCommit) error {
+	config := currentConfig(ctx)
+	domain, err := manifestCommitsDomain(commits)
+	if err != nil {
+		return err
+	}
+
+	// The changes are determined before committing, while the manifests being replaced
+	// can still be retrieved.
+	diffs := map[string]*ManifestDiff{}
+	if config.Audit.Collect {
+		for _, commit := range commits {
+			_, project, _ := strings.Cut(commit.Name, "/")
+			diff := audited.diffCommit(ctx, commit.Name, commit.Manifest)
+			if diff != nil {
+				diffs[project] = diff
+			}
+		}
+	}
+
+	committed := map[string]bool{}
+	commitErr := audited.Backend.CommitManifests(ctx, commits)
+	if partialErr := (*PartialCommitError)(nil); errors.As(commitErr, &partialErr) {
+		for _, name := range partialErr.Committed {
+			committed[name] = true
+		}
+	} else if commitErr != nil {
+		return commitErr
+	} else {
+		for _, commit := range commits {
+			committed[commit.Name] = true
+		}
+	}
+
+	manifests := map[string]*Manifest{}
+	for _, commit := range commits {
+		_, project, _ := strings.Cut(commit.Name, "/")
+		if committed[commit.Name] {
+			manifests[project] = commit.Manifest
+		} else {
+			delete(diffs, project)
+		}
+	}
+	if err := audited.appendNewAuditRecord(ctx, &AuditRecord{
+		Event:     AuditEvent_CommitManifests.Enum(),
+		Domain:    proto.String(domain),
+		Manifests: manifests,
+		Diffs:     diffs,
+	}); err != nil {
+		return errors.Join(commitErr, err)
+	}
+	return commitErr
+}
+
 func (audited *auditedBackend) DeleteManifest(
 	ctx context.Context, name string, opts ModifyManifestOptions,
 ) error {
@@ -0,0 +1,347 @@
+package git_pages
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/kankanreno/go-snowflake"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Audit records made by each node form a chain: every record includes the ID and the hash of
+// the previous record made by the same node, so that removing, reordering, or modifying a record
+// breaks the chain. Since the hashes can be recomputed by anyone who can write to the store,
+// each node also periodically appends a checkpoint record signed with `[audit].checkpoint-key`,
+// which covers every record before it.
+
+// Returns the machine ID of the node that generated the audit ID.
+func (id AuditID) Node() int {
+	return int(int64(id)>>snowflake.SequenceLength) & snowflake.MaxMachineID
+}
+
+// Returns the hash of the audit record metadata covered by the chain. Manifest snapshots are
+// not covered, so that audit records can still be detached.
+func AuditChainHash(record *AuditRecord) []byte {
+	metadata := proto.CloneOf(record)
+	metadata.Detach()
+	metadata.Encrypted = nil
+	hash := sha256.Sum256(EncodeAuditRecord(metadata))
+	return hash[:]
+}
+
+// Returns the hash that the signature of a checkpoint record is made over.
+func auditCheckpointHash(record *AuditRecord) []byte {
+	unsigned := proto.CloneOf(record)
+	unsigned.Signature = nil
+	return AuditChainHash(unsigned)
+}
+
+// Parses the checkpoint signing key (nil if not configured), and returns it together with every
+// public key that checkpoints are verified with.
+func parseAuditCheckpointKeys(auditConfig *AuditConfig) (
+	signingKey ed25519.PrivateKey, publicKeys []ed25519.PublicKey, err error,
+) {
+	if auditConfig.CheckpointKey != "" {
+		seed, err := base64.StdEncoding.DecodeString(auditConfig.CheckpointKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, nil, fmt.Errorf("[audit].checkpoint-key: malformed key " +
+				"(expected a base64-encoded 32-byte Ed25519 seed)")
+		}
+		signingKey = ed25519.NewKeyFromSeed(seed)
+		publicKeys = append(publicKeys, signingKey.Public().(ed25519.PublicKey))
+	}
+	for _, encodedKey := range auditConfig.CheckpointPublicKeys {
+		publicKey, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("[audit].checkpoint-public-keys: malformed key %q "+
+				"(expected a base64-encoded 32-byte Ed25519 public key)", encodedKey)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return
+}
+
+func decodeAuditChainHead(data []byte) (*AuditChainHead, error) {
+	head := &AuditChainHead{}
+	if err := proto.Unmarshal(data, head); err != nil {
+		return nil, fmt.Errorf("malformed: %w", err)
+	}
+	return head, nil
+}
+
+func encodeAuditChainHead(head *AuditChainHead) []byte {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(head)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// Allocates an ID for an audit record, links it to the previous record made by the same node,
+// signs it if it is a checkpoint, appends it, and then makes it the head of the chain. The ID is
+// allocated and the record is appended while the head of the chain is being replaced, so that
+// concurrently made records are chained in the order of their IDs, and so that the chain never
+// refers to a record that could not be appended.
+func (audited *auditedBackend) appendChainedAuditRecord(
+	ctx context.Context, record *AuditRecord,
+) (err error) {
+	config := currentConfig(ctx)
+	signingKey, _, err := parseAuditCheckpointKeys(&config.Audit)
+	if err != nil {
+		return err
+	}
+
+	node := config.Audit.NodeID
+	var appended AuditID
+	var appendErr error
+	err = audited.Backend.UpdateAuditChainHead(ctx, node, func(data []byte) []byte {
+		if appended != 0 {
+			// The head of the chain was replaced concurrently after the record was appended,
+			// so it is appended again with a new ID that follows the new head.
+			if err := audited.Backend.ExpireAuditRecord(ctx, appended); err != nil {
+				logc.Printf(ctx, "audit chain err: %d: expire %s: %s\n", node, appended, err)
+			}
+			appended = 0
+		}
+		record.Id = proto.Int64(int64(GenerateAuditID()))
+		record.Timestamp = timestamppb.Now()
+		record.PreviousId, record.PreviousHash, record.Signature = nil, nil, nil
+		if data != nil {
+			if head, err := decodeAuditChainHead(data); err != nil {
+				// Refusing to append audit records would make every audited operation fail;
+				// instead, a new chain is started, which `-audit-verify` reports.
+				logc.Printf(ctx, "audit chain err: %d: %s\n", node, err)
+			} else if head.Id != nil {
+				record.PreviousId, record.PreviousHash = head.Id, head.Hash
+			}
+		}
+		if record.GetEvent() == AuditEvent_ChainCheckpoint {
+			record.Signature = ed25519.Sign(signingKey, AuditChainHash(record))
+		}
+		appendErr = audited.Backend.AppendAuditLog(ctx, record.GetAuditID(), record)
+		if appendErr != nil {
+			return nil // leave the head of the chain unchanged
+		}
+		appended = record.GetAuditID()
+		return encodeAuditChainHead(&AuditChainHead{
+			Id:    record.Id,
+			Hash:  AuditChainHash(record),
+			Event: record.Event,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("chain: %w", err)
+	}
+	return appendErr
+}
+
+// Appends a signed checkpoint record to the chain of this node, unless no audit records were
+// appended since the last checkpoint.
+func CheckpointAuditChain(ctx context.Context) error {
+	config := currentConfig(ctx)
+	audited, ok := unwrapBackend[*auditedBackend](backend)
+	if !ok || !config.Audit.Collect || config.Audit.CheckpointKey == "" {
+		return nil
+	}
+
+	data, err := audited.Backend.GetAuditChainHead(ctx, config.Audit.NodeID)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("audit chain: %w", err)
+	}
+	head, err := decodeAuditChainHead(data)
+	if err != nil {
+		return fmt.Errorf("audit chain: %w", err)
+	} else if head.Id == nil || head.GetEvent() == AuditEvent_ChainCheckpoint {
+		return nil
+	}
+	return audited.appendNewAuditRecord(ctx, &AuditRecord{
+		Event: AuditEvent_ChainCheckpoint.Enum(),
+	})
+}
+
+func CheckpointAuditChainPeriodically(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(max(time.Duration(currentConfig(ctx).Audit.CheckpointInterval), time.Minute)):
+		}
+		if err := CheckpointAuditChain(ctx); err != nil {
+			logc.Printf(ctx, "audit checkpoint err: %s", err)
+		}
+	}
+}
+
+// The parts of an audit record needed to verify the chain it belongs to.
+type auditChainLink struct {
+	id           AuditID
+	timestamp    time.Time
+	previousID   AuditID
+	previousHash []byte
+	hash         []byte
+	checkpoint   bool
+	signed       bool
+	encrypted    bool
+}
+
+// Checks that the audit records made by each node form an unbroken chain that ends at the head
+// of the chain, and that every checkpoint is signed with one of the configured keys. Every
+// problem that is found is logged, and an error is returned if there were any.
+//
+// Since audit records are expired starting with the oldest ones, a chain that starts with
+// a record whose previous record is missing is not considered broken. Neither is a record whose
+// missing previous record is older than `[audit].retention-days`, since the retention policy may
+// keep some records older than that while expiring the ones around them.
+func VerifyAuditLog(ctx context.Context) error {
+	config := currentConfig(ctx)
+	_, publicKeys, err := parseAuditCheckpointKeys(&config.Audit)
+	if err != nil {
+		return err
+	}
+
+	chains := map[int][]*auditChainLink{}
+	ids := backend.SearchAuditLog(ctx, SearchAuditLogOptions{})
+	for record, err := range backend.GetAuditLogRecords(ctx, ids) {
+		if err != nil {
+			return err
+		}
+		link := &auditChainLink{
+			id:           record.GetAuditID(),
+			timestamp:    record.GetTimestamp().AsTime(),
+			previousID:   AuditID(record.GetPreviousId()),
+			previousHash: record.GetPreviousHash(),
+			hash:         AuditChainHash(record),
+			checkpoint:   record.GetEvent() == AuditEvent_ChainCheckpoint,
+			encrypted:    record.Encrypted != nil,
+		}
+		if link.checkpoint {
+			for _, publicKey := range publicKeys {
+				if ed25519.Verify(publicKey, auditCheckpointHash(record), record.GetSignature()) {
+					link.signed = true
+				}
+			}
+		}
+		node := link.id.Node()
+		chains[node] = append(chains[node], link)
+	}
+
+	problems := 0
+	report := func(id AuditID, format string, args ...any) {
+		problems += 1
+		logc.Printf(ctx, "audit verify: %s: %s\n", id, fmt.Sprintf(format, args...))
+	}
+	for _, node := range slices.Sorted(maps.Keys(chains)) {
+		links := chains[node]
+		slices.SortFunc(links, func(a, b *auditChainLink) int {
+			return cmp.Compare(a.id, b.id)
+		})
+		linkIndex := map[AuditID]int{}
+		for index, link := range links {
+			linkIndex[link.id] = index
+		}
+
+		chained, unchained, checkpoints, lastCheckpoint := false, 0, 0, -1
+		expiredGaps := 0
+		for index, link := range links {
+			if link.encrypted {
+				report(link.id, "encrypted (storage encryption is not configured)")
+				continue
+			}
+			if link.previousID == 0 {
+				if chained {
+					report(link.id, "not chained to previous record %s", links[index-1].id)
+				} else {
+					unchained += 1
+				}
+				continue
+			}
+			chained = true
+			if index == 0 {
+				logc.Printf(ctx, "audit verify: node %d: chain starts after expired record %s\n",
+					node, link.previousID)
+			} else if previous := links[index-1]; link.previousID != previous.id {
+				if _, found := linkIndex[link.previousID]; found {
+					report(link.id, "chained to %s, skipping %s", link.previousID, previous.id)
+				} else if auditRecordExpirable(link.previousID) {
+					// Records kept by the retention policy (see `MaintainAuditLog`) may
+					// follow expired ones.
+					expiredGaps += 1
+				} else {
+					report(link.id, "previous record %s is missing", link.previousID)
+				}
+			} else if !bytes.Equal(link.previousHash, previous.hash) {
+				report(previous.id, "modified (hash does not match the one in %s)", link.id)
+			}
+			if link.checkpoint {
+				checkpoints += 1
+				if len(publicKeys) == 0 {
+					// reported below
+				} else if !link.signed {
+					report(link.id, "checkpoint signature is invalid")
+				} else {
+					lastCheckpoint = index
+				}
+			}
+		}
+		if expiredGaps > 0 {
+			logc.Printf(ctx, "audit verify: node %d: %d records follow expired records\n",
+				node, expiredGaps)
+		}
+		if unchained > 1 {
+			logc.Printf(ctx, "audit verify: node %d: %d records predate the chain\n",
+				node, unchained-1)
+		}
+
+		last := links[len(links)-1]
+		data, err := backend.GetAuditChainHead(ctx, node)
+		if errors.Is(err, ErrObjectNotFound) {
+			if last.previousID != 0 {
+				report(last.id, "chain head is missing")
+			}
+		} else if err != nil {
+			return fmt.Errorf("audit chain %d: %w", node, err)
+		} else if head, err := decodeAuditChainHead(data); err != nil {
+			report(last.id, "chain head is %s", err)
+		} else if head.GetId() > int64(last.id) {
+			report(AuditID(head.GetId()), "missing (records up to the chain head were removed)")
+		} else if head.GetId() != int64(last.id) {
+			report(last.id, "not chained to the chain head %s", AuditID(head.GetId()))
+		} else if !bytes.Equal(head.GetHash(), last.hash) {
+			report(last.id, "modified (hash does not match the chain head)")
+		}
+
+		if len(publicKeys) == 0 {
+			logc.Printf(ctx, "audit verify: node %d: %d checkpoints not verified "+
+				"(no checkpoint keys configured)\n", node, checkpoints)
+		} else if uncovered := links[lastCheckpoint+1:]; len(uncovered) > 0 {
+			logc.Printf(ctx, "audit verify: node %d: %d records since %s not covered by "+
+				"a checkpoint\n", node, len(uncovered), uncovered[0].id)
+			// The server makes a checkpoint every `[audit].checkpoint-interval`, so older
+			// records not covered by one indicate that checkpoints were removed.
+			checkpointDeadline := time.Now().Add(-2 * time.Duration(config.Audit.CheckpointInterval))
+			if uncovered[0].timestamp.Before(checkpointDeadline) {
+				report(uncovered[0].id, "not covered by a checkpoint made since")
+			}
+		}
+		logc.Printf(ctx, "audit verify: node %d: %d records, %d checkpoints\n",
+			node, len(links), checkpoints)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("audit verify: %d problems found", problems)
+	}
+	logc.Println(ctx, "audit verify: ok")
+	return nil
+}
@@ -0,0 +1,232 @@
+package git_pages
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kankanreno/go-snowflake"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAuditChainHash(t *testing.T) {
+	record := &AuditRecord{
+		Id:           proto.Int64(2),
+		Timestamp:    timestamppb.New(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Event:        AuditEvent_CommitManifest.Enum(),
+		Domain:       proto.String("example.org"),
+		Project:      proto.String("project"),
+		Manifest:     &Manifest{RepoUrl: proto.String("https://example.org/repo.git")},
+		PreviousId:   proto.Int64(1),
+		PreviousHash: []byte("previous"),
+		Diff:         &ManifestDiff{Added: []string{"index.html"}},
+	}
+	hash := AuditChainHash(record)
+
+	for _, test := range []struct {
+		name    string
+		modify  func(record *AuditRecord)
+		changed bool
+	}{
+		{"unmodified", func(record *AuditRecord) {}, false},
+		{"detached", func(record *AuditRecord) { record.Detach() }, false},
+		{"manifest", func(record *AuditRecord) { record.Manifest.Branch = proto.String("main") }, false},
+		{"encrypted", func(record *AuditRecord) { record.Encrypted = &EncryptedPayload{} }, false},
+		{"id", func(record *AuditRecord) { record.Id = proto.Int64(3) }, true},
+		{"timestamp", func(record *AuditRecord) { record.Timestamp.Seconds += 1 }, true},
+		{"event", func(record *AuditRecord) { record.Event = AuditEvent_DeleteManifest.Enum() }, true},
+		{"domain", func(record *AuditRecord) { record.Domain = proto.String("example.com") }, true},
+		{"previous id", func(record *AuditRecord) { record.PreviousId = nil }, true},
+		{"previous hash", func(record *AuditRecord) { record.PreviousHash[0] ^= 1 }, true},
+		{"signature", func(record *AuditRecord) { record.Signature = []byte("signature") }, true},
+		{"diff", func(record *AuditRecord) { record.Diff.Added = nil }, true},
+	} {
+		modified := proto.CloneOf(record)
+		test.modify(modified)
+		if changed := !bytes.Equal(AuditChainHash(modified), hash); changed != test.changed {
+			t.Errorf("%s: expect hash changed %t, got %t", test.name, test.changed, changed)
+		}
+	}
+	if !proto.Equal(record.Manifest, &Manifest{RepoUrl: proto.String("https://example.org/repo.git")}) {
+		t.Errorf("expect record to be unmodified by hashing")
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	ctx := context.Background()
+
+	savedSnapshot, savedBackend := currentConfigSnapshot.Load(), backend
+	defer func() {
+		currentConfigSnapshot.Store(savedSnapshot)
+		backend = savedBackend
+	}()
+
+	config, err := Configure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Audit.Collect = true
+	config.Audit.CheckpointKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	currentConfigSnapshot.Store(&configSnapshot{config: config})
+	snowflake.SetStartTime(AuditSnowflakeStartTime)
+	snowflake.SetMachineID(config.Audit.NodeID)
+
+	// Each case starts with a new audit log made of three records followed by a checkpoint,
+	// and modifies it using the IDs of these four records.
+	for _, test := range []struct {
+		name      string
+		modify    func(ids []AuditID) error
+		expectErr bool
+	}{
+		{"intact", func(ids []AuditID) error { return nil }, false},
+		{"detached", func(ids []AuditID) error {
+			return backend.DetachAuditRecord(ctx, ids[1])
+		}, false},
+		{"first expired", func(ids []AuditID) error {
+			return backend.ExpireAuditRecord(ctx, ids[0])
+		}, false},
+		{"middle removed", func(ids []AuditID) error {
+			return backend.ExpireAuditRecord(ctx, ids[1])
+		}, true},
+		{"last removed", func(ids []AuditID) error {
+			return backend.ExpireAuditRecord(ctx, ids[3])
+		}, true},
+		{"modified", func(ids []AuditID) error {
+			record, err := backend.QueryAuditLog(ctx, ids[1])
+			if err != nil {
+				return err
+			}
+			record.Action = proto.String("forged")
+			return backend.ReplaceAuditRecord(ctx, ids[1], record)
+		}, true},
+		{"rechained", func(ids []AuditID) error {
+			// Updating the hash that the next record refers to changes the hash of that
+			// record in turn, up to the chain head.
+			record, err := backend.QueryAuditLog(ctx, ids[1])
+			if err != nil {
+				return err
+			}
+			record.Action = proto.String("forged")
+			next, err := backend.QueryAuditLog(ctx, ids[2])
+			if err != nil {
+				return err
+			}
+			next.PreviousHash = AuditChainHash(record)
+			return errors.Join(
+				backend.ReplaceAuditRecord(ctx, ids[1], record),
+				backend.ReplaceAuditRecord(ctx, ids[2], next),
+			)
+		}, true},
+		{"new chain", func(ids []AuditID) error {
+			return backend.UpdateAuditChainHead(ctx, config.Audit.NodeID,
+				func(data []byte) []byte { return []byte{} })
+		}, true},
+	} {
+		fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend = NewAuditedBackend(fsBackend)
+
+		for _, action := range []string{"one", "two", "three"} {
+			if err := AuditAdminAction(ctx, action); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+		}
+		if err := CheckpointAuditChain(ctx); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		var ids []AuditID
+		for id, err := range backend.SearchAuditLog(ctx, SearchAuditLogOptions{}) {
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		if len(ids) != 4 {
+			t.Fatalf("%s: expect 4 records, got %d", test.name, len(ids))
+		}
+
+		if err := test.modify(ids); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		err = VerifyAuditLog(ctx)
+		if test.expectErr && err == nil {
+			t.Errorf("%s: expect err, got ok", test.name)
+		} else if !test.expectErr && err != nil {
+			t.Errorf("%s: expect ok, got err %s", test.name, err)
+		}
+	}
+}
+
+type failingAuditBackend struct {
+	Backend
+	fail bool
+}
+
+func (backend *failingAuditBackend) AppendAuditLog(
+	ctx context.Context, id AuditID, record *AuditRecord,
+) error {
+	if backend.fail {
+		return ErrBackendUnavailable
+	}
+	return backend.Backend.AppendAuditLog(ctx, id, record)
+}
+
+func TestAppendChainedAuditRecord(t *testing.T) {
+	ctx := context.Background()
+
+	savedSnapshot, savedBackend := currentConfigSnapshot.Load(), backend
+	defer func() {
+		currentConfigSnapshot.Store(savedSnapshot)
+		backend = savedBackend
+	}()
+
+	config, err := Configure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Audit.Collect = true
+	currentConfigSnapshot.Store(&configSnapshot{config: config})
+	snowflake.SetStartTime(AuditSnowflakeStartTime)
+	snowflake.SetMachineID(config.Audit.NodeID)
+
+	fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingAuditBackend{Backend: fsBackend}
+	backend = NewAuditedBackend(failing)
+
+	if err := AuditAdminAction(ctx, "one"); err != nil {
+		t.Fatal(err)
+	}
+	failing.fail = true
+	if err := AuditAdminAction(ctx, "two"); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("expect err %s, got err %v", ErrBackendUnavailable, err)
+	}
+	failing.fail = false
+	if err := AuditAdminAction(ctx, "three"); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, err := range backend.SearchAuditLog(ctx, SearchAuditLogOptions{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += 1
+	}
+	if count != 2 {
+		t.Errorf("expect 2 records, got %d", count)
+	}
+	if err := VerifyAuditLog(ctx); err != nil {
+		t.Errorf("expect ok, got err %s", err)
+	}
+}
@@ -0,0 +1,169 @@
+package git_pages
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"time"
+)
+
+// The audit retention policy in `[audit]` is applied by the server every
+// `[audit].maintain-interval`, or by `git-pages -audit-maintain`. Both may run at once (e.g. on
+// several nodes), since expiring or detaching an audit record again has no effect.
+
+func validateAuditRetention(auditConfig *AuditConfig) error {
+	for _, event := range auditConfig.KeepEvents {
+		if value, found := AuditEvent_value[event]; !found || value == 0 {
+			return fmt.Errorf("[audit].keep-events: unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+func auditRetentionConfigured() bool {
+	config := loadConfig()
+	return config.Audit.RetentionDays > 0 || config.Audit.DetachAfterDays > 0
+}
+
+// Returns whether the audit record may have been expired per `[audit].retention-days`.
+func auditRecordExpirable(id AuditID) bool {
+	config := loadConfig()
+	return config.Audit.RetentionDays > 0 &&
+		id.CompareTime(time.Now().AddDate(0, 0, -int(config.Audit.RetentionDays))) < 0
+}
+
+// The parts of an audit record needed to apply the retention policy to it.
+type auditRetentionEntry struct {
+	id         AuditID
+	event      AuditEvent
+	sites      []string
+	detachable bool
+}
+
+// Expires audit records older than `[audit].retention-days` and detaches those older than
+// `[audit].detach-after-days`, except for the last `[audit].keep-per-site` records of each site
+// (not counting its staged versions), the records of `[audit].keep-events`, and the last checkpoint
+// made by each node (which covers the records that are kept).
+func MaintainAuditLog(ctx context.Context, dryRun bool) error {
+	config := currentConfig(ctx)
+	if !auditRetentionConfigured() {
+		logc.Println(ctx, "audit maintain: no retention policy configured")
+		return nil
+	}
+
+	now := time.Now()
+	var expireBefore, detachBefore time.Time
+	if config.Audit.RetentionDays > 0 {
+		expireBefore = now.AddDate(0, 0, -int(config.Audit.RetentionDays))
+	}
+	if config.Audit.DetachAfterDays > 0 {
+		detachBefore = now.AddDate(0, 0, -int(config.Audit.DetachAfterDays))
+	}
+
+	var entries []*auditRetentionEntry
+	ids := backend.SearchAuditLog(ctx, SearchAuditLogOptions{})
+	for record, err := range backend.GetAuditLogRecords(ctx, ids) {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrObjectNotFound) {
+			// Not finding the record means that it was expired concurrently.
+			continue
+		} else if err != nil {
+			return fmt.Errorf("audit maintain: %w", err)
+		}
+		entry := &auditRetentionEntry{
+			id:         record.GetAuditID(),
+			event:      record.GetEvent(),
+			detachable: record.IsDetachable() && !record.IsDetached(),
+		}
+		for _, project := range record.GetProjects() {
+			if isStagedProjectName(project) {
+				continue // staged versions are not part of the history of the site
+			}
+			entry.sites = append(entry.sites, path.Join(record.GetDomain(), project))
+		}
+		if len(record.GetProjects()) == 0 && record.GetDomain() != "" {
+			entry.sites = append(entry.sites, record.GetDomain())
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b *auditRetentionEntry) int {
+		return cmp.Compare(b.id, a.id)
+	})
+
+	var expired, detached, failed int
+	siteRecords := map[string]uint{}
+	nodeCheckpoints := map[int]bool{}
+	for _, entry := range entries {
+		// Entries are visited from newest to oldest.
+		keep := slices.Contains(config.Audit.KeepEvents, entry.event.String())
+		for _, site := range entry.sites {
+			if siteRecords[site] < config.Audit.KeepPerSite {
+				keep = true
+			}
+			siteRecords[site] += 1
+		}
+		if entry.event == AuditEvent_ChainCheckpoint && !nodeCheckpoints[entry.id.Node()] {
+			nodeCheckpoints[entry.id.Node()] = true
+			keep = true
+		}
+		if keep {
+			continue
+		}
+
+		var action, done string
+		var count *int
+		var apply func(ctx context.Context, id AuditID) error
+		if !expireBefore.IsZero() && entry.id.CompareTime(expireBefore) < 0 {
+			action, done, count, apply = "expire", "expired", &expired, backend.ExpireAuditRecord
+		} else if !detachBefore.IsZero() && entry.detachable &&
+			entry.id.CompareTime(detachBefore) < 0 {
+			action, done, count, apply = "detach", "detached", &detached, backend.DetachAuditRecord
+		} else {
+			continue
+		}
+
+		if dryRun {
+			logc.Printf(ctx, "audit maintain: would %s record %s\n", action, entry.id)
+		} else if err := apply(ctx, entry.id); err != nil &&
+			!errors.Is(err, os.ErrNotExist) && !errors.Is(err, ErrObjectNotFound) {
+			logc.Printf(ctx, "audit maintain: %s record %s err: %s\n", action, entry.id, err)
+			failed += 1
+			continue
+		} else {
+			// Not finding the record means that it was expired concurrently.
+			logc.Printf(ctx, "audit maintain: %s record %s\n", done, entry.id)
+		}
+		*count += 1
+	}
+
+	if dryRun {
+		logc.Printf(ctx, "audit maintain: would expire %d and detach %d out of %d records "+
+			"(dry run)\n", expired, detached, len(entries))
+	} else {
+		logc.Printf(ctx, "audit maintain: expired %d and detached %d out of %d records\n",
+			expired, detached, len(entries))
+	}
+	if failed > 0 {
+		return fmt.Errorf("audit maintain: %d records could not be expired or detached", failed)
+	}
+	return nil
+}
+
+func MaintainAuditLogPeriodically(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(max(time.Duration(currentConfig(ctx).Audit.MaintainInterval), time.Minute)):
+		}
+		if !auditRetentionConfigured() {
+			continue
+		}
+		if err := MaintainAuditLog(ctx, false); err != nil {
+			logc.Printf(ctx, "audit maintain err: %s", err)
+		}
+	}
+}
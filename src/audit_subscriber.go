@@ -0,0 +1,266 @@
+package git_pages
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Audit records are sent to each `[[audit.subscriber]]` as the body of a `POST` request, with
+// the audit ID in the `X-Git-Pages-Audit-Id:` header field and the HMAC-SHA256 of the body
+// (keyed with the subscriber secret) in the `X-Git-Pages-Signature: sha256=<hex>` header field.
+// Notifications are delivered at least once; subscribers may use the audit ID to discard
+// duplicates.
+
+func parseAuditRecordScope(scope string) (AuditRecordScope, error) {
+	switch scope {
+	case "no-manifest":
+		return AuditRecordNoManifest, nil
+	case "complete":
+		return AuditRecordComplete, nil
+	default:
+		return 0, fmt.Errorf("unknown scope %q", scope)
+	}
+}
+
+func validateAuditSubscribers(subscribers []AuditSubscriberConfig) error {
+	names := map[string]bool{}
+	for index, subscriber := range subscribers {
+		// The name is used as a directory name in the outbox.
+		if subscriber.Name == "" || subscriber.Name == "." || subscriber.Name == ".." ||
+			strings.ContainsAny(subscriber.Name, `/\`) {
+			return fmt.Errorf("[[audit.subscriber]] #%d: malformed name %q", index+1, subscriber.Name)
+		} else if names[subscriber.Name] {
+			return fmt.Errorf("[[audit.subscriber]] %s: duplicate name", subscriber.Name)
+		}
+		names[subscriber.Name] = true
+
+		if subscriber.URL == nil {
+			return fmt.Errorf("[[audit.subscriber]] %s: url is required", subscriber.Name)
+		} else if subscriber.Secret == "" {
+			return fmt.Errorf("[[audit.subscriber]] %s: secret is required", subscriber.Name)
+		} else if _, err := parseAuditRecordScope(subscriber.Scope); err != nil {
+			return fmt.Errorf("[[audit.subscriber]] %s: %w", subscriber.Name, err)
+		}
+		for _, event := range subscriber.Events {
+			if value, found := AuditEvent_value[event]; !found || value == 0 {
+				return fmt.Errorf("[[audit.subscriber]] %s: unknown event %q",
+					subscriber.Name, event)
+			}
+		}
+	}
+	return nil
+}
+
+func findAuditSubscriber(name string) *AuditSubscriberConfig {
+	config := loadConfig()
+	for index := range config.Audit.Subscribers {
+		if config.Audit.Subscribers[index].Name == name {
+			return &config.Audit.Subscribers[index]
+		}
+	}
+	return nil
+}
+
+func signAuditNotification(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func auditOutboxEntryName(id AuditID) string {
+	return id.String() + ".json"
+}
+
+// Stores a notification in the outbox. The file is renamed into place once it is written, so
+// that a crash cannot leave a partially written notification behind. Notifications include
+// the same data as audit records, so they are encrypted if `[storage.encryption]` is configured.
+func saveAuditOutboxEntry(outbox string, subscriber string, id AuditID, body []byte) error {
+	if encrypted, ok := unwrapBackend[*encryptedBackend](backend); ok {
+		body = encrypted.encryptData(body, auditOutboxAdditionalData(subscriber, id))
+	}
+	dir := filepath.Join(outbox, subscriber)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	file, err := os.CreateTemp(dir, ".*.tmp")
+	if err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	_, err = file.Write(body)
+	if err = errors.Join(err, file.Close()); err == nil {
+		err = os.Rename(file.Name(), filepath.Join(dir, auditOutboxEntryName(id)))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("outbox: %w", err)
+	}
+	return nil
+}
+
+func loadAuditOutboxEntry(outbox string, subscriber string, id AuditID) ([]byte, error) {
+	body, err := os.ReadFile(filepath.Join(outbox, subscriber, auditOutboxEntryName(id)))
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	if encrypted, ok := unwrapBackend[*encryptedBackend](backend); ok {
+		body, err = encrypted.decryptData(body, auditOutboxAdditionalData(subscriber, id))
+		if err != nil {
+			return nil, fmt.Errorf("outbox: %w", err)
+		}
+	}
+	return body, nil
+}
+
+type auditDelivery struct {
+	subscriber string
+	id         AuditID
+}
+
+func (delivery auditDelivery) String() string {
+	return fmt.Sprintf("%s to %s", delivery.id, delivery.subscriber)
+}
+
+// Sends a notification to a subscriber in the background, and removes it from the outbox (if
+// it was stored there) once it has been delivered.
+func sendAuditNotification(
+	ctx context.Context, subscriber *AuditSubscriberConfig, id AuditID, body []byte, outbox string,
+) {
+	delivery := auditDelivery{subscriber.Name, id}
+	notifyURL, signature := subscriber.URL.URL, signAuditNotification(subscriber.Secret, body)
+
+	pendingAuditNotifications.Lock()
+	pendingAuditNotifications.deliveries[delivery] = outbox != ""
+	pendingAuditNotifications.Unlock()
+
+	goBackground(func() {
+		delivered := deliverAuditNotification(ctx, delivery.String(), func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(abandonCtx, "POST", notifyURL.String(),
+				bytes.NewReader(body))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Git-Pages-Audit-Id", id.String())
+				req.Header.Set("X-Git-Pages-Signature", signature)
+			}
+			return req, err
+		})
+		if !delivered {
+			return
+		}
+		if outbox != "" {
+			err := os.Remove(filepath.Join(outbox, subscriber.Name, auditOutboxEntryName(id)))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				logc.Printf(ctx, "audit notify %s err: outbox: %s\n", delivery, err)
+			}
+		}
+		pendingAuditNotifications.Lock()
+		delete(pendingAuditNotifications.deliveries, delivery)
+		pendingAuditNotifications.Unlock()
+	})
+}
+
+// Sends the audit record to every subscriber interested in its event, storing the notifications
+// in the outbox (if configured) until they are delivered.
+func notifyAuditSubscribers(ctx context.Context, id AuditID, record *AuditRecord) {
+	config := currentConfig(ctx)
+	for index := range config.Audit.Subscribers {
+		subscriber := &config.Audit.Subscribers[index]
+		event := record.GetEvent().String()
+		if len(subscriber.Events) > 0 && !slices.Contains(subscriber.Events, event) {
+			continue
+		}
+
+		scope, _ := parseAuditRecordScope(subscriber.Scope)
+		body := AuditRecordJSON(record, scope)
+		outbox := config.Audit.NotifyOutbox
+		if outbox != "" {
+			if err := saveAuditOutboxEntry(outbox, subscriber.Name, id, body); err != nil {
+				logc.Printf(ctx, "audit notify %s err: %s\n", auditDelivery{subscriber.Name, id}, err)
+				outbox = ""
+			}
+		}
+		sendAuditNotification(ctx, subscriber, id, body, outbox)
+	}
+}
+
+// Resumes delivery of the notifications left in the outbox, e.g. by a process that exited
+// before they could be delivered, or for a subscriber that was configured after they have been
+// stored. Notifications that are already being delivered by this process are skipped.
+func resumeAuditOutbox(ctx context.Context) error {
+	config := currentConfig(ctx)
+	outbox := config.Audit.NotifyOutbox
+	if outbox == "" {
+		return nil
+	}
+
+	dirEntries, err := os.ReadDir(outbox)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("audit notify: outbox: %w", err)
+	}
+	for _, dirEntry := range dirEntries {
+		subscriber := findAuditSubscriber(dirEntry.Name())
+		if subscriber == nil {
+			logc.Printf(ctx, "audit notify: outbox: unknown subscriber %s\n", dirEntry.Name())
+			continue
+		}
+		dir := filepath.Join(outbox, subscriber.Name)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("audit notify: outbox: %w", err)
+		}
+		for _, entry := range entries {
+			idRepr, found := strings.CutSuffix(entry.Name(), ".json")
+			if !found || strings.HasPrefix(idRepr, ".") {
+				continue // partially written
+			}
+			id, err := ParseAuditID(idRepr)
+			if err != nil {
+				return fmt.Errorf("audit notify: outbox: %s: %w", entry.Name(), err)
+			}
+			delivery := auditDelivery{subscriber.Name, id}
+			pendingAuditNotifications.Lock()
+			_, pending := pendingAuditNotifications.deliveries[delivery]
+			pendingAuditNotifications.Unlock()
+			if pending {
+				continue
+			}
+			body, err := loadAuditOutboxEntry(outbox, subscriber.Name, id)
+			if err != nil {
+				// The other notifications may still be delivered (e.g. if this one was
+				// encrypted with a key that has since been removed).
+				logc.Printf(ctx, "audit notify %s err: %s\n", delivery, err)
+				continue
+			}
+			logc.Printf(ctx, "audit notify %s: resuming", delivery)
+			sendAuditNotification(ctx, subscriber, id, body, outbox)
+		}
+	}
+	return nil
+}
+
+const auditOutboxResumeInterval = 5 * time.Minute
+
+func ResumeAuditOutboxPeriodically(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(auditOutboxResumeInterval):
+		}
+		if err := resumeAuditOutbox(ctx); err != nil {
+			logc.Println(ctx, err)
+		}
+	}
+}
@@ -34,6 +34,7 @@ func IsUnauthorized(err error) bool {
 }
 
 func authorizeInsecure(r *http.Request) *Authorization {
+	config := currentConfig(r.Context())
 	if config.Insecure { // for testing only
 		logc.Println(r.Context(), "auth: INSECURE mode")
 		return &Authorization{
@@ -47,6 +48,7 @@ func authorizeInsecure(r *http.Request) *Authorization {
 var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule())
 
 func GetHost(r *http.Request) (string, error) {
+	config := currentConfig(r.Context())
 	host, _, err := net.SplitHostPort(r.Host)
 	if err != nil {
 		host = r.Host
@@ -365,6 +367,7 @@ func authorizeCodebergPagesV2(r *http.Request) (*Authorization, error) {
 
 // Checks whether an operation that enables enumerating site contents is allowed.
 func AuthorizeMetadataRetrieval(r *http.Request, hasBasicAuth bool) (*Authorization, error) {
+	config := currentConfig(r.Context())
 	causes := []error{AuthError{http.StatusUnauthorized, "unauthorized"}}
 
 	auth := authorizeInsecure(r)
@@ -386,7 +389,7 @@ func AuthorizeMetadataRetrieval(r *http.Request, hasBasicAuth bool) (*Authorizat
 	// public and safe to retrieve without authorization. However, this is no longer the case if
 	// they have password-protected sections.
 	if !hasBasicAuth {
-		for _, pattern := range wildcards {
+		for _, pattern := range loadConfigSnapshot(r.Context()).wildcards {
 			auth, err = authorizeWildcardMatchHost(r, pattern)
 			if err != nil && IsUnauthorized(err) {
 				causes = append(causes, err)
@@ -415,6 +418,7 @@ func AuthorizeMetadataRetrieval(r *http.Request, hasBasicAuth bool) (*Authorizat
 }
 
 func AuthorizeUpdateFromRepository(r *http.Request) (*Authorization, error) {
+	config := currentConfig(r.Context())
 	causes := []error{AuthError{http.StatusUnauthorized, "unauthorized"}}
 
 	if err := CheckForbiddenDomain(r); err != nil {
@@ -452,7 +456,7 @@ func AuthorizeUpdateFromRepository(r *http.Request) (*Authorization, error) {
 
 	// Wildcard match is only available for webhooks, not the REST API.
 	if r.Method == http.MethodPost {
-		for _, pattern := range wildcards {
+		for _, pattern := range loadConfigSnapshot(r.Context()).wildcards {
 			auth, err = authorizeWildcardMatchSite(r, pattern)
 			if err != nil && IsUnauthorized(err) {
 				causes = append(causes, err)
@@ -482,6 +486,7 @@ func AuthorizeUpdateFromRepository(r *http.Request) (*Authorization, error) {
 }
 
 func checkAllowedURLPrefixes(repoURLs ...string) error {
+	config := loadConfig()
 	if len(config.Limits.AllowedRepositoryURLPrefixes) > 0 {
 		for _, repoURL := range repoURLs {
 			allowedPrefix := false
@@ -652,7 +657,7 @@ func authorizeForgeWildcard(r *http.Request) (*Authorization, error) {
 	}
 
 	var errs []error
-	for _, pattern := range wildcards {
+	for _, pattern := range loadConfigSnapshot(r.Context()).wildcards {
 		if pattern.Authorization {
 			if userName, found := pattern.Matches(host, WildcardDomainPrimary); found {
 				repoName := projectName
@@ -777,6 +782,7 @@ func authorizeDNSChallengeOrForgeWithToken(r *http.Request) (*Authorization, err
 }
 
 func AuthorizeUpdateFromArchive(r *http.Request) (*Authorization, error) {
+	config := currentConfig(r.Context())
 	auth := authorizeInsecure(r)
 	if auth != nil {
 		return auth, nil
@@ -808,6 +814,7 @@ func AuthorizeDeletion(r *http.Request) (*Authorization, error) {
 }
 
 func CheckForbiddenDomain(r *http.Request) error {
+	config := currentConfig(r.Context())
 	host, err := GetHost(r)
 	if err != nil {
 		return err
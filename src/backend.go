@@ -14,6 +14,26 @@ var ErrObjectNotFound = errors.New("not found")
 var ErrPreconditionFailed = errors.New("precondition failed")
 var ErrWriteConflict = errors.New("write conflict")
 var ErrDomainFrozen = errors.New("domain administratively frozen")
+var ErrPartialCommit = errors.New("transaction partially committed")
+var ErrBackendUnavailable = errors.New("storage backend unavailable")
+
+// Returned by `CommitManifests` if only some of the manifests were committed. Wraps both
+// `ErrPartialCommit` and the error that interrupted the transaction.
+type PartialCommitError struct {
+	// Names of the manifests that were committed.
+	Committed []string
+	Total     int
+	Err       error
+}
+
+func (err *PartialCommitError) Error() string {
+	return fmt.Sprintf("%s (%d of %d manifests): %s",
+		ErrPartialCommit, len(err.Committed), err.Total, err.Err)
+}
+
+func (err *PartialCommitError) Unwrap() []error {
+	return []error{ErrPartialCommit, err.Err}
+}
 
 func splitBlobName(name string) []string {
 	if algo, hash, found := strings.Cut(name, "-"); found {
@@ -31,12 +51,20 @@ type BackendFeature string
 
 const (
 	FeatureCheckDomainMarker BackendFeature = "check-domain-marker"
+	FeatureAuditIndex        BackendFeature = "audit-index"
+	// Enabled while the `index-audit-log` migration runs, and disabled together with
+	// `FeatureAuditIndex` if a record cannot be indexed, so that the migration can tell whether
+	// the index it has rebuilt is complete.
+	FeatureAuditIndexRebuild BackendFeature = "audit-index-rebuild"
 )
 
 type BlobMetadata struct {
 	Name         string
 	Size         int64
 	LastModified time.Time
+	// If true, the blob was retrieved from a cache past its expiry because the backend
+	// is unavailable.
+	Stale bool
 }
 
 type GetManifestOptions struct {
@@ -50,6 +78,9 @@ type ManifestMetadata struct {
 	Size         int64
 	LastModified time.Time
 	ETag         string
+	// If true, the manifest was retrieved from a cache past its expiry because the backend
+	// is unavailable, and may not reflect the latest changes.
+	Stale bool
 }
 
 type ModifyManifestOptions struct {
@@ -60,6 +91,41 @@ type ModifyManifestOptions struct {
 	// the state corresponding to the ETag. Whether this is racy or not is can be determined
 	// via `HasAtomicCAS()`.
 	IfMatch string
+	// If true, the request succeeds even if the domain is frozen. Only used to change how
+	// a manifest is stored without changing its contents (e.g. when re-encrypting it).
+	IgnoreFrozen bool
+}
+
+type UploadMetadata struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+}
+
+type ManifestCommit struct {
+	Name     string
+	Manifest *Manifest
+	Options  ModifyManifestOptions
+}
+
+// Returns the domain all of the commits belong to, or an error if there is more than one.
+func manifestCommitsDomain(commits []ManifestCommit) (string, error) {
+	domain := ""
+	for _, commit := range commits {
+		commitDomain, _, ok := strings.Cut(commit.Name, "/")
+		if !ok {
+			panic("malformed manifest name")
+		}
+		if domain == "" {
+			domain = commitDomain
+		} else if domain != commitDomain {
+			return "", fmt.Errorf("transaction spans domains %s and %s", domain, commitDomain)
+		}
+	}
+	if domain == "" {
+		return "", fmt.Errorf("empty transaction")
+	}
+	return domain, nil
 }
 
 type SearchAuditLogOptions struct {
@@ -71,6 +137,18 @@ type SearchAuditLogOptions struct {
 	// slightly from the embedded timestamp). If zero, audit records are returned until the end
 	// of time.
 	Until time.Time
+	// If not empty, only audit records for this domain are returned.
+	Domain string
+	// If not empty, only audit records affecting this project (e.g. `.index`) are returned.
+	// Usually combined with `Domain`.
+	Project string
+	// If not `InvalidEvent`, only audit records of this event type are returned.
+	Event AuditEvent
+	// If not empty, only audit records of actions by this forge user (`<origin>/<name>`, e.g.
+	// `codeberg.org/username`) are returned.
+	ForgeUser string
+	// If not empty, only audit records of updates from this repository URL are returned.
+	RepoURL string
 }
 
 type SearchAuditLogResult struct {
@@ -85,6 +163,10 @@ type Backend interface {
 	// Enables the feature for this store.
 	EnableFeature(ctx context.Context, feature BackendFeature) error
 
+	// Disables the feature for this store. Disabling a feature that is not enabled is not
+	// an error.
+	DisableFeature(ctx context.Context, feature BackendFeature) error
+
 	// Retrieve a blob. Returns `reader, size, mtime, err`.
 	GetBlob(ctx context.Context, name string) (
 		reader io.ReadSeeker, metadata BlobMetadata, err error,
@@ -98,6 +180,12 @@ type Backend interface {
 	// Delete a blob. This is an unconditional operation that can break integrity of manifests.
 	DeleteBlob(ctx context.Context, name string) error
 
+	// Store a blob, replacing any existing blob with the same name. This is an atomic operation;
+	// `GetBlob` calls will return either the old or the new contents, never anything else. This
+	// is only used to change how a blob is stored (e.g. the key it is encrypted with), since
+	// the contents of a blob are otherwise determined by its name.
+	ReplaceBlob(ctx context.Context, name string, data []byte) error
+
 	// Iterate through all blobs. Whether blobs that are newly added during iteration will appear
 	// in the results is unspecified.
 	EnumerateBlobs(ctx context.Context) iter.Seq2[BlobMetadata, error]
@@ -107,6 +195,10 @@ type Backend interface {
 		manifest *Manifest, metadata ManifestMetadata, err error,
 	)
 
+	// Retrieve a manifest shard (see `ManifestShard`). Shards are stored as blobs, and are
+	// retrieved using this method so that the backend may cache them like manifests.
+	GetManifestShard(ctx context.Context, name string) (*Manifest, error)
+
 	// Stage a manifest. This operation stores a new version of a manifest, locking any blobs
 	// referenced from it in place (for garbage collection purposes) but without any other side
 	// effects.
@@ -121,6 +213,14 @@ type Backend interface {
 	// the old version or the new version of the manifest, never anything else.
 	CommitManifest(ctx context.Context, name string, manifest *Manifest, opts ModifyManifestOptions) error
 
+	// Commit several manifests belonging to one domain as a single transaction. Every
+	// precondition is checked before any of the manifests are committed, and then they are
+	// committed one by one. If committing one of them fails, the backend may restore
+	// the previously committed ones; if any of them remain committed, the returned error is
+	// a `*PartialCommitError` (which wraps `ErrPartialCommit`) listing them. Whether another
+	// commit can interleave with the transaction is determined by `HasAtomicCAS()`.
+	CommitManifests(ctx context.Context, commits []ManifestCommit) error
+
 	// Delete a manifest. This operation is initiated via the API.
 	DeleteManifest(ctx context.Context, name string, opts ModifyManifestOptions) error
 
@@ -131,6 +231,10 @@ type Backend interface {
 	// iteration will appear in the results is unspecified.
 	EnumerateManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error]
 
+	// Iterate through metadata of all staged manifests (see `Stage`), which are skipped by
+	// `EnumerateManifests`. Same considerations apply as for `EnumerateManifests`.
+	EnumerateStagedManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error]
+
 	// Iterate through contents of all manifests. Same considerations apply as for
 	// `EnumerateManifests`.
 	GetAllManifests(ctx context.Context) iter.Seq2[tuple[*ManifestMetadata, *Manifest], error]
@@ -151,13 +255,67 @@ type Backend interface {
 	// Thaw a domain. This removes the previously placed administrative lock (if any).
 	UnfreezeDomain(ctx context.Context, domain string) error
 
+	// Append data to a resumable upload, creating it if it does not exist and `offset` is zero.
+	// If `offset` is not equal to the amount of data already uploaded, returns an error wrapping
+	// `ErrPreconditionFailed`. Whether this is racy or not can be determined via `HasAtomicCAS()`.
+	AppendUpload(ctx context.Context, name string, offset int64, data []byte) error
+
+	// Retrieve metadata of a resumable upload.
+	StatUpload(ctx context.Context, name string) (metadata UploadMetadata, err error)
+
+	// Retrieve contents of a resumable upload.
+	GetUpload(ctx context.Context, name string) (reader io.ReadCloser, err error)
+
+	// Delete a resumable upload.
+	DeleteUpload(ctx context.Context, name string) error
+
+	// Iterate through metadata of all resumable uploads.
+	EnumerateUploads(ctx context.Context) iter.Seq2[UploadMetadata, error]
+
+	// Retrieve TLS data (an ACME account key or a certificate). Returns an error wrapping
+	// `ErrObjectNotFound` if no such object exists.
+	GetTLSData(ctx context.Context, name string) (data []byte, err error)
+
+	// Store TLS data, replacing any existing object with the same name.
+	PutTLSData(ctx context.Context, name string, data []byte) error
+
+	// Delete TLS data. Deleting an object that does not exist is not an error.
+	DeleteTLSData(ctx context.Context, name string) error
+
+	// Iterate through names of all TLS data objects.
+	EnumerateTLSData(ctx context.Context) iter.Seq2[string, error]
+
+	// Replace the state of a shared rate limiter with the result of `update`, which is called
+	// with the current state (nil if there is none) and may be called more than once. If `update`
+	// returns nil, the state is left unchanged. Whether this is racy or not can be determined via
+	// `HasAtomicCAS()`.
+	UpdateRateLimit(ctx context.Context, name string, update func(state []byte) []byte) error
+
+	// Retrieve the traffic statistics of a site. Returns an error wrapping `ErrObjectNotFound`
+	// if none have been stored.
+	GetSiteStats(ctx context.Context, webRoot string) (data []byte, err error)
+
+	// Replace the traffic statistics of a site with the result of `update`, with the same
+	// semantics as `UpdateRateLimit`.
+	UpdateSiteStats(ctx context.Context, webRoot string, update func(data []byte) []byte) error
+
 	// Append a record to the audit log.
 	AppendAuditLog(ctx context.Context, id AuditID, record *AuditRecord) error
 
+	// Replace an existing record in the audit log. This is an atomic operation like
+	// `ReplaceBlob`, and is likewise only used to change how the record is stored.
+	ReplaceAuditRecord(ctx context.Context, id AuditID, record *AuditRecord) error
+
 	// Retrieve a single record from the audit log.
 	QueryAuditLog(ctx context.Context, id AuditID) (record *AuditRecord, err error)
 
-	// Retrieve record IDs from the audit log by time range.
+	// Add an audit record to the secondary index used to search the audit log, under each of
+	// `terms` (see `AuditIndexTerms`). Indexing a record again is not an error.
+	IndexAuditRecord(ctx context.Context, id AuditID, terms []string) error
+
+	// Retrieve record IDs from the audit log by time range and other criteria. The criteria
+	// other than time range are looked up in the secondary index if the store has
+	// the `FeatureAuditIndex` feature; otherwise, every record in the time range is decoded.
 	SearchAuditLog(ctx context.Context, opts SearchAuditLogOptions) iter.Seq2[AuditID, error]
 
 	// Retrieve audit record contents for given IDs.
@@ -168,20 +326,68 @@ type Backend interface {
 
 	// Delete an audit record with a given ID.
 	ExpireAuditRecord(ctx context.Context, id AuditID) error
+
+	// Retrieve the head of the chain of audit records made by a node (an `AuditChainHead`).
+	// Returns an error wrapping `ErrObjectNotFound` if none has been stored.
+	GetAuditChainHead(ctx context.Context, node int) (data []byte, err error)
+
+	// Replace the head of the chain of audit records made by a node with the result of `update`,
+	// with the same semantics as `UpdateRateLimit`.
+	UpdateAuditChainHead(ctx context.Context, node int, update func(data []byte) []byte) error
 }
 
-func CreateBackend(ctx context.Context, config *StorageConfig) (backend Backend, err error) {
-	switch config.Type {
+// Looks through the decorators wrapping `backend` (auditing, encryption, observability)
+// for a backend of type `T`.
+func unwrapBackend[T Backend](backend Backend) (T, bool) {
+	for {
+		if found, ok := backend.(T); ok {
+			return found, true
+		}
+		switch decorator := backend.(type) {
+		case *auditedBackend:
+			backend = decorator.Backend
+		case *encryptedBackend:
+			backend = decorator.Backend
+		case *observedBackend:
+			backend = decorator.inner
+		default:
+			var zero T
+			return zero, false
+		}
+	}
+}
+
+func createStorageBackend(
+	ctx context.Context, kind string, fsConfig *FSConfig, s3Config *S3Config,
+) (backend Backend, err error) {
+	switch kind {
 	case "fs":
-		if backend, err = NewFSBackend(ctx, &config.FS); err != nil {
+		if backend, err = NewFSBackend(ctx, fsConfig); err != nil {
 			err = fmt.Errorf("fs backend: %w", err)
 		}
 	case "s3":
-		if backend, err = NewS3Backend(ctx, &config.S3); err != nil {
+		if backend, err = NewS3Backend(ctx, s3Config); err != nil {
 			err = fmt.Errorf("s3 backend: %w", err)
 		}
 	default:
-		err = fmt.Errorf("unknown backend: %s", config.Type)
+		err = fmt.Errorf("unknown backend: %s", kind)
+	}
+	return
+}
+
+func CreateBackend(ctx context.Context, config *StorageConfig) (backend Backend, err error) {
+	switch config.Type {
+	case "replicated":
+		if backend, err = NewReplicatedBackend(ctx, &config.Replicated); err != nil {
+			err = fmt.Errorf("replicated backend: %w", err)
+		}
+	default:
+		backend, err = createStorageBackend(ctx, config.Type, &config.FS, &config.S3)
+	}
+	if err == nil && len(config.Encryption.Keys) > 0 {
+		if backend, err = NewEncryptedBackend(backend, &config.Encryption); err != nil {
+			err = fmt.Errorf("encryption: %w", err)
+		}
 	}
 	backend = NewAuditedBackend(backend)
 	return
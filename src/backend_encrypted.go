@@ -0,0 +1,780 @@
+package git_pages
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+
+	"github.com/kankanreno/go-snowflake"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+// Encrypted blobs start with this prefix, followed by an `EncryptedPayload` message. Blobs that
+// do not start with it were stored before encryption was enabled, and are only returned as-is
+// if `[storage.encryption].allow-plaintext` is enabled.
+const encryptedBlobMagic = "\x00git-pages-encrypted\x00"
+
+// Resumable uploads start with this prefix, followed by the fingerprint of the key they are
+// encrypted with (see `encryptedBackend.AppendUpload`).
+const encryptedUploadMagic = "\x00git-pages-upload\x00"
+
+const encryptedUploadHeaderSize = len(encryptedUploadMagic) + 8
+
+type encryptionKey struct {
+	id          string
+	aead        cipher.AEAD
+	nonceKey    []byte
+	uploadKey   []byte
+	fingerprint []byte
+}
+
+func newEncryptionKey(id string, key []byte) (*encryptionKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key %q: must be 256 bits long", id)
+	}
+	aeadKey, err := hkdf.Key(sha256.New, key, nil, "git-pages aead", 32)
+	if err != nil {
+		return nil, err
+	}
+	nonceKey, err := hkdf.Key(sha256.New, key, nil, "git-pages nonce", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aeadKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	uploadKey, err := hkdf.Key(sha256.New, key, nil, "git-pages upload", 32)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := hkdf.Key(sha256.New, key, nil, "git-pages fingerprint", 8)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionKey{id, aead, nonceKey, uploadKey, fingerprint}, nil
+}
+
+// The nonce is derived from the plaintext, which makes encryption deterministic. This is
+// necessary for staging and then committing a manifest to refer to the same stored object,
+// and only reveals whether two payloads are equal, which content-addressed blob names reveal
+// anyway.
+func (key *encryptionKey) seal(plaintext []byte, additionalData string) *EncryptedPayload {
+	mac := hmac.New(sha256.New, key.nonceKey)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(additionalData))))
+	mac.Write([]byte(additionalData))
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:key.aead.NonceSize()]
+	return &EncryptedPayload{
+		KeyId:      proto.String(key.id),
+		Nonce:      nonce,
+		Ciphertext: key.aead.Seal(nil, nonce, plaintext, []byte(additionalData)),
+	}
+}
+
+// Returns the keystream used to encrypt the resumable upload `name`, starting at `offset`.
+// Upload names include a random identifier and are never reused, so each upload is encrypted
+// with a distinct keystream.
+func (key *encryptionKey) uploadStream(name string, offset int64) (cipher.Stream, error) {
+	material, err := hkdf.Key(sha256.New, key.uploadKey, nil, "upload:"+name, 32+aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(material[:32])
+	if err != nil {
+		return nil, err
+	}
+	// Advance the 128-bit counter to the block containing `offset`.
+	iv := material[32:]
+	low := binary.BigEndian.Uint64(iv[8:])
+	newLow := low + uint64(offset/aes.BlockSize)
+	if newLow < low {
+		binary.BigEndian.PutUint64(iv[:8], binary.BigEndian.Uint64(iv[:8])+1)
+	}
+	binary.BigEndian.PutUint64(iv[8:], newLow)
+	stream := cipher.NewCTR(block, iv)
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	return stream, nil
+}
+
+type encryptedBackend struct {
+	Backend
+	keys           map[string]*encryptionKey
+	currentKey     *encryptionKey
+	allowPlaintext bool
+}
+
+var _ Backend = (*encryptedBackend)(nil)
+
+func NewEncryptedBackend(backend Backend, config *EncryptionConfig) (Backend, error) {
+	encrypted := &encryptedBackend{
+		Backend:        backend,
+		keys:           map[string]*encryptionKey{},
+		allowPlaintext: config.AllowPlaintext,
+	}
+	for _, item := range config.Keys {
+		id, encodedKey, found := strings.Cut(item, ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("malformed key (expected '<key-id>:<base64-key>')")
+		}
+		if _, exists := encrypted.keys[id]; exists {
+			return nil, fmt.Errorf("key %q: specified more than once", id)
+		}
+		rawKey, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		key, err := newEncryptionKey(id, rawKey)
+		if err != nil {
+			return nil, err
+		}
+		encrypted.keys[id] = key
+		if encrypted.currentKey == nil && (config.KeyID == "" || config.KeyID == id) {
+			encrypted.currentKey = key
+		}
+	}
+	if encrypted.currentKey == nil {
+		return nil, fmt.Errorf("key %q: not found", config.KeyID)
+	}
+	return encrypted, nil
+}
+
+func (encrypted *encryptedBackend) seal(plaintext []byte, additionalData string) *EncryptedPayload {
+	return encrypted.currentKey.seal(plaintext, additionalData)
+}
+
+func (encrypted *encryptedBackend) open(
+	payload *EncryptedPayload, additionalData string,
+) (
+	plaintext []byte, err error,
+) {
+	key, found := encrypted.keys[payload.GetKeyId()]
+	if !found {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrDecryptionFailed, payload.GetKeyId())
+	}
+	plaintext, err = key.aead.Open(nil, payload.GetNonce(), payload.GetCiphertext(),
+		[]byte(additionalData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryptionFailed, additionalData)
+	}
+	return
+}
+
+// Returns an error unless unencrypted data may be read (see `[storage.encryption]`).
+func (encrypted *encryptedBackend) checkPlaintext(additionalData string) error {
+	if !encrypted.allowPlaintext {
+		return fmt.Errorf("%w: %s: not encrypted", ErrDecryptionFailed, additionalData)
+	}
+	return nil
+}
+
+func blobAdditionalData(name string) string {
+	return "blob:" + name
+}
+
+func tlsAdditionalData(name string) string {
+	return "tls:" + name
+}
+
+func statsAdditionalData(webRoot string) string {
+	return "stats:" + webRoot
+}
+
+func auditChainAdditionalData(node int) string {
+	return fmt.Sprintf("audit-chain:%d", node)
+}
+
+func auditAdditionalData(id AuditID) string {
+	return "audit:" + id.String()
+}
+
+func auditOutboxAdditionalData(subscriber string, id AuditID) string {
+	return "audit-outbox:" + subscriber + "/" + id.String()
+}
+
+// Manifests are staged before they have a name, so it cannot be used as associated data.
+const manifestAdditionalData = "manifest"
+
+func (encrypted *encryptedBackend) encryptData(data []byte, additionalData string) []byte {
+	payload := encrypted.seal(data, additionalData)
+	result, err := proto.MarshalOptions{Deterministic: true}.
+		MarshalAppend([]byte(encryptedBlobMagic), payload)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func (encrypted *encryptedBackend) decryptData(data []byte, additionalData string) ([]byte, error) {
+	encoded, found := bytes.CutPrefix(data, []byte(encryptedBlobMagic))
+	if !found {
+		return data, encrypted.checkPlaintext(additionalData)
+	}
+	payload := &EncryptedPayload{}
+	if err := proto.Unmarshal(encoded, payload); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrDecryptionFailed, additionalData, err)
+	}
+	return encrypted.open(payload, additionalData)
+}
+
+func (encrypted *encryptedBackend) encryptBlob(name string, data []byte) []byte {
+	return encrypted.encryptData(data, blobAdditionalData(name))
+}
+
+func (encrypted *encryptedBackend) decryptBlob(name string, data []byte) ([]byte, error) {
+	return encrypted.decryptData(data, blobAdditionalData(name))
+}
+
+func (encrypted *encryptedBackend) encryptManifest(manifest *Manifest) *Manifest {
+	if manifest == nil || manifest.Encrypted != nil {
+		return manifest
+	}
+	payload := encrypted.seal(EncodeManifest(manifest), manifestAdditionalData)
+	return &Manifest{Encrypted: payload}
+}
+
+func (encrypted *encryptedBackend) decryptManifest(manifest *Manifest) (*Manifest, error) {
+	if manifest == nil {
+		return nil, nil
+	} else if manifest.Encrypted == nil {
+		return manifest, encrypted.checkPlaintext(manifestAdditionalData)
+	}
+	data, err := encrypted.open(manifest.Encrypted, manifestAdditionalData)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeManifest(data)
+}
+
+// The audit record metadata needed to operate on audit records without decrypting them remains
+// in plaintext. Manifest snapshots are encrypted separately so that they can still be detached.
+func (encrypted *encryptedBackend) encryptAuditRecord(record *AuditRecord) *AuditRecord {
+	if record == nil || record.Encrypted != nil {
+		return record
+	}
+	inner := proto.CloneOf(record)
+	inner.Id, inner.Timestamp, inner.Event = nil, nil, nil
+	inner.Manifest, inner.Manifests = nil, nil
+	outer := &AuditRecord{
+		Id:        record.Id,
+		Timestamp: record.Timestamp,
+		Event:     record.Event,
+		Manifest:  encrypted.encryptManifest(record.Manifest),
+		Encrypted: encrypted.seal(EncodeAuditRecord(inner),
+			auditAdditionalData(record.GetAuditID())),
+	}
+	if record.Manifests != nil {
+		outer.Manifests = map[string]*Manifest{}
+		for project, manifest := range record.Manifests {
+			outer.Manifests[project] = encrypted.encryptManifest(manifest)
+		}
+	}
+	return outer
+}
+
+func (encrypted *encryptedBackend) decryptAuditRecord(record *AuditRecord) (*AuditRecord, error) {
+	if record == nil {
+		return nil, nil
+	} else if record.Encrypted == nil {
+		return record, encrypted.checkPlaintext(auditAdditionalData(record.GetAuditID()))
+	}
+	data, err := encrypted.open(record.Encrypted, auditAdditionalData(record.GetAuditID()))
+	if err != nil {
+		return nil, err
+	}
+	result, err := DecodeAuditRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	result.Id, result.Timestamp, result.Event = record.Id, record.Timestamp, record.Event
+	if result.Manifest, err = encrypted.decryptManifest(record.Manifest); err != nil {
+		return nil, err
+	}
+	if record.Manifests != nil {
+		result.Manifests = map[string]*Manifest{}
+		for project, manifest := range record.Manifests {
+			if result.Manifests[project], err = encrypted.decryptManifest(manifest); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func (encrypted *encryptedBackend) GetBlob(
+	ctx context.Context, name string,
+) (
+	reader io.ReadSeeker, metadata BlobMetadata, err error,
+) {
+	reader, metadata, err = encrypted.Backend.GetBlob(ctx, name)
+	if err != nil {
+		return
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	if data, err = encrypted.decryptBlob(name, data); err != nil {
+		return nil, BlobMetadata{}, err
+	}
+	metadata.Size = int64(len(data))
+	return bytes.NewReader(data), metadata, nil
+}
+
+func (encrypted *encryptedBackend) PutBlob(ctx context.Context, name string, data []byte) error {
+	return encrypted.Backend.PutBlob(ctx, name, encrypted.encryptBlob(name, data))
+}
+
+func (encrypted *encryptedBackend) ReplaceBlob(ctx context.Context, name string, data []byte) error {
+	return encrypted.Backend.ReplaceBlob(ctx, name, encrypted.encryptBlob(name, data))
+}
+
+func (encrypted *encryptedBackend) GetManifest(
+	ctx context.Context, name string, opts GetManifestOptions,
+) (
+	manifest *Manifest, metadata ManifestMetadata, err error,
+) {
+	manifest, metadata, err = encrypted.Backend.GetManifest(ctx, name, opts)
+	if err == nil {
+		manifest, err = encrypted.decryptManifest(manifest)
+	}
+	return
+}
+
+// Manifest shards are stored as encrypted blobs, which the inner backend cannot decode.
+func (encrypted *encryptedBackend) GetManifestShard(ctx context.Context, name string) (*Manifest, error) {
+	return getManifestShardBlob(ctx, encrypted, name)
+}
+
+func (encrypted *encryptedBackend) StageManifest(ctx context.Context, manifest *Manifest) error {
+	return encrypted.Backend.StageManifest(ctx, encrypted.encryptManifest(manifest))
+}
+
+func (encrypted *encryptedBackend) CommitManifest(
+	ctx context.Context, name string, manifest *Manifest, opts ModifyManifestOptions,
+) error {
+	return encrypted.Backend.CommitManifest(ctx, name, encrypted.encryptManifest(manifest), opts)
+}
+
+func (encrypted *encryptedBackend) CommitManifests(ctx context.Context, commits []ManifestCommit) error {
+	encryptedCommits := []ManifestCommit{}
+	for _, commit := range commits {
+		commit.Manifest = encrypted.encryptManifest(commit.Manifest)
+		encryptedCommits = append(encryptedCommits, commit)
+	}
+	return encrypted.Backend.CommitManifests(ctx, encryptedCommits)
+}
+
+func (encrypted *encryptedBackend) GetAllManifests(
+	ctx context.Context,
+) iter.Seq2[tuple[*ManifestMetadata, *Manifest], error] {
+	return func(yield func(tuple[*ManifestMetadata, *Manifest], error) bool) {
+		for item, err := range encrypted.Backend.GetAllManifests(ctx) {
+			if err == nil {
+				var manifest *Manifest
+				if manifest, err = encrypted.decryptManifest(item.B); err == nil {
+					item.B = manifest
+				}
+			}
+			if !yield(item, err) {
+				break
+			}
+		}
+	}
+}
+
+func (encrypted *encryptedBackend) GetTLSData(ctx context.Context, name string) ([]byte, error) {
+	data, err := encrypted.Backend.GetTLSData(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted.decryptData(data, tlsAdditionalData(name))
+}
+
+func (encrypted *encryptedBackend) PutTLSData(ctx context.Context, name string, data []byte) error {
+	return encrypted.Backend.PutTLSData(ctx, name, encrypted.encryptData(data, tlsAdditionalData(name)))
+}
+
+// Returns the key that the resumable upload `name` is encrypted with.
+func (encrypted *encryptedBackend) uploadKey(ctx context.Context, name string) (*encryptionKey, error) {
+	reader, err := encrypted.Backend.GetUpload(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	header := make([]byte, encryptedUploadHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("upload %s: %w", name, err)
+	}
+	if fingerprint, found := bytes.CutPrefix(header, []byte(encryptedUploadMagic)); found {
+		for _, key := range encrypted.keys {
+			if bytes.Equal(key.fingerprint, fingerprint) {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: upload %s: unknown key", ErrDecryptionFailed, name)
+	}
+	// Uploads started before encryption was enabled cannot be continued, and must be restarted.
+	return nil, fmt.Errorf("%w: upload %s: not encrypted", ErrDecryptionFailed, name)
+}
+
+// Resumable uploads are appended to in pieces, and the size of the stored data must correspond
+// to the amount of data received, so they are encrypted with AES-CTR rather than AES-GCM. Unlike
+// other data, they are therefore not authenticated; they are only retained until they are
+// finalized or expire.
+func (encrypted *encryptedBackend) AppendUpload(
+	ctx context.Context, name string, offset int64, data []byte,
+) error {
+	key, err := encrypted.uploadKey(ctx, name)
+	var header []byte
+	if errors.Is(err, ErrObjectNotFound) && offset == 0 {
+		key = encrypted.currentKey
+		header = append([]byte(encryptedUploadMagic), key.fingerprint...)
+	} else if err != nil {
+		return err
+	}
+	stream, err := key.uploadStream(name, offset)
+	if err != nil {
+		return err
+	}
+	ciphertext := make([]byte, len(data))
+	stream.XORKeyStream(ciphertext, data)
+	if header != nil {
+		return encrypted.Backend.AppendUpload(ctx, name, 0, append(header, ciphertext...))
+	}
+	return encrypted.Backend.AppendUpload(ctx, name,
+		offset+int64(encryptedUploadHeaderSize), ciphertext)
+}
+
+func (encrypted *encryptedBackend) StatUpload(ctx context.Context, name string) (UploadMetadata, error) {
+	metadata, err := encrypted.Backend.StatUpload(ctx, name)
+	metadata.Size = max(0, metadata.Size-int64(encryptedUploadHeaderSize))
+	return metadata, err
+}
+
+func (encrypted *encryptedBackend) GetUpload(ctx context.Context, name string) (io.ReadCloser, error) {
+	key, err := encrypted.uploadKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := key.uploadStream(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := encrypted.Backend.GetUpload(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, reader, int64(encryptedUploadHeaderSize)); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("upload %s: %w", name, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{cipher.StreamReader{S: stream, R: reader}, reader}, nil
+}
+
+func (encrypted *encryptedBackend) EnumerateUploads(ctx context.Context) iter.Seq2[UploadMetadata, error] {
+	return func(yield func(UploadMetadata, error) bool) {
+		for metadata, err := range encrypted.Backend.EnumerateUploads(ctx) {
+			metadata.Size = max(0, metadata.Size-int64(encryptedUploadHeaderSize))
+			if !yield(metadata, err) {
+				return
+			}
+		}
+	}
+}
+
+func (encrypted *encryptedBackend) GetSiteStats(ctx context.Context, webRoot string) ([]byte, error) {
+	data, err := encrypted.Backend.GetSiteStats(ctx, webRoot)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted.decryptData(data, statsAdditionalData(webRoot))
+}
+
+func (encrypted *encryptedBackend) UpdateSiteStats(
+	ctx context.Context, webRoot string, update func(data []byte) []byte,
+) error {
+	return encrypted.Backend.UpdateSiteStats(ctx, webRoot, func(data []byte) []byte {
+		if data != nil {
+			var err error
+			data, err = encrypted.decryptData(data, statsAdditionalData(webRoot))
+			if err != nil {
+				// Statistics are not re-encrypted during key rotation; once the key they were
+				// encrypted with is removed, they are collected anew.
+				logc.Printf(ctx, "stats err: %s: %s\n", webRoot, err)
+				data = nil
+			}
+		}
+		if newData := update(data); newData != nil {
+			return encrypted.encryptData(newData, statsAdditionalData(webRoot))
+		}
+		return nil
+	})
+}
+
+func (encrypted *encryptedBackend) AppendAuditLog(
+	ctx context.Context, id AuditID, record *AuditRecord,
+) error {
+	return encrypted.Backend.AppendAuditLog(ctx, id, encrypted.encryptAuditRecord(record))
+}
+
+func (encrypted *encryptedBackend) ReplaceAuditRecord(
+	ctx context.Context, id AuditID, record *AuditRecord,
+) error {
+	return encrypted.Backend.ReplaceAuditRecord(ctx, id, encrypted.encryptAuditRecord(record))
+}
+
+func (encrypted *encryptedBackend) QueryAuditLog(
+	ctx context.Context, id AuditID,
+) (
+	record *AuditRecord, err error,
+) {
+	record, err = encrypted.Backend.QueryAuditLog(ctx, id)
+	if err == nil {
+		record, err = encrypted.decryptAuditRecord(record)
+	}
+	return
+}
+
+// The secondary index of the audit log would reveal the domains, projects, and principals
+// that are encrypted in the audit records, so encrypted audit records are not indexed, and
+// are searched by decrypting each of them instead.
+func (encrypted *encryptedBackend) IndexAuditRecord(
+	ctx context.Context, id AuditID, terms []string,
+) error {
+	return nil
+}
+
+func (encrypted *encryptedBackend) SearchAuditLog(
+	ctx context.Context, opts SearchAuditLogOptions,
+) iter.Seq2[AuditID, error] {
+	timeRange := SearchAuditLogOptions{Since: opts.Since, Until: opts.Until}
+	ids := encrypted.Backend.SearchAuditLog(ctx, timeRange)
+	if len(opts.indexTerms()) == 0 {
+		return ids
+	}
+	return searchAuditLogByDecoding(ctx, encrypted, ids, opts)
+}
+
+func (encrypted *encryptedBackend) GetAuditLogRecords(
+	ctx context.Context, ids iter.Seq2[AuditID, error],
+) iter.Seq2[*AuditRecord, error] {
+	return func(yield func(*AuditRecord, error) bool) {
+		for record, err := range encrypted.Backend.GetAuditLogRecords(ctx, ids) {
+			if err == nil {
+				record, err = encrypted.decryptAuditRecord(record)
+			}
+			if !yield(record, err) {
+				break
+			}
+		}
+	}
+}
+
+func (encrypted *encryptedBackend) GetAuditChainHead(ctx context.Context, node int) ([]byte, error) {
+	data, err := encrypted.Backend.GetAuditChainHead(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted.decryptData(data, auditChainAdditionalData(node))
+}
+
+func (encrypted *encryptedBackend) UpdateAuditChainHead(
+	ctx context.Context, node int, update func(data []byte) []byte,
+) error {
+	return encrypted.Backend.UpdateAuditChainHead(ctx, node, func(data []byte) []byte {
+		if data != nil {
+			var err error
+			data, err = encrypted.decryptData(data, auditChainAdditionalData(node))
+			if err != nil {
+				// Refusing to append audit records would make every audited operation fail;
+				// instead, a new chain is started, which `-audit-verify` reports.
+				logc.Printf(ctx, "audit chain err: %d: %s\n", node, err)
+				data = nil
+			}
+		}
+		if newData := update(data); newData != nil {
+			return encrypted.encryptData(newData, auditChainAdditionalData(node))
+		}
+		return nil
+	})
+}
+
+// Re-encrypts every blob, manifest, audit record, audit chain head, and TLS object that is
+// stored unencrypted or encrypted with a key other than the current one, after which the other
+// keys may be removed. Blobs, audit records, and TLS objects are replaced in place, and manifests
+// (including staged ones) are committed anew, even if their domain is frozen.
+func (encrypted *encryptedBackend) Reencrypt(ctx context.Context) error {
+	isCurrent := func(payload *EncryptedPayload) bool {
+		return payload != nil && payload.GetKeyId() == encrypted.currentKey.id
+	}
+
+	blobCount := 0
+	for metadata, err := range encrypted.Backend.EnumerateBlobs(ctx) {
+		if err != nil {
+			return fmt.Errorf("enum blobs: %w", err)
+		}
+		reader, _, err := encrypted.Backend.GetBlob(ctx, metadata.Name)
+		if err != nil {
+			return fmt.Errorf("get blob %s: %w", metadata.Name, err)
+		}
+		data, err := io.ReadAll(reader)
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("get blob %s: %w", metadata.Name, err)
+		}
+		if encoded, found := bytes.CutPrefix(data, []byte(encryptedBlobMagic)); found {
+			payload := &EncryptedPayload{}
+			if err = proto.Unmarshal(encoded, payload); err == nil && isCurrent(payload) {
+				continue
+			}
+		}
+		if data, err = encrypted.decryptBlob(metadata.Name, data); err != nil {
+			return err
+		}
+		// `PutBlob` does nothing if the blob already exists.
+		if err = encrypted.ReplaceBlob(ctx, metadata.Name, data); err != nil {
+			return fmt.Errorf("replace blob %s: %w", metadata.Name, err)
+		}
+		blobCount += 1
+	}
+	logc.Printf(ctx, "reencrypt: %d blobs", blobCount)
+
+	manifestCount := 0
+	allManifests := func(yield func(*ManifestMetadata, error) bool) {
+		for metadata, err := range encrypted.Backend.EnumerateManifests(ctx) {
+			if !yield(metadata, err) {
+				return
+			}
+		}
+		for metadata, err := range encrypted.Backend.EnumerateStagedManifests(ctx) {
+			if !yield(metadata, err) {
+				return
+			}
+		}
+	}
+	for metadata, err := range allManifests {
+		if err != nil {
+			return fmt.Errorf("enum manifests: %w", err)
+		}
+		name := metadata.Name
+		manifest, stored, err := encrypted.Backend.GetManifest(ctx, name,
+			GetManifestOptions{BypassCache: true})
+		if err != nil {
+			return fmt.Errorf("get manifest %s: %w", name, err)
+		}
+		if isCurrent(manifest.Encrypted) {
+			continue
+		}
+		if manifest, err = encrypted.decryptManifest(manifest); err != nil {
+			return fmt.Errorf("manifest %s: %w", name, err)
+		}
+		if err = encrypted.StageManifest(ctx, manifest); err != nil {
+			return fmt.Errorf("stage manifest %s: %w", name, err)
+		}
+		err = encrypted.CommitManifest(ctx, name, manifest,
+			ModifyManifestOptions{IfMatch: stored.ETag, IgnoreFrozen: true})
+		if err != nil {
+			return fmt.Errorf("commit manifest %s: %w", name, err)
+		}
+		manifestCount += 1
+	}
+	logc.Printf(ctx, "reencrypt: %d manifests", manifestCount)
+
+	auditCount := 0
+	for id, err := range encrypted.Backend.SearchAuditLog(ctx, SearchAuditLogOptions{}) {
+		if err != nil {
+			return fmt.Errorf("search audit log: %w", err)
+		}
+		record, err := encrypted.Backend.QueryAuditLog(ctx, id)
+		if err != nil {
+			return fmt.Errorf("query audit %s: %w", id, err)
+		}
+		current := record.Encrypted != nil && isCurrent(record.Encrypted)
+		for _, manifest := range record.ManifestsByProject() {
+			current = current && isCurrent(manifest.Encrypted)
+		}
+		if current {
+			continue
+		}
+		if record, err = encrypted.decryptAuditRecord(record); err != nil {
+			return fmt.Errorf("audit %s: %w", id, err)
+		}
+		// `AppendAuditLog` refuses to overwrite an existing audit record.
+		if err = encrypted.ReplaceAuditRecord(ctx, id, record); err != nil {
+			return fmt.Errorf("replace audit %s: %w", id, err)
+		}
+		auditCount += 1
+	}
+	logc.Printf(ctx, "reencrypt: %d audit records", auditCount)
+
+	tlsCount := 0
+	for name, err := range encrypted.Backend.EnumerateTLSData(ctx) {
+		if err != nil {
+			return fmt.Errorf("enum tls: %w", err)
+		}
+		data, err := encrypted.Backend.GetTLSData(ctx, name)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue // deleted since it was enumerated
+		} else if err != nil {
+			return fmt.Errorf("get tls %s: %w", name, err)
+		}
+		if encoded, found := bytes.CutPrefix(data, []byte(encryptedBlobMagic)); found {
+			payload := &EncryptedPayload{}
+			if err = proto.Unmarshal(encoded, payload); err == nil && isCurrent(payload) {
+				continue
+			}
+		}
+		if data, err = encrypted.decryptData(data, tlsAdditionalData(name)); err != nil {
+			return fmt.Errorf("tls %s: %w", name, err)
+		}
+		if err = encrypted.PutTLSData(ctx, name, data); err != nil {
+			return fmt.Errorf("put tls %s: %w", name, err)
+		}
+		tlsCount += 1
+	}
+	logc.Printf(ctx, "reencrypt: %d tls objects", tlsCount)
+
+	chainCount := 0
+	for node := range snowflake.MaxMachineID + 1 {
+		found := false
+		err := encrypted.UpdateAuditChainHead(ctx, node, func(data []byte) []byte {
+			found = data != nil
+			return data
+		})
+		if err != nil {
+			return fmt.Errorf("audit chain %d: %w", node, err)
+		} else if found {
+			chainCount += 1
+		}
+	}
+	logc.Printf(ctx, "reencrypt: %d audit chains", chainCount)
+
+	return nil
+}
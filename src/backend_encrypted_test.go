@@ -0,0 +1,170 @@
+package git_pages
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedUpload(t *testing.T) {
+	ctx := context.Background()
+	keys := []string{
+		"2:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+		"1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	}
+	data := bytes.Repeat([]byte("0123456789abcdef-"), 100)
+
+	for _, test := range []struct {
+		name   string
+		pieces []int // sizes of the appended pieces
+		keyID  string
+	}{
+		{"single", []int{len(data)}, "1"},
+		{"aligned", []int{16, 32, len(data) - 48}, "1"},
+		{"unaligned", []int{1, 15, 17, 1000, len(data) - 1033}, "1"},
+		{"rotated", []int{7, len(data) - 7}, "2"},
+	} {
+		fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldBackend, err := NewEncryptedBackend(fsBackend, &EncryptionConfig{Keys: keys, KeyID: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		newBackend, err := NewEncryptedBackend(fsBackend, &EncryptionConfig{Keys: keys, KeyID: test.keyID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := "example.org/project/UPLOAD"
+		if err := oldBackend.AppendUpload(ctx, name, 0, nil); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		offset := 0
+		for _, size := range test.pieces {
+			if metadata, err := newBackend.StatUpload(ctx, name); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			} else if metadata.Size != int64(offset) {
+				t.Errorf("%s: expect size %d, got %d", test.name, offset, metadata.Size)
+			}
+			if err := newBackend.AppendUpload(ctx, name, int64(offset), data[offset:offset+size]); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			offset += size
+		}
+		if err := newBackend.AppendUpload(ctx, name, 1, data[:1]); !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("%s: expect err %s, got err %v", test.name, ErrPreconditionFailed, err)
+		}
+
+		reader, err := fsBackend.GetUpload(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, _ := io.ReadAll(reader)
+		reader.Close()
+		if bytes.Contains(stored, data[:16]) {
+			t.Errorf("%s: expect upload to be stored encrypted", test.name)
+		}
+
+		reader, err = newBackend.GetUpload(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		result, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(result, data) {
+			t.Errorf("%s: expect upload to round-trip, got %d bytes, err %v", test.name, len(result), err)
+		}
+	}
+}
+
+func TestReencryptTLSData(t *testing.T) {
+	ctx := context.Background()
+	oldKey := "1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := "2:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+
+	fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldBackend, err := NewEncryptedBackend(fsBackend, &EncryptionConfig{Keys: []string{oldKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatingBackend, err := NewEncryptedBackend(fsBackend,
+		&EncryptionConfig{Keys: []string{oldKey, newKey}, KeyID: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBackend, err := NewEncryptedBackend(fsBackend, &EncryptionConfig{Keys: []string{newKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"acme_account+key", "example.org", "example.org+rsa"}
+	for _, name := range names {
+		if err := oldBackend.PutTLSData(ctx, name, []byte("data for "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rotatingBackend.(*encryptedBackend).Reencrypt(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if data, err := newBackend.GetTLSData(ctx, name); err != nil {
+			t.Errorf("%s: expect ok, got err %s", name, err)
+		} else if string(data) != "data for "+name {
+			t.Errorf("%s: expect data for %s, got %q", name, name, data)
+		}
+	}
+}
+
+func TestEncryptedAuditOutbox(t *testing.T) {
+	ctx := context.Background()
+	key := "1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	savedBackend := backend
+	defer func() { backend = savedBackend }()
+
+	fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err = NewEncryptedBackend(fsBackend, &EncryptionConfig{Keys: []string{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox, id, body := t.TempDir(), AuditID(1), []byte(`{"event":"CommitManifest"}`)
+	if err := saveAuditOutboxEntry(outbox, "subscriber", id, body); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(filepath.Join(outbox, "subscriber", auditOutboxEntryName(id)))
+	if err != nil {
+		t.Fatal(err)
+	} else if bytes.Contains(stored, body) {
+		t.Errorf("expect notification to be stored encrypted")
+	}
+	if result, err := loadAuditOutboxEntry(outbox, "subscriber", id); err != nil {
+		t.Errorf("expect ok, got err %s", err)
+	} else if !bytes.Equal(result, body) {
+		t.Errorf("expect %q, got %q", body, result)
+	}
+	// The notification is bound to its subscriber, and cannot be moved to another one.
+	if err := os.MkdirAll(filepath.Join(outbox, "other"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(outbox, "other", auditOutboxEntryName(id)), stored, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAuditOutboxEntry(outbox, "other", id); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("other: expect err %s, got err %v", ErrDecryptionFailed, err)
+	}
+}
@@ -10,21 +10,60 @@ import (
 	"iter"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	sys "codeberg.org/git-pages/git-pages/src/sys"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
 )
 
 type FSBackend struct {
 	blobRoot     *os.Root
 	siteRoot     *os.Root
 	auditRoot    *os.Root
+	indexRoot    *os.Root
+	uploadRoot   *os.Root
+	tlsRoot      *os.Root
+	limitRoot    *os.Root
+	statsRoot    *os.Root
+	chainRoot    *os.Root
 	hasAtomicCAS bool
+
+	blobReads     singleflight.Group
+	manifestReads singleflight.Group
 }
 
 var _ Backend = (*FSBackend)(nil)
 
+var (
+	fsBlobCoalescedCount     prometheus.Counter
+	fsManifestCoalescedCount prometheus.Counter
+)
+
+var initFSBackendMetricsOnce sync.Once
+
+// Several filesystem backends may be created when replication is configured, but the metrics
+// are shared between them.
+func initFSBackendMetrics() {
+	initFSBackendMetricsOnce.Do(registerFSBackendMetrics)
+}
+
+func registerFSBackendMetrics() {
+	fsBlobCoalescedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_fs_blob_coalesced_count",
+		Help: "Count of blobs that were already being read by a concurrent request",
+	})
+	fsManifestCoalescedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_fs_manifest_coalesced_count",
+		Help: "Count of manifests that were already being read by a concurrent request",
+	})
+}
+
 func maybeCreateOpenRoot(dir string, name string) (*os.Root, error) {
 	dirName := filepath.Join(dir, name)
 
@@ -86,23 +125,71 @@ func NewFSBackend(ctx context.Context, config *FSConfig) (*FSBackend, error) {
 	if err != nil {
 		return nil, fmt.Errorf("audit: %w", err)
 	}
+	indexRoot, err := maybeCreateOpenRoot(config.Root, "audit-index")
+	if err != nil {
+		return nil, fmt.Errorf("audit index: %w", err)
+	}
+	uploadRoot, err := maybeCreateOpenRoot(config.Root, "upload")
+	if err != nil {
+		return nil, fmt.Errorf("upload: %w", err)
+	}
+	tlsRoot, err := maybeCreateOpenRoot(config.Root, "tls")
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	limitRoot, err := maybeCreateOpenRoot(config.Root, "ratelimit")
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: %w", err)
+	}
+	statsRoot, err := maybeCreateOpenRoot(config.Root, "stats")
+	if err != nil {
+		return nil, fmt.Errorf("stats: %w", err)
+	}
+	chainRoot, err := maybeCreateOpenRoot(config.Root, "audit-chain")
+	if err != nil {
+		return nil, fmt.Errorf("audit chain: %w", err)
+	}
 	hasAtomicCAS := checkAtomicCAS(siteRoot)
 	if hasAtomicCAS {
 		logc.Println(ctx, "fs: has atomic CAS")
 	} else {
 		logc.Println(ctx, "fs: has best-effort CAS")
 	}
-	return &FSBackend{blobRoot, siteRoot, auditRoot, hasAtomicCAS}, nil
+
+	initFSBackendMetrics()
+
+	return &FSBackend{
+		blobRoot:     blobRoot,
+		siteRoot:     siteRoot,
+		auditRoot:    auditRoot,
+		indexRoot:    indexRoot,
+		uploadRoot:   uploadRoot,
+		tlsRoot:      tlsRoot,
+		limitRoot:    limitRoot,
+		statsRoot:    statsRoot,
+		chainRoot:    chainRoot,
+		hasAtomicCAS: hasAtomicCAS,
+	}, nil
 }
 
 func (fs *FSBackend) Backend() Backend {
 	return fs
 }
 
+// Marks the secondary index of the audit log as containing every audit record.
+const auditIndexCompleteName = ".complete"
+const auditIndexRebuildName = ".rebuild"
+
 func (fs *FSBackend) HasFeature(ctx context.Context, feature BackendFeature) bool {
 	switch feature {
 	case FeatureCheckDomainMarker:
 		return true
+	case FeatureAuditIndex:
+		_, err := fs.indexRoot.Stat(auditIndexCompleteName)
+		return err == nil
+	case FeatureAuditIndexRebuild:
+		_, err := fs.indexRoot.Stat(auditIndexRebuildName)
+		return err == nil
 	default:
 		return false
 	}
@@ -112,36 +199,133 @@ func (fs *FSBackend) EnableFeature(ctx context.Context, feature BackendFeature)
 	switch feature {
 	case FeatureCheckDomainMarker:
 		return nil
+	case FeatureAuditIndex:
+		return fs.indexRoot.WriteFile(auditIndexCompleteName, []byte{}, 0o644)
+	case FeatureAuditIndexRebuild:
+		return fs.indexRoot.WriteFile(auditIndexRebuildName, []byte{}, 0o644)
 	default:
 		return fmt.Errorf("not implemented")
 	}
 }
 
+func (fs *FSBackend) DisableFeature(ctx context.Context, feature BackendFeature) error {
+	switch feature {
+	case FeatureAuditIndex, FeatureAuditIndexRebuild:
+		name := auditIndexCompleteName
+		if feature == FeatureAuditIndexRebuild {
+			name = auditIndexRebuildName
+		}
+		err := fs.indexRoot.Remove(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("not implemented")
+	}
+}
+
+// A blob file opened by one of several concurrent `GetBlob` calls and shared between them. Each
+// caller reads the file through its own `io.SectionReader`, and the file is closed once every
+// reader is closed. If the file has been closed before a caller gets to use it, it is reopened.
+type fsBlobHandle struct {
+	root     *os.Root
+	path     string
+	mutex    sync.Mutex
+	file     *os.File
+	metadata BlobMetadata
+	readers  int
+}
+
+type fsBlobReader struct {
+	*io.SectionReader
+	handle *fsBlobHandle
+	closed bool
+}
+
+func (reader *fsBlobReader) Close() error {
+	if reader.closed {
+		return nil
+	}
+	reader.closed = true
+	return reader.handle.release()
+}
+
+func (fs *FSBackend) openBlob(name string) (*fsBlobHandle, error) {
+	handle := &fsBlobHandle{root: fs.blobRoot, path: filepath.Join(splitBlobName(name)...)}
+	handle.metadata.Name = name
+	if err := handle.open(); err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+func (handle *fsBlobHandle) open() error {
+	file, err := handle.root.Open(handle.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, err.(*os.PathError).Path)
+	} else if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat: %w", err)
+	}
+	handle.file = file
+	handle.metadata.Size, handle.metadata.LastModified = stat.Size(), stat.ModTime()
+	return nil
+}
+
+func (handle *fsBlobHandle) acquire() (*fsBlobReader, BlobMetadata, error) {
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+	if handle.file == nil {
+		if err := handle.open(); err != nil {
+			return nil, BlobMetadata{}, err
+		}
+	}
+	handle.readers += 1
+	reader := io.NewSectionReader(handle.file, 0, handle.metadata.Size)
+	return &fsBlobReader{SectionReader: reader, handle: handle}, handle.metadata, nil
+}
+
+func (handle *fsBlobHandle) release() (err error) {
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+	handle.readers -= 1
+	if handle.readers == 0 {
+		err = handle.file.Close()
+		handle.file = nil
+	}
+	return
+}
+
 func (fs *FSBackend) GetBlob(
 	ctx context.Context, name string,
 ) (
 	reader io.ReadSeeker, metadata BlobMetadata, err error,
 ) {
-	blobPath := filepath.Join(splitBlobName(name)...)
-	stat, err := fs.blobRoot.Stat(blobPath)
-	if errors.Is(err, os.ErrNotExist) {
-		err = fmt.Errorf("%w: %s", ErrObjectNotFound, err.(*os.PathError).Path)
-		return
-	} else if err != nil {
-		err = fmt.Errorf("stat: %w", err)
+	// Like manifests, the blobs of a popular site are requested by many clients at once; since
+	// blobs are immutable, the requests share a single open file, which they read concurrently.
+	result, err, shared := fs.blobReads.Do(name, func() (any, error) {
+		return fs.openBlob(name)
+	})
+	if shared {
+		fsBlobCoalescedCount.Inc()
+	}
+	if err != nil {
 		return
 	}
-	file, err := fs.blobRoot.Open(blobPath)
+	blobReader, metadata, err := result.(*fsBlobHandle).acquire()
 	if err != nil {
-		err = fmt.Errorf("open: %w", err)
 		return
 	}
-	return file, BlobMetadata{name, int64(stat.Size()), stat.ModTime()}, nil
+	return blobReader, metadata, nil
 }
 
 func (fs *FSBackend) PutBlob(ctx context.Context, name string, data []byte) error {
 	blobPath := filepath.Join(splitBlobName(name)...)
-	blobDir := filepath.Dir(blobPath)
 
 	if _, err := fs.blobRoot.Stat(blobPath); err == nil {
 		// Blob already exists. While on Linux it would be benign to write and replace a blob
@@ -149,6 +333,18 @@ func (fs *FSBackend) PutBlob(ctx context.Context, name string, data []byte) erro
 		return nil
 	}
 
+	return fs.storeBlob(name, data)
+}
+
+func (fs *FSBackend) ReplaceBlob(ctx context.Context, name string, data []byte) error {
+	return fs.storeBlob(name, data)
+}
+
+// Writes a blob to a temporary file and renames it into place, replacing any existing blob.
+func (fs *FSBackend) storeBlob(name string, data []byte) error {
+	blobPath := filepath.Join(splitBlobName(name)...)
+	blobDir := filepath.Dir(blobPath)
+
 	tempPath, err := createTempInRoot(fs.blobRoot, name, data)
 	if err != nil {
 		return err
@@ -216,10 +412,37 @@ func (fs *FSBackend) EnumerateBlobs(ctx context.Context) iter.Seq2[BlobMetadata,
 	}
 }
 
+type fsManifestResult struct {
+	manifest *Manifest
+	metadata ManifestMetadata
+}
+
 func (fs *FSBackend) GetManifest(
 	ctx context.Context, name string, opts GetManifestOptions,
 ) (
 	manifest *Manifest, metadata ManifestMetadata, err error,
+) {
+	// Reading and decoding a large manifest is relatively expensive, and a burst of requests
+	// to a popular site will all need the same manifest at once, so they share a single read.
+	result, err, shared := fs.manifestReads.Do(name, func() (any, error) {
+		manifest, metadata, err := fs.readManifest(name)
+		return fsManifestResult{manifest, metadata}, err
+	})
+	if shared {
+		fsManifestCoalescedCount.Inc()
+	}
+	if err != nil {
+		return
+	}
+	return result.(fsManifestResult).manifest, result.(fsManifestResult).metadata, nil
+}
+
+func (fs *FSBackend) GetManifestShard(ctx context.Context, name string) (*Manifest, error) {
+	return getManifestShardBlob(ctx, fs, name)
+}
+
+func (fs *FSBackend) readManifest(name string) (
+	manifest *Manifest, metadata ManifestMetadata, err error,
 ) {
 	stat, err := fs.siteRoot.Stat(name)
 	if errors.Is(err, os.ErrNotExist) {
@@ -351,7 +574,9 @@ func (fs *FSBackend) CommitManifest(
 	}
 
 	domain := filepath.Dir(name)
-	if err := fs.checkDomainFrozen(ctx, domain); err != nil {
+	if opts.IgnoreFrozen {
+		// skip check
+	} else if err := fs.checkDomainFrozen(ctx, domain); err != nil {
 		return err
 	}
 
@@ -377,6 +602,101 @@ func (fs *FSBackend) CommitManifest(
 	return nil
 }
 
+func (fs *FSBackend) CommitManifests(ctx context.Context, commits []ManifestCommit) error {
+	domain, err := manifestCommitsDomain(commits)
+	if err != nil {
+		return err
+	}
+
+	if fs.hasAtomicCAS {
+		// Always acquire the locks in the same order to avoid deadlocks between transactions.
+		names := []string{}
+		for _, commit := range commits {
+			names = append(names, commit.Name)
+		}
+		slices.Sort(names)
+		for _, name := range slices.Compact(names) {
+			if guard, err := lockManifest(fs.siteRoot, name); err != nil {
+				return err
+			} else {
+				defer guard.Unlock()
+			}
+		}
+	}
+
+	if err := fs.checkDomainFrozen(ctx, domain); err != nil {
+		return err
+	}
+
+	// Check every precondition before making any changes, so that a conflict aborts
+	// the entire transaction.
+	oldManifestsData := make([][]byte, len(commits))
+	for index, commit := range commits {
+		if err := fs.checkManifestPrecondition(ctx, commit.Name, commit.Options); err != nil {
+			return err
+		}
+
+		manifestData := EncodeManifest(commit.Manifest)
+		if _, err := fs.siteRoot.Stat(stagedManifestName(manifestData)); err != nil {
+			return fmt.Errorf("manifest not staged")
+		}
+
+		oldManifestData, err := fs.siteRoot.ReadFile(commit.Name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("read: %w", err)
+		}
+		oldManifestsData[index] = oldManifestData
+	}
+
+	if err := fs.siteRoot.MkdirAll(domain, 0o755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	// If moving one of the manifests into place fails, restore the ones that were already
+	// moved to their previous state, and report the ones that could not be restored.
+	rollback := func(count int, commitErr error) error {
+		var committed []string
+		for index := range count {
+			name, oldManifestData := commits[index].Name, oldManifestsData[index]
+			var err error
+			if oldManifestData == nil {
+				err = fs.siteRoot.Remove(name)
+			} else {
+				var tempPath string
+				tempPath, err = createTempInRoot(fs.siteRoot, ".manifest", oldManifestData)
+				if err == nil {
+					err = fs.siteRoot.Rename(tempPath, name)
+				}
+			}
+			if err != nil {
+				logc.Printf(ctx, "fs: rollback %s err: %s\n", name, err)
+				committed = append(committed, name)
+			}
+		}
+		if len(committed) > 0 {
+			return &PartialCommitError{Committed: committed, Total: len(commits), Err: commitErr}
+		}
+		return commitErr
+	}
+	for index, commit := range commits {
+		// Several sites may have identical manifests, so the staged manifest is copied rather
+		// than moved into place.
+		tempPath, err := createTempInRoot(fs.siteRoot, ".manifest", EncodeManifest(commit.Manifest))
+		if err != nil {
+			return rollback(index, err)
+		}
+		if err := fs.siteRoot.Rename(tempPath, commit.Name); err != nil {
+			fs.siteRoot.Remove(tempPath)
+			return rollback(index, fmt.Errorf("rename: %w", err))
+		}
+	}
+	for _, commit := range commits {
+		fs.siteRoot.Remove(stagedManifestName(EncodeManifest(commit.Manifest)))
+	}
+
+	return nil
+}
+
 func (fs *FSBackend) DeleteManifest(
 	ctx context.Context, name string, opts ModifyManifestOptions,
 ) error {
@@ -412,6 +732,16 @@ func (fs *FSBackend) ExpireManifest(
 }
 
 func (fs *FSBackend) EnumerateManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error] {
+	return fs.enumerateManifests(ctx, false)
+}
+
+func (fs *FSBackend) EnumerateStagedManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error] {
+	return fs.enumerateManifests(ctx, true)
+}
+
+func (fs *FSBackend) enumerateManifests(
+	ctx context.Context, staged bool,
+) iter.Seq2[*ManifestMetadata, error] {
 	return func(yield func(*ManifestMetadata, error) bool) {
 		iofs.WalkDir(fs.siteRoot.FS(), ".",
 			func(path string, entry iofs.DirEntry, err error) error {
@@ -422,7 +752,11 @@ func (fs *FSBackend) EnumerateManifests(ctx context.Context) iter.Seq2[*Manifest
 				} else if entry.IsDir() {
 					// skip directory
 					return nil
-				} else if project == "" || strings.HasPrefix(project, ".") && project != ".index" {
+				} else if staged != isStagedProjectName(project) {
+					// skip staged or deployed
+					return nil
+				} else if !staged &&
+					(project == "" || strings.HasPrefix(project, ".") && project != ".index") {
 					// skip internal
 					return nil
 				} else if info, err := entry.Info(); err != nil {
@@ -492,6 +826,233 @@ func (fs *FSBackend) HasSiteListChanged(ctx context.Context, since time.Time) (b
 	return true, time.Time{}, nil // not implemented
 }
 
+func (fs *FSBackend) AppendUpload(ctx context.Context, name string, offset int64, data []byte) error {
+	if err := fs.uploadRoot.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	flags := os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_CREATE
+	}
+	file, err := fs.uploadRoot.OpenFile(name, flags, 0o644)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	} else if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer file.Close()
+
+	if fs.hasAtomicCAS {
+		if err := sys.FileLock(file); err != nil {
+			return fmt.Errorf("flock(LOCK_EX): %w", err)
+		}
+		defer sys.FileUnlock(file)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+	if stat.Size() != offset {
+		return fmt.Errorf("%w: offset %d, expected %d", ErrPreconditionFailed, offset, stat.Size())
+	}
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+func (fs *FSBackend) StatUpload(ctx context.Context, name string) (UploadMetadata, error) {
+	stat, err := fs.uploadRoot.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return UploadMetadata{}, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	} else if err != nil {
+		return UploadMetadata{}, fmt.Errorf("stat: %w", err)
+	}
+	return UploadMetadata{name, stat.Size(), stat.ModTime()}, nil
+}
+
+func (fs *FSBackend) GetUpload(ctx context.Context, name string) (io.ReadCloser, error) {
+	file, err := fs.uploadRoot.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	return file, nil
+}
+
+func (fs *FSBackend) DeleteUpload(ctx context.Context, name string) error {
+	err := fs.uploadRoot.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (fs *FSBackend) EnumerateUploads(ctx context.Context) iter.Seq2[UploadMetadata, error] {
+	return func(yield func(UploadMetadata, error) bool) {
+		iofs.WalkDir(fs.uploadRoot.FS(), ".",
+			func(path string, entry iofs.DirEntry, err error) error {
+				var metadata UploadMetadata
+				if err != nil {
+					// report error
+				} else if entry.IsDir() {
+					// skip directory
+					return nil
+				} else if info, err := entry.Info(); err != nil {
+					// report error
+				} else {
+					// report upload
+					metadata = UploadMetadata{path, info.Size(), info.ModTime()}
+				}
+				if !yield(metadata, err) {
+					return iofs.SkipAll
+				}
+				return nil
+			})
+	}
+}
+
+func (fs *FSBackend) GetTLSData(ctx context.Context, name string) ([]byte, error) {
+	data, err := fs.tlsRoot.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return data, nil
+}
+
+func (fs *FSBackend) PutTLSData(ctx context.Context, name string, data []byte) error {
+	tempPath, err := createTempInRoot(fs.tlsRoot, ".tls", data)
+	if err != nil {
+		return err
+	}
+	if err := fs.tlsRoot.Chmod(tempPath, 0o600); err != nil {
+		return fmt.Errorf("chmod: %w", err)
+	}
+	if err := fs.tlsRoot.Rename(tempPath, name); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+func (fs *FSBackend) DeleteTLSData(ctx context.Context, name string) error {
+	err := fs.tlsRoot.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (fs *FSBackend) EnumerateTLSData(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		entries, err := iofs.ReadDir(fs.tlsRoot.FS(), ".")
+		if err != nil {
+			yield("", err)
+			return
+		}
+		for _, entry := range entries {
+			// skip directories and temporary files written by `PutTLSData`
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if !yield(entry.Name(), nil) {
+				return
+			}
+		}
+	}
+}
+
+// Replaces the contents of a file with the result of `update`, locking the file while it
+// is being updated if the filesystem supports it.
+func (fs *FSBackend) updateFile(
+	root *os.Root, name string, update func(data []byte) []byte,
+) error {
+	if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	file, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer file.Close()
+
+	if fs.hasAtomicCAS {
+		if err := sys.FileLock(file); err != nil {
+			return fmt.Errorf("flock(LOCK_EX): %w", err)
+		}
+		defer sys.FileUnlock(file)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if len(data) == 0 {
+		data = nil
+	}
+	if newData := update(data); newData != nil {
+		if err := file.Truncate(0); err != nil {
+			return fmt.Errorf("truncate: %w", err)
+		}
+		if _, err := file.WriteAt(newData, 0); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+	return nil
+}
+
+func (fs *FSBackend) UpdateRateLimit(
+	ctx context.Context, name string, update func(state []byte) []byte,
+) error {
+	return fs.updateFile(fs.limitRoot, name, update)
+}
+
+// Reads the contents of a file written by `updateFile`. An empty file is treated as missing.
+func (fs *FSBackend) readFile(root *os.Root, name string) ([]byte, error) {
+	file, err := root.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer file.Close()
+
+	// Wait for a concurrent update to finish instead of reading a partially written file.
+	if fs.hasAtomicCAS {
+		if err := sys.FileLock(file); err != nil {
+			return nil, fmt.Errorf("flock(LOCK_EX): %w", err)
+		}
+		defer sys.FileUnlock(file)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	} else if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	}
+	return data, nil
+}
+
+func (fs *FSBackend) GetSiteStats(ctx context.Context, webRoot string) ([]byte, error) {
+	return fs.readFile(fs.statsRoot, webRoot)
+}
+
+func (fs *FSBackend) UpdateSiteStats(
+	ctx context.Context, webRoot string, update func(data []byte) []byte,
+) error {
+	return fs.updateFile(fs.statsRoot, webRoot, update)
+}
+
 func auditDetachedName(id AuditID) string {
 	return fmt.Sprintf("%s.detached", id)
 }
@@ -504,6 +1065,24 @@ func (fs *FSBackend) AppendAuditLog(ctx context.Context, id AuditID, record *Aud
 	return fs.auditRoot.WriteFile(id.String(), EncodeAuditRecord(record), 0o444)
 }
 
+func (fs *FSBackend) ReplaceAuditRecord(ctx context.Context, id AuditID, record *AuditRecord) error {
+	if _, err := fs.auditRoot.Stat(id.String()); err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	tempPath, err := createTempInRoot(fs.auditRoot, ".audit", EncodeAuditRecord(record))
+	if err != nil {
+		return err
+	}
+	if err := fs.auditRoot.Chmod(tempPath, 0o444); err != nil && !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("chmod: %w", err)
+	}
+	if err := fs.auditRoot.Rename(tempPath, id.String()); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
 func (fs *FSBackend) QueryAuditLog(ctx context.Context, id AuditID) (*AuditRecord, error) {
 	if data, err := fs.auditRoot.ReadFile(id.String()); err != nil {
 		return nil, fmt.Errorf("read: %w", err)
@@ -511,7 +1090,7 @@ func (fs *FSBackend) QueryAuditLog(ctx context.Context, id AuditID) (*AuditRecor
 		return nil, fmt.Errorf("decode: %w", err)
 	} else {
 		if _, err := fs.auditRoot.Stat(auditDetachedName(id)); err == nil {
-			record.Manifest = nil
+			record.Detach()
 		} else if !errors.Is(err, os.ErrNotExist) {
 			return nil, fmt.Errorf("stat detached marker: %w", err)
 		}
@@ -519,10 +1098,36 @@ func (fs *FSBackend) QueryAuditLog(ctx context.Context, id AuditID) (*AuditRecor
 	}
 }
 
+// The index entries of each audit record are listed in a file named after the audit record,
+// so that they can be removed together with it.
+func auditIndexEntriesName(id AuditID) string {
+	return filepath.Join("by-id", id.String())
+}
+
+func (fs *FSBackend) IndexAuditRecord(ctx context.Context, id AuditID, terms []string) error {
+	var termNames []string
+	for _, term := range terms {
+		termName := auditIndexTermName(term)
+		if err := fs.indexRoot.MkdirAll(termName, 0o755); err != nil {
+			return fmt.Errorf("mkdir: %w", err)
+		}
+		if err := fs.indexRoot.WriteFile(filepath.Join(termName, id.String()),
+			[]byte{}, 0o644); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		termNames = append(termNames, termName)
+	}
+	return fs.updateFile(fs.indexRoot, auditIndexEntriesName(id), func(data []byte) []byte {
+		termNames = append(termNames, strings.Fields(string(data))...)
+		slices.Sort(termNames)
+		return []byte(strings.Join(slices.Compact(termNames), "\n") + "\n")
+	})
+}
+
 func (fs *FSBackend) SearchAuditLog(
 	ctx context.Context, opts SearchAuditLogOptions,
 ) iter.Seq2[AuditID, error] {
-	return func(yield func(AuditID, error) bool) {
+	ids := func(yield func(AuditID, error) bool) {
 		iofs.WalkDir(fs.auditRoot.FS(), ".",
 			func(path string, entry iofs.DirEntry, err error) error {
 				if path == "." {
@@ -535,9 +1140,7 @@ func (fs *FSBackend) SearchAuditLog(
 					return nil // skip
 				} else if id, err = ParseAuditID(path); err != nil {
 					// report error
-				} else if !opts.Since.IsZero() && id.CompareTime(opts.Since) < 0 {
-					return nil // skip
-				} else if !opts.Until.IsZero() && id.CompareTime(opts.Until) > 0 {
+				} else if !opts.inTimeRange(id) {
 					return nil // skip
 				}
 				if !yield(id, err) {
@@ -547,6 +1150,28 @@ func (fs *FSBackend) SearchAuditLog(
 				}
 			})
 	}
+	if len(opts.indexTerms()) == 0 {
+		return ids
+	} else if !fs.HasFeature(ctx, FeatureAuditIndex) {
+		return searchAuditLogByDecoding(ctx, fs, ids, opts)
+	}
+	return searchAuditIndex(opts, func(termName string) iter.Seq2[AuditID, error] {
+		return func(yield func(AuditID, error) bool) {
+			entries, err := iofs.ReadDir(fs.indexRoot.FS(), termName)
+			if errors.Is(err, os.ErrNotExist) {
+				return
+			} else if err != nil {
+				yield(0, fmt.Errorf("readdir: %w", err))
+				return
+			}
+			for _, entry := range entries {
+				id, err := ParseAuditID(entry.Name())
+				if !yield(id, err) {
+					return
+				}
+			}
+		}
+	})
 }
 
 func (fs *FSBackend) GetAuditLogRecords(
@@ -570,5 +1195,33 @@ func (fs *FSBackend) DetachAuditRecord(ctx context.Context, id AuditID) error {
 }
 
 func (fs *FSBackend) ExpireAuditRecord(ctx context.Context, id AuditID) error {
+	data, err := fs.indexRoot.ReadFile(auditIndexEntriesName(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read: %w", err)
+	}
+	for _, termName := range strings.Fields(string(data)) {
+		err := fs.indexRoot.Remove(filepath.Join(termName, id.String()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove: %w", err)
+		}
+	}
+	err = fs.indexRoot.Remove(auditIndexEntriesName(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove: %w", err)
+	}
 	return fs.auditRoot.Remove(id.String())
 }
+
+func auditChainHeadName(node int) string {
+	return strconv.Itoa(node)
+}
+
+func (fs *FSBackend) GetAuditChainHead(ctx context.Context, node int) ([]byte, error) {
+	return fs.readFile(fs.chainRoot, auditChainHeadName(node))
+}
+
+func (fs *FSBackend) UpdateAuditChainHead(
+	ctx context.Context, node int, update func(data []byte) []byte,
+) error {
+	return fs.updateFile(fs.chainRoot, auditChainHeadName(node), update)
+}
@@ -0,0 +1,52 @@
+package git_pages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestFSGetBlob(t *testing.T) {
+	ctx := context.Background()
+	fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("blob contents "), 1000)
+	name := "sha256-" + string(bytes.Repeat([]byte("ab"), 32))
+	if err := fsBackend.PutBlob(ctx, name, data); err != nil {
+		t.Fatal(err)
+	}
+
+	missingName := "sha256-" + string(bytes.Repeat([]byte("cd"), 32))
+	if _, _, err := fsBackend.GetBlob(ctx, missingName); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("missing: expect err %s, got err %v", ErrObjectNotFound, err)
+	}
+
+	// Readers obtained concurrently may share an open file, and must not affect each other.
+	var wait sync.WaitGroup
+	for index := range 16 {
+		wait.Go(func() {
+			reader, metadata, err := fsBackend.GetBlob(ctx, name)
+			if err != nil {
+				t.Errorf("%d: expect ok, got err %s", index, err)
+				return
+			}
+			defer reader.(io.Closer).Close()
+			if metadata.Size != int64(len(data)) {
+				t.Errorf("%d: expect size %d, got %d", index, len(data), metadata.Size)
+			}
+			if _, err := reader.Seek(int64(index), io.SeekStart); err != nil {
+				t.Errorf("%d: expect ok, got err %s", index, err)
+				return
+			}
+			if result, err := io.ReadAll(reader); err != nil || !bytes.Equal(result, data[index:]) {
+				t.Errorf("%d: expect contents, got %d bytes, err %v", index, len(result), err)
+			}
+		})
+	}
+	wait.Wait()
+}
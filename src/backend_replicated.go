@@ -0,0 +1,973 @@
+package git_pages
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	replicaReadFallbacksCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "git_pages_replica_read_fallbacks_count",
+		Help: "Count of reads from a replica that failed and were retried on another replica",
+	}, []string{"replica"})
+	replicaWriteFailuresCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "git_pages_replica_write_failures_count",
+		Help: "Count of writes to a replica that failed",
+	}, []string{"replica"})
+)
+
+// Latency added to the estimate for a replica each time a read from it fails, so that
+// the "nearest" read policy moves away from replicas that are down.
+const replicaFailurePenalty = 5 * time.Second
+
+type replica struct {
+	name    string
+	backend Backend
+
+	mu      sync.Mutex
+	latency time.Duration // exponentially weighted moving average
+}
+
+func (r *replica) observe(duration time.Duration, err error) {
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		duration += replicaFailurePenalty
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.latency == 0 {
+		r.latency = duration
+	} else {
+		r.latency = (r.latency*4 + duration) / 5
+	}
+}
+
+func (r *replica) estimatedLatency() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latency
+}
+
+// A backend that stores the same data in several other backends. Writes are made to every
+// replica, and succeed according to the write policy; reads are made from one replica and
+// fall back to the others if it fails.
+//
+// Manifests are compared by content rather than by the ETag returned from a replica, since
+// each replica computes its own ETags. Conditional manifest updates are checked against
+// the first available replica (the coordinator) and then applied unconditionally to the rest.
+type replicatedBackend struct {
+	replicas    []*replica
+	writeQuorum int
+	readNearest bool
+}
+
+var _ Backend = (*replicatedBackend)(nil)
+
+func NewReplicatedBackend(ctx context.Context, config *ReplicatedConfig) (*replicatedBackend, error) {
+	if len(config.Replicas) < 2 {
+		return nil, fmt.Errorf("at least two replicas must be configured")
+	}
+
+	replicated := &replicatedBackend{}
+	switch config.WritePolicy {
+	case "all":
+		replicated.writeQuorum = len(config.Replicas)
+	case "quorum":
+		replicated.writeQuorum = len(config.Replicas)/2 + 1
+	default:
+		return nil, fmt.Errorf("unknown write policy: %s", config.WritePolicy)
+	}
+	switch config.ReadPolicy {
+	case "primary":
+		replicated.readNearest = false
+	case "nearest":
+		replicated.readNearest = true
+	default:
+		return nil, fmt.Errorf("unknown read policy: %s", config.ReadPolicy)
+	}
+
+	names := map[string]bool{}
+	for index, replicaConfig := range config.Replicas {
+		name := replicaConfig.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", index)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate replica name: %s", name)
+		}
+		names[name] = true
+
+		if replicaConfig.Type == "replicated" {
+			return nil, fmt.Errorf("replica %s: replicated backends cannot be nested", name)
+		}
+		backend, err := createStorageBackend(ctx, replicaConfig.Type,
+			&replicaConfig.FS, &replicaConfig.S3)
+		if err != nil {
+			return nil, fmt.Errorf("replica %s: %w", name, err)
+		}
+		replicated.replicas = append(replicated.replicas, &replica{name: name, backend: backend})
+	}
+	return replicated, nil
+}
+
+// Returns the replicas in the order they should be read from.
+func (rb *replicatedBackend) readOrder() []*replica {
+	replicas := slices.Clone(rb.replicas)
+	if rb.readNearest {
+		slices.SortStableFunc(replicas, func(a, b *replica) int {
+			return int(a.estimatedLatency() - b.estimatedLatency())
+		})
+	}
+	return replicas
+}
+
+func (rb *replicatedBackend) primary() *replica {
+	return rb.replicas[0]
+}
+
+// Performs a read on each replica in read order until one of them succeeds. Unless
+// `fallbackIfNotFound` is set, the object is reported as not found once more replicas report
+// that it does not exist than a successful write could have missed under the write policy;
+// this is the case for manifests, where falling back on every replica could resurrect a site
+// that has been deleted while another replica was unavailable, but trusting a single replica
+// could hide a site that it has missed.
+func readReplicated[T any](
+	rb *replicatedBackend, fallbackIfNotFound bool, read func(Backend) (T, error),
+) (result T, err error) {
+	var notFound int
+	var otherErr error
+	replicas := rb.readOrder()
+	for index, replica := range replicas {
+		start := time.Now()
+		result, err = read(replica.backend)
+		replica.observe(time.Since(start), err)
+		if err == nil {
+			return
+		} else if !fallbackIfNotFound && errors.Is(err, ErrObjectNotFound) {
+			if notFound += 1; notFound > len(rb.replicas)-rb.writeQuorum {
+				return
+			}
+		} else {
+			otherErr = err
+		}
+		if index < len(replicas)-1 {
+			replicaReadFallbacksCount.WithLabelValues(replica.name).Inc()
+		}
+	}
+	if otherErr != nil {
+		// Too few replicas were available to tell whether the object exists.
+		err = otherErr
+	}
+	return
+}
+
+// Errors that are caused by the request rather than by the replica, and that are reported
+// to the caller even if the write succeeded on enough other replicas.
+func isSemanticError(err error) bool {
+	return errors.Is(err, ErrObjectNotFound) ||
+		errors.Is(err, ErrPreconditionFailed) ||
+		errors.Is(err, ErrWriteConflict) ||
+		errors.Is(err, ErrDomainFrozen)
+}
+
+// Performs a write on the given replicas concurrently. The write succeeds if, together with
+// `succeeded` writes already performed elsewhere, it has succeeded on enough replicas to
+// satisfy the write policy.
+func (rb *replicatedBackend) writeReplicas(
+	replicas []*replica, succeeded int, write func(Backend) error,
+) error {
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for index, replica := range replicas {
+		wg.Go(func() {
+			if err := write(replica.backend); err != nil {
+				replicaWriteFailuresCount.WithLabelValues(replica.name).Inc()
+				errs[index] = fmt.Errorf("replica %s: %w", replica.name, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if isSemanticError(err) {
+			return err
+		}
+	}
+	if succeeded < rb.writeQuorum {
+		return fmt.Errorf("write succeeded on %d of %d replicas (%d required): %w",
+			succeeded, len(rb.replicas), rb.writeQuorum, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (rb *replicatedBackend) writeAll(write func(Backend) error) error {
+	return rb.writeReplicas(rb.replicas, 0, write)
+}
+
+func manifestContentETag(manifest *Manifest) string {
+	hash := sha256.Sum256(EncodeManifest(manifest))
+	return hex.EncodeToString(hash[:])
+}
+
+// Picks the replica that checks preconditions of a conditional manifest update, and translates
+// the options (which refer to content ETags) into options native to that replica. Returns
+// a nil coordinator if the update is unconditional.
+func (rb *replicatedBackend) coordinate(
+	ctx context.Context, names []string, opts []ModifyManifestOptions,
+) (coordinator *replica, native []ModifyManifestOptions, err error) {
+	native = slices.Clone(opts)
+	conditional := false
+	for _, opt := range opts {
+		if opt.IfMatch != "" || !opt.IfUnmodifiedSince.IsZero() {
+			conditional = true
+		}
+	}
+	if !conditional {
+		return nil, native, nil
+	}
+
+	var errs []error
+	for _, replica := range rb.replicas {
+		err = nil
+		for index, name := range names {
+			if opts[index].IfMatch == "" {
+				continue
+			}
+			var manifest *Manifest
+			var metadata ManifestMetadata
+			manifest, metadata, err = replica.backend.GetManifest(ctx, name,
+				GetManifestOptions{BypassCache: true})
+			if errors.Is(err, ErrObjectNotFound) {
+				return nil, nil, fmt.Errorf("%w: If-Match", ErrPreconditionFailed)
+			} else if err != nil {
+				break
+			} else if manifestContentETag(manifest) != opts[index].IfMatch {
+				return nil, nil, fmt.Errorf("%w: If-Match", ErrPreconditionFailed)
+			}
+			native[index].IfMatch = metadata.ETag
+			if !opts[index].IfUnmodifiedSince.IsZero() {
+				// The timestamp may have been observed on a different replica; since the content
+				// is known to match, use the one from the coordinator instead.
+				native[index].IfUnmodifiedSince = metadata.LastModified
+			}
+		}
+		if err == nil {
+			return replica, native, nil
+		}
+		errs = append(errs, fmt.Errorf("replica %s: %w", replica.name, err))
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// Performs a manifest write on the coordinator first, and then on the rest of the replicas
+// with preconditions removed.
+func (rb *replicatedBackend) writeCoordinated(
+	ctx context.Context, names []string, opts []ModifyManifestOptions,
+	write func(backend Backend, opts []ModifyManifestOptions) error,
+) error {
+	coordinator, native, err := rb.coordinate(ctx, names, opts)
+	if err != nil {
+		return err
+	} else if coordinator == nil {
+		return rb.writeAll(func(backend Backend) error {
+			return write(backend, native)
+		})
+	}
+	if err := write(coordinator.backend, native); err != nil {
+		if !isSemanticError(err) {
+			replicaWriteFailuresCount.WithLabelValues(coordinator.name).Inc()
+		}
+		return fmt.Errorf("replica %s: %w", coordinator.name, err)
+	}
+	var others []*replica
+	for _, replica := range rb.replicas {
+		if replica != coordinator {
+			others = append(others, replica)
+		}
+	}
+	unconditional := make([]ModifyManifestOptions, len(opts))
+	return rb.writeReplicas(others, 1, func(backend Backend) error {
+		return write(backend, unconditional)
+	})
+}
+
+func (rb *replicatedBackend) HasFeature(ctx context.Context, feature BackendFeature) bool {
+	return rb.readOrder()[0].backend.HasFeature(ctx, feature)
+}
+
+func (rb *replicatedBackend) EnableFeature(ctx context.Context, feature BackendFeature) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.EnableFeature(ctx, feature)
+	})
+}
+
+func (rb *replicatedBackend) DisableFeature(ctx context.Context, feature BackendFeature) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.DisableFeature(ctx, feature)
+	})
+}
+
+func (rb *replicatedBackend) GetBlob(
+	ctx context.Context, name string,
+) (
+	reader io.ReadSeeker, metadata BlobMetadata, err error,
+) {
+	result, err := readReplicated(rb, true,
+		func(backend Backend) (tuple[io.ReadSeeker, BlobMetadata], error) {
+			reader, metadata, err := backend.GetBlob(ctx, name)
+			return tuple[io.ReadSeeker, BlobMetadata]{reader, metadata}, err
+		})
+	reader, metadata = result.Splat()
+	return
+}
+
+func (rb *replicatedBackend) PutBlob(ctx context.Context, name string, data []byte) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.PutBlob(ctx, name, data)
+	})
+}
+
+func (rb *replicatedBackend) ReplaceBlob(ctx context.Context, name string, data []byte) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.ReplaceBlob(ctx, name, data)
+	})
+}
+
+func (rb *replicatedBackend) DeleteBlob(ctx context.Context, name string) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.DeleteBlob(ctx, name)
+	})
+}
+
+func (rb *replicatedBackend) EnumerateBlobs(ctx context.Context) iter.Seq2[BlobMetadata, error] {
+	return rb.readOrder()[0].backend.EnumerateBlobs(ctx)
+}
+
+func (rb *replicatedBackend) GetManifest(
+	ctx context.Context, name string, opts GetManifestOptions,
+) (
+	manifest *Manifest, metadata ManifestMetadata, err error,
+) {
+	result, err := readReplicated(rb, false,
+		func(backend Backend) (tuple[*Manifest, ManifestMetadata], error) {
+			manifest, metadata, err := backend.GetManifest(ctx, name, opts)
+			return tuple[*Manifest, ManifestMetadata]{manifest, metadata}, err
+		})
+	manifest, metadata = result.Splat()
+	if err == nil {
+		metadata.ETag = manifestContentETag(manifest)
+	}
+	return
+}
+
+func (rb *replicatedBackend) GetManifestShard(ctx context.Context, name string) (*Manifest, error) {
+	return readReplicated(rb, true, func(backend Backend) (*Manifest, error) {
+		return backend.GetManifestShard(ctx, name)
+	})
+}
+
+func (rb *replicatedBackend) StageManifest(ctx context.Context, manifest *Manifest) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.StageManifest(ctx, manifest)
+	})
+}
+
+func (rb *replicatedBackend) HasAtomicCAS(ctx context.Context) bool {
+	return rb.primary().backend.HasAtomicCAS(ctx)
+}
+
+func (rb *replicatedBackend) CommitManifest(
+	ctx context.Context, name string, manifest *Manifest, opts ModifyManifestOptions,
+) error {
+	return rb.writeCoordinated(ctx, []string{name}, []ModifyManifestOptions{opts},
+		func(backend Backend, opts []ModifyManifestOptions) error {
+			return backend.CommitManifest(ctx, name, manifest, opts[0])
+		})
+}
+
+func (rb *replicatedBackend) CommitManifests(ctx context.Context, commits []ManifestCommit) error {
+	var names []string
+	var opts []ModifyManifestOptions
+	for _, commit := range commits {
+		names = append(names, commit.Name)
+		opts = append(opts, commit.Options)
+	}
+	return rb.writeCoordinated(ctx, names, opts,
+		func(backend Backend, opts []ModifyManifestOptions) error {
+			nativeCommits := slices.Clone(commits)
+			for index := range nativeCommits {
+				nativeCommits[index].Options = opts[index]
+			}
+			return backend.CommitManifests(ctx, nativeCommits)
+		})
+}
+
+func (rb *replicatedBackend) DeleteManifest(
+	ctx context.Context, name string, opts ModifyManifestOptions,
+) error {
+	return rb.writeCoordinated(ctx, []string{name}, []ModifyManifestOptions{opts},
+		func(backend Backend, opts []ModifyManifestOptions) error {
+			return backend.DeleteManifest(ctx, name, opts[0])
+		})
+}
+
+func (rb *replicatedBackend) ExpireManifest(ctx context.Context, name string) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.ExpireManifest(ctx, name)
+	})
+}
+
+func (rb *replicatedBackend) EnumerateManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error] {
+	return rb.readOrder()[0].backend.EnumerateManifests(ctx)
+}
+
+func (rb *replicatedBackend) EnumerateStagedManifests(
+	ctx context.Context,
+) iter.Seq2[*ManifestMetadata, error] {
+	return rb.readOrder()[0].backend.EnumerateStagedManifests(ctx)
+}
+
+func (rb *replicatedBackend) GetAllManifests(
+	ctx context.Context,
+) iter.Seq2[tuple[*ManifestMetadata, *Manifest], error] {
+	return func(yield func(tuple[*ManifestMetadata, *Manifest], error) bool) {
+		for item, err := range rb.readOrder()[0].backend.GetAllManifests(ctx) {
+			if metadata, manifest := item.Splat(); err == nil {
+				metadata.ETag = manifestContentETag(manifest)
+			}
+			if !yield(item, err) {
+				break
+			}
+		}
+	}
+}
+
+func (rb *replicatedBackend) HasSiteListChanged(
+	ctx context.Context, since time.Time,
+) (bool, time.Time, error) {
+	result, err := readReplicated(rb, true,
+		func(backend Backend) (tuple[bool, time.Time], error) {
+			changed, lastChanged, err := backend.HasSiteListChanged(ctx, since)
+			return tuple[bool, time.Time]{changed, lastChanged}, err
+		})
+	changed, lastChanged := result.Splat()
+	return changed, lastChanged, err
+}
+
+func (rb *replicatedBackend) CheckDomain(ctx context.Context, domain string) (bool, error) {
+	return readReplicated(rb, true, func(backend Backend) (bool, error) {
+		return backend.CheckDomain(ctx, domain)
+	})
+}
+
+func (rb *replicatedBackend) CreateDomain(ctx context.Context, domain string) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.CreateDomain(ctx, domain)
+	})
+}
+
+func (rb *replicatedBackend) FreezeDomain(ctx context.Context, domain string) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.FreezeDomain(ctx, domain)
+	})
+}
+
+func (rb *replicatedBackend) UnfreezeDomain(ctx context.Context, domain string) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.UnfreezeDomain(ctx, domain)
+	})
+}
+
+// Resumable uploads are short-lived and only stored on the primary replica.
+
+func (rb *replicatedBackend) AppendUpload(
+	ctx context.Context, name string, offset int64, data []byte,
+) error {
+	return rb.primary().backend.AppendUpload(ctx, name, offset, data)
+}
+
+func (rb *replicatedBackend) StatUpload(ctx context.Context, name string) (UploadMetadata, error) {
+	return rb.primary().backend.StatUpload(ctx, name)
+}
+
+func (rb *replicatedBackend) GetUpload(ctx context.Context, name string) (io.ReadCloser, error) {
+	return rb.primary().backend.GetUpload(ctx, name)
+}
+
+func (rb *replicatedBackend) DeleteUpload(ctx context.Context, name string) error {
+	return rb.primary().backend.DeleteUpload(ctx, name)
+}
+
+func (rb *replicatedBackend) EnumerateUploads(ctx context.Context) iter.Seq2[UploadMetadata, error] {
+	return rb.primary().backend.EnumerateUploads(ctx)
+}
+
+// Rate limiter state is short-lived and only stored on the primary replica.
+func (rb *replicatedBackend) UpdateRateLimit(
+	ctx context.Context, name string, update func(state []byte) []byte,
+) error {
+	return rb.primary().backend.UpdateRateLimit(ctx, name, update)
+}
+
+func (rb *replicatedBackend) GetSiteStats(ctx context.Context, webRoot string) ([]byte, error) {
+	return readReplicated(rb, true, func(backend Backend) ([]byte, error) {
+		return backend.GetSiteStats(ctx, webRoot)
+	})
+}
+
+// Each replica adds the same counts to the statistics it has stored.
+func (rb *replicatedBackend) UpdateSiteStats(
+	ctx context.Context, webRoot string, update func(data []byte) []byte,
+) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.UpdateSiteStats(ctx, webRoot, update)
+	})
+}
+
+// TLS data is written to every replica, so it is only enumerated on the primary replica.
+func (rb *replicatedBackend) EnumerateTLSData(ctx context.Context) iter.Seq2[string, error] {
+	return rb.primary().backend.EnumerateTLSData(ctx)
+}
+
+func (rb *replicatedBackend) GetTLSData(ctx context.Context, name string) ([]byte, error) {
+	return readReplicated(rb, true, func(backend Backend) ([]byte, error) {
+		return backend.GetTLSData(ctx, name)
+	})
+}
+
+func (rb *replicatedBackend) PutTLSData(ctx context.Context, name string, data []byte) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.PutTLSData(ctx, name, data)
+	})
+}
+
+func (rb *replicatedBackend) DeleteTLSData(ctx context.Context, name string) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.DeleteTLSData(ctx, name)
+	})
+}
+
+func (rb *replicatedBackend) AppendAuditLog(ctx context.Context, id AuditID, record *AuditRecord) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.AppendAuditLog(ctx, id, record)
+	})
+}
+
+func (rb *replicatedBackend) ReplaceAuditRecord(ctx context.Context, id AuditID, record *AuditRecord) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.ReplaceAuditRecord(ctx, id, record)
+	})
+}
+
+func (rb *replicatedBackend) QueryAuditLog(ctx context.Context, id AuditID) (*AuditRecord, error) {
+	return readReplicated(rb, true, func(backend Backend) (*AuditRecord, error) {
+		return backend.QueryAuditLog(ctx, id)
+	})
+}
+
+func (rb *replicatedBackend) IndexAuditRecord(ctx context.Context, id AuditID, terms []string) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.IndexAuditRecord(ctx, id, terms)
+	})
+}
+
+func (rb *replicatedBackend) SearchAuditLog(
+	ctx context.Context, opts SearchAuditLogOptions,
+) iter.Seq2[AuditID, error] {
+	return rb.readOrder()[0].backend.SearchAuditLog(ctx, opts)
+}
+
+func (rb *replicatedBackend) GetAuditLogRecords(
+	ctx context.Context, ids iter.Seq2[AuditID, error],
+) iter.Seq2[*AuditRecord, error] {
+	return rb.readOrder()[0].backend.GetAuditLogRecords(ctx, ids)
+}
+
+func (rb *replicatedBackend) DetachAuditRecord(ctx context.Context, id AuditID) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.DetachAuditRecord(ctx, id)
+	})
+}
+
+func (rb *replicatedBackend) ExpireAuditRecord(ctx context.Context, id AuditID) error {
+	return rb.writeAll(func(backend Backend) error {
+		return backend.ExpireAuditRecord(ctx, id)
+	})
+}
+
+func (rb *replicatedBackend) GetAuditChainHead(ctx context.Context, node int) ([]byte, error) {
+	return readReplicated(rb, true, func(backend Backend) ([]byte, error) {
+		return backend.GetAuditChainHead(ctx, node)
+	})
+}
+
+// The first available replica (in configuration order) orders the audit records appended by
+// a node, and the resulting chain head is then stored on the other replicas.
+func (rb *replicatedBackend) UpdateAuditChainHead(
+	ctx context.Context, node int, update func(data []byte) []byte,
+) error {
+	var errs []error
+	for index, ordering := range rb.replicas {
+		var newData []byte
+		err := ordering.backend.UpdateAuditChainHead(ctx, node, func(data []byte) []byte {
+			newData = update(data)
+			return newData
+		})
+		if err != nil {
+			replicaWriteFailuresCount.WithLabelValues(ordering.name).Inc()
+			errs = append(errs, fmt.Errorf("replica %s: %w", ordering.name, err))
+			continue
+		} else if newData == nil {
+			return nil
+		}
+		others := slices.Concat(rb.replicas[:index], rb.replicas[index+1:])
+		return rb.writeReplicas(others, 1, func(backend Backend) error {
+			return backend.UpdateAuditChainHead(ctx, node, func([]byte) []byte {
+				return newData
+			})
+		})
+	}
+	return errors.Join(errs...)
+}
+
+func ReconcileReplicas(ctx context.Context, dryRun bool) error {
+	replicated, ok := unwrapBackend[*replicatedBackend](backend)
+	if !ok {
+		return fmt.Errorf("storage replication is not configured")
+	}
+	return replicated.Reconcile(ctx, dryRun)
+}
+
+type reconcileStats struct {
+	repaired int
+	failed   int
+}
+
+func (stats *reconcileStats) record(ctx context.Context, err error, format string, args ...any) {
+	what := fmt.Sprintf(format, args...)
+	if err != nil {
+		logc.Printf(ctx, "reconcile: %s: %s", what, err)
+		stats.failed++
+	} else {
+		logc.Printf(ctx, "reconcile: %s", what)
+		stats.repaired++
+	}
+}
+
+// Repairs divergence between replicas, e.g. after one of them has been unavailable.
+//
+// Blobs and audit records are only ever added (aside from garbage collection and expiration),
+// so they are copied to every replica that is missing them. Manifests are set to the state
+// that the majority of replicas agree on; if there is no majority, the most recently modified
+// version is used. If a manifest is absent from as many replicas as agree on its version,
+// the audit log decides whether it has been deleted; if the audit log has no record of it,
+// the manifest is left as is and reported as a failure.
+// If `dryRun` is true, the repairs are logged but not performed.
+func (rb *replicatedBackend) Reconcile(ctx context.Context, dryRun bool) error {
+	stats := &reconcileStats{}
+	for _, step := range []func(context.Context, bool, *reconcileStats) error{
+		rb.reconcileBlobs,
+		rb.reconcileAuditLog,
+		rb.reconcileManifests,
+	} {
+		if err := step(ctx, dryRun, stats); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		logc.Printf(ctx, "reconcile: would repair %d objects (dry run)", stats.repaired)
+	} else {
+		logc.Printf(ctx, "reconcile: repaired %d objects, %d failed", stats.repaired, stats.failed)
+	}
+	if stats.failed > 0 {
+		return fmt.Errorf("reconcile: %d repairs failed", stats.failed)
+	}
+	return nil
+}
+
+func (rb *replicatedBackend) reconcileBlobs(
+	ctx context.Context, dryRun bool, stats *reconcileStats,
+) error {
+	present := make([]map[string]bool, len(rb.replicas))
+	union := map[string]bool{}
+	for index, replica := range rb.replicas {
+		present[index] = map[string]bool{}
+		for metadata, err := range replica.backend.EnumerateBlobs(ctx) {
+			if err != nil {
+				return fmt.Errorf("replica %s: enum blobs: %w", replica.name, err)
+			}
+			present[index][metadata.Name] = true
+			union[metadata.Name] = true
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(union)) {
+		var source Backend
+		var missing []*replica
+		for index, replica := range rb.replicas {
+			if present[index][name] {
+				source = replica.backend
+			} else {
+				missing = append(missing, replica)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		var data []byte
+		if !dryRun {
+			reader, _, err := source.GetBlob(ctx, name)
+			if err == nil {
+				data, err = io.ReadAll(reader)
+				if closer, ok := reader.(io.Closer); ok {
+					closer.Close()
+				}
+			}
+			if err != nil {
+				stats.record(ctx, err, "read blob %s", name)
+				continue
+			}
+		}
+		for _, replica := range missing {
+			var err error
+			if !dryRun {
+				err = replica.backend.PutBlob(ctx, name, data)
+			}
+			stats.record(ctx, err, "copy blob %s to %s", name, replica.name)
+		}
+	}
+	return nil
+}
+
+type replicaManifestState struct {
+	manifest     *Manifest
+	etag         string // empty if absent
+	lastModified time.Time
+}
+
+func (rb *replicatedBackend) reconcileManifests(
+	ctx context.Context, dryRun bool, stats *reconcileStats,
+) error {
+	union := map[string]bool{}
+	for _, replica := range rb.replicas {
+		for metadata, err := range replica.backend.EnumerateManifests(ctx) {
+			if err != nil {
+				return fmt.Errorf("replica %s: enum manifests: %w", replica.name, err)
+			}
+			union[metadata.Name] = true
+		}
+	}
+
+	domains := map[string]bool{}
+	for _, name := range slices.Sorted(maps.Keys(union)) {
+		states := make([]replicaManifestState, len(rb.replicas))
+		var readErr error
+		for index, replica := range rb.replicas {
+			manifest, metadata, err := replica.backend.GetManifest(ctx, name,
+				GetManifestOptions{BypassCache: true})
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			} else if err != nil {
+				readErr = fmt.Errorf("replica %s: %w", replica.name, err)
+				break
+			}
+			states[index] = replicaManifestState{
+				manifest:     manifest,
+				etag:         manifestContentETag(manifest),
+				lastModified: metadata.LastModified,
+			}
+		}
+		if readErr != nil {
+			stats.record(ctx, readErr, "read manifest %s", name)
+			continue
+		}
+
+		votes := map[string]int{}
+		for _, state := range states {
+			votes[state.etag]++
+		}
+		var winner replicaManifestState
+		for _, state := range states {
+			switch {
+			case state.etag == "":
+			case winner.etag == "" || votes[state.etag] > votes[winner.etag]:
+				winner = state
+			case votes[state.etag] < votes[winner.etag]:
+			case state.lastModified.After(winner.lastModified):
+				winner = state
+			}
+		}
+		if votes[""] > votes[winner.etag] {
+			winner = replicaManifestState{}
+		} else if votes[""] == votes[winner.etag] {
+			// As many replicas lack the manifest as agree on its most popular version; whether
+			// it has been deleted or not can only be determined from the audit log.
+			deleted, err := isSiteDeletedPerAuditLog(ctx, name)
+			if err != nil {
+				stats.record(ctx, err, "resolve manifest %s", name)
+				continue
+			} else if deleted {
+				winner = replicaManifestState{}
+			}
+		}
+
+		if winner.etag != "" {
+			domain, _, _ := strings.Cut(name, "/")
+			domains[domain] = true
+		}
+		for index, replica := range rb.replicas {
+			if states[index].etag == winner.etag {
+				continue
+			}
+			var err error
+			if winner.etag == "" {
+				if !dryRun {
+					err = replica.backend.DeleteManifest(ctx, name, ModifyManifestOptions{})
+				}
+				stats.record(ctx, err, "delete manifest %s from %s", name, replica.name)
+			} else {
+				if !dryRun {
+					err = replica.backend.StageManifest(ctx, winner.manifest)
+					if err == nil {
+						err = replica.backend.CommitManifest(ctx, name, winner.manifest,
+							ModifyManifestOptions{})
+					}
+				}
+				stats.record(ctx, err, "copy manifest %s to %s", name, replica.name)
+			}
+		}
+	}
+
+	for _, domain := range slices.Sorted(maps.Keys(domains)) {
+		for _, replica := range rb.replicas {
+			found, err := replica.backend.CheckDomain(ctx, domain)
+			if err == nil && found {
+				continue
+			}
+			if err == nil && !dryRun {
+				err = replica.backend.CreateDomain(ctx, domain)
+			}
+			stats.record(ctx, err, "create domain %s on %s", domain, replica.name)
+		}
+	}
+	return nil
+}
+
+var errReconcileAmbiguous = errors.New("present on as many replicas as it is absent from, " +
+	"and the audit log does not record its latest change")
+
+// Returns whether the latest change to the site `name` recorded in the audit log is its deletion
+// or expiration. The audit log is reconciled before manifests, so that records made while
+// a replica was unavailable are taken into account.
+func isSiteDeletedPerAuditLog(ctx context.Context, name string) (bool, error) {
+	domain, project, _ := strings.Cut(name, "/")
+	var latestID AuditID
+	var latestEvent AuditEvent
+	for _, event := range []AuditEvent{
+		AuditEvent_CommitManifest,
+		AuditEvent_CommitManifests,
+		AuditEvent_DeleteManifest,
+		AuditEvent_ExpireManifest,
+	} {
+		ids := backend.SearchAuditLog(ctx, SearchAuditLogOptions{
+			Domain:  domain,
+			Project: project,
+			Event:   event,
+		})
+		for id, err := range ids {
+			if err != nil {
+				return false, fmt.Errorf("search audit log: %w", err)
+			}
+			if id > latestID {
+				latestID, latestEvent = id, event
+			}
+		}
+	}
+	switch latestEvent {
+	case AuditEvent_DeleteManifest, AuditEvent_ExpireManifest:
+		return true, nil
+	case AuditEvent_CommitManifest, AuditEvent_CommitManifests:
+		return false, nil
+	default:
+		return false, errReconcileAmbiguous
+	}
+}
+
+func (rb *replicatedBackend) reconcileAuditLog(
+	ctx context.Context, dryRun bool, stats *reconcileStats,
+) error {
+	present := make([]map[AuditID]bool, len(rb.replicas))
+	union := map[AuditID]bool{}
+	for index, replica := range rb.replicas {
+		present[index] = map[AuditID]bool{}
+		for id, err := range replica.backend.SearchAuditLog(ctx, SearchAuditLogOptions{}) {
+			if err != nil {
+				return fmt.Errorf("replica %s: search audit log: %w", replica.name, err)
+			}
+			present[index][id] = true
+			union[id] = true
+		}
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(union)) {
+		records := make([]*AuditRecord, len(rb.replicas))
+		var source *AuditRecord
+		var readErr error
+		detached := false
+		for index, replica := range rb.replicas {
+			if !present[index][id] {
+				continue
+			}
+			record, err := replica.backend.QueryAuditLog(ctx, id)
+			if err != nil {
+				readErr = fmt.Errorf("replica %s: %w", replica.name, err)
+				break
+			}
+			records[index] = record
+			if source == nil || !record.IsDetached() {
+				source = record
+			}
+			detached = detached || record.IsDetached()
+		}
+		if readErr != nil {
+			stats.record(ctx, readErr, "read audit record %s", id)
+			continue
+		}
+
+		for index, replica := range rb.replicas {
+			if records[index] == nil {
+				var err error
+				if !dryRun {
+					err = replica.backend.AppendAuditLog(ctx, id, source)
+					// Encrypted audit records are not indexed (see `IndexAuditRecord`).
+					if err == nil && source.Encrypted == nil {
+						err = replica.backend.IndexAuditRecord(ctx, id, AuditIndexTerms(source))
+					}
+				}
+				stats.record(ctx, err, "copy audit record %s to %s", id, replica.name)
+			}
+			if detached && (records[index] == nil || !records[index].IsDetached()) {
+				var err error
+				if !dryRun {
+					err = replica.backend.DetachAuditRecord(ctx, id)
+				}
+				stats.record(ctx, err, "detach audit record %s on %s", id, replica.name)
+			}
+		}
+	}
+	return nil
+}
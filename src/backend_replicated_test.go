@@ -0,0 +1,53 @@
+package git_pages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReplicatedGetManifest(t *testing.T) {
+	ctx := context.Background()
+	manifest := NewManifest()
+	AddFile(manifest, "index.html", []byte("<p>"))
+
+	for _, test := range []struct {
+		name        string
+		writeQuorum int
+		stored      []bool // whether the manifest is stored on each replica
+		expectFound bool
+	}{
+		{"everywhere", 3, []bool{true, true, true}, true},
+		{"missed by primary", 2, []bool{false, true, true}, true},
+		{"missed by primary and another", 2, []bool{false, false, true}, false},
+		{"missed by primary, all", 3, []bool{false, true, true}, false},
+		{"nowhere", 2, []bool{false, false, false}, false},
+	} {
+		rb := &replicatedBackend{writeQuorum: test.writeQuorum}
+		for index, stored := range test.stored {
+			fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored {
+				if err := fsBackend.StageManifest(ctx, manifest); err != nil {
+					t.Fatal(err)
+				}
+				err := fsBackend.CommitManifest(ctx, "example.org/.index", manifest,
+					ModifyManifestOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			rb.replicas = append(rb.replicas, &replica{name: fmt.Sprint(index), backend: fsBackend})
+		}
+
+		_, _, err := rb.GetManifest(ctx, "example.org/.index", GetManifestOptions{})
+		if test.expectFound && err != nil {
+			t.Errorf("%s: expect found, got err %s", test.name, err)
+		} else if !test.expectFound && !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("%s: expect err %s, got err %v", test.name, ErrObjectNotFound, err)
+		}
+	}
+}
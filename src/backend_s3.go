@@ -3,12 +3,18 @@ package git_pages
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"iter"
+	"net"
 	"net/http"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +23,7 @@ import (
 	"github.com/maypok86/otter/v2"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -29,18 +36,35 @@ var (
 	blobCacheHitsBytes      prometheus.Counter
 	blobCacheMissesCount    prometheus.Counter
 	blobCacheMissesBytes    prometheus.Counter
+	blobCacheCoalescedCount prometheus.Counter
+	blobCacheStaleCount     prometheus.Counter
 	blobCacheEvictionsCount prometheus.Counter
 	blobCacheEvictionsBytes prometheus.Counter
 
 	manifestCacheHitsCount      prometheus.Counter
 	manifestCacheMissesCount    prometheus.Counter
+	manifestCacheCoalescedCount prometheus.Counter
+	manifestCacheStaleCount     prometheus.Counter
 	manifestCacheEvictionsCount prometheus.Counter
 
+	manifestInvalidationsCount prometheus.Counter
+
+	s3CircuitBreakerOpen       prometheus.Gauge
+	s3CircuitBreakerTripsCount prometheus.Counter
+
 	s3GetObjectDurationSeconds *prometheus.HistogramVec
 	s3GetObjectResponseCount   *prometheus.CounterVec
 )
 
+var initS3BackendMetricsOnce sync.Once
+
+// Several S3 backends may be created when replication is configured, but the metrics are
+// shared between them.
 func initS3BackendMetrics() {
+	initS3BackendMetricsOnce.Do(registerS3BackendMetrics)
+}
+
+func registerS3BackendMetrics() {
 	blobsDedupedCount = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "git_pages_blobs_deduped",
 		Help: "Count of blobs deduplicated",
@@ -66,6 +90,14 @@ func initS3BackendMetrics() {
 		Name: "git_pages_blob_cache_misses_bytes",
 		Help: "Total size in bytes of blobs that were not found in the cache (and were then successfully cached)",
 	})
+	blobCacheCoalescedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_blob_cache_coalesced_count",
+		Help: "Count of blobs that were not found in the cache, but were already being retrieved by a concurrent request",
+	})
+	blobCacheStaleCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_blob_cache_stale_count",
+		Help: "Count of blobs that were retrieved from the cache past expiry because the backend was unavailable",
+	})
 	blobCacheEvictionsCount = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "git_pages_blob_cache_evictions_count",
 		Help: "Count of blobs evicted from the cache",
@@ -83,11 +115,33 @@ func initS3BackendMetrics() {
 		Name: "git_pages_manifest_cache_misses_count",
 		Help: "Count of manifests that were not found in the cache (and were then successfully cached)",
 	})
+	manifestCacheCoalescedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_manifest_cache_coalesced_count",
+		Help: "Count of manifests that were not found in the cache, but were already being retrieved by a concurrent request",
+	})
+	manifestCacheStaleCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_manifest_cache_stale_count",
+		Help: "Count of manifests that were retrieved from the cache past expiry because the backend was unavailable",
+	})
 	manifestCacheEvictionsCount = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "git_pages_manifest_cache_evictions_count",
 		Help: "Count of manifests evicted from the cache",
 	})
 
+	manifestInvalidationsCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_manifest_invalidations_count",
+		Help: "Count of manifest changes on other nodes that caused cache invalidation",
+	})
+
+	s3CircuitBreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "git_pages_s3_circuit_breaker_open",
+		Help: "Whether requests to S3 are currently not being made because of repeated failures",
+	})
+	s3CircuitBreakerTripsCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_s3_circuit_breaker_trips_count",
+		Help: "Count of times requests to S3 were stopped because of repeated failures",
+	})
+
 	s3GetObjectDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "git_pages_s3_get_object_duration_seconds",
 		Help:    "Time to read a whole object from S3",
@@ -130,6 +184,14 @@ type S3Backend struct {
 	blobCache    *observedCache[string, *CachedBlob]
 	siteCache    *observedCache[string, *CachedManifest]
 	featureCache *otter.Cache[BackendFeature, bool]
+	breaker      *circuitBreaker
+
+	sse               encrypt.ServerSide
+	blobStorageClass  string
+	auditStorageClass string
+
+	nodeID        string
+	watchInterval time.Duration
 }
 
 var _ Backend = (*S3Backend)(nil)
@@ -143,17 +205,106 @@ func makeCacheOptions[K comparable, V any](
 		options.MaximumWeight = config.MaxSize.Bytes()
 		options.Weigher = weigher
 	}
-	if config.MaxStale != 0 {
+	// Entries retained for `stale-if-error` are revalidated by refreshing them, which keeps
+	// the cached value in place in case the backend is unavailable.
+	if config.MaxStale != 0 || (config.MaxAge != 0 && config.StaleIfError != 0) {
 		options.RefreshCalculator = otter.RefreshWriting[K, V](
 			time.Duration(config.MaxAge))
 	}
 	if config.MaxAge != 0 || config.MaxStale != 0 {
 		options.ExpiryCalculator = otter.ExpiryWriting[K, V](
-			time.Duration(config.MaxAge + config.MaxStale))
+			time.Duration(config.MaxAge + config.MaxStale + config.StaleIfError))
 	}
 	return options
 }
 
+// Returns `ErrBackendUnavailable` wrapping `err` if it indicates that S3 could not be reached or
+// has failed to process the request, and `err` otherwise.
+func wrapS3Unavailable(err error) error {
+	var netErr net.Error
+	if err == nil {
+		return nil
+	} else if errResp := minio.ToErrorResponse(err); errResp.StatusCode >= 500 ||
+		errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	} else {
+		return err
+	}
+}
+
+// Runs `load` unless the circuit breaker is open, and records whether S3 has failed.
+func (s3 *S3Backend) guardLoad(load func() error) error {
+	if err := s3.breaker.Allow(); err != nil {
+		return err
+	}
+	err := wrapS3Unavailable(load())
+	if errors.Is(err, ErrBackendUnavailable) {
+		s3.breaker.Record(err)
+	} else {
+		s3.breaker.Record(nil)
+	}
+	return err
+}
+
+func makeS3Credentials(config *S3Config) (*credentials.Credentials, error) {
+	static := &credentials.Static{Value: credentials.Value{
+		AccessKeyID:     config.AccessKeyID,
+		SecretAccessKey: config.SecretAccessKey,
+		SignerType:      credentials.SignatureV4,
+	}}
+	env := []credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+	}
+	file := &credentials.FileAWSCredentials{
+		Filename: config.CredentialsFile,
+		Profile:  config.CredentialsProfile,
+	}
+	// Also handles ECS task roles and web identity (`AWS_WEB_IDENTITY_TOKEN_FILE`).
+	iam := &credentials.IAM{}
+
+	switch config.CredentialSource {
+	case "static":
+		return credentials.New(static), nil
+	case "env":
+		return credentials.NewChainCredentials(env), nil
+	case "file":
+		return credentials.New(file), nil
+	case "iam":
+		return credentials.New(iam), nil
+	case "chain":
+		providers := []credentials.Provider{}
+		if config.AccessKeyID != "" {
+			providers = append(providers, static)
+		}
+		providers = append(providers, env...)
+		providers = append(providers, file, iam)
+		return credentials.NewChainCredentials(providers), nil
+	default:
+		return nil, fmt.Errorf("unknown credential source: %s", config.CredentialSource)
+	}
+}
+
+func makeS3Encryption(config *S3EncryptionConfig) (encrypt.ServerSide, error) {
+	switch config.Type {
+	case "":
+		return nil, nil
+	case "sse-s3":
+		return encrypt.NewSSE(), nil
+	case "sse-kms":
+		return encrypt.NewSSEKMS(config.KMSKeyID, nil)
+	case "sse-c":
+		key, err := base64.StdEncoding.DecodeString(config.CustomerKey)
+		if err != nil {
+			return nil, fmt.Errorf("sse-c customer key: %w", err)
+		}
+		return encrypt.NewSSEC(key)
+	default:
+		return nil, fmt.Errorf("unknown encryption type: %s", config.Type)
+	}
+}
+
 func NewS3Backend(ctx context.Context, config *S3Config) (*S3Backend, error) {
 	var bucketLookup minio.BucketLookupType
 
@@ -168,12 +319,20 @@ func NewS3Backend(ctx context.Context, config *S3Config) (*S3Backend, error) {
 		return nil, fmt.Errorf("unknown bucket lookup type: %s", config.BucketLookup)
 	}
 
+	creds, err := makeS3Credentials(config)
+	if err != nil {
+		return nil, err
+	}
+
+	sse, err := makeS3Encryption(&config.Encryption)
+	if err != nil {
+		return nil, err
+	} else if sse != nil && sse.Type() == encrypt.SSEC && config.Insecure {
+		return nil, fmt.Errorf("sse-c encryption requires a secure connection")
+	}
+
 	client, err := minio.New(config.Endpoint, &minio.Options{
-		Creds: credentials.NewStaticV4(
-			config.AccessKeyID,
-			config.SecretAccessKey,
-			"",
-		),
+		Creds:        creds,
 		Secure:       !config.Insecure,
 		BucketLookup: bucketLookup,
 	})
@@ -194,7 +353,7 @@ func NewS3Backend(ctx context.Context, config *S3Config) (*S3Backend, error) {
 			return nil, err
 		}
 
-		err = (&S3Backend{client: client, bucket: bucket}).
+		err = (&S3Backend{client: client, bucket: bucket, sse: sse}).
 			EnableFeature(ctx, FeatureCheckDomainMarker)
 		if err != nil {
 			return nil, err
@@ -204,28 +363,32 @@ func NewS3Backend(ctx context.Context, config *S3Config) (*S3Backend, error) {
 	initS3BackendMetrics()
 
 	blobCacheMetrics := observedCacheMetrics{
-		HitNumberCounter:      blobCacheHitsCount,
-		HitWeightCounter:      blobCacheHitsBytes,
-		MissNumberCounter:     blobCacheMissesCount,
-		MissWeightCounter:     blobCacheMissesBytes,
-		EvictionNumberCounter: blobCacheEvictionsCount,
-		EvictionWeightCounter: blobCacheEvictionsBytes,
+		HitNumberCounter:       blobCacheHitsCount,
+		HitWeightCounter:       blobCacheHitsBytes,
+		MissNumberCounter:      blobCacheMissesCount,
+		MissWeightCounter:      blobCacheMissesBytes,
+		CoalescedNumberCounter: blobCacheCoalescedCount,
+		StaleNumberCounter:     blobCacheStaleCount,
+		EvictionNumberCounter:  blobCacheEvictionsCount,
+		EvictionWeightCounter:  blobCacheEvictionsBytes,
 	}
 	blobCache, err := newObservedCache(makeCacheOptions(&config.BlobCache,
 		func(key string, value *CachedBlob) uint32 { return uint32(len(value.blob)) }),
-		blobCacheMetrics)
+		time.Duration(config.BlobCache.StaleIfError), blobCacheMetrics)
 	if err != nil {
 		return nil, err
 	}
 
 	siteCacheMetrics := observedCacheMetrics{
-		HitNumberCounter:      manifestCacheHitsCount,
-		MissNumberCounter:     manifestCacheMissesCount,
-		EvictionNumberCounter: manifestCacheEvictionsCount,
+		HitNumberCounter:       manifestCacheHitsCount,
+		MissNumberCounter:      manifestCacheMissesCount,
+		CoalescedNumberCounter: manifestCacheCoalescedCount,
+		StaleNumberCounter:     manifestCacheStaleCount,
+		EvictionNumberCounter:  manifestCacheEvictionsCount,
 	}
 	siteCache, err := newObservedCache(makeCacheOptions(&config.SiteCache,
 		func(key string, value *CachedManifest) uint32 { return value.weight }),
-		siteCacheMetrics)
+		time.Duration(config.SiteCache.StaleIfError), siteCacheMetrics)
 	if err != nil {
 		return nil, err
 	}
@@ -237,13 +400,46 @@ func NewS3Backend(ctx context.Context, config *S3Config) (*S3Backend, error) {
 		return nil, err
 	}
 
-	return &S3Backend{client, bucket, blobCache, siteCache, featureCache}, nil
+	breaker := newCircuitBreaker(
+		config.CircuitBreakerThreshold, time.Duration(config.CircuitBreakerCooldown),
+		s3CircuitBreakerOpen, s3CircuitBreakerTripsCount)
+
+	return &S3Backend{
+		client:            client,
+		bucket:            bucket,
+		blobCache:         blobCache,
+		siteCache:         siteCache,
+		featureCache:      featureCache,
+		breaker:           breaker,
+		sse:               sse,
+		blobStorageClass:  config.BlobStorageClass,
+		auditStorageClass: config.AuditStorageClass,
+		nodeID:            rand.Text(),
+		watchInterval:     time.Duration(config.InvalidationInterval),
+	}, nil
 }
 
 func (s3 *S3Backend) Backend() Backend {
 	return s3
 }
 
+// Every object is written with the configured server-side encryption, so that the encryption
+// settings apply equally to blobs, manifests, audit records, and all metadata.
+func (s3 *S3Backend) putObjectOptions(storageClass string) minio.PutObjectOptions {
+	return minio.PutObjectOptions{
+		ServerSideEncryption: s3.sse,
+		StorageClass:         storageClass,
+	}
+}
+
+// Objects written with SSE-C can only be read (or even stat'd) by providing the same key.
+// For the other encryption types, this has no effect.
+func (s3 *S3Backend) getObjectOptions() minio.GetObjectOptions {
+	return minio.GetObjectOptions{
+		ServerSideEncryption: s3.sse,
+	}
+}
+
 func blobObjectName(name string) string {
 	return fmt.Sprintf("blob/%s", path.Join(splitBlobName(name)...))
 }
@@ -255,7 +451,7 @@ func storeFeatureObjectName(feature BackendFeature) string {
 func (s3 *S3Backend) HasFeature(ctx context.Context, feature BackendFeature) bool {
 	loader := func(ctx context.Context, feature BackendFeature) (bool, error) {
 		_, err := s3.client.StatObject(ctx, s3.bucket, storeFeatureObjectName(feature),
-			minio.StatObjectOptions{})
+			s3.getObjectOptions())
 		if err != nil {
 			if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
 				logc.Printf(ctx, "s3 feature %q: disabled", feature)
@@ -268,7 +464,16 @@ func (s3 *S3Backend) HasFeature(ctx context.Context, feature BackendFeature) boo
 		return true, nil
 	}
 
-	isOn, err := s3.featureCache.Get(ctx, feature, otter.LoaderFunc[BackendFeature, bool](loader))
+	var isOn bool
+	var err error
+	switch feature {
+	case FeatureAuditIndex, FeatureAuditIndexRebuild:
+		// These features are disabled by any node that fails to index an audit record, and
+		// acting on a stale value would make searches miss records, so they are not cached.
+		isOn, err = loader(ctx, feature)
+	default:
+		isOn, err = s3.featureCache.Get(ctx, feature, otter.LoaderFunc[BackendFeature, bool](loader))
+	}
 	if err != nil {
 		err = fmt.Errorf("getting s3 backend feature %q: %w", feature, err)
 		ObserveError(err)
@@ -280,7 +485,14 @@ func (s3 *S3Backend) HasFeature(ctx context.Context, feature BackendFeature) boo
 
 func (s3 *S3Backend) EnableFeature(ctx context.Context, feature BackendFeature) error {
 	_, err := s3.client.PutObject(ctx, s3.bucket, storeFeatureObjectName(feature),
-		&bytes.Reader{}, 0, minio.PutObjectOptions{})
+		&bytes.Reader{}, 0, s3.putObjectOptions(""))
+	return err
+}
+
+func (s3 *S3Backend) DisableFeature(ctx context.Context, feature BackendFeature) error {
+	err := s3.client.RemoveObject(ctx, s3.bucket, storeFeatureObjectName(feature),
+		minio.RemoveObjectOptions{})
+	s3.featureCache.Invalidate(feature)
 	return err
 }
 
@@ -295,7 +507,7 @@ func (s3 *S3Backend) GetBlob(
 		startTime := time.Now()
 
 		object, err := s3.client.GetObject(ctx, s3.bucket, blobObjectName(name),
-			minio.GetObjectOptions{})
+			s3.getObjectOptions())
 		// Note that many errors (e.g. NoSuchKey) will be reported only after this point.
 		if err != nil {
 			return nil, err
@@ -319,18 +531,23 @@ func (s3 *S3Backend) GetBlob(
 		return &CachedBlob{data, stat.LastModified}, nil
 	}
 
-	observer := func(ctx context.Context, name string) (*CachedBlob, error) {
-		cached, err := loader(ctx, name)
-		var code = "OK"
-		if resp, ok := err.(minio.ErrorResponse); ok {
-			code = resp.Code
-		}
-		s3GetObjectResponseCount.With(prometheus.Labels{"kind": "blob", "code": code}).Inc()
-		return cached, err
+	observer := func(ctx context.Context, name string) (cached *CachedBlob, err error) {
+		err = s3.guardLoad(func() (err error) {
+			cached, err = loader(ctx, name)
+			var code = "OK"
+			if resp, ok := err.(minio.ErrorResponse); ok {
+				code = resp.Code
+			}
+			s3GetObjectResponseCount.With(prometheus.Labels{"kind": "blob", "code": code}).Inc()
+			return err
+		})
+		return
 	}
 
 	var cached *CachedBlob
-	cached, err = s3.blobCache.Get(ctx, name, otter.LoaderFunc[string, *CachedBlob](observer))
+	var stale bool
+	cached, stale, err = s3.blobCache.Get(ctx, name,
+		otter.LoaderFunc[string, *CachedBlob](observer))
 	if err != nil {
 		if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
 			err = fmt.Errorf("%w: %s", ErrObjectNotFound, errResp.Key)
@@ -340,6 +557,7 @@ func (s3 *S3Backend) GetBlob(
 		metadata.Name = name
 		metadata.Size = int64(len(cached.blob))
 		metadata.LastModified = cached.mtime
+		metadata.Stale = stale
 	}
 	return
 }
@@ -348,11 +566,12 @@ func (s3 *S3Backend) PutBlob(ctx context.Context, name string, data []byte) erro
 	logc.Printf(ctx, "s3: put blob %s (%s)\n", name, datasize.ByteSize(len(data)).HumanReadable())
 
 	_, err := s3.client.StatObject(ctx, s3.bucket, blobObjectName(name),
-		minio.GetObjectOptions{})
+		s3.getObjectOptions())
 	if err != nil {
 		if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
 			_, err := s3.client.PutObject(ctx, s3.bucket, blobObjectName(name),
-				bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+				bytes.NewReader(data), int64(len(data)),
+				s3.putObjectOptions(s3.blobStorageClass))
 			if err != nil {
 				return err
 			} else {
@@ -372,6 +591,16 @@ func (s3 *S3Backend) PutBlob(ctx context.Context, name string, data []byte) erro
 	}
 }
 
+func (s3 *S3Backend) ReplaceBlob(ctx context.Context, name string, data []byte) error {
+	logc.Printf(ctx, "s3: replace blob %s (%s)\n", name, datasize.ByteSize(len(data)).HumanReadable())
+
+	_, err := s3.client.PutObject(ctx, s3.bucket, blobObjectName(name),
+		bytes.NewReader(data), int64(len(data)),
+		s3.putObjectOptions(s3.blobStorageClass))
+	s3.blobCache.Cache.Invalidate(name)
+	return err
+}
+
 func (s3 *S3Backend) DeleteBlob(ctx context.Context, name string) error {
 	logc.Printf(ctx, "s3: delete blob %s\n", name)
 
@@ -420,6 +649,8 @@ func stagedManifestObjectName(manifestData []byte) string {
 
 type s3ManifestLoader struct {
 	s3 *S3Backend
+	// If true, the key is the blob name of a manifest shard rather than a manifest name.
+	shard bool
 }
 
 func (l s3ManifestLoader) Load(
@@ -443,14 +674,20 @@ func (l s3ManifestLoader) load(
 ) (
 	*CachedManifest, error,
 ) {
-	logc.Printf(ctx, "s3: get manifest %s\n", name)
+	objectName := manifestObjectName(name)
+	if l.shard {
+		logc.Printf(ctx, "s3: get manifest shard %s\n", name)
+		objectName = blobObjectName(name)
+	} else {
+		logc.Printf(ctx, "s3: get manifest %s\n", name)
+	}
 
 	loader := func() (*CachedManifest, error) {
-		opts := minio.GetObjectOptions{}
+		opts := l.s3.getObjectOptions()
 		if oldManifest != nil && oldManifest.metadata.ETag != "" {
 			opts.SetMatchETagExcept(oldManifest.metadata.ETag)
 		}
-		object, err := l.s3.client.GetObject(ctx, l.s3.bucket, manifestObjectName(name), opts)
+		object, err := l.s3.client.GetObject(ctx, l.s3.bucket, objectName, opts)
 		// Note that many errors (e.g. NoSuchKey) will be reported only after this point.
 		if err != nil {
 			return nil, err
@@ -490,7 +727,11 @@ func (l s3ManifestLoader) load(
 	}
 
 	startTime := time.Now()
-	cached, err := observer()
+	var cached *CachedManifest
+	err := l.s3.guardLoad(func() (err error) {
+		cached, err = observer()
+		return err
+	})
 	s3GetObjectDurationSeconds.
 		With(prometheus.Labels{"kind": "manifest"}).
 		Observe(time.Since(startTime).Seconds())
@@ -515,30 +756,45 @@ func (s3 *S3Backend) GetManifest(
 ) (
 	manifest *Manifest, metadata ManifestMetadata, err error,
 ) {
+	revalidate := false
 	if opts.BypassCache {
 		entry, found := s3.siteCache.Cache.GetEntry(name)
-		if found && entry.RefreshableAt().Before(time.Now()) {
-			s3.siteCache.Cache.Invalidate(name)
-		}
+		revalidate = found && entry.RefreshableAt().Before(time.Now())
 	}
 
 	var cached *CachedManifest
-	cached, err = s3.siteCache.Get(ctx, name, s3ManifestLoader{s3})
+	var stale bool
+	if revalidate {
+		cached, stale, err = s3.siteCache.Revalidate(ctx, name, s3ManifestLoader{s3, false})
+	} else {
+		cached, stale, err = s3.siteCache.Get(ctx, name, s3ManifestLoader{s3, false})
+	}
 	if err != nil {
 		return
 	} else {
 		// This could be `manifest, mtime, nil` or `nil, time.Time{}, ErrObjectNotFound`.
 		manifest, metadata, err = cached.manifest, cached.metadata, cached.err
+		metadata.Stale = stale
 		return
 	}
 }
 
+// Manifest shards are immutable and are cached in the site cache together with manifests. Their
+// blob names never collide with manifest names, which always include a `/`.
+func (s3 *S3Backend) GetManifestShard(ctx context.Context, name string) (*Manifest, error) {
+	cached, _, err := s3.siteCache.Get(ctx, name, s3ManifestLoader{s3, true})
+	if err != nil {
+		return nil, err
+	}
+	return cached.manifest, cached.err
+}
+
 func (s3 *S3Backend) StageManifest(ctx context.Context, manifest *Manifest) error {
 	data := EncodeManifest(manifest)
 	logc.Printf(ctx, "s3: stage manifest %x\n", sha256.Sum256(data))
 
 	_, err := s3.client.PutObject(ctx, s3.bucket, stagedManifestObjectName(data),
-		bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+		bytes.NewReader(data), int64(len(data)), s3.putObjectOptions(""))
 	return err
 }
 
@@ -548,7 +804,7 @@ func domainFrozenObjectName(domain string) string {
 
 func (s3 *S3Backend) checkDomainFrozen(ctx context.Context, domain string) error {
 	_, err := s3.client.StatObject(ctx, s3.bucket, domainFrozenObjectName(domain),
-		minio.GetObjectOptions{})
+		s3.getObjectOptions())
 	if err == nil {
 		return ErrDomainFrozen
 	} else if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
@@ -579,7 +835,7 @@ func (s3 *S3Backend) checkManifestPrecondition(
 	ctx context.Context, name string, opts ModifyManifestOptions,
 ) (exists bool, err error) {
 	stat, err := s3.client.StatObject(ctx, s3.bucket, manifestObjectName(name),
-		minio.GetObjectOptions{})
+		s3.getObjectOptions())
 	if err != nil {
 		errResp := minio.ToErrorResponse(err)
 		if opts.IfUnmodifiedSince.IsZero() && opts.IfMatch == "" && errResp.Code == "NoSuchKey" {
@@ -608,7 +864,9 @@ func (s3 *S3Backend) CommitManifest(
 	logc.Printf(ctx, "s3: commit manifest %x -> %s", sha256.Sum256(data), name)
 
 	domain, _, _ := strings.Cut(name, "/")
-	if err := s3.checkDomainFrozen(ctx, domain); err != nil {
+	if opts.IgnoreFrozen {
+		// skip check
+	} else if err := s3.checkDomainFrozen(ctx, domain); err != nil {
 		return err
 	}
 
@@ -619,7 +877,7 @@ func (s3 *S3Backend) CommitManifest(
 
 	// Remove staged object unconditionally (whether commit succeeded or failed), since
 	// the upper layer has to retry the complete operation anyway.
-	putOptions := minio.PutObjectOptions{}
+	putOptions := s3.putObjectOptions("")
 	putOptions.Header().Add("X-Tigris-Consistent", "true")
 	if opts.IfMatch != "" {
 		// Not guaranteed to do anything (see `HasAtomicCAS`), but let's try anyway;
@@ -638,7 +896,9 @@ func (s3 *S3Backend) CommitManifest(
 		} else {
 			return putErr
 		}
-	} else if removeErr != nil {
+	}
+	s3.publishInvalidation(ctx, name)
+	if removeErr != nil {
 		return removeErr
 	}
 
@@ -651,6 +911,39 @@ func (s3 *S3Backend) CommitManifest(
 	return nil
 }
 
+func (s3 *S3Backend) CommitManifests(ctx context.Context, commits []ManifestCommit) error {
+	domain, err := manifestCommitsDomain(commits)
+	if err != nil {
+		return err
+	}
+
+	// S3 has no multi-object transactions; the best we can do is to check every precondition
+	// before making any changes, which makes a partial commit unlikely but still possible.
+	if err := s3.checkDomainFrozen(ctx, domain); err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		if _, err := s3.checkManifestPrecondition(ctx, commit.Name, commit.Options); err != nil {
+			return err
+		}
+	}
+
+	var committed []string
+	for _, commit := range commits {
+		if err := s3.CommitManifest(ctx, commit.Name, commit.Manifest, commit.Options); err != nil {
+			if len(committed) > 0 {
+				logc.Printf(ctx, "s3: commit manifests: %d of %d committed\n",
+					len(committed), len(commits))
+				return &PartialCommitError{Committed: committed, Total: len(commits), Err: err}
+			}
+			return err
+		}
+		committed = append(committed, commit.Name)
+	}
+
+	return nil
+}
+
 func (s3 *S3Backend) DeleteManifest(
 	ctx context.Context, name string, opts ModifyManifestOptions,
 ) error {
@@ -672,6 +965,7 @@ func (s3 *S3Backend) DeleteManifest(
 		return err
 	}
 	s3.siteCache.Cache.Invalidate(name)
+	s3.publishInvalidation(ctx, name)
 
 	if existed {
 		if err := s3.bumpLastSiteUpdateTimestamp(ctx); err != nil {
@@ -689,8 +983,22 @@ func (s3 *S3Backend) ExpireManifest(
 }
 
 func (s3 *S3Backend) EnumerateManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error] {
+	return s3.enumerateManifests(ctx, false)
+}
+
+func (s3 *S3Backend) EnumerateStagedManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error] {
+	return s3.enumerateManifests(ctx, true)
+}
+
+func (s3 *S3Backend) enumerateManifests(
+	ctx context.Context, staged bool,
+) iter.Seq2[*ManifestMetadata, error] {
 	return func(yield func(*ManifestMetadata, error) bool) {
-		logc.Println(ctx, "s3: enumerate manifests")
+		if staged {
+			logc.Println(ctx, "s3: enumerate staged manifests")
+		} else {
+			logc.Println(ctx, "s3: enumerate manifests")
+		}
 
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
@@ -707,7 +1015,10 @@ func (s3 *S3Backend) EnumerateManifests(ctx context.Context) iter.Seq2[*Manifest
 				_, project, _ := strings.Cut(key, "/")
 				if strings.HasSuffix(key, "/") {
 					continue // directory; skip
-				} else if project == "" || strings.HasPrefix(project, ".") && project != ".index" {
+				} else if staged != isStagedProjectName(project) {
+					continue // staged or deployed; skip
+				} else if !staged &&
+					(project == "" || strings.HasPrefix(project, ".") && project != ".index") {
 					continue // internal; skip
 				} else {
 					metadata = &ManifestMetadata{
@@ -775,7 +1086,7 @@ func (s3 *S3Backend) CheckDomain(ctx context.Context, domain string) (exists boo
 	logc.Printf(ctx, "s3: check domain %s\n", domain)
 
 	_, err = s3.client.StatObject(ctx, s3.bucket, domainCheckObjectName(domain),
-		minio.StatObjectOptions{})
+		s3.getObjectOptions())
 	if err != nil {
 		if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
 			exists, err = false, nil
@@ -806,7 +1117,7 @@ func (s3 *S3Backend) CreateDomain(ctx context.Context, domain string) error {
 	logc.Printf(ctx, "s3: create domain %s\n", domain)
 
 	_, err := s3.client.PutObject(ctx, s3.bucket, domainCheckObjectName(domain),
-		&bytes.Reader{}, 0, minio.PutObjectOptions{})
+		&bytes.Reader{}, 0, s3.putObjectOptions(""))
 	return err
 }
 
@@ -814,7 +1125,10 @@ func (s3 *S3Backend) FreezeDomain(ctx context.Context, domain string) error {
 	logc.Printf(ctx, "s3: freeze domain %s\n", domain)
 
 	_, err := s3.client.PutObject(ctx, s3.bucket, domainFrozenObjectName(domain),
-		&bytes.Reader{}, 0, minio.PutObjectOptions{})
+		&bytes.Reader{}, 0, s3.putObjectOptions(""))
+	if err == nil {
+		s3.publishInvalidation(ctx, domain)
+	}
 	return err
 }
 
@@ -825,15 +1139,16 @@ func (s3 *S3Backend) UnfreezeDomain(ctx context.Context, domain string) error {
 		minio.RemoveObjectOptions{})
 	if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
 		return nil
-	} else {
-		return err
+	} else if err == nil {
+		s3.publishInvalidation(ctx, domain)
 	}
+	return err
 }
 
 const lastSiteUpdateObjectName = "meta/last-site-update"
 
 func (s3 *S3Backend) HasSiteListChanged(ctx context.Context, since time.Time) (bool, time.Time, error) {
-	opts := minio.GetObjectOptions{}
+	opts := s3.getObjectOptions()
 	if !since.IsZero() {
 		if err := opts.SetModified(since); err != nil {
 			return false, time.Time{}, err
@@ -859,10 +1174,506 @@ func (s3 *S3Backend) HasSiteListChanged(ctx context.Context, since time.Time) (b
 func (s3 *S3Backend) bumpLastSiteUpdateTimestamp(ctx context.Context) error {
 	logc.Println(ctx, "s3: bumping last site update timestamp")
 	_, err := s3.client.PutObject(ctx, s3.bucket, lastSiteUpdateObjectName,
-		&bytes.Reader{}, 0, minio.PutObjectOptions{})
+		&bytes.Reader{}, 0, s3.putObjectOptions(""))
+	return err
+}
+
+// Manifests are cached by every node for up to `site-cache.max-age`. To make changes visible on
+// every node sooner than that, each change is announced by writing an empty object called
+// `meta/invalidate/<timestamp>-<node>/<name>`, where `<name>` is either a manifest name or
+// a domain name (to invalidate every manifest on the domain). Every node periodically lists
+// the recently written objects and evicts the corresponding manifests from its cache.
+const invalidationObjectPrefix = "meta/invalidate/"
+
+// Only the invalidation objects written within this window are listed by each node. This bounds
+// both the amount of objects listed on every poll and the clock skew between nodes that can be
+// tolerated.
+const invalidationWindow = 30 * time.Second
+
+// Invalidation objects older than this are removed.
+const invalidationRetention = 10 * time.Minute
+
+func invalidationObjectName(at time.Time, nodeID string, name string) string {
+	return fmt.Sprintf("%s%016x-%s/%s", invalidationObjectPrefix, at.UnixNano(), nodeID, name)
+}
+
+func parseInvalidationObjectName(key string) (at time.Time, nodeID string, name string, ok bool) {
+	key, ok = strings.CutPrefix(key, invalidationObjectPrefix)
+	if !ok {
+		return
+	}
+	header, name, ok := strings.Cut(key, "/")
+	if !ok {
+		return
+	}
+	timestamp, nodeID, ok := strings.Cut(header, "-")
+	if !ok {
+		return
+	}
+	nanos, err := strconv.ParseInt(timestamp, 16, 64)
+	if err != nil {
+		return time.Time{}, "", "", false
+	}
+	return time.Unix(0, nanos), nodeID, name, true
+}
+
+// Announces to other nodes that the manifest `name` (or every manifest on the domain, if `name`
+// is a domain name) has changed. The change has already been made at this point, so failing to
+// announce it only delays its visibility and is not treated as an error.
+func (s3 *S3Backend) publishInvalidation(ctx context.Context, name string) {
+	_, err := s3.client.PutObject(ctx, s3.bucket,
+		invalidationObjectName(time.Now(), s3.nodeID, name),
+		&bytes.Reader{}, 0, s3.putObjectOptions(""))
+	if err != nil {
+		logc.Printf(ctx, "s3: publish invalidation %s err: %s\n", name, err)
+	}
+}
+
+func (s3 *S3Backend) invalidateLocally(ctx context.Context, name string) {
+	if strings.Contains(name, "/") {
+		s3.siteCache.Cache.Invalidate(name)
+		// The site may have just been created, in which case the existence cache (if any) will
+		// be rejecting requests for it.
+		if existenceCache != nil {
+			existenceCache.AddSite(ctx, name)
+		}
+	} else {
+		for key := range s3.siteCache.Cache.Keys() {
+			if strings.HasPrefix(key, name+"/") {
+				s3.siteCache.Cache.Invalidate(key)
+			}
+		}
+	}
+	manifestInvalidationsCount.Inc()
+}
+
+// Starts watching for manifests changed by other nodes in each S3 backend of the store (including
+// replicas), until `ctx` is cancelled. Only the server does this, since one-shot CLI operations
+// do not keep manifests cached for long enough to need it.
+func WatchManifestInvalidations(ctx context.Context) {
+	var s3Backends []*S3Backend
+	if replicated, ok := unwrapBackend[*replicatedBackend](backend); ok {
+		for _, replica := range replicated.replicas {
+			if s3, ok := unwrapBackend[*S3Backend](replica.backend); ok {
+				s3Backends = append(s3Backends, s3)
+			}
+		}
+	} else if s3, ok := unwrapBackend[*S3Backend](backend); ok {
+		s3Backends = append(s3Backends, s3)
+	}
+	for _, s3 := range s3Backends {
+		if s3.watchInterval != 0 {
+			go s3.watchInvalidations(ctx, s3.watchInterval)
+		}
+	}
+}
+
+// Evicts manifests changed by other nodes from the cache, checking for changes every `interval`.
+// Runs until `ctx` is cancelled.
+func (s3 *S3Backend) watchInvalidations(ctx context.Context, interval time.Duration) {
+	seen := map[string]time.Time{}
+	lastCleanup := time.Now()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		objects := s3.client.ListObjectsIter(ctx, s3.bucket, minio.ListObjectsOptions{
+			Prefix:     invalidationObjectPrefix,
+			StartAfter: invalidationObjectName(now.Add(-invalidationWindow), "", ""),
+			Recursive:  true,
+		})
+		for object := range objects {
+			if object.Err != nil {
+				logc.Printf(ctx, "s3: watch invalidations err: %s\n", object.Err)
+				break
+			}
+			if _, found := seen[object.Key]; found {
+				continue
+			}
+			at, nodeID, name, ok := parseInvalidationObjectName(object.Key)
+			if !ok {
+				continue
+			}
+			seen[object.Key] = at
+			if nodeID != s3.nodeID {
+				logc.Printf(ctx, "s3: invalidate %s\n", name)
+				s3.invalidateLocally(ctx, name)
+			}
+		}
+		for key, at := range seen {
+			if now.Sub(at) > 2*invalidationWindow {
+				delete(seen, key)
+			}
+		}
+
+		if now.Sub(lastCleanup) > invalidationRetention {
+			if err := s3.cleanupInvalidations(ctx, now.Add(-invalidationRetention)); err != nil {
+				logc.Printf(ctx, "s3: clean up invalidations err: %s\n", err)
+			}
+			lastCleanup = now
+		}
+	}
+}
+
+// Removes invalidation objects written before `before`. Every node does this, so the objects
+// are removed even if the node that wrote them is gone.
+func (s3 *S3Backend) cleanupInvalidations(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cutoff := invalidationObjectName(before, "", "")
+	objects := func(yield func(minio.ObjectInfo) bool) {
+		for object := range s3.client.ListObjectsIter(ctx, s3.bucket, minio.ListObjectsOptions{
+			Prefix:    invalidationObjectPrefix,
+			Recursive: true,
+		}) {
+			if object.Err != nil || object.Key >= cutoff {
+				return
+			}
+			if !yield(object) {
+				return
+			}
+		}
+	}
+	results, err := s3.client.RemoveObjectsWithIter(ctx, s3.bucket, objects,
+		minio.RemoveObjectsOptions{})
+	if err != nil {
+		return err
+	}
+	for result := range results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+func uploadChunkObjectName(name string, offset int64) string {
+	return fmt.Sprintf("upload/%s/%016x", name, offset)
+}
+
+// S3 objects cannot be appended to, so each chunk of a resumable upload is stored as a separate
+// object named after its offset. Returns the chunks in order; any chunks that do not immediately
+// follow the previous one (which could only be created by racing requests) are ignored.
+func (s3 *S3Backend) listUploadChunks(ctx context.Context, name string) (
+	chunks []minio.ObjectInfo, metadata UploadMetadata, err error,
+) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	metadata.Name = name
+	prefix := fmt.Sprintf("upload/%s/", name)
+	for object := range s3.client.ListObjectsIter(ctx, s3.bucket, minio.ListObjectsOptions{
+		Prefix: prefix,
+	}) {
+		if object.Err != nil {
+			return nil, UploadMetadata{}, object.Err
+		}
+		if object.Key != uploadChunkObjectName(name, metadata.Size) {
+			continue
+		}
+		chunks = append(chunks, object)
+		metadata.Size += object.Size
+		if object.LastModified.After(metadata.LastModified) {
+			metadata.LastModified = object.LastModified
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, UploadMetadata{}, fmt.Errorf("%w: %s", ErrObjectNotFound, name)
+	}
+	return
+}
+
+func (s3 *S3Backend) AppendUpload(
+	ctx context.Context, name string, offset int64, data []byte,
+) error {
+	logc.Printf(ctx, "s3: append upload %s at %d\n", name, offset)
+
+	if offset != 0 {
+		_, metadata, err := s3.listUploadChunks(ctx, name)
+		if err != nil {
+			return err
+		}
+		if metadata.Size != offset {
+			return fmt.Errorf("%w: offset %d, expected %d",
+				ErrPreconditionFailed, offset, metadata.Size)
+		}
+	} else if len(data) == 0 {
+		// Creating an upload; nothing to check.
+	} else if _, metadata, err := s3.listUploadChunks(ctx, name); err == nil && metadata.Size != 0 {
+		return fmt.Errorf("%w: offset %d, expected %d",
+			ErrPreconditionFailed, offset, metadata.Size)
+	}
+
+	_, err := s3.client.PutObject(ctx, s3.bucket, uploadChunkObjectName(name, offset),
+		bytes.NewReader(data), int64(len(data)), s3.putObjectOptions(""))
 	return err
 }
 
+func (s3 *S3Backend) StatUpload(ctx context.Context, name string) (UploadMetadata, error) {
+	logc.Printf(ctx, "s3: stat upload %s\n", name)
+
+	_, metadata, err := s3.listUploadChunks(ctx, name)
+	return metadata, err
+}
+
+type s3UploadReader struct {
+	io.Reader
+	objects []*minio.Object
+}
+
+func (reader *s3UploadReader) Close() error {
+	for _, object := range reader.objects {
+		object.Close()
+	}
+	return nil
+}
+
+func (s3 *S3Backend) GetUpload(ctx context.Context, name string) (io.ReadCloser, error) {
+	logc.Printf(ctx, "s3: get upload %s\n", name)
+
+	chunks, _, err := s3.listUploadChunks(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks are only fetched once the reader reaches them.
+	reader := &s3UploadReader{}
+	readers := []io.Reader{}
+	for _, chunk := range chunks {
+		object, err := s3.client.GetObject(ctx, s3.bucket, chunk.Key, s3.getObjectOptions())
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		reader.objects = append(reader.objects, object)
+		readers = append(readers, object)
+	}
+	reader.Reader = io.MultiReader(readers...)
+	return reader, nil
+}
+
+func (s3 *S3Backend) DeleteUpload(ctx context.Context, name string) error {
+	logc.Printf(ctx, "s3: delete upload %s\n", name)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := s3.client.ListObjectsIter(ctx, s3.bucket, minio.ListObjectsOptions{
+		Prefix: fmt.Sprintf("upload/%s/", name),
+	})
+	results, err := s3.client.RemoveObjectsWithIter(ctx, s3.bucket, objects,
+		minio.RemoveObjectsOptions{})
+	if err != nil {
+		return err
+	}
+	for result := range results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+func (s3 *S3Backend) EnumerateUploads(ctx context.Context) iter.Seq2[UploadMetadata, error] {
+	return func(yield func(UploadMetadata, error) bool) {
+		logc.Println(ctx, "s3: enumerate uploads")
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// Objects are listed in lexicographic order, so all chunks of an upload are adjacent.
+		var metadata *UploadMetadata
+		prefix := "upload/"
+		for object := range s3.client.ListObjectsIter(ctx, s3.bucket, minio.ListObjectsOptions{
+			Prefix:    prefix,
+			Recursive: true,
+		}) {
+			if object.Err != nil {
+				if !yield(UploadMetadata{}, object.Err) {
+					return
+				}
+				continue
+			}
+			name := path.Dir(strings.TrimPrefix(object.Key, prefix))
+			if metadata != nil && metadata.Name != name {
+				if !yield(*metadata, nil) {
+					return
+				}
+				metadata = nil
+			}
+			if metadata == nil {
+				metadata = &UploadMetadata{Name: name}
+			}
+			metadata.Size += object.Size
+			if object.LastModified.After(metadata.LastModified) {
+				metadata.LastModified = object.LastModified
+			}
+		}
+		if metadata != nil {
+			yield(*metadata, nil)
+		}
+	}
+}
+
+func tlsObjectName(name string) string {
+	return fmt.Sprintf("tls/%s", name)
+}
+
+func (s3 *S3Backend) GetTLSData(ctx context.Context, name string) ([]byte, error) {
+	logc.Printf(ctx, "s3: get tls %s\n", name)
+
+	object, err := s3.client.GetObject(ctx, s3.bucket, tlsObjectName(name),
+		s3.getObjectOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, errResp.Key)
+	} else if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s3 *S3Backend) PutTLSData(ctx context.Context, name string, data []byte) error {
+	logc.Printf(ctx, "s3: put tls %s\n", name)
+
+	_, err := s3.client.PutObject(ctx, s3.bucket, tlsObjectName(name),
+		bytes.NewReader(data), int64(len(data)), s3.putObjectOptions(""))
+	return err
+}
+
+func (s3 *S3Backend) DeleteTLSData(ctx context.Context, name string) error {
+	logc.Printf(ctx, "s3: delete tls %s\n", name)
+
+	return s3.client.RemoveObject(ctx, s3.bucket, tlsObjectName(name),
+		minio.RemoveObjectOptions{})
+}
+
+func (s3 *S3Backend) EnumerateTLSData(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		logc.Println(ctx, "s3: enumerate tls")
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		prefix := tlsObjectName("")
+		for object := range s3.client.ListObjectsIter(ctx, s3.bucket, minio.ListObjectsOptions{
+			Prefix: prefix,
+		}) {
+			if object.Err != nil {
+				if !yield("", object.Err) {
+					return
+				}
+				continue
+			}
+			if !yield(strings.TrimPrefix(object.Key, prefix), nil) {
+				return
+			}
+		}
+	}
+}
+
+func rateLimitObjectName(name string) string {
+	return fmt.Sprintf("ratelimit/%s", name)
+}
+
+// Replaces the contents of an object with the result of `update`, retrying if the object was
+// concurrently changed.
+func (s3 *S3Backend) updateObject(
+	ctx context.Context, objectName string, update func(data []byte) []byte,
+) error {
+	for {
+		var data []byte
+		var etag string
+		object, err := s3.client.GetObject(ctx, s3.bucket, objectName, s3.getObjectOptions())
+		if err != nil {
+			return err
+		}
+		stat, err := object.Stat()
+		if err == nil {
+			etag = stat.ETag
+			data, err = io.ReadAll(object)
+		}
+		object.Close()
+		if errResp := minio.ToErrorResponse(err); err != nil && errResp.Code != "NoSuchKey" {
+			return err
+		}
+
+		newData := update(data)
+		if newData == nil {
+			return nil
+		}
+
+		// Conditional writes are not guaranteed to be supported (see `HasAtomicCAS`).
+		options := s3.putObjectOptions("")
+		if etag == "" {
+			options.SetMatchETagExcept("*")
+		} else {
+			options.SetMatchETag(etag)
+		}
+		_, err = s3.client.PutObject(ctx, s3.bucket, objectName,
+			bytes.NewReader(newData), int64(len(newData)), options)
+		if errResp := minio.ToErrorResponse(err); errResp.Code == "PreconditionFailed" {
+			logc.Printf(ctx, "s3: update %s (conflict)\n", objectName)
+			continue
+		}
+		return err
+	}
+}
+
+func (s3 *S3Backend) UpdateRateLimit(
+	ctx context.Context, name string, update func(state []byte) []byte,
+) error {
+	return s3.updateObject(ctx, rateLimitObjectName(name), update)
+}
+
+func statsObjectName(webRoot string) string {
+	return fmt.Sprintf("stats/%s", webRoot)
+}
+
+// Reads the contents of an object written by `updateObject`.
+func (s3 *S3Backend) readObject(ctx context.Context, objectName string) ([]byte, error) {
+	object, err := s3.client.GetObject(ctx, s3.bucket, objectName, s3.getObjectOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if errResp := minio.ToErrorResponse(err); errResp.Code == "NoSuchKey" {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, errResp.Key)
+	} else if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s3 *S3Backend) GetSiteStats(ctx context.Context, webRoot string) ([]byte, error) {
+	logc.Printf(ctx, "s3: get stats %s\n", webRoot)
+
+	return s3.readObject(ctx, statsObjectName(webRoot))
+}
+
+func (s3 *S3Backend) UpdateSiteStats(
+	ctx context.Context, webRoot string, update func(data []byte) []byte,
+) error {
+	logc.Printf(ctx, "s3: update stats %s\n", webRoot)
+
+	return s3.updateObject(ctx, statsObjectName(webRoot), update)
+}
+
 func auditObjectName(id AuditID) string {
 	return fmt.Sprintf("audit/%s", id)
 }
@@ -877,7 +1688,7 @@ func (s3 *S3Backend) AppendAuditLog(ctx context.Context, id AuditID, record *Aud
 	name := auditObjectName(id)
 	data := EncodeAuditRecord(record)
 
-	options := minio.PutObjectOptions{}
+	options := s3.putObjectOptions(s3.auditStorageClass)
 	options.SetMatchETagExcept("*") // may or may not be supported
 	_, err := s3.client.PutObject(ctx, s3.bucket, name,
 		bytes.NewReader(data), int64(len(data)), options)
@@ -887,11 +1698,24 @@ func (s3 *S3Backend) AppendAuditLog(ctx context.Context, id AuditID, record *Aud
 	return err
 }
 
+func (s3 *S3Backend) ReplaceAuditRecord(ctx context.Context, id AuditID, record *AuditRecord) error {
+	logc.Printf(ctx, "s3: replace audit %s\n", id)
+
+	name := auditObjectName(id)
+	data := EncodeAuditRecord(record)
+
+	options := s3.putObjectOptions(s3.auditStorageClass)
+	options.SetMatchETag("*") // may or may not be supported
+	_, err := s3.client.PutObject(ctx, s3.bucket, name,
+		bytes.NewReader(data), int64(len(data)), options)
+	return err
+}
+
 func (s3 *S3Backend) QueryAuditLog(ctx context.Context, id AuditID) (*AuditRecord, error) {
 	logc.Printf(ctx, "s3: read audit %s\n", id)
 
 	object, err := s3.client.GetObject(ctx, s3.bucket, auditObjectName(id),
-		minio.GetObjectOptions{})
+		s3.getObjectOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -908,9 +1732,9 @@ func (s3 *S3Backend) QueryAuditLog(ctx context.Context, id AuditID) (*AuditRecor
 	}
 
 	_, err = s3.client.StatObject(ctx, s3.bucket, auditDetachedObjectName(id),
-		minio.StatObjectOptions{})
+		s3.getObjectOptions())
 	if err == nil {
-		record.Manifest = nil
+		record.Detach()
 	} else if errResp := minio.ToErrorResponse(err); errResp.Code != "NoSuchKey" {
 		return nil, err
 	}
@@ -918,10 +1742,40 @@ func (s3 *S3Backend) QueryAuditLog(ctx context.Context, id AuditID) (*AuditRecor
 	return record, nil
 }
 
+func auditIndexObjectName(termName string, id AuditID) string {
+	return fmt.Sprintf("audit-index/%s/%s", termName, id)
+}
+
+// The index entries of each audit record are listed in an object named after the audit record,
+// so that they can be removed together with it.
+func auditIndexEntriesObjectName(id AuditID) string {
+	return fmt.Sprintf("audit-index/by-id/%s", id)
+}
+
+func (s3 *S3Backend) IndexAuditRecord(ctx context.Context, id AuditID, terms []string) error {
+	logc.Printf(ctx, "s3: index audit %s\n", id)
+
+	var termNames []string
+	for _, term := range terms {
+		termName := auditIndexTermName(term)
+		_, err := s3.client.PutObject(ctx, s3.bucket, auditIndexObjectName(termName, id),
+			&bytes.Reader{}, 0, s3.putObjectOptions(""))
+		if err != nil {
+			return err
+		}
+		termNames = append(termNames, termName)
+	}
+	return s3.updateObject(ctx, auditIndexEntriesObjectName(id), func(data []byte) []byte {
+		termNames = append(termNames, strings.Fields(string(data))...)
+		slices.Sort(termNames)
+		return []byte(strings.Join(slices.Compact(termNames), "\n") + "\n")
+	})
+}
+
 func (s3 *S3Backend) SearchAuditLog(
 	ctx context.Context, opts SearchAuditLogOptions,
 ) iter.Seq2[AuditID, error] {
-	return func(yield func(AuditID, error) bool) {
+	ids := func(yield func(AuditID, error) bool) {
 		logc.Printf(ctx, "s3: search audit\n")
 
 		ctx, cancel := context.WithCancel(ctx)
@@ -939,9 +1793,7 @@ func (s3 *S3Backend) SearchAuditLog(
 				continue
 			} else if id, err = ParseAuditID(strings.TrimPrefix(object.Key, prefix)); err != nil {
 				// report error
-			} else if !opts.Since.IsZero() && id.CompareTime(opts.Since) < 0 {
-				continue
-			} else if !opts.Until.IsZero() && id.CompareTime(opts.Until) > 0 {
+			} else if !opts.inTimeRange(id) {
 				continue
 			}
 			if !yield(id, err) {
@@ -949,6 +1801,35 @@ func (s3 *S3Backend) SearchAuditLog(
 			}
 		}
 	}
+	if len(opts.indexTerms()) == 0 {
+		return ids
+	} else if !s3.HasFeature(ctx, FeatureAuditIndex) {
+		return searchAuditLogByDecoding(ctx, s3, ids, opts)
+	}
+	return searchAuditIndex(opts, func(termName string) iter.Seq2[AuditID, error] {
+		return func(yield func(AuditID, error) bool) {
+			logc.Printf(ctx, "s3: search audit index %s\n", termName)
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			prefix := fmt.Sprintf("audit-index/%s/", termName)
+			for object := range s3.client.ListObjectsIter(ctx, s3.bucket, minio.ListObjectsOptions{
+				Prefix: prefix,
+			}) {
+				var id AuditID
+				var err error
+				if object.Err != nil {
+					err = object.Err
+				} else {
+					id, err = ParseAuditID(strings.TrimPrefix(object.Key, prefix))
+				}
+				if !yield(id, err) {
+					break
+				}
+			}
+		}
+	})
 }
 
 var getAuditLogRecordsSemaphore = make(chan struct{}, 64)
@@ -992,13 +1873,54 @@ func (s3 *S3Backend) DetachAuditRecord(ctx context.Context, id AuditID) error {
 	logc.Printf(ctx, "s3: detach audit record %s\n", id)
 
 	_, err := s3.client.PutObject(ctx, s3.bucket, auditDetachedObjectName(id),
-		&bytes.Reader{}, 0, minio.PutObjectOptions{})
+		&bytes.Reader{}, 0, s3.putObjectOptions(s3.auditStorageClass))
 	return err
 }
 
 func (s3 *S3Backend) ExpireAuditRecord(ctx context.Context, id AuditID) error {
 	logc.Printf(ctx, "s3: expire audit record %s\n", id)
 
+	object, err := s3.client.GetObject(ctx, s3.bucket, auditIndexEntriesObjectName(id),
+		s3.getObjectOptions())
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(object)
+	object.Close()
+	if errResp := minio.ToErrorResponse(err); err != nil && errResp.Code != "NoSuchKey" {
+		return err
+	}
+	for _, termName := range strings.Fields(string(data)) {
+		err := s3.client.RemoveObject(ctx, s3.bucket, auditIndexObjectName(termName, id),
+			minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	err = s3.client.RemoveObject(ctx, s3.bucket, auditIndexEntriesObjectName(id),
+		minio.RemoveObjectOptions{})
+	if err != nil {
+		return err
+	}
+
 	return s3.client.RemoveObject(ctx, s3.bucket, auditObjectName(id),
 		minio.RemoveObjectOptions{})
 }
+
+func auditChainHeadObjectName(node int) string {
+	return fmt.Sprintf("audit-chain/%d", node)
+}
+
+func (s3 *S3Backend) GetAuditChainHead(ctx context.Context, node int) ([]byte, error) {
+	logc.Printf(ctx, "s3: get audit chain %d\n", node)
+
+	return s3.readObject(ctx, auditChainHeadObjectName(node))
+}
+
+func (s3 *S3Backend) UpdateAuditChainHead(
+	ctx context.Context, node int, update func(data []byte) []byte,
+) error {
+	logc.Printf(ctx, "s3: update audit chain %d\n", node)
+
+	return s3.updateObject(ctx, auditChainHeadObjectName(node), update)
+}
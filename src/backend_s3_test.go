@@ -0,0 +1,132 @@
+package git_pages
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+func TestMakeS3Credentials(t *testing.T) {
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY",
+		"AWS_SESSION_TOKEN", "MINIO_ROOT_USER", "MINIO_ROOT_PASSWORD",
+		"MINIO_ACCESS_KEY", "MINIO_SECRET_KEY", "AWS_PROFILE",
+	} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	err := os.WriteFile(credentialsFile, []byte(
+		"[default]\naws_access_key_id = default-key\naws_secret_access_key = default-secret\n"+
+			"[other]\naws_access_key_id = other-key\naws_secret_access_key = other-secret\n",
+	), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		config    S3Config
+		expectKey string
+		expectErr string
+	}{
+		{"static", S3Config{CredentialSource: "static",
+			AccessKeyID: "static-key", SecretAccessKey: "static-secret"}, "static-key", ""},
+		{"env", S3Config{CredentialSource: "env",
+			AccessKeyID: "static-key", SecretAccessKey: "static-secret"}, "env-key", ""},
+		{"file", S3Config{CredentialSource: "file",
+			CredentialsFile: credentialsFile}, "default-key", ""},
+		{"file profile", S3Config{CredentialSource: "file",
+			CredentialsFile: credentialsFile, CredentialsProfile: "other"}, "other-key", ""},
+		{"chain with static", S3Config{CredentialSource: "chain",
+			AccessKeyID: "static-key", SecretAccessKey: "static-secret"}, "static-key", ""},
+		{"chain without static", S3Config{CredentialSource: "chain",
+			CredentialsFile: credentialsFile}, "env-key", ""},
+		{"unknown", S3Config{CredentialSource: "vault"}, "", "unknown credential source"},
+	} {
+		creds, err := makeS3Credentials(&test.config)
+		if test.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+				t.Errorf("%s: expect err %s, got err %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expect ok %s, got err %s", test.name, test.expectKey, err)
+		} else if value, err := creds.Get(); err != nil {
+			t.Errorf("%s: expect ok %s, got err %s", test.name, test.expectKey, err)
+		} else if value.AccessKeyID != test.expectKey {
+			t.Errorf("%s: expect ok %s, got ok %s", test.name, test.expectKey, value.AccessKeyID)
+		}
+	}
+
+	// Retrieving IAM credentials requires the instance metadata service; only check that
+	// the provider is created.
+	if creds, err := makeS3Credentials(&S3Config{CredentialSource: "iam"}); err != nil || creds == nil {
+		t.Errorf("iam: expect ok, got err %v", err)
+	}
+}
+
+func TestMakeS3Encryption(t *testing.T) {
+	customerKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	for _, test := range []struct {
+		name       string
+		config     S3EncryptionConfig
+		expectType encrypt.Type // none if empty
+		expectErr  string
+	}{
+		{"none", S3EncryptionConfig{}, "", ""},
+		{"sse-s3", S3EncryptionConfig{Type: "sse-s3"}, encrypt.S3, ""},
+		{"sse-kms", S3EncryptionConfig{Type: "sse-kms", KMSKeyID: "key"}, encrypt.KMS, ""},
+		{"sse-kms default key", S3EncryptionConfig{Type: "sse-kms"}, encrypt.KMS, ""},
+		{"sse-c", S3EncryptionConfig{Type: "sse-c", CustomerKey: customerKey}, encrypt.SSEC, ""},
+		{"sse-c malformed key", S3EncryptionConfig{Type: "sse-c", CustomerKey: "!"},
+			"", "sse-c customer key"},
+		{"sse-c short key", S3EncryptionConfig{Type: "sse-c",
+			CustomerKey: base64.StdEncoding.EncodeToString([]byte("short"))}, "", "256 bit"},
+		{"unknown", S3EncryptionConfig{Type: "sse-x"}, "", "unknown encryption type"},
+	} {
+		sse, err := makeS3Encryption(&test.config)
+		if test.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+				t.Errorf("%s: expect err %s, got err %v", test.name, test.expectErr, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: expect ok, got err %s", test.name, err)
+		} else if test.expectType == "" && sse != nil {
+			t.Errorf("%s: expect no encryption, got %s", test.name, sse.Type())
+		} else if test.expectType != "" && (sse == nil || sse.Type() != test.expectType) {
+			t.Errorf("%s: expect encryption %s, got %v", test.name, test.expectType, sse)
+		}
+	}
+}
+
+func TestS3ObjectOptions(t *testing.T) {
+	sse := encrypt.NewSSE()
+	s3 := &S3Backend{sse: sse, blobStorageClass: "GLACIER_IR", auditStorageClass: "STANDARD_IA"}
+	for _, test := range []struct {
+		storageClass string
+	}{
+		{""},
+		{s3.blobStorageClass},
+		{s3.auditStorageClass},
+	} {
+		options := s3.putObjectOptions(test.storageClass)
+		if options.StorageClass != test.storageClass {
+			t.Errorf("%q: expect storage class %q, got %q",
+				test.storageClass, test.storageClass, options.StorageClass)
+		}
+		if options.ServerSideEncryption != sse {
+			t.Errorf("%q: expect server-side encryption on put", test.storageClass)
+		}
+	}
+	if options := s3.getObjectOptions(); options.ServerSideEncryption != sse {
+		t.Errorf("expect server-side encryption on get")
+	}
+}
@@ -0,0 +1,84 @@
+package git_pages
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A circuit breaker stops requests from being made to a backend that is failing, both to let
+// it recover and to avoid making every request wait for a response that is unlikely to come.
+//
+// After `threshold` consecutive failures, the breaker opens and rejects all requests for
+// `cooldown`. Once the cooldown expires, a single request is let through to probe the backend;
+// if it succeeds, the breaker closes, and if it fails, the breaker opens again.
+type circuitBreaker struct {
+	threshold uint
+	cooldown  time.Duration
+
+	mutex     sync.Mutex
+	failures  uint
+	openUntil time.Time
+	probing   bool
+
+	openGauge    prometheus.Gauge
+	tripsCounter prometheus.Counter
+}
+
+func newCircuitBreaker(
+	threshold uint, cooldown time.Duration,
+	openGauge prometheus.Gauge, tripsCounter prometheus.Counter,
+) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:    threshold,
+		cooldown:     cooldown,
+		openGauge:    openGauge,
+		tripsCounter: tripsCounter,
+	}
+}
+
+// Returns `ErrBackendUnavailable` if a request should not be made, and nil otherwise. Every
+// request that is allowed must be followed by a call to `Record` with its outcome.
+func (b *circuitBreaker) Allow() error {
+	if b == nil || b.threshold == 0 {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	} else if !b.probing && time.Now().After(b.openUntil) {
+		b.probing = true
+		return nil
+	} else {
+		return ErrBackendUnavailable
+	}
+}
+
+// Records the outcome of a request. Only errors indicating that the backend itself is failing
+// should be passed here; errors such as a missing object are a successful outcome.
+func (b *circuitBreaker) Record(err error) {
+	if b == nil || b.threshold == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err == nil {
+		if b.failures >= b.threshold {
+			b.openGauge.Set(0)
+		}
+		b.failures = 0
+		b.probing = false
+	} else {
+		b.failures += 1
+		if b.probing || b.failures == b.threshold {
+			b.openUntil = time.Now().Add(b.cooldown)
+			b.openGauge.Set(1)
+			b.tripsCounter.Inc()
+		}
+		b.probing = false
+	}
+}
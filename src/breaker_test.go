@@ -0,0 +1,68 @@
+package git_pages
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	errFailing := errors.New("failing")
+
+	// Each step either checks whether a request is allowed ("allow" or "reject"), records
+	// the outcome of a request ("ok" or "fail"), or waits for the cooldown to expire ("wait").
+	for _, test := range []struct {
+		name      string
+		threshold uint
+		steps     []string
+	}{
+		{"disabled", 0, []string{
+			"fail", "fail", "fail", "allow",
+		}},
+		{"below threshold", 3, []string{
+			"allow", "fail", "allow", "fail", "allow",
+		}},
+		{"success resets", 3, []string{
+			"fail", "fail", "ok", "fail", "fail", "allow",
+		}},
+		{"trip", 3, []string{
+			"fail", "fail", "fail", "reject", "reject",
+		}},
+		{"probe succeeds", 2, []string{
+			"fail", "fail", "reject", "wait", "allow", "reject", "ok", "allow", "allow",
+		}},
+		{"probe fails", 2, []string{
+			"fail", "fail", "wait", "allow", "fail", "reject", "wait", "allow", "ok", "allow",
+		}},
+	} {
+		breaker := newCircuitBreaker(test.threshold, cooldown,
+			prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_open"}),
+			prometheus.NewCounter(prometheus.CounterOpts{Name: "test_trips"}))
+		for index, step := range test.steps {
+			switch step {
+			case "allow", "reject":
+				err := breaker.Allow()
+				if step == "allow" && err != nil {
+					t.Errorf("%s: step %d: expect allow, got err %s", test.name, index, err)
+				} else if step == "reject" && !errors.Is(err, ErrBackendUnavailable) {
+					t.Errorf("%s: step %d: expect reject, got err %v", test.name, index, err)
+				}
+			case "ok":
+				breaker.Record(nil)
+			case "fail":
+				breaker.Record(errFailing)
+			case "wait":
+				time.Sleep(cooldown + 10*time.Millisecond)
+			}
+		}
+	}
+
+	var breaker *circuitBreaker
+	breaker.Record(errFailing)
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("nil: expect allow, got err %s", err)
+	}
+}
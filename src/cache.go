@@ -2,6 +2,8 @@ package git_pages
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/maypok86/otter/v2"
@@ -12,45 +14,70 @@ type weightedCacheEntry interface {
 	Weight() uint32
 }
 
+// Maximum time a cache load may take. Loads are shared by every request for the same key that
+// arrives while the load is in progress, so they are not cancelled together with the request
+// that started them, and are limited by this timeout instead.
+const cacheLoadTimeout = 5 * time.Minute
+
 type trackedLoader[K comparable, V any] struct {
 	loader   otter.Loader[K, V]
+	loading  *sync.Map
 	loaded   bool
 	reloaded bool
 }
 
 func (l *trackedLoader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.loading.Store(key, struct{}{})
+	defer l.loading.Delete(key)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheLoadTimeout)
+	defer cancel()
+
 	val, err := l.loader.Load(ctx, key)
 	l.loaded = true
 	return val, err
 }
 
 func (l *trackedLoader[K, V]) Reload(ctx context.Context, key K, oldValue V) (V, error) {
+	ctx, cancel := context.WithTimeout(ctx, cacheLoadTimeout)
+	defer cancel()
+
 	val, err := l.loader.Reload(ctx, key, oldValue)
 	l.reloaded = true
 	return val, err
 }
 
 type observedCacheMetrics struct {
-	HitNumberCounter      prometheus.Counter
-	HitWeightCounter      prometheus.Counter
-	MissNumberCounter     prometheus.Counter
-	MissWeightCounter     prometheus.Counter
-	EvictionNumberCounter prometheus.Counter
-	EvictionWeightCounter prometheus.Counter
+	HitNumberCounter       prometheus.Counter
+	HitWeightCounter       prometheus.Counter
+	MissNumberCounter      prometheus.Counter
+	MissWeightCounter      prometheus.Counter
+	CoalescedNumberCounter prometheus.Counter
+	StaleNumberCounter     prometheus.Counter
+	EvictionNumberCounter  prometheus.Counter
+	EvictionWeightCounter  prometheus.Counter
 }
 
 type observedCache[K comparable, V weightedCacheEntry] struct {
 	Cache *otter.Cache[K, V]
 
 	metrics observedCacheMetrics
+	// Keys that are currently being loaded. Concurrent requests for such keys are coalesced
+	// by `otter.Cache.Get`; this is only used to tell them apart from cache hits.
+	loading sync.Map
+	// How long before expiry an entry stops being used as-is. Such entries are retained only
+	// to be used if the backend becomes unavailable, and are revalidated on every access.
+	staleIfError time.Duration
 }
 
 func newObservedCache[K comparable, V weightedCacheEntry](
 	options *otter.Options[K, V],
+	staleIfError time.Duration,
 	metrics observedCacheMetrics,
 ) (*observedCache[K, V], error) {
 	c := &observedCache[K, V]{}
 	c.metrics = metrics
+	c.staleIfError = staleIfError
 
 	optionsCopy := *options
 	options = &optionsCopy
@@ -64,9 +91,23 @@ func newObservedCache[K comparable, V weightedCacheEntry](
 	return c, nil
 }
 
-func (c *observedCache[K, V]) Get(ctx context.Context, key K, loader otter.Loader[K, V]) (V, error) {
-	observedLoader := trackedLoader[K, V]{loader: loader}
-	val, err := c.Cache.Get(ctx, key, &observedLoader)
+// Returns the value for `key`, loading it if it is not cached. If the backend is unavailable,
+// returns a cached value retained for `stale-if-error`, if any, and sets `stale`.
+func (c *observedCache[K, V]) Get(
+	ctx context.Context, key K, loader otter.Loader[K, V],
+) (
+	val V, stale bool, err error,
+) {
+	if c.staleIfError != 0 {
+		entry, found := c.Cache.GetEntry(key)
+		if found && time.Now().After(entry.ExpiresAt().Add(-c.staleIfError)) {
+			return c.Revalidate(ctx, key, loader)
+		}
+	}
+
+	observedLoader := trackedLoader[K, V]{loader: loader, loading: &c.loading}
+	_, coalesced := c.loading.Load(key)
+	val, err = c.Cache.Get(ctx, key, &observedLoader)
 	if err == nil {
 		if observedLoader.loaded {
 			if c.metrics.MissNumberCounter != nil {
@@ -75,6 +116,10 @@ func (c *observedCache[K, V]) Get(ctx context.Context, key K, loader otter.Loade
 			if c.metrics.MissWeightCounter != nil {
 				c.metrics.MissWeightCounter.Add(float64(val.Weight()))
 			}
+		} else if coalesced {
+			if c.metrics.CoalescedNumberCounter != nil {
+				c.metrics.CoalescedNumberCounter.Inc()
+			}
 		} else {
 			if c.metrics.HitNumberCounter != nil {
 				c.metrics.HitNumberCounter.Inc()
@@ -84,7 +129,49 @@ func (c *observedCache[K, V]) Get(ctx context.Context, key K, loader otter.Loade
 			}
 		}
 	}
-	return val, err
+	return val, false, err
+}
+
+// Loads a fresh value for `key`, replacing the cached one, if any. If the backend is
+// unavailable, returns the cached value instead, and sets `stale`.
+func (c *observedCache[K, V]) Revalidate(
+	ctx context.Context, key K, loader otter.Loader[K, V],
+) (
+	val V, stale bool, err error,
+) {
+	entry, found := c.Cache.GetEntry(key)
+	if !found || c.staleIfError == 0 {
+		c.Cache.Invalidate(key)
+		return c.Get(ctx, key, loader)
+	}
+
+	observedLoader := trackedLoader[K, V]{loader: loader, loading: &c.loading}
+	refresh := c.Cache.Refresh(ctx, key, &observedLoader)
+	if refresh == nil {
+		// The cache has no refresh calculator, so the cached value cannot be kept in place
+		// while loading a fresh one.
+		c.Cache.Invalidate(key)
+		return c.Get(ctx, key, loader)
+	}
+	result := <-refresh
+	if result.Err == nil {
+		if c.metrics.MissNumberCounter != nil {
+			c.metrics.MissNumberCounter.Inc()
+		}
+		if c.metrics.MissWeightCounter != nil {
+			c.metrics.MissWeightCounter.Add(float64(result.Value.Weight()))
+		}
+		return result.Value, false, nil
+	} else if errors.Is(result.Err, ErrBackendUnavailable) {
+		// A failed refresh leaves the cached value in place.
+		if c.metrics.StaleNumberCounter != nil {
+			c.metrics.StaleNumberCounter.Inc()
+		}
+		return entry.Value, true, nil
+	} else {
+		c.Cache.Invalidate(key)
+		return val, false, result.Err
+	}
 }
 
 func (c *observedCache[K, V]) RecordHits(count int)   {}
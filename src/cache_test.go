@@ -0,0 +1,66 @@
+package git_pages
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/maypok86/otter/v2"
+)
+
+type testCacheEntry string
+
+func (entry testCacheEntry) Weight() uint32 { return uint32(len(entry)) }
+
+func TestObservedCacheRevalidate(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name   string
+		config CacheConfig
+	}{
+		{"max-stale", CacheConfig{
+			MaxAge: Duration(time.Millisecond), MaxStale: Duration(time.Millisecond),
+			StaleIfError: Duration(time.Hour)}},
+		{"no max-stale", CacheConfig{
+			MaxAge: Duration(time.Millisecond), StaleIfError: Duration(time.Hour)}},
+		{"no max-age", CacheConfig{StaleIfError: Duration(time.Hour)}},
+	} {
+		cache, err := newObservedCache(
+			makeCacheOptions[string, testCacheEntry](&test.config, nil),
+			time.Duration(test.config.StaleIfError), observedCacheMetrics{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var loadErr error
+		loads := 0
+		loader := otter.LoaderFunc[string, testCacheEntry](
+			func(ctx context.Context, key string) (testCacheEntry, error) {
+				loads += 1
+				return testCacheEntry(fmt.Sprintf("%s %d", key, loads)), loadErr
+			})
+
+		if value, stale, err := cache.Get(ctx, "a", loader); err != nil || stale || value != "a 1" {
+			t.Errorf("%s: get: expect ok a 1, got %q, stale %t, err %v", test.name, value, stale, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if value, stale, err := cache.Revalidate(ctx, "a", loader); err != nil || stale || value != "a 2" {
+			t.Errorf("%s: revalidate: expect ok a 2, got %q, stale %t, err %v",
+				test.name, value, stale, err)
+		}
+
+		loadErr = fmt.Errorf("%w: test", ErrBackendUnavailable)
+		time.Sleep(5 * time.Millisecond)
+		value, stale, err := cache.Revalidate(ctx, "a", loader)
+		if test.config.MaxAge == 0 {
+			// Without an expiry, the value cannot be refreshed and is loaded again instead.
+			if err == nil {
+				t.Errorf("%s: revalidate unavailable: expect err, got %q", test.name, value)
+			}
+		} else if err != nil || !stale || value != "a 2" {
+			t.Errorf("%s: revalidate unavailable: expect stale a 2, got %q, stale %t, err %v",
+				test.name, value, stale, err)
+		}
+	}
+}
@@ -26,29 +26,8 @@ func ServeCaddy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var err error
 	domain = strings.ToLower(domain)
-
-	// Run a cheap check as to whether we might be serving the domain.
-	found := existenceCache.CheckDomain(r.Context(), domain).IsPossible()
-
-	if found {
-		// Run an expensive check as to whether we are actually serving the domain.
-		found, err = backend.CheckDomain(r.Context(), domain)
-	}
-
-	if !found {
-		// If we don't serve the domain, but a fallback server does, then we should let our
-		// Caddy instance request a TLS certificate. Otherwise, we'll never have an opportunity
-		// to proxy the request further. (This functionality was originally added for Codeberg
-		// Pages v2, which would under some circumstances return certificates with subjectAltName
-		// not valid for the SNI. Go's TLS stack makes `tls.Dial` return an error for these,
-		// thankfully making it unnecessary to examine X.509 certificates manually here.)
-		found, err = tryDialWithSNI(r.Context(), domain)
-		if err != nil {
-			logc.Printf(r.Context(), "caddy err: check SNI: %s\n", err)
-		}
-	}
+	found, err := checkCertificateDomain(r.Context(), domain)
 
 	if found {
 		logc.Println(r.Context(), "caddy:", domain, 200)
@@ -63,7 +42,34 @@ func ServeCaddy(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Checks whether a TLS certificate should be provisioned for `domain`, which is the case if
+// either git-pages or the fallback server is serving it.
+func checkCertificateDomain(ctx context.Context, domain string) (found bool, err error) {
+	// Run a cheap check as to whether we might be serving the domain.
+	found = existenceCache.CheckDomain(ctx, domain).IsPossible()
+
+	if found {
+		// Run an expensive check as to whether we are actually serving the domain.
+		found, err = backend.CheckDomain(ctx, domain)
+	}
+
+	if !found {
+		// If we don't serve the domain, but a fallback server does, then we should let Caddy
+		// (or the built-in ACME client) request a TLS certificate. Otherwise, we'll never have
+		// an opportunity to proxy the request further. (This functionality was originally added
+		// for Codeberg Pages v2, which would under some circumstances return certificates with
+		// subjectAltName not valid for the SNI. Go's TLS stack makes `tls.Dial` return an error
+		// for these, thankfully making it unnecessary to examine X.509 certificates manually.)
+		found, err = tryDialWithSNI(ctx, domain)
+		if err != nil {
+			logc.Printf(ctx, "tls err: check SNI: %s\n", err)
+		}
+	}
+	return
+}
+
 func tryDialWithSNI(ctx context.Context, domain string) (bool, error) {
+	config := currentConfig(ctx)
 	if config.Fallback.ProxyTo == nil {
 		return false, nil
 	}
@@ -78,7 +84,7 @@ func tryDialWithSNI(ctx context.Context, domain string) (bool, error) {
 		connectPort = "443"
 	}
 
-	logc.Printf(ctx, "caddy: check TLS %s", fallbackURL)
+	logc.Printf(ctx, "tls: check SNI %s via %s", domain, fallbackURL)
 	dialer := tls.Dialer{Config: &tls.Config{ServerName: domain}}
 	connection, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(connectHost, connectPort))
 	if err != nil {
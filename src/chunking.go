@@ -0,0 +1,178 @@
+package git_pages
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// Gear hash values used to find chunk boundaries. These values are arbitrary, but must never
+// change, since chunks could no longer be deduplicated across uploads otherwise.
+var chunkGearTable = func() (table [256]uint64) {
+	for index := range table {
+		hash := sha256.Sum256([]byte{byte(index)})
+		table[index] = binary.LittleEndian.Uint64(hash[:8])
+	}
+	return
+}()
+
+// Splits `data` into chunks at content-defined boundaries using the FastCDC algorithm (with
+// normalized chunking). The chunks are between `avgSize/4` and `avgSize*4` bytes long, except
+// for the last one, which may be shorter.
+func SplitChunks(data []byte, avgSize int) (chunks [][]byte) {
+	avgSize = max(avgSize, 64)
+	minSize, maxSize := avgSize/4, avgSize*4
+	// The gear hash mixes each byte into the upper bits, so the masks select the upper bits.
+	// Before reaching the average size, a boundary is harder to find; past it, easier.
+	avgBits := bits.Len(uint(avgSize)) - 1
+	strictMask := ^uint64(0) << (64 - (avgBits + 1))
+	looseMask := ^uint64(0) << (64 - (avgBits - 1))
+
+	for len(data) > 0 {
+		end := len(data)
+		if end > minSize {
+			end = min(end, maxSize)
+			hash, index := uint64(0), minSize
+			for ; index < min(end, avgSize); index++ {
+				hash = (hash << 1) + chunkGearTable[data[index]]
+				if hash&strictMask == 0 {
+					end = index + 1
+					break
+				}
+			}
+			if end > avgSize {
+				for ; index < end; index++ {
+					hash = (hash << 1) + chunkGearTable[data[index]]
+					if hash&looseMask == 0 {
+						end = index + 1
+						break
+					}
+				}
+			}
+		}
+		chunks = append(chunks, data[:end])
+		data = data[end:]
+	}
+	return
+}
+
+// Returns the names and sizes of blobs an entry refers to.
+func EntryBlobs(entry *Entry) map[string]int64 {
+	blobs := map[string]int64{}
+	switch entry.GetType() {
+	case Type_ExternalFile:
+		blobs[string(entry.GetData())] = entry.GetCompressedSize()
+	case Type_ChunkedFile:
+		for _, chunk := range entry.GetChunks() {
+			blobs[string(chunk.GetBlob())] = chunk.GetSize()
+		}
+	}
+	return blobs
+}
+
+func chunkedEntryETag(entry *Entry) string {
+	hasher := sha256.New()
+	for _, chunk := range entry.GetChunks() {
+		hasher.Write(chunk.GetBlob())
+		hasher.Write([]byte{0})
+	}
+	return fmt.Sprintf(`"chunked-%x"`, hasher.Sum(nil))
+}
+
+var ErrChunkSizeMismatch = errors.New("chunk size mismatch")
+
+// Reads the contents of a chunked file, retrieving chunks from the backend as they are needed.
+// Only the chunks that are read from are retrieved, so seeking to serve a range request does
+// not require retrieving the whole file.
+type chunkedReader struct {
+	ctx     context.Context
+	chunks  []*Chunk
+	starts  []int64 // offset of the first byte of each chunk
+	size    int64
+	offset  int64
+	current int // index of the chunk in `data`, or -1
+	data    []byte
+}
+
+var _ io.ReadSeeker = (*chunkedReader)(nil)
+
+// Opens a chunked file and retrieves its first chunk, so that errors can be reported before
+// any of the contents are served. Returns the metadata of the first chunk.
+func OpenChunkedFile(ctx context.Context, entry *Entry) (*chunkedReader, BlobMetadata, error) {
+	if entry.GetType() != Type_ChunkedFile {
+		return nil, BlobMetadata{}, ErrNotRegularFile
+	}
+	reader := &chunkedReader{ctx: ctx, chunks: entry.GetChunks(), current: -1}
+	for _, chunk := range reader.chunks {
+		reader.starts = append(reader.starts, reader.size)
+		reader.size += chunk.GetSize()
+	}
+	var metadata BlobMetadata
+	if len(reader.chunks) > 0 {
+		var err error
+		if metadata, err = reader.load(0); err != nil {
+			return nil, BlobMetadata{}, err
+		}
+	}
+	return reader, metadata, nil
+}
+
+func (reader *chunkedReader) load(index int) (BlobMetadata, error) {
+	chunk := reader.chunks[index]
+	blobReader, metadata, err := backend.GetBlob(reader.ctx, string(chunk.GetBlob()))
+	if err != nil {
+		return metadata, fmt.Errorf("chunk %s: %w", chunk.GetBlob(), err)
+	}
+	data, err := io.ReadAll(blobReader)
+	if closer, ok := blobReader.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return metadata, fmt.Errorf("chunk %s: %w", chunk.GetBlob(), err)
+	}
+	if int64(len(data)) != chunk.GetSize() {
+		return metadata, fmt.Errorf("chunk %s: %w", chunk.GetBlob(), ErrChunkSizeMismatch)
+	}
+	reader.current, reader.data = index, data
+	return metadata, nil
+}
+
+func (reader *chunkedReader) Read(p []byte) (int, error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+	index, found := slices.BinarySearch(reader.starts, reader.offset)
+	if !found {
+		index -= 1
+	}
+	if index != reader.current {
+		if _, err := reader.load(index); err != nil {
+			return 0, err
+		}
+	}
+	count := copy(p, reader.data[reader.offset-reader.starts[index]:])
+	reader.offset += int64(count)
+	return count, nil
+}
+
+func (reader *chunkedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	default:
+		return reader.offset, fmt.Errorf("seek: invalid whence")
+	}
+	if offset < 0 {
+		return reader.offset, fmt.Errorf("seek: negative position")
+	}
+	reader.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,84 @@
+package git_pages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand/v2"
+	"testing"
+)
+
+func randomTestData(seed uint64, size int) []byte {
+	data := make([]byte, size)
+	random := rand.New(rand.NewPCG(seed, seed))
+	for index := range data {
+		data[index] = byte(random.Uint32())
+	}
+	return data
+}
+
+func TestSplitChunks(t *testing.T) {
+	for _, test := range []struct {
+		size    int
+		avgSize int
+	}{
+		{0, 1024},
+		{1, 1024},
+		{255, 1024},
+		{256, 1024},
+		{4096, 1024},
+		{100000, 1024},
+		{100000, 4096},
+		// the average size is clamped to 64 bytes
+		{10000, 1},
+	} {
+		data := randomTestData(1, test.size)
+		chunks := SplitChunks(data, test.avgSize)
+		if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, data) {
+			t.Errorf("size %d, avg %d: chunks do not add up to data", test.size, test.avgSize)
+		}
+		avgSize := max(test.avgSize, 64)
+		for index, chunk := range chunks {
+			if len(chunk) > avgSize*4 ||
+				(len(chunk) < avgSize/4 && index != len(chunks)-1) {
+				t.Errorf("size %d, avg %d: chunk %d has size %d",
+					test.size, test.avgSize, index, len(chunk))
+			}
+		}
+	}
+}
+
+// Chunk boundaries depend only on the data around them, so that changing a part of a file
+// leaves the chunks of the rest of it unchanged, and they can be deduplicated.
+func TestSplitChunksBoundaryStability(t *testing.T) {
+	data := randomTestData(2, 200000)
+	for _, test := range []struct {
+		name     string
+		modified []byte
+	}{
+		{"prepend", append([]byte("prefix"), data...)},
+		{"append", append(bytes.Clone(data), []byte("suffix")...)},
+		{"insert", append(append(bytes.Clone(data[:100000]), []byte("infix")...), data[100000:]...)},
+		{"remove", append(bytes.Clone(data[:100000]), data[100010:]...)},
+		{"overwrite", append(append(bytes.Clone(data[:100000]), []byte("overwritten")...),
+			data[100011:]...)},
+	} {
+		chunkHashes := map[[32]byte]bool{}
+		chunks := SplitChunks(data, 1024)
+		for _, chunk := range chunks {
+			chunkHashes[sha256.Sum256(chunk)] = true
+		}
+		modifiedChunks := SplitChunks(test.modified, 1024)
+		changed := 0
+		for _, chunk := range modifiedChunks {
+			if !chunkHashes[sha256.Sum256(chunk)] {
+				changed += 1
+			}
+		}
+		// An edit affects the chunk it is in, and at most a few chunks after it until
+		// the boundaries realign.
+		if changed == 0 || changed > 3 {
+			t.Errorf("%s: expect 1 to 3 of %d chunks changed, got %d",
+				test.name, len(modifiedChunks), changed)
+		}
+	}
+}
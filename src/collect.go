@@ -17,6 +17,11 @@ func CollectTar(
 ) (
 	err error,
 ) {
+	manifest, err = UnshardManifest(context, manifest)
+	if err != nil {
+		return err
+	}
+
 	archive := tar.NewWriter(writer)
 
 	appendFile := func(header *tar.Header, data []byte, transform Transform) (err error) {
@@ -83,6 +88,23 @@ func CollectTar(
 			header.ModTime = blobMetadata.LastModified
 			err = appendFile(&header, blobData, entry.GetTransform())
 
+		case Type_ChunkedFile:
+			var chunkedReader io.Reader
+			var chunkMetadata BlobMetadata
+			var fileData []byte
+			chunkedReader, chunkMetadata, err = OpenChunkedFile(context, entry)
+			if err != nil {
+				return
+			}
+			fileData, err = io.ReadAll(chunkedReader)
+			if err != nil {
+				return
+			}
+			header.Typeflag = tar.TypeReg
+			header.Mode = 0644
+			header.ModTime = chunkMetadata.LastModified
+			err = appendFile(&header, fileData, entry.GetTransform())
+
 		case Type_Symlink:
 			header.Typeflag = tar.TypeSymlink
 			header.Mode = 0644
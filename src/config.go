@@ -37,6 +37,47 @@ func (t *Duration) MarshalText() ([]byte, error) {
 	return []byte(t.String()), nil
 }
 
+// A rate limit in the form `<count>/<period>`, e.g. `30/1h`, allowing bursts of up to `count`
+// events that are replenished evenly over `period`. An empty rate limit is disabled.
+type RateLimit struct {
+	Count  uint
+	Period time.Duration
+}
+
+func (t RateLimit) String() string {
+	if t.Count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", t.Count, t.Period)
+}
+
+func (t *RateLimit) UnmarshalText(data []byte) (err error) {
+	if len(data) == 0 {
+		*t = RateLimit{}
+		return
+	}
+	count, period, found := strings.Cut(string(data), "/")
+	if !found {
+		return fmt.Errorf("malformed rate limit %q (expected '<count>/<period>')", data)
+	}
+	parsedCount, err := strconv.ParseUint(count, 10, 32)
+	if err != nil {
+		return fmt.Errorf("rate limit %q: %w", data, err)
+	}
+	parsedPeriod, err := time.ParseDuration(period)
+	if err != nil {
+		return fmt.Errorf("rate limit %q: %w", data, err)
+	} else if parsedPeriod <= 0 {
+		return fmt.Errorf("rate limit %q: period must be positive", data)
+	}
+	*t = RateLimit{uint(parsedCount), parsedPeriod}
+	return
+}
+
+func (t *RateLimit) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
 // For a known but upsetting reason, the standard `url.URL` type doesn't implement the standard
 // `encoding.{TextMarshaler,TextUnmarshaler}` interfaces.
 type URL struct {
@@ -68,7 +109,12 @@ type Config struct {
 	Fallback      FallbackConfig      `toml:"fallback"`
 	Storage       StorageConfig       `toml:"storage"`
 	Limits        LimitsConfig        `toml:"limits"`
+	RateLimits    RateLimitsConfig    `toml:"rate-limits"`
+	AccessLog     AccessLogConfig     `toml:"access-log"`
+	Stats         StatsConfig         `toml:"stats"`
 	Audit         AuditConfig         `toml:"audit"`
+	Admin         AdminConfig         `toml:"admin"`
+	ACME          ACMEConfig          `toml:"acme"`
 	Observability ObservabilityConfig `toml:"observability"`
 }
 
@@ -76,6 +122,20 @@ type ServerConfig struct {
 	Pages   string `toml:"pages" default:"tcp/localhost:3000"`
 	Caddy   string `toml:"caddy" default:"tcp/localhost:3001"`
 	Metrics string `toml:"metrics" default:"tcp/localhost:3002"`
+	Admin   string `toml:"admin"` // disabled if empty
+	HTTPS   string `toml:"https"` // disabled if empty
+	// Names of the endpoints above (e.g. "pages") whose connections start with a PROXY protocol
+	// header (v1 or v2), which is read if the connection is made by a trusted proxy.
+	ProxyProtocol []string `toml:"proxy-protocol" default:"[]"`
+	// IP addresses and CIDR ranges of the proxies trusted to report the address of the client
+	// via the PROXY protocol, `X-Forwarded-For:`, or `Forwarded:`. These headers are ignored
+	// in requests made by any other host (except via a Unix domain socket).
+	TrustedProxies []string `toml:"trusted-proxies" default:"[]"`
+	// Time between the server starting to report itself as unhealthy on shutdown and it
+	// closing its listeners, to let load balancers stop routing requests to it.
+	ShutdownDelay Duration `toml:"shutdown-delay" default:"0s"`
+	// Maximum time to wait for in-flight requests and background updates to finish on shutdown.
+	DrainTimeout Duration `toml:"drain-timeout" default:"30s"`
 }
 
 type WildcardConfig struct {
@@ -97,11 +157,49 @@ type CacheConfig struct {
 	MaxSize  datasize.ByteSize `toml:"max-size"`
 	MaxAge   Duration          `toml:"max-age"`
 	MaxStale Duration          `toml:"max-stale"`
+	// How long past `max-age + max-stale` an entry is retained, to be served if the backend
+	// cannot be reached to revalidate it.
+	StaleIfError Duration `toml:"stale-if-error"`
 }
 
 type StorageConfig struct {
-	Type string   `toml:"type" default:"fs"`
-	FS   FSConfig `toml:"fs"  default:"{\"Root\":\"./data\"}"`
+	Type       string           `toml:"type" default:"fs"`
+	FS         FSConfig         `toml:"fs"  default:"{\"Root\":\"./data\"}"`
+	S3         S3Config         `toml:"s3"`
+	Replicated ReplicatedConfig `toml:"replicated"`
+	// Client-side encryption of site contents and audit records; independent of the backend.
+	Encryption EncryptionConfig `toml:"encryption"`
+}
+
+type EncryptionConfig struct {
+	// Keys used to encrypt blobs, manifests, and audit records before they are stored, each
+	// in the form `<key-id>:<base64-encoded 256-bit key>`. If empty, nothing is encrypted.
+	Keys []string `toml:"keys" default:"[]"`
+	// ID of the key used to encrypt new data; the other keys are only used to decrypt existing
+	// data. If empty, the first key is used.
+	KeyID string `toml:"key-id"`
+	// Whether to read data stored before encryption was enabled. Such data is not authenticated,
+	// so anyone who can write to the storage could use it to inject manifests or audit records;
+	// this option should only be enabled until `-run-migration reencrypt` has been run.
+	AllowPlaintext bool `toml:"allow-plaintext"`
+}
+
+type ReplicatedConfig struct {
+	// Backends that the data is replicated across, in order of preference. The first replica is
+	// the primary one.
+	Replicas []ReplicaConfig `toml:"replica"`
+	// Either "all", where a write succeeds only if it succeeds on every replica, or "quorum",
+	// where a write succeeds if it succeeds on a majority of replicas.
+	WritePolicy string `toml:"write-policy" default:"all"`
+	// Either "primary", where reads are made from the first available replica in the configured
+	// order, or "nearest", where reads are made from the replica with the lowest latency first.
+	ReadPolicy string `toml:"read-policy" default:"primary"`
+}
+
+type ReplicaConfig struct {
+	Name string   `toml:"name"`
+	Type string   `toml:"type"`
+	FS   FSConfig `toml:"fs"`
 	S3   S3Config `toml:"s3"`
 }
 
@@ -118,7 +216,44 @@ type S3Config struct {
 	Region          string      `toml:"region"`
 	Bucket          string      `toml:"bucket"`
 	BlobCache       CacheConfig `toml:"blob-cache" default:"{\"MaxSize\":\"256MB\"}"`
-	SiteCache       CacheConfig `toml:"site-cache" default:"{\"MaxAge\":\"60s\",\"MaxStale\":\"1h\",\"MaxSize\":\"16MB\"}"`
+	SiteCache       CacheConfig `toml:"site-cache" default:"{\"MaxAge\":\"60s\",\"MaxStale\":\"1h\",\"StaleIfError\":\"24h\",\"MaxSize\":\"16MB\"}"`
+	// How often to check for manifests changed by other nodes. If zero, changes made by other
+	// nodes become visible only after `site-cache.max-age`.
+	InvalidationInterval Duration `toml:"invalidation-interval" default:"1s"`
+	// Number of consecutive failed requests after which the backend is considered unavailable,
+	// and requests to it are not made for `circuit-breaker-cooldown`. If zero, requests are
+	// always made.
+	CircuitBreakerThreshold uint     `toml:"circuit-breaker-threshold" default:"5"`
+	CircuitBreakerCooldown  Duration `toml:"circuit-breaker-cooldown" default:"10s"`
+	// Source of the credentials used to access the bucket. One of:
+	// - "static": `access-key-id` and `secret-access-key`;
+	// - "env": the `AWS_ACCESS_KEY_ID`/`AWS_SECRET_ACCESS_KEY` or
+	//   `MINIO_ROOT_USER`/`MINIO_ROOT_PASSWORD` environment variables;
+	// - "file": the AWS shared credentials file `credentials-file` (`~/.aws/credentials` if
+	//   empty) and its profile `credentials-profile` (`AWS_PROFILE` or "default" if empty);
+	// - "iam": the EC2 instance role, ECS task role, or web identity
+	//   (`AWS_WEB_IDENTITY_TOKEN_FILE`);
+	// - "chain": each of the above, in order, using the first that provides credentials.
+	CredentialSource   string             `toml:"credential-source" default:"static"`
+	CredentialsFile    string             `toml:"credentials-file"`
+	CredentialsProfile string             `toml:"credentials-profile"`
+	Encryption         S3EncryptionConfig `toml:"encryption"`
+	// Storage classes for blobs and for audit records. Manifests and all other objects are
+	// always stored using the bucket's default storage class. If empty, the default storage
+	// class is used.
+	BlobStorageClass  string `toml:"blob-storage-class"`
+	AuditStorageClass string `toml:"audit-storage-class"`
+}
+
+type S3EncryptionConfig struct {
+	// Server-side encryption applied to every object. One of "" (the bucket's default
+	// encryption), "sse-s3", "sse-kms", or "sse-c". Changing this option does not re-encrypt
+	// existing objects; in case of "sse-c", existing objects become unreadable.
+	Type string `toml:"type"`
+	// Key ID for "sse-kms". If empty, the default KMS key is used.
+	KMSKeyID string `toml:"kms-key-id"`
+	// Base64-encoded 256-bit key for "sse-c". Requires a secure connection.
+	CustomerKey string `toml:"customer-key"`
 }
 
 type LimitsConfig struct {
@@ -128,8 +263,19 @@ type LimitsConfig struct {
 	// Maximum size of a single site manifest, computed over its binary Protobuf
 	// serialization.
 	MaxManifestSize datasize.ByteSize `toml:"max-manifest-size" default:"1M"`
+	// Maximum size of a site manifest before the entries in some of its directories are moved
+	// into separate shards, which allows sites to contain more files than would fit within
+	// `max-manifest-size`. If zero, manifests are never split into shards.
+	ManifestShardSize datasize.ByteSize `toml:"manifest-shard-size"`
 	// Maximum size of a file that will still be inlined into the site manifest.
 	MaxInlineFileSize datasize.ByteSize `toml:"max-inline-file-size" default:"256B"`
+	// Minimum size of a file that will be split into content-defined chunks, each stored as
+	// a separate blob, so that changing a part of a large file only stores the changed chunks.
+	// If zero, files are never split into chunks.
+	ChunkedFileThreshold datasize.ByteSize `toml:"chunked-file-threshold"`
+	// Average size of a chunk of a chunked file. Chunks are between a quarter and four times
+	// this size.
+	ChunkSize datasize.ByteSize `toml:"chunk-size" default:"1M"`
 	// Maximum size of a Git object that will be cached in memory during Git operations.
 	GitLargeObjectThreshold datasize.ByteSize `toml:"git-large-object-threshold" default:"1M"`
 	// Maximum number of symbolic link traversals before the path is considered unreachable.
@@ -137,6 +283,8 @@ type LimitsConfig struct {
 	// Maximum time that an update operation (PUT or POST request) could take before being
 	// interrupted.
 	UpdateTimeout Duration `toml:"update-timeout" default:"60s"`
+	// Time after which a resumable archive upload that has not received any data is discarded.
+	ResumableUploadTimeout Duration `toml:"resumable-upload-timeout" default:"24h"`
 	// Maximum number of concurrent blob uploads, globally across every update request.
 	ConcurrentUploads uint `toml:"concurrent-uploads" default:"1024"`
 	// Soft limit on Go heap size, expressed as a fraction of total available RAM.
@@ -158,18 +306,126 @@ type LimitsConfig struct {
 	AllowExpiration bool `toml:"allow-expiration" default:"false"`
 }
 
+type RateLimitsConfig struct {
+	// Rate of update requests (including deletions, promotions, and resumable uploads) made by
+	// a single principal, identified by their forge account if the request was authorized using
+	// a forge token, or by their IP address otherwise.
+	UpdatesPerPrincipal RateLimit `toml:"updates-per-principal"`
+	// Rate of update requests made for a single site.
+	UpdatesPerSite RateLimit `toml:"updates-per-site"`
+	// Rate of repository fetches from a single forge host.
+	FetchesPerForgeHost RateLimit `toml:"fetches-per-forge-host"`
+	// Rate of read requests made for a single site.
+	ReadsPerSite RateLimit `toml:"reads-per-site"`
+	// Whether the state of update and fetch rate limits is stored in the backend, shared by
+	// every node using the same storage. Read rate limits are always tracked by each node.
+	Shared bool `toml:"shared"`
+}
+
+type AccessLogConfig struct {
+	// Format of the access log, which has an entry for every request made to the `pages` and
+	// `https` endpoints. One of "" (disabled), "common" (the Common Log Format), "combined"
+	// (the Combined Log Format), or "json". Client addresses are truncated to their /24 (IPv4)
+	// or /48 (IPv6) prefix.
+	Format string `toml:"format"`
+	// File that the access log is appended to; it is reopened when the configuration is
+	// reloaded. If empty, the access log is written to standard output.
+	File string `toml:"file"`
+}
+
+type StatsConfig struct {
+	// Whether per-site traffic statistics are collected. These are available via
+	// `.git-pages/stats.json` and never include client addresses.
+	Collect bool `toml:"collect"`
+	// How often the statistics collected by each node are added to the ones in the backend.
+	FlushInterval Duration `toml:"flush-interval" default:"1m"`
+	// Number of days for which daily statistics are kept.
+	RetentionDays uint `toml:"retention-days" default:"30"`
+	// Number of the most requested paths kept for every day.
+	TopPaths uint `toml:"top-paths" default:"20"`
+}
+
 type AuditConfig struct {
 	// Globally unique machine identifier (0 to 63 inclusive).
 	NodeID int `toml:"node-id"`
 	// Whether audit reports should be stored whenever an audit event occurs.
 	Collect bool `toml:"collect"`
 	// If not empty, includes the principal's IP address in audit reports, with the value specifying
-	// the source of the IP address. If the value is "X-Forwarded-For", the last item of the
-	// corresponding header field (assumed to be comma-separated) is used. If the value is
-	// "RemoteAddr", the connecting host's address is used. Any other value is disallowed.
+	// the source of the IP address. If the value is "X-Forwarded-For", the client's address is
+	// used, which is taken from the `X-Forwarded-For:` (or `Forwarded:`) header fields if
+	// the request was made by one of `[server].trusted-proxies`. If the value is "RemoteAddr",
+	// the connecting host's address is used. Any other value is disallowed.
 	IncludeIPs string `toml:"include-ip"`
 	// Endpoint to notify with a `GET /<notify-url>?<id>` whenever an audit event occurs.
 	NotifyURL *URL `toml:"notify-url"`
+	// Endpoints that audit records are sent to as signed JSON whenever an audit event occurs.
+	Subscribers []AuditSubscriberConfig `toml:"subscriber"`
+	// Directory where notifications for subscribers are stored until they are delivered, so that
+	// they are delivered after a restart. If empty, such notifications are only kept in memory.
+	NotifyOutbox string `toml:"notify-outbox"`
+	// File where audit notifications that could not be delivered before shutdown are saved,
+	// to be delivered after the next startup. If empty, such notifications are only logged.
+	PendingNotifyFile string `toml:"pending-notify-file"`
+	// Ed25519 private key (as a base64-encoded 32-byte seed) that checkpoints of the audit record
+	// chain are signed with. If empty, no checkpoints are made.
+	CheckpointKey string `toml:"checkpoint-key"`
+	// Interval at which checkpoints are made, if any audit records were appended since the last
+	// checkpoint.
+	CheckpointInterval Duration `toml:"checkpoint-interval" default:"1h"`
+	// Additional Ed25519 public keys (base64-encoded) accepted when verifying checkpoints, e.g.
+	// the public keys of previously used `checkpoint-key` values.
+	CheckpointPublicKeys []string `toml:"checkpoint-public-keys" default:"[]"`
+	// Number of days after which audit records are expired. If zero, audit records are only
+	// expired with `-audit-expire`.
+	RetentionDays uint `toml:"retention-days"`
+	// Number of days after which audit records are detached from their manifest snapshots, so
+	// that the blobs only referenced by the snapshots can be reclaimed. If zero, audit records
+	// are only detached with `-audit-detach`.
+	DetachAfterDays uint `toml:"detach-after-days"`
+	// Number of the most recent audit records of each site that are never expired or detached.
+	KeepPerSite uint `toml:"keep-per-site"`
+	// Names of the audit events (e.g. "FreezeDomain") whose records are never expired or detached.
+	KeepEvents []string `toml:"keep-events" default:"[]"`
+	// Interval at which the server expires and detaches audit records per the options above.
+	MaintainInterval Duration `toml:"maintain-interval" default:"24h"`
+}
+
+type AuditSubscriberConfig struct {
+	// Name identifying the subscriber in logs and in `[audit].notify-outbox`.
+	Name string `toml:"name"`
+	// Endpoint that audit records are sent to with `POST` requests.
+	URL *URL `toml:"url"`
+	// Key that the requests are signed with using HMAC-SHA256.
+	Secret string `toml:"secret"`
+	// Names of the audit events to send (e.g. "CommitManifest"). If empty, every event is sent.
+	Events []string `toml:"events" default:"[]"`
+	// Either "no-manifest", where manifest snapshots are omitted from audit records, or
+	// "complete".
+	Scope string `toml:"scope" default:"no-manifest"`
+}
+
+type AdminConfig struct {
+	// Bearer tokens accepted by the admin API, each in the form `<name>:<token>`. The name
+	// identifies the credential in audit records.
+	Tokens []string `toml:"tokens" default:"[]"`
+	// Paths to the PEM-encoded certificate chain and private key of the admin API. If set,
+	// the admin API is served over TLS.
+	TLSCert string `toml:"tls-cert"`
+	TLSKey  string `toml:"tls-key"`
+	// Path to PEM-encoded CA certificates. If set, client certificates issued by these CAs are
+	// accepted by the admin API, and identified in audit records by their subject.
+	ClientCA string `toml:"client-ca"`
+}
+
+type ACMEConfig struct {
+	// Contact address registered with the ACME account, used by the CA to send notices about
+	// the certificates it issued.
+	Email string `toml:"email"`
+	// Directory URL of the ACME server that certificates are requested from.
+	DirectoryURL string `toml:"directory-url" default:"https://acme-v02.api.letsencrypt.org/directory"`
+	// Path to PEM-encoded CA certificates trusted when connecting to the ACME server, in addition
+	// to the system ones. Useful for testing against a local ACME server such as Pebble.
+	CACert string `toml:"ca-cert"`
 }
 
 type ObservabilityConfig struct {
@@ -189,6 +445,44 @@ func (config *Config) Feature(name string) bool {
 	return slices.Contains(config.Features, name)
 }
 
+// Returns the keys (in the TOML syntax) whose values differ between two configurations.
+func DiffConfig(oldConfig, newConfig *Config) (keys []string) {
+	keys = []string{}
+	flatten := func(config *Config) map[string]any {
+		var tree map[string]any
+		if _, err := toml.Decode(config.TOML(), &tree); err != nil {
+			panic(err)
+		}
+		flat := map[string]any{}
+		var walk func(prefix toml.Key, tree map[string]any)
+		walk = func(prefix toml.Key, tree map[string]any) {
+			for key, value := range tree {
+				if subtree, ok := value.(map[string]any); ok {
+					walk(append(slices.Clone(prefix), key), subtree)
+				} else {
+					flat[PrettyTomlKey(append(slices.Clone(prefix), key))] = value
+				}
+			}
+		}
+		walk(nil, tree)
+		return flat
+	}
+
+	oldValues, newValues := flatten(oldConfig), flatten(newConfig)
+	for key := range newValues {
+		if _, found := oldValues[key]; !found {
+			oldValues[key] = nil
+		}
+	}
+	for key, oldValue := range oldValues {
+		if !reflect.DeepEqual(oldValue, newValues[key]) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return
+}
+
 type walkConfigState struct {
 	config    reflect.Value
 	scopeType reflect.Type
@@ -292,6 +586,30 @@ func setConfigValue(reflValue reflect.Value, repr string) (err error) {
 			}
 			reflValue.Set(reflect.ValueOf(assigned))
 		}
+	case []AuditSubscriberConfig:
+		var parsed []*AuditSubscriberConfig
+		decoder := json.NewDecoder(bytes.NewReader([]byte(repr)))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&parsed); err == nil {
+			var assigned []AuditSubscriberConfig
+			for _, subscriber := range parsed {
+				defaults.MustSet(subscriber)
+				assigned = append(assigned, *subscriber)
+			}
+			reflValue.Set(reflect.ValueOf(assigned))
+		}
+	case []ReplicaConfig:
+		var parsed []*ReplicaConfig
+		decoder := json.NewDecoder(bytes.NewReader([]byte(repr)))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&parsed); err == nil {
+			var assigned []ReplicaConfig
+			for _, replica := range parsed {
+				defaults.MustSet(replica)
+				assigned = append(assigned, *replica)
+			}
+			reflValue.Set(reflect.ValueOf(assigned))
+		}
 	default:
 		panic("unhandled config value type")
 	}
@@ -369,6 +687,12 @@ func Configure(tomlPaths ...string) (config *Config, err error) {
 	for i := range config.Wildcard {
 		defaults.MustSet(&config.Wildcard[i])
 	}
+	for i := range config.Storage.Replicated.Replicas {
+		defaults.MustSet(&config.Storage.Replicated.Replicas[i])
+	}
+	for i := range config.Audit.Subscribers {
+		defaults.MustSet(&config.Audit.Subscribers[i])
+	}
 
 	return
 }
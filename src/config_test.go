@@ -0,0 +1,82 @@
+package git_pages
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitUnmarshalText(t *testing.T) {
+	for _, test := range []struct {
+		text      string
+		expect    RateLimit
+		expectErr string
+	}{
+		{"", RateLimit{}, ""},
+		{"30/1h", RateLimit{30, time.Hour}, ""},
+		{"1/500ms", RateLimit{1, 500 * time.Millisecond}, ""},
+		{"0/1m", RateLimit{0, time.Minute}, ""},
+		{"30", RateLimit{}, "malformed rate limit"},
+		{"x/1h", RateLimit{}, "invalid syntax"},
+		{"-1/1h", RateLimit{}, "invalid syntax"},
+		{"30/hour", RateLimit{}, "invalid duration"},
+		{"30/0s", RateLimit{}, "period must be positive"},
+		{"30/-1h", RateLimit{}, "period must be positive"},
+	} {
+		var rateLimit RateLimit
+		err := rateLimit.UnmarshalText([]byte(test.text))
+		if test.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+				t.Errorf("%q: expect err %s, got %v, err %v", test.text, test.expectErr, rateLimit, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: expect ok %v, got err %s", test.text, test.expect, err)
+		} else if rateLimit != test.expect {
+			t.Errorf("%q: expect ok %v, got ok %v", test.text, test.expect, rateLimit)
+		}
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		modify func(config *Config)
+		keys   []string
+	}{
+		{"unchanged", func(config *Config) {}, []string{}},
+		{"top level", func(config *Config) {
+			config.Features = []string{"archive-site"}
+		}, []string{"features"}},
+		{"section", func(config *Config) {
+			config.Limits.MaxSiteSize *= 2
+		}, []string{"[limits].max-site-size"}},
+		{"list", func(config *Config) {
+			config.Server.TrustedProxies = []string{"192.0.2.0/24"}
+		}, []string{"[server].trusted-proxies"}},
+		{"rate limit", func(config *Config) {
+			config.RateLimits.UpdatesPerSite = RateLimit{30, time.Hour}
+		}, []string{"[rate-limits].updates-per-site"}},
+		{"several", func(config *Config) {
+			config.Server.TrustedProxies = []string{"192.0.2.0/24"}
+			config.LogFormat = "json"
+			config.Limits.MaxSiteSize *= 2
+		}, []string{"[limits].max-site-size", "[server].trusted-proxies", "log-format"}},
+		{"wildcard", func(config *Config) {
+			config.Wildcard = []WildcardConfig{{Domain: "example.org"}}
+		}, []string{"wildcard"}},
+	} {
+		oldConfig, err := Configure()
+		if err != nil {
+			t.Fatal(err)
+		}
+		newConfig, err := Configure()
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.modify(newConfig)
+		if keys := DiffConfig(oldConfig, newConfig); !slices.Equal(keys, test.keys) {
+			t.Errorf("%s: expect keys %q, got %q", test.name, test.keys, keys)
+		}
+	}
+}
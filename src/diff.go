@@ -0,0 +1,242 @@
+package git_pages
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/fatih/color"
+	"google.golang.org/protobuf/proto"
+)
+
+// Returns the changes made to a site by replacing the manifest `from` with the manifest `to`.
+// Either manifest may be nil if the site was created or deleted. Shards that are present in both
+// manifests are not retrieved, since shards with the same blob names have the same entries.
+func DiffManifests(ctx context.Context, from, to *Manifest) (*ManifestDiff, error) {
+	fromShards := map[string]string{}
+	for _, shard := range from.GetShards() {
+		fromShards[shard.GetPath()] = string(shard.GetBlob())
+	}
+	sameShards := map[string]bool{}
+	for _, shard := range to.GetShards() {
+		if blob, found := fromShards[shard.GetPath()]; found && blob == string(shard.GetBlob()) {
+			sameShards[shard.GetPath()] = true
+		}
+	}
+	fromContents, err := diffManifestContents(ctx, from, sameShards)
+	if err != nil {
+		return nil, err
+	}
+	toContents, err := diffManifestContents(ctx, to, sameShards)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ManifestDiff{}
+	for name, fromEntry := range fromContents {
+		if toEntry, found := toContents[name]; !found {
+			diff.Removed = append(diff.Removed, name)
+		} else if !isSameEntry(fromEntry, toEntry) {
+			diff.Modified = append(diff.Modified, name)
+		}
+	}
+	for name := range toContents {
+		if _, found := fromContents[name]; !found {
+			diff.Added = append(diff.Added, name)
+		}
+	}
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Modified)
+
+	diff.AddedRedirects, diff.RemovedRedirects =
+		diffRuleLines(redirectRuleLines(from), redirectRuleLines(to))
+	diff.AddedHeaders, diff.RemovedHeaders =
+		diffRuleLines(headerRuleLines(from), headerRuleLines(to))
+	fromBasicAuth, toBasicAuth := basicAuthRulesByPath(from), basicAuthRulesByPath(to)
+	for path, fromRules := range fromBasicAuth {
+		if toRules, found := toBasicAuth[path]; !found || toRules != fromRules {
+			diff.ChangedBasicAuth = append(diff.ChangedBasicAuth, path)
+		}
+	}
+	for path := range toBasicAuth {
+		if _, found := fromBasicAuth[path]; !found {
+			diff.ChangedBasicAuth = append(diff.ChangedBasicAuth, path)
+		}
+	}
+	slices.Sort(diff.ChangedBasicAuth)
+
+	diff.SizeDelta = proto.Int64(to.GetOriginalSize() - from.GetOriginalSize())
+	return diff, nil
+}
+
+// Returns the files and symlinks in the manifest, including those in its shards except for
+// `skipShards`.
+func diffManifestContents(
+	ctx context.Context, manifest *Manifest, skipShards map[string]bool,
+) (map[string]*Entry, error) {
+	contents := map[string]*Entry{}
+	addContents := func(entries map[string]*Entry) {
+		for name, entry := range entries {
+			if entry.GetType() != Type_Directory {
+				contents[name] = entry
+			}
+		}
+	}
+	addContents(manifest.GetContents())
+	for _, shard := range manifest.GetShards() {
+		if skipShards[shard.GetPath()] {
+			continue
+		}
+		shardManifest, err := backend.GetManifestShard(ctx, string(shard.GetBlob()))
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard.GetPath(), err)
+		}
+		addContents(shardManifest.GetContents())
+	}
+	return contents, nil
+}
+
+// Entries with the same git hash have the same contents; otherwise, the contents are compared
+// by blob name (or by data, for inline files and symlinks).
+func isSameEntry(left, right *Entry) bool {
+	if left.GetContentType() != right.GetContentType() {
+		return false
+	}
+	if IsEntryRegularFile(left) && IsEntryRegularFile(right) &&
+		left.GetGitHash() != "" && right.GetGitHash() != "" {
+		return left.GetGitHash() == right.GetGitHash()
+	}
+	return left.GetType() == right.GetType() &&
+		left.GetTransform() == right.GetTransform() &&
+		bytes.Equal(left.GetData(), right.GetData()) &&
+		slices.EqualFunc(left.GetChunks(), right.GetChunks(), func(a, b *Chunk) bool {
+			return bytes.Equal(a.GetBlob(), b.GetBlob())
+		})
+}
+
+func redirectRuleLines(manifest *Manifest) (lines []string) {
+	for _, rule := range manifest.GetRedirects() {
+		lines = append(lines, unparseRedirectRule(exportRedirectRule(rule)))
+	}
+	return
+}
+
+func headerRuleLines(manifest *Manifest) (lines []string) {
+	for _, rule := range manifest.GetHeaders() {
+		for _, header := range rule.GetHeaderMap() {
+			lines = append(lines, fmt.Sprintf("%s %s: %s",
+				rule.GetPath(), header.GetName(), strings.Join(header.GetValues(), ", ")))
+		}
+	}
+	return
+}
+
+// Returns the credentials of the basic authorization rules by path, in a form that is only
+// suitable for comparison.
+func basicAuthRulesByPath(manifest *Manifest) map[string]string {
+	rules := map[string]string{}
+	for _, rule := range manifest.GetBasicAuth() {
+		var credentials []string
+		for _, credential := range rule.GetCredentials() {
+			credentials = append(credentials,
+				fmt.Sprintf("%q:%q", credential.GetUsername(), credential.GetPassword()))
+		}
+		rules[rule.GetPath()] += fmt.Sprintf("[%s]", strings.Join(credentials, " "))
+	}
+	return rules
+}
+
+// Returns the lines that only appear in `to` and only appear in `from`, in order; lines that
+// appear several times are matched up by count.
+func diffRuleLines(from, to []string) (added, removed []string) {
+	counts := map[string]int{}
+	for _, line := range from {
+		counts[line] += 1
+	}
+	for _, line := range to {
+		if counts[line] > 0 {
+			counts[line] -= 1
+		} else {
+			added = append(added, line)
+		}
+	}
+	for _, line := range slices.Backward(from) {
+		if counts[line] > 0 {
+			counts[line] -= 1
+			removed = append(removed, line)
+		}
+	}
+	slices.Reverse(removed)
+	return
+}
+
+func formatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + datasize.ByteSize(-delta).HR()
+	} else {
+		return "+" + datasize.ByteSize(delta).HR()
+	}
+}
+
+// Returns a one-line summary of the changes, such as "3 added, 1 modified, size +12.0 KB".
+func (diff *ManifestDiff) Summary() string {
+	var parts []string
+	for _, item := range []struct {
+		count int
+		desc  string
+	}{
+		{len(diff.GetAdded()), "added"},
+		{len(diff.GetRemoved()), "removed"},
+		{len(diff.GetModified()), "modified"},
+	} {
+		if item.count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", item.count, item.desc))
+		}
+	}
+	if len(diff.GetAddedRedirects())+len(diff.GetRemovedRedirects()) > 0 {
+		parts = append(parts, fmt.Sprintf("redirects +%d -%d",
+			len(diff.GetAddedRedirects()), len(diff.GetRemovedRedirects())))
+	}
+	if len(diff.GetAddedHeaders())+len(diff.GetRemovedHeaders()) > 0 {
+		parts = append(parts, fmt.Sprintf("headers +%d -%d",
+			len(diff.GetAddedHeaders()), len(diff.GetRemovedHeaders())))
+	}
+	if count := len(diff.GetChangedBasicAuth()); count > 0 {
+		parts = append(parts, fmt.Sprintf("basic auth ~%d", count))
+	}
+	if diff.GetSizeDelta() != 0 {
+		parts = append(parts, "size "+formatSizeDelta(diff.GetSizeDelta()))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Writes every change, one per line, in a format resembling `git diff --name-status`.
+func WriteManifestDiff(w io.Writer, diff *ManifestDiff) {
+	for _, group := range []struct {
+		items  []string
+		format func(format string, a ...any) string
+		prefix string
+	}{
+		{diff.GetAdded(), color.HiGreenString, "A\t/"},
+		{diff.GetRemoved(), color.HiRedString, "D\t/"},
+		{diff.GetModified(), color.HiYellowString, "M\t/"},
+		{diff.GetAddedRedirects(), color.HiGreenString, "+redirect\t"},
+		{diff.GetRemovedRedirects(), color.HiRedString, "-redirect\t"},
+		{diff.GetAddedHeaders(), color.HiGreenString, "+header\t"},
+		{diff.GetRemovedHeaders(), color.HiRedString, "-header\t"},
+		{diff.GetChangedBasicAuth(), color.HiYellowString, "~basic-auth\t"},
+	} {
+		for _, item := range group.items {
+			fmt.Fprintln(w, group.format("%s%s", group.prefix, item))
+		}
+	}
+	fmt.Fprintf(w, "size\t%s\n", formatSizeDelta(diff.GetSizeDelta()))
+}
@@ -0,0 +1,154 @@
+package git_pages
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDiffRuleLines(t *testing.T) {
+	for _, test := range []struct {
+		from, to       []string
+		added, removed []string
+	}{
+		{nil, nil, nil, nil},
+		{[]string{"a"}, []string{"a"}, nil, nil},
+		{nil, []string{"a", "b"}, []string{"a", "b"}, nil},
+		{[]string{"a", "b"}, nil, nil, []string{"a", "b"}},
+		{[]string{"a", "b"}, []string{"b", "c"}, []string{"c"}, []string{"a"}},
+		// order is preserved
+		{[]string{"c", "b", "a"}, []string{"f", "e", "d"},
+			[]string{"f", "e", "d"}, []string{"c", "b", "a"}},
+		// reordering is not a change
+		{[]string{"a", "b"}, []string{"b", "a"}, nil, nil},
+		// duplicates are matched up by count
+		{[]string{"a", "a"}, []string{"a"}, nil, []string{"a"}},
+		{[]string{"a"}, []string{"a", "a"}, []string{"a"}, nil},
+		{[]string{"a", "b", "a"}, []string{"b", "a", "a", "a"}, []string{"a"}, nil},
+		// the last duplicates are the removed ones
+		{[]string{"a", "b", "a", "c"}, []string{"a", "c"}, nil, []string{"b", "a"}},
+	} {
+		added, removed := diffRuleLines(test.from, test.to)
+		if !slices.Equal(added, test.added) || !slices.Equal(removed, test.removed) {
+			t.Errorf("%q -> %q: expect +%q -%q, got +%q -%q",
+				test.from, test.to, test.added, test.removed, added, removed)
+		}
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	file := func(data string, gitHash string) *Entry {
+		return &Entry{
+			Type:         Type_InlineFile.Enum(),
+			Data:         []byte(data),
+			GitHash:      proto.String(gitHash),
+			OriginalSize: proto.Int64(int64(len(data))),
+		}
+	}
+	manifest := func(size int64, contents map[string]*Entry, basicAuth ...*BasicAuthRule) *Manifest {
+		return &Manifest{
+			Contents:     contents,
+			OriginalSize: proto.Int64(size),
+			BasicAuth:    basicAuth,
+		}
+	}
+	basicAuth := func(path string, password string) *BasicAuthRule {
+		return &BasicAuthRule{
+			Path: proto.String(path),
+			Credentials: []*BasicCredential{
+				{Username: proto.String("user"), Password: proto.String(password)},
+			},
+		}
+	}
+	directory := &Entry{Type: Type_Directory.Enum()}
+
+	for _, test := range []struct {
+		name             string
+		from, to         *Manifest
+		added, removed   []string
+		modified         []string
+		changedBasicAuth []string
+		sizeDelta        int64
+	}{
+		{
+			name: "created",
+			from: nil,
+			to: manifest(3, map[string]*Entry{
+				"":           directory,
+				"index.html": file("abc", ""),
+			}),
+			added:     []string{"index.html"},
+			sizeDelta: 3,
+		},
+		{
+			name: "deleted",
+			from: manifest(3, map[string]*Entry{
+				"":           directory,
+				"index.html": file("abc", ""),
+			}),
+			to:        nil,
+			removed:   []string{"index.html"},
+			sizeDelta: -3,
+		},
+		{
+			name: "replaced",
+			from: manifest(6, map[string]*Entry{
+				"a": file("abc", ""),
+				"b": file("def", ""),
+				"c": file("ghi", ""),
+			}),
+			to: manifest(7, map[string]*Entry{
+				"a":   file("abc", ""),
+				"c":   file("xyz", ""),
+				"d/e": file("f", ""),
+			}),
+			added:     []string{"d/e"},
+			removed:   []string{"b"},
+			modified:  []string{"c"},
+			sizeDelta: 1,
+		},
+		{
+			name: "git hash takes precedence over data",
+			from: manifest(0, map[string]*Entry{
+				"a": file("inline", "1111"),
+				"b": file("same", "2222"),
+			}),
+			to: manifest(0, map[string]*Entry{
+				"a": file("external", "1111"),
+				"b": file("same", "3333"),
+			}),
+			modified: []string{"b"},
+		},
+		{
+			name: "basic auth",
+			from: manifest(0, nil, basicAuth("/a", "x"), basicAuth("/b", "x")),
+			to: manifest(0, nil,
+				basicAuth("/a", "x"), basicAuth("/b", "y"), basicAuth("/c", "x")),
+			changedBasicAuth: []string{"/b", "/c"},
+		},
+	} {
+		diff, err := DiffManifests(context.Background(), test.from, test.to)
+		if err != nil {
+			t.Errorf("%s: expect ok, got err %s", test.name, err)
+			continue
+		}
+		if !slices.Equal(diff.GetAdded(), test.added) {
+			t.Errorf("%s: expect added %q, got %q", test.name, test.added, diff.GetAdded())
+		}
+		if !slices.Equal(diff.GetRemoved(), test.removed) {
+			t.Errorf("%s: expect removed %q, got %q", test.name, test.removed, diff.GetRemoved())
+		}
+		if !slices.Equal(diff.GetModified(), test.modified) {
+			t.Errorf("%s: expect modified %q, got %q", test.name, test.modified, diff.GetModified())
+		}
+		if !slices.Equal(diff.GetChangedBasicAuth(), test.changedBasicAuth) {
+			t.Errorf("%s: expect changed basic auth %q, got %q",
+				test.name, test.changedBasicAuth, diff.GetChangedBasicAuth())
+		}
+		if diff.GetSizeDelta() != test.sizeDelta {
+			t.Errorf("%s: expect size delta %d, got %d", test.name, test.sizeDelta, diff.GetSizeDelta())
+		}
+	}
+}
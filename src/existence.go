@@ -55,6 +55,7 @@ type ExistenceCache interface {
 }
 
 func CreateExistenceCache(ctx context.Context) (ExistenceCache, error) {
+	config := currentConfig(ctx)
 	switch config.Storage.Type {
 	case "s3":
 		maxAge := time.Duration(config.Storage.S3.SiteCache.MaxAge)
@@ -3,24 +3,31 @@ package git_pages
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"mime"
+	"mime/multipart"
 	"os"
 	"path"
 	"strings"
 
 	"github.com/c2h5oh/datasize"
 	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 var ErrArchiveTooLarge = errors.New("archive too large")
 
 func boundArchiveStream(reader io.Reader) io.Reader {
+	config := loadConfig()
 	return ReadAtMost(reader, int64(config.Limits.MaxSiteSize.Bytes()),
 		fmt.Errorf("%w: %s limit exceeded", ErrArchiveTooLarge, config.Limits.MaxSiteSize.HR()))
 }
@@ -51,6 +58,294 @@ func ExtractZstd(
 	return next(ctx, boundArchiveStream(stream))
 }
 
+// The `ulikunitz/xz` package does not export its error values, so errors returned by it are
+// wrapped to make them recognizable by `IsArchiveParseError`.
+var errMalformedXz = errors.New("malformed xz stream")
+
+type xzReader struct {
+	stream *xz.Reader
+}
+
+func (reader xzReader) Read(data []byte) (int, error) {
+	count, err := reader.stream.Read(data)
+	if err != nil && err != io.EOF && !errors.Is(err, ErrArchiveTooLarge) &&
+		!errors.Is(err, errMalformedXz) {
+		err = fmt.Errorf("%w: %w", errMalformedXz, err)
+	}
+	return count, err
+}
+
+// The `ulikunitz/xz` package allocates the dictionary declared in each block header up front,
+// which may be as large as 4 GiB. To avoid this, the framing of the stream is followed as it is
+// read, and a block declaring a dictionary larger than `[limits].max-site-size` (which would
+// never be useful, since the dictionary only needs to hold the decompressed data) is rejected
+// before it reaches the decoder.
+type xzDictionaryGuard struct {
+	reader  *bufio.Reader
+	maxDict uint64
+	state   xzGuardState
+	pass    int    // bytes to pass through before the next structure
+	check   int    // size of the check field of each block
+	offset  int    // offset within the current block data or index, for padding
+	records uint64 // remaining fields of the index records
+}
+
+type xzGuardState int
+
+const (
+	xzStreamHeader xzGuardState = iota
+	xzBlockHeader
+	xzBlockChunk
+	xzBlockEnd
+	xzIndexHeader
+	xzIndexRecords
+	xzIndexEnd
+	xzStreamPadding
+)
+
+var xzStreamMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+const xzFilterLZMA2 = 0x21
+
+func newXzDictionaryGuard(reader io.Reader, maxDict uint64) *xzDictionaryGuard {
+	return &xzDictionaryGuard{reader: bufio.NewReader(reader), maxDict: maxDict}
+}
+
+func (guard *xzDictionaryGuard) Read(data []byte) (int, error) {
+	for guard.pass == 0 {
+		if err := guard.advance(); err != nil {
+			return 0, err
+		}
+	}
+	if len(data) > guard.pass {
+		data = data[:guard.pass]
+	}
+	count, err := guard.reader.Read(data)
+	guard.pass -= count
+	return count, err
+}
+
+func (guard *xzDictionaryGuard) peek(count int) ([]byte, error) {
+	data, err := guard.reader.Peek(count)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMalformedXz, err)
+	}
+	return data, nil
+}
+
+// Parses a multibyte integer at the start of `data`, returning its value and size.
+func parseXzVarint(data []byte) (value uint64, size int, ok bool) {
+	for size < len(data) && size < 9 {
+		value |= uint64(data[size]&0x7f) << (7 * size)
+		size += 1
+		if data[size-1]&0x80 == 0 {
+			return value, size, size == 1 || data[size-1] != 0
+		}
+	}
+	return 0, 0, false
+}
+
+// Returns the dictionary size encoded in the LZMA2 filter properties.
+func parseXzDictSize(props byte) (uint64, bool) {
+	if props > 40 {
+		return 0, false
+	} else if props == 40 {
+		return math.MaxUint32, true
+	}
+	return uint64(2|props&1) << (props/2 + 11), true
+}
+
+func (guard *xzDictionaryGuard) checkBlockHeader(header []byte) error {
+	flags := header[1]
+	if flags&0x3c != 0 {
+		return fmt.Errorf("%w: reserved block flags", errMalformedXz)
+	}
+	fields := header[2 : len(header)-4] // without CRC32
+	skipVarint := func() (uint64, bool) {
+		value, size, ok := parseXzVarint(fields)
+		fields = fields[size:]
+		return value, ok
+	}
+	if flags&0x40 != 0 { // compressed size
+		if _, ok := skipVarint(); !ok {
+			return fmt.Errorf("%w: block header", errMalformedXz)
+		}
+	}
+	if flags&0x80 != 0 { // uncompressed size
+		if _, ok := skipVarint(); !ok {
+			return fmt.Errorf("%w: block header", errMalformedXz)
+		}
+	}
+	for range flags&0x03 + 1 {
+		filterID, ok := skipVarint()
+		if !ok {
+			return fmt.Errorf("%w: block header", errMalformedXz)
+		}
+		propsSize, ok := skipVarint()
+		if !ok || propsSize > uint64(len(fields)) {
+			return fmt.Errorf("%w: block header", errMalformedXz)
+		}
+		props := fields[:propsSize]
+		fields = fields[propsSize:]
+		if filterID == xzFilterLZMA2 && len(props) == 1 {
+			dictSize, ok := parseXzDictSize(props[0])
+			if !ok {
+				return fmt.Errorf("%w: LZMA2 dictionary size", errMalformedXz)
+			} else if dictSize > guard.maxDict {
+				return fmt.Errorf("%w: xz dictionary size %s exceeds %s limit",
+					ErrArchiveTooLarge, datasize.ByteSize(dictSize).HR(),
+					datasize.ByteSize(guard.maxDict).HR())
+			}
+		}
+	}
+	return nil
+}
+
+// Parses the next structure of the stream, and determines how many bytes belong to it.
+func (guard *xzDictionaryGuard) advance() error {
+	switch guard.state {
+	case xzStreamHeader:
+		header, err := guard.peek(12)
+		if err != nil {
+			return err
+		} else if !bytes.HasPrefix(header, xzStreamMagic) {
+			return fmt.Errorf("%w: stream header", errMalformedXz)
+		}
+		if checkID := int(header[7] & 0x0f); checkID == 0 {
+			guard.check = 0
+		} else {
+			guard.check = 4 << ((checkID - 1) / 3)
+		}
+		guard.pass, guard.state = len(header), xzBlockHeader
+
+	case xzBlockHeader:
+		indicator, err := guard.peek(1)
+		if err != nil {
+			return err
+		} else if indicator[0] == 0 {
+			guard.state = xzIndexHeader
+			return nil
+		}
+		header, err := guard.peek((int(indicator[0]) + 1) * 4)
+		if err != nil {
+			return err
+		} else if err = guard.checkBlockHeader(header); err != nil {
+			return err
+		}
+		guard.pass, guard.state, guard.offset = len(header), xzBlockChunk, 0
+
+	case xzBlockChunk:
+		control, err := guard.peek(1)
+		if err != nil {
+			return err
+		}
+		var size int
+		switch {
+		case control[0] == 0x00: // end of block data
+			size, guard.state = 1, xzBlockEnd
+		case control[0] == 0x01 || control[0] == 0x02: // uncompressed chunk
+			header, err := guard.peek(3)
+			if err != nil {
+				return err
+			}
+			size = 3 + (int(header[1])<<8 | int(header[2])) + 1
+		case control[0] >= 0x80: // LZMA chunk
+			headerSize := 5
+			if (control[0]>>5)&0x03 >= 2 { // with properties
+				headerSize = 6
+			}
+			header, err := guard.peek(headerSize)
+			if err != nil {
+				return err
+			}
+			size = headerSize + (int(header[3])<<8 | int(header[4])) + 1
+		default:
+			return fmt.Errorf("%w: LZMA2 chunk", errMalformedXz)
+		}
+		guard.pass = size
+		guard.offset += size
+
+	case xzBlockEnd:
+		guard.pass, guard.state = (4-guard.offset%4)%4+guard.check, xzBlockHeader
+
+	case xzIndexHeader:
+		header, err := guard.peek(10)
+		if err != nil {
+			return err
+		}
+		count, size, ok := parseXzVarint(header[1:])
+		if !ok {
+			return fmt.Errorf("%w: index", errMalformedXz)
+		}
+		guard.pass, guard.state, guard.offset = 1+size, xzIndexRecords, 1+size
+		guard.records = count * 2 // unpadded size and uncompressed size
+
+	case xzIndexRecords:
+		if guard.records == 0 {
+			guard.state = xzIndexEnd
+			return nil
+		}
+		field, err := guard.peek(9)
+		if err != nil {
+			return err
+		}
+		_, size, ok := parseXzVarint(field)
+		if !ok {
+			return fmt.Errorf("%w: index", errMalformedXz)
+		}
+		guard.pass = size
+		guard.offset += size
+		guard.records -= 1
+
+	case xzIndexEnd:
+		// Index padding, CRC32, and stream footer.
+		guard.pass, guard.state = (4-guard.offset%4)%4+4+12, xzStreamPadding
+
+	case xzStreamPadding:
+		padding, err := guard.reader.Peek(4)
+		if len(padding) == 0 && err == io.EOF {
+			return io.EOF
+		} else if len(padding) > 0 && padding[0] != 0 {
+			guard.state = xzStreamHeader
+			return nil
+		} else if !bytes.Equal(padding, []byte{0, 0, 0, 0}) {
+			return fmt.Errorf("%w: stream padding", errMalformedXz)
+		}
+		guard.pass = len(padding)
+	}
+	return nil
+}
+
+func ExtractXz(
+	ctx context.Context, reader io.Reader,
+	next func(context.Context, io.Reader) (*Manifest, error),
+) (*Manifest, error) {
+	config := currentConfig(ctx)
+	// The dictionary declared in the stream is allocated if it is larger than `DictCap`.
+	guard := newXzDictionaryGuard(reader, config.Limits.MaxSiteSize.Bytes())
+	stream, err := xz.ReaderConfig{DictCap: lzma.MinDictCap}.NewReader(guard)
+	if err != nil {
+		if errors.Is(err, ErrArchiveTooLarge) || errors.Is(err, errMalformedXz) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", errMalformedXz, err)
+	}
+
+	return next(ctx, boundArchiveStream(xzReader{stream}))
+}
+
+func ExtractBzip2(
+	ctx context.Context, reader io.Reader,
+	next func(context.Context, io.Reader) (*Manifest, error),
+) (*Manifest, error) {
+	stream := bzip2.NewReader(reader)
+
+	return next(ctx, boundArchiveStream(stream))
+}
+
 func normalizeArchiveMemberName(fileName string) string {
 	// Strip the leading slash and any extraneous path segments.
 	fileName = path.Clean(fileName)
@@ -78,13 +373,15 @@ func addSymlinkOrBlobReference(
 	}
 }
 
-func ExtractTar(ctx context.Context, reader io.Reader, oldManifest *Manifest) (*Manifest, error) {
+func ExtractTar(
+	ctx context.Context, reader io.Reader, oldManifest *Manifest, reuseManifests ...*Manifest,
+) (*Manifest, error) {
 	archive := tar.NewReader(reader)
 
 	var dataBytesRecycled int64
 	var dataBytesTransferred int64
 
-	index := IndexManifestByGitHash(oldManifest)
+	index := IndexManifestByGitHash(append([]*Manifest{oldManifest}, reuseManifests...)...)
 	missing := []string{}
 	manifest := NewManifest()
 	hardLinks := map[string]*Entry{}
@@ -153,10 +450,96 @@ func ExtractTar(ctx context.Context, reader io.Reader, oldManifest *Manifest) (*
 	return manifest, nil
 }
 
+var errMalformedMultipart = errors.New("malformed multipart form")
+
+// Extracts a `multipart/form-data` body, such as one submitted by a browser from a form with
+// an `<input type="file" multiple webkitdirectory>` element. Each part that has a file name is
+// a file, with the file name (which may contain slashes) being its path within the site; other
+// parts are ignored. Parts with the `inode/symlink` content type are symlinks, with the part
+// contents being the symlink target.
+func ExtractMultipart(
+	ctx context.Context, reader io.Reader, boundary string,
+	oldManifest *Manifest, reuseManifests ...*Manifest,
+) (*Manifest, error) {
+	form := multipart.NewReader(boundArchiveStream(reader), boundary)
+
+	var dataBytesRecycled int64
+	var dataBytesTransferred int64
+
+	index := IndexManifestByGitHash(append([]*Manifest{oldManifest}, reuseManifests...)...)
+	missing := []string{}
+	manifest := NewManifest()
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, ErrArchiveTooLarge) {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", errMalformedMultipart, err)
+		}
+
+		// `part.FileName()` discards everything but the last path segment, which is not what
+		// we want here.
+		_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errMalformedMultipart, err)
+		}
+		partName, isFile := params["filename"]
+		if !isFile {
+			continue
+		}
+
+		fileName := normalizeArchiveMemberName(partName)
+		if fileName == "" {
+			continue
+		}
+
+		fileData, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("multipart: %s: %w", fileName, err)
+		}
+
+		if getMediaType(part.Header.Get("Content-Type")) == "inode/symlink" {
+			entry := addSymlinkOrBlobReference(
+				manifest, fileName, string(fileData), index, &missing)
+			switch {
+			case entry == nil:
+				// unresolved blob reference
+			case entry.GetType() != Type_Symlink:
+				dataBytesRecycled += entry.GetOriginalSize() // resolved blob reference
+			default:
+				dataBytesTransferred += int64(len(fileData)) // actual symlink
+			}
+		} else {
+			AddFile(manifest, fileName, fileData)
+			dataBytesTransferred += int64(len(fileData))
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, UnresolvedRefError{missing}
+	}
+
+	// Ensure parent directories exist for all entries.
+	EnsureLeadingDirectories(manifest)
+
+	logc.Printf(ctx,
+		"reuse: %s recycled, %s transferred\n",
+		datasize.ByteSize(dataBytesRecycled).HR(),
+		datasize.ByteSize(dataBytesTransferred).HR(),
+	)
+
+	return manifest, nil
+}
+
 // Used for zstd decompression inside zip files, it is recommended to share this.
 var zstdDecomp = zstd.ZipDecompressor()
 
-func ExtractZip(ctx context.Context, reader io.Reader, oldManifest *Manifest) (*Manifest, error) {
+func ExtractZip(
+	ctx context.Context, reader io.Reader, oldManifest *Manifest, reuseManifests ...*Manifest,
+) (*Manifest, error) {
+	config := currentConfig(ctx)
 	data, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, err
@@ -192,7 +575,7 @@ func ExtractZip(ctx context.Context, reader io.Reader, oldManifest *Manifest) (*
 	var dataBytesRecycled int64
 	var dataBytesTransferred int64
 
-	index := IndexManifestByGitHash(oldManifest)
+	index := IndexManifestByGitHash(append([]*Manifest{oldManifest}, reuseManifests...)...)
 	missing := []string{}
 	manifest := NewManifest()
 	for _, file := range archive.File {
@@ -273,6 +656,9 @@ func IsArchiveParseError(err error) bool {
 		zstd.ErrFrameSizeExceeded,
 		zstd.ErrFrameSizeMismatch,
 		zstd.ErrCRCMismatch,
+
+		errMalformedXz,
+		errMalformedMultipart,
 	}
 
 	for _, target := range targets {
@@ -280,5 +666,7 @@ func IsArchiveParseError(err error) bool {
 			return true
 		}
 	}
-	return false
+
+	var bzip2Err bzip2.StructuralError
+	return errors.As(err, &bzip2Err)
 }
@@ -0,0 +1,176 @@
+package git_pages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type multipartTestPart struct {
+	fileName    string // a form field if empty
+	contentType string
+	data        string
+}
+
+func multipartTestBody(parts []multipartTestPart) (body []byte, boundary string) {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		if part.fileName == "" {
+			header.Set("Content-Disposition", `form-data; name="field"`)
+		} else {
+			header.Set("Content-Disposition",
+				fmt.Sprintf(`form-data; name="files"; filename=%q`, part.fileName))
+		}
+		if part.contentType != "" {
+			header.Set("Content-Type", part.contentType)
+		}
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			panic(err)
+		}
+		partWriter.Write([]byte(part.data))
+	}
+	if err := writer.Close(); err != nil {
+		panic(err)
+	}
+	return buffer.Bytes(), writer.Boundary()
+}
+
+func TestExtractMultipart(t *testing.T) {
+	savedSnapshot := currentConfigSnapshot.Load()
+	defer currentConfigSnapshot.Store(savedSnapshot)
+
+	config, err := Configure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Limits.MaxSiteSize = 1024
+	currentConfigSnapshot.Store(&configSnapshot{config: config})
+
+	oldManifest := NewManifest()
+	oldEntry := AddFile(oldManifest, "old.html", []byte("old"))
+	blobReference := BlobReferencePrefix + oldEntry.GetGitHash()
+
+	for _, test := range []struct {
+		name  string
+		parts []multipartTestPart
+		// Entries by path, as "<type>:<data>".
+		expect    map[string]string
+		problems  []string
+		expectErr string
+	}{
+		{
+			name: "files",
+			parts: []multipartTestPart{
+				{"index.html", "text/html", "<p>"},
+				{"a/b/c.txt", "", "abc"},
+			},
+			expect: map[string]string{
+				"":           "Directory:",
+				"index.html": "InlineFile:<p>",
+				"a":          "Directory:",
+				"a/b":        "Directory:",
+				"a/b/c.txt":  "InlineFile:abc",
+			},
+		},
+		{
+			name: "fields ignored",
+			parts: []multipartTestPart{
+				{"", "", "value"},
+				{"index.html", "", "<p>"},
+			},
+			expect: map[string]string{
+				"":           "Directory:",
+				"index.html": "InlineFile:<p>",
+			},
+		},
+		{
+			name: "names normalized",
+			parts: []multipartTestPart{
+				{"/a/../b.txt", "", "b"},
+				{"./c//d.txt", "", "d"},
+				{"/", "", "root"},
+			},
+			expect: map[string]string{
+				"":        "Directory:",
+				"b.txt":   "InlineFile:b",
+				"c":       "Directory:",
+				"c/d.txt": "InlineFile:d",
+			},
+		},
+		{
+			name: "symlinks",
+			parts: []multipartTestPart{
+				{"link", "inode/symlink", "index.html"},
+				{"absolute", "inode/symlink", "/etc/passwd"},
+			},
+			expect: map[string]string{
+				"":     "Directory:",
+				"link": "Symlink:index.html",
+			},
+			problems: []string{"/absolute: absolute symlink: /etc/passwd"},
+		},
+		{
+			name: "blob reference",
+			parts: []multipartTestPart{
+				{"new.html", "inode/symlink", blobReference},
+			},
+			expect: map[string]string{
+				"":         "Directory:",
+				"new.html": "InlineFile:old",
+			},
+		},
+		{
+			name: "unresolved blob reference",
+			parts: []multipartTestPart{
+				{"new.html", "inode/symlink", BlobReferencePrefix + strings.Repeat("0", 64)},
+			},
+			expectErr: "1 unresolved blob references",
+		},
+		{
+			name: "too large",
+			parts: []multipartTestPart{
+				{"index.html", "", strings.Repeat("x", 2048)},
+			},
+			expectErr: "limit exceeded",
+		},
+	} {
+		body, boundary := multipartTestBody(test.parts)
+		manifest, err := ExtractMultipart(context.Background(), bytes.NewReader(body), boundary,
+			oldManifest)
+		if test.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+				t.Errorf("%s: expect err %s, got err %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expect ok, got err %s", test.name, err)
+			continue
+		}
+		contents := map[string]string{}
+		for name, entry := range manifest.Contents {
+			contents[name] = fmt.Sprintf("%s:%s", entry.GetType(), entry.GetData())
+		}
+		if !maps.Equal(contents, test.expect) {
+			t.Errorf("%s: expect contents %q, got %q", test.name, test.expect, contents)
+		}
+		if problems := GetProblemReport(manifest); !slices.Equal(problems, test.problems) {
+			t.Errorf("%s: expect problems %q, got %q", test.name, test.problems, problems)
+		}
+	}
+
+	_, err = ExtractMultipart(context.Background(), strings.NewReader("garbage"), "boundary", nil)
+	if !errors.Is(err, errMalformedMultipart) {
+		t.Errorf("malformed: expect err %s, got err %v", errMalformedMultipart, err)
+	}
+}
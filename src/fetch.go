@@ -32,6 +32,7 @@ func FetchRepository(
 ) (
 	*Manifest, error,
 ) {
+	config := currentConfig(ctx)
 	span, ctx := ObserveFunction(ctx, "FetchRepository",
 		"git.repository", repoURL, "git.branch", branch)
 	defer span.Finish()
@@ -41,6 +42,10 @@ func FetchRepository(
 		return nil, fmt.Errorf("URL parse: %w", err)
 	}
 
+	if err := CheckFetchRateLimit(ctx, parsedRepoURL.Host); err != nil {
+		return nil, err
+	}
+
 	var repo *git.Repository
 	var storer *filesystem.Storage
 	for _, filter := range []packp.Filter{packp.FilterBlobNone(), packp.Filter("")} {
@@ -209,6 +214,7 @@ func FetchRepository(
 func readGitBlob(
 	repo *git.Repository, hash plumbing.Hash, entry *Entry, bytesTransferred *int64,
 ) error {
+	config := loadConfig()
 	blob, err := repo.BlobObject(hash)
 	if err != nil {
 		return fmt.Errorf("git blob %s: %w", hash, err)
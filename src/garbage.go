@@ -7,7 +7,16 @@ import (
 	"github.com/c2h5oh/datasize"
 )
 
-func TraceGarbage(ctx context.Context) error {
+type GarbageStats struct {
+	AllBlobs  int64 `json:"allBlobs"`
+	AllSize   int64 `json:"allSize"`
+	LiveBlobs int64 `json:"liveBlobs"`
+	LiveSize  int64 `json:"liveSize"`
+	DeadBlobs int64 `json:"deadBlobs"`
+	DeadSize  int64 `json:"deadSize"`
+}
+
+func TraceGarbage(ctx context.Context) (*GarbageStats, error) {
 	allBlobs := map[string]int64{}
 	liveBlobs := map[string]int64{}
 
@@ -20,15 +29,16 @@ func TraceGarbage(ctx context.Context) error {
 	}
 
 	traceManifest := func(manifestKind string, manifestName string, manifest *Manifest) {
-		for _, entry := range manifest.GetContents() {
-			if entry.GetType() == Type_ExternalFile {
-				blobName := string(entry.Data)
-				if size, ok := allBlobs[blobName]; ok {
-					liveBlobs[blobName] = size
-				} else {
-					logc.Printf(ctx, "trace err: %s/%s: dangling reference %s",
-						manifestKind, manifestName, blobName)
-				}
+		blobs, err := ManifestBlobs(ctx, manifest)
+		if err != nil {
+			logc.Printf(ctx, "trace err: %s/%s: %s", manifestKind, manifestName, err)
+		}
+		for blobName := range blobs {
+			if size, ok := allBlobs[blobName]; ok {
+				liveBlobs[blobName] = size
+			} else {
+				logc.Printf(ctx, "trace err: %s/%s: dangling reference %s",
+					manifestKind, manifestName, blobName)
 			}
 		}
 	}
@@ -37,7 +47,7 @@ func TraceGarbage(ctx context.Context) error {
 	logc.Printf(ctx, "trace: enumerating blobs")
 	for metadata, err := range backend.EnumerateBlobs(ctx) {
 		if err != nil {
-			return fmt.Errorf("trace err: %w", err)
+			return nil, fmt.Errorf("trace err: %w", err)
 		}
 		allBlobs[metadata.Name] = metadata.Size
 	}
@@ -47,31 +57,40 @@ func TraceGarbage(ctx context.Context) error {
 	for item, err := range backend.GetAllManifests(ctx) {
 		metadata, manifest := item.Splat()
 		if err != nil {
-			return fmt.Errorf("trace err: %w", err)
+			return nil, fmt.Errorf("trace err: %w", err)
 		}
 		traceManifest("site", metadata.Name, manifest)
 	}
+	for item, err := range GetAllStagedManifests(ctx) {
+		metadata, manifest := item.Splat()
+		if err != nil {
+			return nil, fmt.Errorf("trace err: %w", err)
+		}
+		traceManifest("staged", metadata.Name, manifest)
+	}
 
 	// Enumerate blobs live via audit records.
 	logc.Printf(ctx, "trace: enumerating audit records")
 	auditIDs := backend.SearchAuditLog(ctx, SearchAuditLogOptions{})
 	for record, err := range backend.GetAuditLogRecords(ctx, auditIDs) {
 		if err != nil {
-			return fmt.Errorf("trace err: %w", err)
+			return nil, fmt.Errorf("trace err: %w", err)
 		}
-		if record.Manifest != nil {
-			traceManifest("audit", record.GetAuditID().String(), record.Manifest)
+		for _, manifest := range record.ManifestsByProject() {
+			traceManifest("audit", record.GetAuditID().String(), manifest)
 		}
 	}
 
-	allBlobsCount, allBlobsSize := reduceBlobs(allBlobs)
-	liveBlobsCount, liveBlobsSize := reduceBlobs(liveBlobs)
+	stats := &GarbageStats{}
+	stats.AllBlobs, stats.AllSize = reduceBlobs(allBlobs)
+	stats.LiveBlobs, stats.LiveSize = reduceBlobs(liveBlobs)
+	stats.DeadBlobs, stats.DeadSize = stats.AllBlobs-stats.LiveBlobs, stats.AllSize-stats.LiveSize
 	logc.Printf(ctx, "trace all: %d blobs, %s",
-		allBlobsCount, datasize.ByteSize(allBlobsSize).HR())
+		stats.AllBlobs, datasize.ByteSize(stats.AllSize).HR())
 	logc.Printf(ctx, "trace live: %d blobs, %s",
-		liveBlobsCount, datasize.ByteSize(liveBlobsSize).HR())
+		stats.LiveBlobs, datasize.ByteSize(stats.LiveSize).HR())
 	logc.Printf(ctx, "trace dead: %d blobs, %s",
-		allBlobsCount-liveBlobsCount, datasize.ByteSize(allBlobsSize-liveBlobsSize).HR())
+		stats.DeadBlobs, datasize.ByteSize(stats.DeadSize).HR())
 
-	return nil
+	return stats, nil
 }
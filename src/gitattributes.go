@@ -20,7 +20,7 @@ func ReadGitAttributes(ctx context.Context, manifest *Manifest) gitattributes.Ma
 	var files []entryPair
 	for name, entry := range manifest.GetContents() {
 		switch entry.GetType() {
-		case Type_InlineFile, Type_ExternalFile:
+		case Type_InlineFile, Type_ExternalFile, Type_ChunkedFile:
 			parts := strings.Split(name, "/")
 			if parts[len(parts)-1] == ".gitattributes" {
 				files = append(files, entryPair{parts, entry})
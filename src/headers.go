@@ -39,6 +39,7 @@ var unsafeHeaders []string = []string{
 }
 
 func IsAllowedCustomHeader(header string) bool {
+	config := loadConfig()
 	header = textproto.CanonicalMIMEHeaderKey(header)
 	switch {
 	case slices.Contains(unsafeHeaders, header):
@@ -51,6 +52,7 @@ func IsAllowedCustomHeader(header string) bool {
 }
 
 func validateHeaderRule(rule headers.Rule) error {
+	config := loadConfig()
 	url, err := url.Parse(rule.Path)
 	if err != nil {
 		return fmt.Errorf("malformed path")
@@ -241,6 +243,7 @@ func ApplyHeaderRules(manifest *Manifest, url *url.URL) (
 // Note that `Basic-Auth:` is not a security mechanism; it is provided on a best-effort basis
 // and not expected to be resistant against malicious misuse.
 func ApplyBasicAuthRules(manifest *Manifest, url *url.URL, r *http.Request) (bool, error) {
+	config := currentConfig(r.Context())
 	if rule := matchPathRules(manifest.BasicAuth, url); rule == nil {
 		// no matches, authorized by default
 		return true, nil
@@ -2,13 +2,17 @@ package git_pages
 
 import (
 	"cmp"
+	"context"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+
+	"google.golang.org/protobuf/proto"
 )
 
 var httpAcceptRegexp = regexp.MustCompile(`` +
@@ -138,36 +142,150 @@ func chainHTTPMiddleware(middleware ...func(http.Handler) http.Handler) func(htt
 	}
 }
 
-func remoteAddrMiddleware(handler http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var readXForwardedFor bool
-		switch config.Audit.IncludeIPs {
-		case "X-Forwarded-For":
-			readXForwardedFor = true
-		case "RemoteAddr", "":
-			readXForwardedFor = false
-		default:
-			panic(fmt.Errorf("config.Audit.IncludeIPs is set to an unknown value (%q)",
-				config.Audit.IncludeIPs))
+// Parses a list of IP addresses and CIDR ranges, e.g. from `[server].trusted-proxies`.
+func ParseTrustedProxies(items []string) (prefixes []netip.Prefix, err error) {
+	for _, item := range items {
+		var prefix netip.Prefix
+		if strings.Contains(item, "/") {
+			prefix, err = netip.ParsePrefix(item)
+		} else {
+			var addr netip.Addr
+			if addr, err = netip.ParseAddr(item); err == nil {
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", item, err)
 		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return
+}
+
+// Returns whether `addr` (an IP address, optionally with a port) is in `[server].trusted-proxies`.
+func isTrustedProxy(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ipAddr, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ipAddr = ipAddr.Unmap()
+	return slices.ContainsFunc(trustedProxies, func(prefix netip.Prefix) bool {
+		return prefix.Contains(ipAddr)
+	})
+}
+
+// Returns whether the peer of a connection (with the address `remoteAddr`) is a trusted proxy.
+// Peers connecting via a Unix domain socket are always trusted, since they are on the same host.
+func isTrustedPeer(remoteAddr string) bool {
+	host := remoteAddr
+	if splitHost, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = splitHost
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		return true
+	}
+	return isTrustedProxy(remoteAddr)
+}
 
-		usingOriginalRemoteAddr := true
-		if readXForwardedFor {
-			forwardedFor := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
-			if len(forwardedFor) > 0 {
-				remoteAddr := strings.TrimSpace(forwardedFor[len(forwardedFor)-1])
-				if remoteAddr != "" {
-					r.RemoteAddr = remoteAddr
-					usingOriginalRemoteAddr = false
+// Returns the addresses in the `X-Forwarded-For:` header fields, or if there are none, in the
+// `for=` parameters of the `Forwarded:` header fields, in the order they were added by proxies.
+func forwardedForAddrs(header http.Header) (addrs []string) {
+	for _, value := range header.Values("X-Forwarded-For") {
+		for item := range strings.SplitSeq(value, ",") {
+			addrs = append(addrs, strings.TrimSpace(item))
+		}
+	}
+	if len(addrs) > 0 {
+		return
+	}
+	for _, value := range header.Values("Forwarded") {
+		for element := range strings.SplitSeq(value, ",") {
+			for pair := range strings.SplitSeq(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if !strings.EqualFold(key, "for") {
+					continue
 				}
+				// Either `192.0.2.1`, `"192.0.2.1:80"`, or `"[2001:db8::1]:80"`.
+				value = strings.Trim(value, `"`)
+				if addrPort, err := netip.ParseAddrPort(value); err == nil {
+					value = addrPort.Addr().String()
+				}
+				addrs = append(addrs, strings.Trim(value, "[]"))
 			}
 		}
-		if usingOriginalRemoteAddr {
-			if ipAddress, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-				r.RemoteAddr = ipAddress
-			}
+	}
+	return
+}
+
+// Returns the address of the client that made the request. The forwarded addresses are only
+// used if the request came from a trusted peer (see `isTrustedPeer`), and the client is the last
+// address that does not belong to a trusted proxy.
+func forwardedClientAddr(r *http.Request, remoteAddr string) string {
+	if !isTrustedPeer(remoteAddr) {
+		return remoteAddr
+	}
+	addrs := forwardedForAddrs(r.Header)
+	for index := len(addrs) - 1; index >= 0; index-- {
+		if addrs[index] == "" {
+			break
+		} else if index == 0 || !isTrustedProxy(addrs[index]) {
+			return addrs[index]
+		}
+	}
+	return remoteAddr
+}
+
+// Loads the configuration snapshot once, so that the request is handled with the configuration
+// in effect when it was received even if it is reloaded in the meantime.
+func configSnapshotMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), configSnapshotKey{}, currentConfigSnapshot.Load())
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type peerAddrKey struct{}
+
+// Replaces the address of the connecting host with the address of the client (see
+// `forwardedClientAddr`), so that logs, the access log, and rate limits all refer to the client.
+// The address of the connecting host remains available via `requestPeerAddr`.
+func remoteAddrMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ipAddress, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			r.RemoteAddr = ipAddress
 		}
+		ctx := context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr)
+		r = r.WithContext(ctx)
+		r.RemoteAddr = forwardedClientAddr(r, r.RemoteAddr)
 
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// Returns the address of the host that made the connection, which is a proxy if the request
+// was forwarded.
+func requestPeerAddr(r *http.Request) string {
+	if peerAddr, ok := r.Context().Value(peerAddrKey{}).(string); ok {
+		return peerAddr
+	}
+	return r.RemoteAddr
+}
+
+// Returns the IP address to include in audit records according to `[audit].include-ip`, or nil.
+func auditIPAddress(r *http.Request) *string {
+	config := currentConfig(r.Context())
+	switch config.Audit.IncludeIPs {
+	case "X-Forwarded-For":
+		return proto.String(r.RemoteAddr)
+	case "RemoteAddr":
+		return proto.String(requestPeerAddr(r))
+	case "":
+		return nil
+	default:
+		panic(fmt.Errorf("config.Audit.IncludeIPs is set to an unknown value (%q)",
+			config.Audit.IncludeIPs))
+	}
+}
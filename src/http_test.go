@@ -1,6 +1,10 @@
 package git_pages
 
-import "testing"
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
 
 func TestHttpContentEncodingNegotiation(t *testing.T) {
 	check := func(available []string, requested string, expected string) {
@@ -37,3 +41,58 @@ func TestHttpContentEncodingNegotiation(t *testing.T) {
 	check([]string{"zstd", "identity"}, "zstd;q=1.0000", "identity")
 	check([]string{"zstd", "identity"}, " zstd ; q=1.0, identity  ;  q=0.5  , *;q=0 ", "zstd")
 }
+
+func TestForwardedClientAddr(t *testing.T) {
+	defer func(saved []netip.Prefix) { trustedProxies = saved }(trustedProxies)
+
+	for _, test := range []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		header         http.Header
+		expect         string
+	}{
+		{"no proxies", nil, "192.0.2.1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1"},
+		{"no proxies, unix socket", nil, "@",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "192.0.2.1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1"},
+		{"trusted peer", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"trusted peer, ipv6", []string{"2001:db8::/32"}, "2001:db8::1",
+			http.Header{"X-Forwarded-For": {"2001:db8:1::1"}}, "2001:db8:1::1"},
+		{"trusted peer, no header", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{}, "10.0.0.1"},
+		{"spoofed by client", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"X-Forwarded-For": {"203.0.113.1, 198.51.100.1"}}, "198.51.100.1"},
+		{"chain of proxies", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"X-Forwarded-For": {"203.0.113.1, 198.51.100.1, 10.0.0.2"}},
+			"198.51.100.1"},
+		{"several fields", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"X-Forwarded-For": {"203.0.113.1", "198.51.100.1"}}, "198.51.100.1"},
+		{"only proxies", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"empty item", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, , 10.0.0.2"}}, "10.0.0.1"},
+		{"forwarded", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"Forwarded": {`for=198.51.100.1;proto=https, for="10.0.0.2:80"`}},
+			"198.51.100.1"},
+		{"forwarded, ipv6", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{"Forwarded": {`For="[2001:db8::1]:80"`}}, "2001:db8::1"},
+		{"x-forwarded-for takes precedence", []string{"10.0.0.0/8"}, "10.0.0.1",
+			http.Header{
+				"X-Forwarded-For": {"198.51.100.1"},
+				"Forwarded":       {"for=203.0.113.1"},
+			}, "198.51.100.1"},
+	} {
+		var err error
+		if trustedProxies, err = ParseTrustedProxies(test.trustedProxies); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		r := &http.Request{Header: test.header}
+		if addr := forwardedClientAddr(r, test.remoteAddr); addr != test.expect {
+			t.Errorf("%s: expect %s, got %s", test.name, test.expect, addr)
+		}
+	}
+}
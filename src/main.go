@@ -1,6 +1,7 @@
 package git_pages
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"crypto/tls"
@@ -11,9 +12,11 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
@@ -21,6 +24,8 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	sys "codeberg.org/git-pages/git-pages/src/sys"
@@ -28,21 +33,62 @@ import (
 	"github.com/c2h5oh/datasize"
 	"github.com/fatih/color"
 	"github.com/kankanreno/go-snowflake"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/protobuf/proto"
 )
 
-var config *Config
-var wildcards []*WildcardPattern
-var fallback http.Handler
+// The configuration in effect, together with the state derived from it. The snapshot is
+// replaced as a whole when the configuration is reloaded (see `ReloadConfig`). Requests load
+// it once (see `configSnapshotMiddleware`), so that each of them sees a consistent
+// configuration.
+type configSnapshot struct {
+	config    *Config
+	wildcards []*WildcardPattern
+	fallback  http.Handler
+}
+
+var currentConfigSnapshot atomic.Pointer[configSnapshot]
+
+type configSnapshotKey struct{}
+
+// Returns the configuration snapshot of the request that `ctx` belongs to, or the one currently
+// in effect if there is none.
+func loadConfigSnapshot(ctx context.Context) *configSnapshot {
+	if snapshot, ok := ctx.Value(configSnapshotKey{}).(*configSnapshot); ok {
+		return snapshot
+	}
+	return currentConfigSnapshot.Load()
+}
+
+func currentConfig(ctx context.Context) *Config {
+	return loadConfigSnapshot(ctx).config
+}
+
+// Returns the configuration currently in effect, for code that does not serve a request.
+func loadConfig() *Config {
+	return currentConfigSnapshot.Load().config
+}
+
+// Replaces a part of the configuration snapshot. Can only be safely called during initial
+// configuration, since the other parts are not updated together with it.
+func updateConfigSnapshot(update func(snapshot *configSnapshot)) {
+	snapshot := *currentConfigSnapshot.Load()
+	update(&snapshot)
+	currentConfigSnapshot.Store(&snapshot)
+}
+
 var backend Backend
 var existenceCache ExistenceCache
+var trustedProxies []netip.Prefix
 
-func configureFeatures(ctx context.Context) (err error) {
-	if len(config.Features) > 0 {
-		logc.Println(ctx, "features:", strings.Join(config.Features, ", "))
+// Paths of the configuration files, used to reload the configuration.
+var configTomlPaths []string
+var reloadConfigMutex sync.Mutex
+
+func checkFeatures(ctx context.Context, features []string) (err error) {
+	if len(features) > 0 {
+		logc.Println(ctx, "features:", strings.Join(features, ", "))
 	}
-	for _, feature := range config.Features {
+	for _, feature := range features {
 		switch feature {
 		// Work-in-progress features:
 		case "preview", "expiration", "absolute-headers":
@@ -62,7 +108,13 @@ func configureFeatures(ctx context.Context) (err error) {
 	return
 }
 
+func configureFeatures(ctx context.Context) (err error) {
+	config := currentConfig(ctx)
+	return checkFeatures(ctx, config.Features)
+}
+
 func configureMemLimit(ctx context.Context) (err error) {
+	config := currentConfig(ctx)
 	// Avoid being OOM killed by not garbage collecting early enough.
 	memlimitBefore := datasize.ByteSize(debug.SetMemoryLimit(-1))
 	automemlimit.SetGoMemLimitWithOpts(
@@ -86,47 +138,153 @@ func configureMemLimit(ctx context.Context) (err error) {
 
 // Can only be safely called during initial configuration.
 func configureConcurrency(_ context.Context) (err error) {
+	config := loadConfig()
 	putBlobSemaphore = make(chan struct{}, config.Limits.ConcurrentUploads)
 	return
 }
 
 func configureWildcards(_ context.Context) (err error) {
+	config := loadConfig()
 	newWildcards, err := TranslateWildcards(config.Wildcard)
 	if err != nil {
 		return err
 	} else {
-		wildcards = newWildcards
+		updateConfigSnapshot(func(snapshot *configSnapshot) { snapshot.wildcards = newWildcards })
 		return nil
 	}
 }
 
-func configureFallback(_ context.Context) (err error) {
-	if config.Fallback.ProxyTo != nil {
-		fallbackURL := &config.Fallback.ProxyTo.URL
-		fallback = &httputil.ReverseProxy{
-			Rewrite: func(r *httputil.ProxyRequest) {
-				r.SetURL(fallbackURL)
-				r.Out.Host = r.In.Host
-				r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
-			},
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: config.Fallback.Insecure,
-				},
+func createFallback(fallbackConfig *FallbackConfig) http.Handler {
+	if fallbackConfig.ProxyTo == nil {
+		return nil
+	}
+	fallbackURL := &fallbackConfig.ProxyTo.URL
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(fallbackURL)
+			r.Out.Host = r.In.Host
+			r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+		},
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: fallbackConfig.Insecure,
 			},
-		}
+		},
 	}
+}
+
+func configureFallback(_ context.Context) (err error) {
+	config := loadConfig()
+	newFallback := createFallback(&config.Fallback)
+	updateConfigSnapshot(func(snapshot *configSnapshot) { snapshot.fallback = newFallback })
 	return
 }
 
+// Can only be safely called during initial configuration.
+func configureTrustedProxies(_ context.Context) (err error) {
+	config := loadConfig()
+	trustedProxies, err = ParseTrustedProxies(config.Server.TrustedProxies)
+	return
+}
+
+func configureAccessLog(_ context.Context) (err error) {
+	config := loadConfig()
+	return openAccessLog(&config.AccessLog)
+}
+
+// Taking the client address from `X-Forwarded-For:` requires trusting the proxies that set it;
+// without any, the address of the connecting host is recorded instead, which is unlikely to be
+// what was intended.
+func checkAuditIncludeIP(ctx context.Context, config *Config) {
+	if config.Audit.IncludeIPs == "X-Forwarded-For" && len(config.Server.TrustedProxies) == 0 {
+		logc.Println(ctx, "config: warning: [audit].include-ip is \"X-Forwarded-For\", "+
+			"but [server].trusted-proxies is empty; the header is only used for requests "+
+			"made via a Unix domain socket")
+	}
+}
+
 // Thread-unsafe, must be called only during initial configuration.
-func configureAudit(_ context.Context) (err error) {
+func configureAudit(ctx context.Context) (err error) {
+	config := loadConfig()
+	checkAuditIncludeIP(ctx, config)
 	snowflake.SetStartTime(AuditSnowflakeStartTime)
 	snowflake.SetMachineID(config.Audit.NodeID)
-	return
+	_, _, err = parseAuditCheckpointKeys(&config.Audit)
+	return errors.Join(err, validateAuditSubscribers(config.Audit.Subscribers),
+		validateAuditRetention(&config.Audit))
+}
+
+// Reads the configuration files again and replaces the global configuration without
+// interrupting requests being served. Options that are only used during startup keep their
+// previous values (and a restart is requested in the log if they were changed). Returns
+// the keys whose values were changed.
+func ReloadConfig(ctx context.Context) (changedKeys []string, err error) {
+	reloadConfigMutex.Lock()
+	defer reloadConfigMutex.Unlock()
+
+	config := loadConfig()
+	newConfig, err := Configure(configTomlPaths...)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	appliedConfig := *newConfig
+	appliedConfig.Insecure = config.Insecure
+	appliedConfig.LogFormat = config.LogFormat
+	appliedConfig.Server = config.Server
+	appliedConfig.Storage = config.Storage
+	appliedConfig.Limits.ResumableUploadTimeout = config.Limits.ResumableUploadTimeout
+	appliedConfig.Limits.ConcurrentUploads = config.Limits.ConcurrentUploads
+	appliedConfig.Audit.NodeID = config.Audit.NodeID
+	appliedConfig.Admin.TLSCert = config.Admin.TLSCert
+	appliedConfig.Admin.TLSKey = config.Admin.TLSKey
+	appliedConfig.Admin.ClientCA = config.Admin.ClientCA
+	appliedConfig.ACME = config.ACME
+	if restartKeys := DiffConfig(newConfig, &appliedConfig); len(restartKeys) > 0 {
+		logc.Printf(ctx, "config: restart required to apply %s", strings.Join(restartKeys, ", "))
+	}
+
+	if err = checkFeatures(ctx, appliedConfig.Features); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	switch appliedConfig.Audit.IncludeIPs {
+	case "", "RemoteAddr", "X-Forwarded-For":
+	default:
+		return nil, fmt.Errorf("config: unknown [audit].include-ip value %q",
+			appliedConfig.Audit.IncludeIPs)
+	}
+	checkAuditIncludeIP(ctx, &appliedConfig)
+	if _, _, err = parseAuditCheckpointKeys(&appliedConfig.Audit); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err = validateAuditSubscribers(appliedConfig.Audit.Subscribers); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err = validateAuditRetention(&appliedConfig.Audit); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	newWildcards, err := TranslateWildcards(appliedConfig.Wildcard)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	newFallback := createFallback(&appliedConfig.Fallback)
+	if err = openAccessLog(&appliedConfig.AccessLog); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	changedKeys = DiffConfig(config, &appliedConfig)
+	currentConfigSnapshot.Store(&configSnapshot{&appliedConfig, newWildcards, newFallback})
+	configureMemLimit(ctx)
+	if len(changedKeys) > 0 {
+		logc.Printf(ctx, "config: reloaded, changed %s", strings.Join(changedKeys, ", "))
+	} else {
+		logc.Println(ctx, "config: reloaded, no changes")
+	}
+	return changedKeys, nil
 }
 
 func listen(ctx context.Context, name string, listen string) net.Listener {
+	config := currentConfig(ctx)
 	if listen == "-" {
 		return nil
 	}
@@ -141,6 +299,13 @@ func listen(ctx context.Context, name string, listen string) net.Listener {
 		logc.Fatalf(ctx, "%s: %s\n", name, err)
 	}
 
+	if slices.Contains(config.Server.ProxyProtocol, name) {
+		if len(trustedProxies) == 0 && protocol != "unix" {
+			logc.Fatalf(ctx, "%s: proxy-protocol requires trusted-proxies\n", name)
+		}
+		listener = &proxyProtocolListener{Listener: listener, ctx: ctx}
+	}
+
 	return listener
 }
 
@@ -165,11 +330,15 @@ func panicHandler(handler http.Handler) http.Handler {
 
 func serve(ctx context.Context, listener net.Listener, handler http.Handler) {
 	if listener != nil {
-		server := http.Server{Handler: handler}
+		server := &http.Server{Handler: handler}
 		server.Protocols = new(http.Protocols)
 		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
 		server.Protocols.SetUnencryptedHTTP2(true)
-		logc.Fatalln(ctx, server.Serve(listener))
+		registerHTTPServer(server)
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logc.Fatalln(ctx, err)
+		}
 	}
 }
 
@@ -186,6 +355,46 @@ func webRootArg(arg string) string {
 	}
 }
 
+// Retrieves the manifest that an argument of `-diff-manifests` refers to: either a site (in
+// the same form as for `-get-manifest`) or an audit record ('<id>', or '<id>/<project>' for
+// records of several projects). Returns nil if the site does not exist or was deleted.
+func diffManifestArg(ctx context.Context, arg string) (*Manifest, error) {
+	idRepr, project, _ := strings.Cut(arg, "/")
+	id, err := ParseAuditID(idRepr)
+	if err != nil || len(idRepr) != 16 {
+		manifest, _, err := backend.GetManifest(ctx, webRootArg(arg), GetManifestOptions{})
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, nil
+		}
+		return manifest, err
+	}
+
+	record, err := backend.QueryAuditLog(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var manifest *Manifest
+	switch record.GetEvent() {
+	case AuditEvent_CommitManifest:
+		manifest = record.Manifest
+	case AuditEvent_CommitManifests:
+		if project == "" {
+			return nil, fmt.Errorf("audit record %s includes several projects, "+
+				"one of which must be chosen with '%s/<project>'", id, id)
+		} else if manifest = record.Manifests[project]; manifest == nil {
+			return nil, fmt.Errorf("audit record %s does not include project %q", id, project)
+		}
+	case AuditEvent_DeleteManifest, AuditEvent_ExpireManifest:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("audit record %s is not of a manifest event", id)
+	}
+	if record.IsDetached() {
+		return nil, fmt.Errorf("audit record %s is detached", id)
+	}
+	return manifest, nil
+}
+
 func fileOutputArg() (writer io.WriteCloser) {
 	var err error
 	if flag.NArg() == 0 {
@@ -209,6 +418,8 @@ func usage() {
 		"git-pages {-list-blobs|-list-manifests}\n")
 	fmt.Fprintf(os.Stderr, "(debug)  "+
 		"git-pages {-get-blob|-get-manifest|-get-archive} <ref> [file]\n")
+	fmt.Fprintf(os.Stderr, "(debug)  "+
+		"git-pages  -diff-manifests {<ref>|<id>} {<ref>|<id>}\n")
 	fmt.Fprintf(os.Stderr, "(admin)  "+
 		"git-pages {-update-site <ref> <file>|-delete-site <ref>}\n")
 	fmt.Fprintf(os.Stderr, "(admin)  "+
@@ -216,13 +427,21 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "(audit)  "+
 		"git-pages {-audit-log|-audit-read <id>|-audit-rollback <id>}\n")
 	fmt.Fprintf(os.Stderr, "(audit)  "+
-		"git-pages {-audit-expire <days>|-audit-detach <domain>/<project>}\n")
+		"git-pages  -audit-log [-domain <domain>] [-project <project>] [-event <event>]\n"+
+		"                               [-forge-user <origin>/<name>] [-repo-url <url>]\n"+
+		"                               [-since <time>] [-until <time>] [-json]\n")
+	fmt.Fprintf(os.Stderr, "(audit)  "+
+		"git-pages {-audit-expire <days>|-audit-detach <domain>/<project>|-audit-verify}\n")
+	fmt.Fprintf(os.Stderr, "(audit)  "+
+		"git-pages  -audit-maintain [-dry-run]\n")
 	fmt.Fprintf(os.Stderr, "(audit)  "+
 		"git-pages  -audit-server <endpoint> <program> [args...]\n")
 	fmt.Fprintf(os.Stderr, "(maint)  "+
 		"git-pages  -expire-sites [-dry-run]\n")
 	fmt.Fprintf(os.Stderr, "(maint)  "+
 		"git-pages {-run-migration <name>|-trace-garbage|-analyze-storage}\n")
+	fmt.Fprintf(os.Stderr, "(maint)  "+
+		"git-pages  -reconcile-replicas [-dry-run]\n")
 	flag.PrintDefaults()
 }
 
@@ -250,6 +469,8 @@ func Main(versionInfo string) {
 		"write manifest for `site` (either 'domain.tld' or 'domain.tld/dir') as ProtoJSON")
 	getArchive := flag.String("get-archive", "",
 		"write archive for `site` (either 'domain.tld' or 'domain.tld/dir') in tar format")
+	diffManifests := flag.String("diff-manifests", "",
+		"display changes from `site` or audit record to the site or audit record given as the argument")
 	updateSite := flag.String("update-site", "",
 		"update `site` (either 'domain.tld' or 'domain.tld/dir') from archive or repository URL")
 	deleteSite := flag.String("delete-site", "",
@@ -260,6 +481,22 @@ func Main(versionInfo string) {
 		"allow site uploads to a `domain` again after it has been frozen")
 	auditLog := flag.Bool("audit-log", false,
 		"display audit log")
+	auditDomain := flag.String("domain", "",
+		"with -audit-log, only display audit records for `domain`")
+	auditProject := flag.String("project", "",
+		"with -audit-log, only display audit records affecting `project` (e.g. '.index')")
+	auditEvent := flag.String("event", "",
+		"with -audit-log, only display audit records of `event` type (e.g. 'CommitManifest')")
+	auditForgeUser := flag.String("forge-user", "",
+		"with -audit-log, only display audit records of actions by `user` ('<origin>/<name>')")
+	auditRepoURL := flag.String("repo-url", "",
+		"with -audit-log, only display audit records of updates from repository `url`")
+	auditSince := flag.String("since", "",
+		"with -audit-log, only display audit records since `time` (RFC 3339 or 'YYYY-MM-DD')")
+	auditUntil := flag.String("until", "",
+		"with -audit-log, only display audit records until `time` (RFC 3339 or 'YYYY-MM-DD')")
+	auditJSON := flag.Bool("json", false,
+		"with -audit-log, display audit records as JSON (one object per line)")
 	auditRead := flag.String("audit-read", "",
 		"extract contents of audit record `id` to files '<id>-*'")
 	auditRollback := flag.String("audit-rollback", "",
@@ -268,16 +505,22 @@ func Main(versionInfo string) {
 		"expire audit records older than `days` old")
 	auditDetach := flag.String("audit-detach", "",
 		"detach all blobs of audit records for a single `site` (or the entire domain with 'domain.tld/*')")
+	auditVerify := flag.Bool("audit-verify", false,
+		"verify that the audit log has not been tampered with")
+	auditMaintain := flag.Bool("audit-maintain", false,
+		"expire and detach audit records per the retention policy in [audit]")
 	auditServer := flag.String("audit-server", "",
 		"listen for notifications on `endpoint` and spawn a process for each audit event")
 	expireSites := flag.Bool("expire-sites", false,
 		"expire sites according to their manifest")
 	runMigration := flag.String("run-migration", "",
-		"run a store `migration` (one of: create-domain-markers)")
+		"run a store `migration` (one of: create-domain-markers, reencrypt, index-audit-log)")
 	analyzeStorage := flag.String("analyze-storage", "",
 		"display aggregate storage used per domain")
 	traceGarbage := flag.Bool("trace-garbage", false,
 		"estimate total size of unreachable blobs")
+	reconcileReplicas := flag.Bool("reconcile-replicas", false,
+		"repair divergence between replicas of a replicated store")
 	dryRun := flag.Bool("dry-run", false,
 		"print what would be performed instead of executing it")
 	version := flag.Bool("version", false,
@@ -296,6 +539,7 @@ func Main(versionInfo string) {
 		*getBlob != "",
 		*getManifest != "",
 		*getArchive != "",
+		*diffManifests != "",
 		*updateSite != "",
 		*deleteSite != "",
 		*freezeDomain != "",
@@ -305,11 +549,14 @@ func Main(versionInfo string) {
 		*auditRollback != "",
 		*auditExpire != "",
 		*auditDetach != "",
+		*auditVerify,
+		*auditMaintain,
 		*auditServer != "",
 		*expireSites,
 		*runMigration != "",
 		*analyzeStorage != "",
 		*traceGarbage,
+		*reconcileReplicas,
 	} {
 		if selected {
 			cliOperations++
@@ -317,14 +564,21 @@ func Main(versionInfo string) {
 	}
 	if cliOperations > 1 {
 		logc.Fatalln(ctx, "-list-blobs, -list-manifests, -get-blob, -get-manifest, "+
-			"-get-archive, -update-site, -delete-site, -freeze-domain, -unfreeze-domain, "+
-			"-audit-log, -audit-read, -audit-rollback, -audit-expire, -audit-detach, "+
-			"-audit-server, -expire-sites, -run-migration, -analyze-storage, "+
-			"and -trace-garbage are mutually exclusive")
+			"-get-archive, -diff-manifests, -update-site, -delete-site, -freeze-domain, "+
+			"-unfreeze-domain, -audit-log, -audit-read, -audit-rollback, -audit-expire, "+
+			"-audit-detach, -audit-verify, -audit-maintain, -audit-server, -expire-sites, "+
+			"-run-migration, -analyze-storage, -trace-garbage, and -reconcile-replicas "+
+			"are mutually exclusive")
 	}
-	if *dryRun && !(*expireSites) {
+	if *dryRun && !(*expireSites || *auditMaintain || *reconcileReplicas) {
 		logc.Fatalln(ctx, "-dry-run is not applicable in this context")
 	}
+	if !*auditLog && (*auditDomain != "" || *auditProject != "" || *auditEvent != "" ||
+		*auditForgeUser != "" || *auditRepoURL != "" || *auditSince != "" ||
+		*auditUntil != "" || *auditJSON) {
+		logc.Fatalln(ctx, "-domain, -project, -event, -forge-user, -repo-url, -since, -until, "+
+			"and -json are only applicable with -audit-log")
+	}
 
 	if *configTomlPath != "" && *noConfig {
 		logc.Fatalln(ctx, "-no-config and -config are mutually exclusive")
@@ -351,9 +605,12 @@ func Main(versionInfo string) {
 		}
 	}
 
-	if config, err = Configure(*configTomlPath, *secretTomlPath); err != nil {
+	configTomlPaths = []string{*configTomlPath, *secretTomlPath}
+	config, err := Configure(configTomlPaths...)
+	if err != nil {
 		logc.Fatalln(ctx, "config:", err)
 	}
+	currentConfigSnapshot.Store(&configSnapshot{config: config})
 
 	if *printConfig {
 		fmt.Println(config.TOML())
@@ -369,6 +626,8 @@ func Main(versionInfo string) {
 		configureConcurrency(ctx),
 		configureWildcards(ctx),
 		configureFallback(ctx),
+		configureTrustedProxies(ctx),
+		configureAccessLog(ctx),
 		configureAudit(ctx),
 	); err != nil {
 		logc.Fatalln(ctx, err)
@@ -430,7 +689,7 @@ func Main(versionInfo string) {
 
 	case *getManifest != "":
 		webRoot := webRootArg(*getManifest)
-		manifest, _, err := backend.GetManifest(ctx, webRoot, GetManifestOptions{})
+		manifest, _, err := GetUnshardedManifest(ctx, webRoot, GetManifestOptions{})
 		if err != nil {
 			logc.Fatalln(ctx, err)
 		}
@@ -447,6 +706,24 @@ func Main(versionInfo string) {
 			logc.Fatalln(ctx, err)
 		}
 
+	case *diffManifests != "":
+		if flag.NArg() != 1 {
+			logc.Fatalln(ctx, "site or audit record to compare with must be provided as the argument")
+		}
+		from, err := diffManifestArg(ctx, *diffManifests)
+		if err != nil {
+			logc.Fatalln(ctx, err)
+		}
+		to, err := diffManifestArg(ctx, flag.Arg(0))
+		if err != nil {
+			logc.Fatalln(ctx, err)
+		}
+		diff, err := DiffManifests(ctx, from, to)
+		if err != nil {
+			logc.Fatalln(ctx, err)
+		}
+		WriteManifestDiff(color.Output, diff)
+
 	case *updateSite != "":
 		ctx = WithPrincipal(ctx)
 		GetPrincipal(ctx).CliAdmin = proto.Bool(true)
@@ -478,6 +755,10 @@ func Main(versionInfo string) {
 				contentType = "application/x-tar+gzip"
 			case strings.HasSuffix(sourceURL.Path, ".tar.zst"):
 				contentType = "application/x-tar+zstd"
+			case strings.HasSuffix(sourceURL.Path, ".tar.xz"):
+				contentType = "application/x-tar+xz"
+			case strings.HasSuffix(sourceURL.Path, ".tar.bz2"):
+				contentType = "application/x-tar+bzip2"
 			default:
 				log.Fatalf("cannot determine content type from filename %q\n", sourceURL)
 			}
@@ -510,6 +791,9 @@ func Main(versionInfo string) {
 		case UpdateNoChange:
 			logc.Println(ctx, "no-change")
 		}
+		if result.diff != nil {
+			logc.Printf(ctx, "changes: %s\n", result.diff.Summary())
+		}
 
 	case *deleteSite != "":
 		ctx = WithPrincipal(ctx)
@@ -550,8 +834,28 @@ func Main(versionInfo string) {
 		}
 
 	case *auditLog:
+		opts := SearchAuditLogOptions{
+			Domain:    *auditDomain,
+			Project:   *auditProject,
+			ForgeUser: *auditForgeUser,
+			RepoURL:   *auditRepoURL,
+		}
+		if *auditEvent != "" {
+			event, found := AuditEvent_value[*auditEvent]
+			if !found || event == int32(AuditEvent_InvalidEvent) {
+				logc.Fatalln(ctx, "unknown audit event", *auditEvent)
+			}
+			opts.Event = AuditEvent(event)
+		}
+		if opts.Since, err = parseAuditLogTime(*auditSince); err != nil {
+			logc.Fatalln(ctx, "since:", err)
+		}
+		if opts.Until, err = parseAuditLogTime(*auditUntil); err != nil {
+			logc.Fatalln(ctx, "until:", err)
+		}
+
 		records := []*AuditRecord{}
-		ids := backend.SearchAuditLog(ctx, SearchAuditLogOptions{})
+		ids := backend.SearchAuditLog(ctx, opts)
 		for record, err := range backend.GetAuditLogRecords(ctx, ids) {
 			if err != nil {
 				logc.Fatalln(ctx, err)
@@ -564,6 +868,15 @@ func Main(versionInfo string) {
 		})
 
 		for _, record := range records {
+			if *auditJSON {
+				var line bytes.Buffer
+				if err := json.Compact(&line, AuditRecordJSON(record, AuditRecordNoManifest)); err != nil {
+					logc.Fatalln(ctx, err)
+				}
+				fmt.Println(line.String())
+				continue
+			}
+
 			parts := []string{
 				record.GetAuditID().String(),
 				color.HiWhiteString("%s", record.GetTimestamp().AsTime().UTC().Format(time.RFC3339)),
@@ -581,6 +894,16 @@ func Main(versionInfo string) {
 			parts = append(parts,
 				color.HiGreenString("%s", record.DescribePrincipal()),
 			)
+			if record.Diff != nil {
+				parts = append(parts,
+					color.HiCyanString("(%s)", record.Diff.Summary()),
+				)
+			}
+			for _, project := range slices.Sorted(maps.Keys(record.Diffs)) {
+				parts = append(parts,
+					color.HiCyanString("(%s: %s)", project, record.Diffs[project].Summary()),
+				)
+			}
 			if record.IsDetached() {
 				parts = append(parts,
 					color.HiYellowString("(detached)"),
@@ -613,22 +936,7 @@ func Main(versionInfo string) {
 			logc.Fatalln(ctx, err)
 		}
 
-		record, err := backend.QueryAuditLog(ctx, id)
-		if err != nil {
-			logc.Fatalln(ctx, err)
-		}
-
-		if record.GetManifest() == nil || record.GetDomain() == "" || record.GetProject() == "" {
-			logc.Fatalln(ctx, "no manifest in audit record")
-		}
-
-		webRoot := path.Join(record.GetDomain(), record.GetProject())
-		err = backend.StageManifest(ctx, record.GetManifest())
-		if err != nil {
-			logc.Fatalln(ctx, err)
-		}
-		err = backend.CommitManifest(ctx, webRoot, record.GetManifest(), ModifyManifestOptions{})
-		if err != nil {
+		if err = RollbackToAuditRecord(ctx, id); err != nil {
 			logc.Fatalln(ctx, err)
 		}
 
@@ -651,7 +959,8 @@ func Main(versionInfo string) {
 			if err != nil {
 				logc.Fatalln(ctx, err)
 			}
-			if record.GetDomain() == domain && (project == "*" || record.GetProject() == project) {
+			if record.GetDomain() == domain &&
+				(project == "*" || slices.Contains(record.GetProjects(), project)) {
 				if !record.IsDetachable() {
 					continue
 				} else if !record.IsDetached() {
@@ -714,42 +1023,27 @@ func Main(versionInfo string) {
 		ctx = WithPrincipal(ctx)
 		GetPrincipal(ctx).CliAdmin = proto.Bool(true)
 
-		if !config.Feature("expiration") {
-			logc.Fatalf(ctx, "expire: feature disabled")
+		if _, err = ExpireSites(ctx, *dryRun); err != nil {
+			logc.Fatalln(ctx, err)
 		}
 
-		countExpired, countTransient := 0, 0
-		for item, err := range backend.GetAllManifests(ctx) {
-			metadata, manifest := item.Splat()
-			if err != nil {
-				logc.Fatalln(ctx, err)
-			}
-			if manifest.ExpiresAt != nil {
-				countTransient += 1
-				if manifest.ExpiresAt.AsTime().Before(time.Now()) {
-					if !*dryRun {
-						err = backend.ExpireManifest(ctx, metadata.Name)
-						if err != nil {
-							logc.Fatalln(ctx, err)
-						}
-					}
-					logc.Printf(ctx, "expire: site %s expired at %s",
-						metadata.Name, manifest.ExpiresAt.AsTime())
-					countExpired += 1
-				}
-			}
+	case *runMigration != "":
+		if err = RunMigration(ctx, *runMigration); err != nil {
+			logc.Fatalln(ctx, err)
 		}
 
-		if *dryRun {
-			logc.Printf(ctx, "expire: would expire %d out of %d transient sites (dry run)\n",
-				countExpired, countTransient)
-		} else {
-			logc.Printf(ctx, "expire: expired %d out of %d transient sites\n",
-				countExpired, countTransient)
+	case *auditVerify:
+		if err = VerifyAuditLog(ctx); err != nil {
+			logc.Fatalln(ctx, err)
 		}
 
-	case *runMigration != "":
-		if err = RunMigration(ctx, *runMigration); err != nil {
+	case *auditMaintain:
+		if err = MaintainAuditLog(ctx, *dryRun); err != nil {
+			logc.Fatalln(ctx, err)
+		}
+
+	case *reconcileReplicas:
+		if err = ReconcileReplicas(ctx, *dryRun); err != nil {
 			logc.Fatalln(ctx, err)
 		}
 
@@ -822,7 +1116,7 @@ func Main(versionInfo string) {
 		logc.Fatalf(ctx, "unsupported -analyze-storage mode")
 
 	case *traceGarbage:
-		if err = TraceGarbage(ctx); err != nil {
+		if _, err = TraceGarbage(ctx); err != nil {
 			logc.Fatalln(ctx, err)
 		}
 
@@ -834,6 +1128,25 @@ func Main(versionInfo string) {
 		pagesListener := listen(ctx, "pages", config.Server.Pages)
 		caddyListener := listen(ctx, "caddy", config.Server.Caddy)
 		metricsListener := listen(ctx, "metrics", config.Server.Metrics)
+		var adminListener net.Listener
+		if config.Server.Admin != "" {
+			adminListener = listen(ctx, "admin", config.Server.Admin)
+		}
+		var httpsListener net.Listener
+		if config.Server.HTTPS != "" {
+			httpsListener = listen(ctx, "https", config.Server.HTTPS)
+		}
+		if adminListener != nil {
+			if len(config.Admin.Tokens) == 0 && config.Admin.ClientCA == "" {
+				logc.Fatalln(ctx, "admin: no credentials configured")
+			}
+			adminTLSConfig, err := AdminTLSConfig()
+			if err != nil {
+				logc.Fatalln(ctx, err)
+			} else if adminTLSConfig != nil {
+				adminListener = tls.NewListener(adminListener, adminTLSConfig)
+			}
+		}
 
 		if backend, err = CreateBackend(ctx, &config.Storage); err != nil {
 			logc.Fatalln(ctx, err)
@@ -844,14 +1157,42 @@ func Main(versionInfo string) {
 			logc.Fatalln(ctx, err)
 		}
 
+		if err = ResumePendingAuditNotifications(ctx); err != nil {
+			logc.Println(ctx, err)
+		}
+
 		middleware := chainHTTPMiddleware(
+			configSnapshotMiddleware,
 			panicHandler,
 			remoteAddrMiddleware,
 			ObserveHTTPHandler,
 		)
-		go serve(ctx, pagesListener, middleware(http.HandlerFunc(ServePages)))
+		var pagesHandler http.Handler = http.HandlerFunc(ServePages)
+		if httpsListener != nil {
+			acmeManager, err := CreateACMEManager(ctx)
+			if err != nil {
+				logc.Fatalln(ctx, err)
+			}
+			// HTTP-01 challenges are answered on the pages listener, which must be reachable
+			// on port 80 for them to succeed.
+			pagesHandler = acmeManager.HTTPHandler(pagesHandler)
+			httpsListener = tls.NewListener(httpsListener, acmeManager.TLSConfig())
+		}
+		go serve(ctx, pagesListener, middleware(AccessLogHandler(pagesHandler)))
+		go serve(ctx, httpsListener, middleware(AccessLogHandler(http.HandlerFunc(ServePages))))
 		go serve(ctx, caddyListener, middleware(http.HandlerFunc(ServeCaddy)))
-		go serve(ctx, metricsListener, promhttp.Handler())
+		go serve(ctx, metricsListener, MetricsHandler())
+		go serve(ctx, adminListener, middleware(AdminHandler()))
+
+		if maxAge := time.Duration(config.Limits.ResumableUploadTimeout); maxAge > 0 {
+			go ExpireUploadsPeriodically(ctx, maxAge)
+		}
+		go FlushSiteStatsPeriodically(ctx)
+		go SweepLocalRateLimitsPeriodically(ctx)
+		WatchManifestInvalidations(ctx)
+		go CheckpointAuditChainPeriodically(ctx)
+		go MaintainAuditLogPeriodically(ctx)
+		go ResumeAuditOutboxPeriodically(ctx)
 
 		if config.Insecure {
 			logc.Println(ctx, "serve: ready (INSECURE)")
@@ -859,7 +1200,31 @@ func Main(versionInfo string) {
 			logc.Println(ctx, "serve: ready")
 		}
 
+		sys.OnHangup(func() {
+			if _, err := ReloadConfig(ctx); err != nil {
+				logc.Println(ctx, "config: reload err:", err)
+			}
+		})
+
 		sys.WaitForInterrupt()
+		Shutdown(ctx)
 		logc.Println(ctx, "serve: exiting")
+		return
+	}
+
+	// Give audit notifications sent by the CLI operation a chance to be delivered.
+	drainCtx, cancel := context.WithTimeout(ctx, time.Duration(config.Server.DrainTimeout))
+	defer cancel()
+	DrainBackgroundTasks(drainCtx)
+}
+
+// Parses a time given either as RFC 3339 or as a date (in UTC); the empty string is parsed
+// as the zero time.
+func parseAuditLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	} else if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
 	}
+	return time.Parse(time.RFC3339, value)
 }
@@ -9,10 +9,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"mime"
 	"net/http"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -68,6 +70,17 @@ func CompareManifest(left *Manifest, right *Manifest) bool {
 	if len(left.Contents) != len(right.Contents) {
 		return false
 	}
+	// Shards are content-addressed, so shards with the same blob names have the same entries.
+	if len(left.Shards) != len(right.Shards) {
+		return false
+	}
+	for index, leftShard := range left.Shards {
+		rightShard := right.Shards[index]
+		if leftShard.GetPath() != rightShard.GetPath() ||
+			!bytes.Equal(leftShard.GetBlob(), rightShard.GetBlob()) {
+			return false
+		}
+	}
 	for name, leftEntry := range left.Contents {
 		rightEntry := right.Contents[name]
 		if rightEntry == nil {
@@ -152,15 +165,18 @@ func AddProblem(manifest *Manifest, pathName, format string, args ...any) error
 	return fmt.Errorf("%s: %s", pathName, cause)
 }
 
-// Returns a map of git hash to entry. If `manifest` is nil, returns an empty map.
-func IndexManifestByGitHash(manifest *Manifest) map[string]*Entry {
+// Returns a map of git hash to entry, across all of `manifests`. If the same hash appears in
+// several manifests, the entry from the earliest one is used. Nil manifests are skipped.
+func IndexManifestByGitHash(manifests ...*Manifest) map[string]*Entry {
 	index := map[string]*Entry{}
-	for _, entry := range manifest.GetContents() {
-		if hash := entry.GetGitHash(); hash != "" {
-			if _, ok := plumbing.FromHex(hash); ok {
-				index[hash] = entry
-			} else {
-				panic(fmt.Errorf("index: malformed hash: %s", hash))
+	for _, manifest := range slices.Backward(manifests) {
+		for _, entry := range manifest.GetContents() {
+			if hash := entry.GetGitHash(); hash != "" {
+				if _, ok := plumbing.FromHex(hash); ok {
+					index[hash] = entry
+				} else {
+					panic(fmt.Errorf("index: malformed hash: %s", hash))
+				}
 			}
 		}
 	}
@@ -173,7 +189,8 @@ func ManifestHasBasicAuth(manifest *Manifest) bool {
 
 func IsEntryRegularFile(entry *Entry) bool {
 	return entry.GetType() == Type_InlineFile ||
-		entry.GetType() == Type_ExternalFile
+		entry.GetType() == Type_ExternalFile ||
+		entry.GetType() == Type_ChunkedFile
 }
 
 var ErrNotRegularFile = errors.New("not a regular file")
@@ -188,6 +205,18 @@ func GetEntryContents(ctx context.Context, entry *Entry) (data []byte, err error
 			return nil, err
 		}
 		data, err = io.ReadAll(reader)
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+	case Type_ChunkedFile:
+		reader, _, err := OpenChunkedFile(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(reader)
 		if err != nil {
 			return nil, err
 		}
@@ -248,14 +277,18 @@ func ManifestJSON(manifest *Manifest) []byte {
 
 var ErrSymlinkLoop = errors.New("symbolic link loop")
 
-func ExpandSymlinks(manifest *Manifest, inPath string) (string, error) {
+func ExpandSymlinks(ctx context.Context, manifest *Manifest, inPath string) (string, error) {
+	config := currentConfig(ctx)
 	var levels uint
 again:
 	for levels = 0; levels < config.Limits.MaxSymlinkDepth; levels += 1 {
 		parts := strings.Split(inPath, "/")
 		for i := 1; i <= len(parts); i++ {
 			linkPath := path.Join(parts[:i]...)
-			entry := manifest.Contents[linkPath]
+			entry, err := GetManifestEntry(ctx, manifest, linkPath)
+			if err != nil {
+				return "", err
+			}
 			if entry != nil && entry.GetType() == Type_Symlink {
 				inPath = path.Join(
 					path.Dir(linkPath),
@@ -296,7 +329,18 @@ func DetectContentType(manifest *Manifest) {
 // allocations of internal buffers.
 var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
 
-// Compress contents of inline files.
+// Returns `true` if the entry will be split into chunks when the manifest is stored.
+func isEntryChunkable(entry *Entry) bool {
+	config := loadConfig()
+	threshold := int64(config.Limits.ChunkedFileThreshold.Bytes())
+	return threshold > 0 &&
+		entry.GetType() == Type_InlineFile &&
+		entry.GetTransform() == Transform_Identity &&
+		entry.GetOriginalSize() >= threshold
+}
+
+// Compress contents of inline files. Files that will be split into chunks are not compressed,
+// since compressing them as a whole would prevent their chunks from being deduplicated.
 func CompressFiles(ctx context.Context, manifest *Manifest) {
 	span, _ := ObserveFunction(ctx, "CompressFiles")
 	defer span.Finish()
@@ -309,6 +353,9 @@ func CompressFiles(ctx context.Context, manifest *Manifest) {
 			if strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/") {
 				continue
 			}
+			if isEntryChunkable(entry) {
+				continue
+			}
 			compressedData := zstdEncoder.EncodeAll(entry.GetData(),
 				make([]byte, 0, entry.GetOriginalSize()))
 			if int64(len(compressedData)) < entry.GetOriginalSize() {
@@ -379,18 +426,39 @@ var putBlobSemaphore = make(chan struct{})
 func StoreManifest(
 	ctx context.Context, name string, manifest *Manifest, opts ModifyManifestOptions,
 ) (*Manifest, error) {
+	config := currentConfig(ctx)
 	span, ctx := ObserveFunction(ctx, "StoreManifest", "manifest.name", name)
 	defer span.Finish()
 
 	extManifest := &Manifest{}
 	proto.Merge(extManifest, manifest)
 
-	// Replace inline files over certain size with references to external data.
+	// Replace inline files over certain size with references to external data, and large files
+	// with references to their chunks.
 	extManifest.Contents = make(map[string]*Entry)
+	chunksData := map[string][]byte{}
 	for name, entry := range manifest.Contents {
 		cannotBeInlined := entry.GetType() == Type_InlineFile &&
 			entry.GetCompressedSize() > int64(config.Limits.MaxInlineFileSize.Bytes())
-		if cannotBeInlined {
+		if isEntryChunkable(entry) {
+			extEntry := &Entry{
+				Type:           Type_ChunkedFile.Enum(),
+				OriginalSize:   entry.OriginalSize,
+				CompressedSize: entry.CompressedSize,
+				Transform:      entry.Transform,
+				ContentType:    entry.ContentType,
+				GitHash:        entry.GitHash,
+			}
+			for _, chunkData := range SplitChunks(entry.Data, int(config.Limits.ChunkSize.Bytes())) {
+				blobName := fmt.Sprintf("sha256-%x", sha256.Sum256(chunkData))
+				extEntry.Chunks = append(extEntry.Chunks, &Chunk{
+					Blob: []byte(blobName),
+					Size: proto.Int64(int64(len(chunkData))),
+				})
+				chunksData[blobName] = chunkData
+			}
+			extManifest.Contents[name] = extEntry
+		} else if cannotBeInlined {
 			dataHash := sha256.Sum256(entry.Data)
 			extManifest.Contents[name] = &Entry{
 				Type:           Type_ExternalFile.Enum(),
@@ -411,9 +479,7 @@ func StoreManifest(
 	blobSizes := map[string]int64{}
 	for _, entry := range extManifest.Contents {
 		totalSize += entry.GetOriginalSize()
-		if entry.GetType() == Type_ExternalFile {
-			blobSizes[string(entry.Data)] = entry.GetCompressedSize()
-		}
+		maps.Copy(blobSizes, EntryBlobs(entry))
 	}
 	if uint64(totalSize) > config.Limits.MaxSiteSize.Bytes() {
 		return nil, fmt.Errorf("%w: contents size %s exceeds %s limit",
@@ -427,6 +493,13 @@ func StoreManifest(
 		*extManifest.StoredSize += blobSize
 	}
 
+	// Move entries of large directories into shards if the manifest is too large.
+	extContents := maps.Clone(extManifest.Contents)
+	var shardsData map[string][]byte
+	if shardSize := config.Limits.ManifestShardSize.Bytes(); shardSize > 0 {
+		shardsData = ShardManifest(extManifest, int64(shardSize))
+	}
+
 	// Upload the resulting manifest and the blob it references.
 	extManifestData := EncodeManifest(extManifest)
 	if uint64(len(extManifestData)) > config.Limits.MaxManifestSize.Bytes() {
@@ -442,8 +515,26 @@ func StoreManifest(
 	}
 
 	wg := sync.WaitGroup{}
-	ch := make(chan error, len(extManifest.Contents))
-	for name, entry := range extManifest.Contents {
+	ch := make(chan error, len(extContents)+len(chunksData)+len(shardsData))
+	for blobName, shardData := range shardsData {
+		putBlobSemaphore <- struct{}{} // acquire (and maybe block)
+		wg.Go(func() {
+			defer func() { <-putBlobSemaphore }() // release
+			if err := backend.PutBlob(ctx, blobName, shardData); err != nil {
+				ch <- fmt.Errorf("put shard %s: %w", blobName, err)
+			}
+		})
+	}
+	for blobName, chunkData := range chunksData {
+		putBlobSemaphore <- struct{}{} // acquire (and maybe block)
+		wg.Go(func() {
+			defer func() { <-putBlobSemaphore }() // release
+			if err := backend.PutBlob(ctx, blobName, chunkData); err != nil {
+				ch <- fmt.Errorf("put chunk %s: %w", blobName, err)
+			}
+		})
+	}
+	for name, entry := range extContents {
 		// Upload external entries (those that were decided as ineligible for being stored inline).
 		// If the entry in the original manifest is already an external reference, there's no need
 		// to externalize it (and no way for us to do so, since the entry only contains the blob name).
@@ -2,6 +2,7 @@ package git_pages
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"slices"
 	"strings"
@@ -11,8 +12,13 @@ func RunMigration(ctx context.Context, name string) error {
 	switch name {
 	case "create-domain-markers":
 		return createDomainMarkers(ctx)
+	case "reencrypt":
+		return reencrypt(ctx)
+	case "index-audit-log":
+		return indexAuditLog(ctx)
 	default:
-		return fmt.Errorf("unknown migration name (expected one of \"create-domain-markers\")")
+		return fmt.Errorf("unknown migration name (expected one of " +
+			"\"create-domain-markers\", \"reencrypt\", \"index-audit-log\")")
 	}
 }
 
@@ -49,3 +55,59 @@ func createDomainMarkers(ctx context.Context) error {
 	logc.Printf(ctx, "created markers for %d domains", len(domains))
 	return nil
 }
+
+func reencrypt(ctx context.Context) error {
+	encrypted, ok := unwrapBackend[*encryptedBackend](backend)
+	if !ok {
+		return fmt.Errorf("storage encryption is not configured")
+	}
+	return encrypted.Reencrypt(ctx)
+}
+
+// Adds every audit record to the audit log index. Since indexing a record again is not an error,
+// this also repairs the gaps left by records that could not be indexed when they were appended
+// (see `auditedBackend.indexAuditRecord`). The index is only marked as complete if no record
+// failed to be indexed while the migration was running.
+func indexAuditLog(ctx context.Context) error {
+	if _, ok := unwrapBackend[*encryptedBackend](backend); ok {
+		logc.Println(ctx, "audit records are encrypted and are not indexed")
+		return nil
+	}
+
+	if err := backend.EnableFeature(ctx, FeatureAuditIndexRebuild); err != nil {
+		return err
+	}
+	count := 0
+	for id, err := range backend.SearchAuditLog(ctx, SearchAuditLogOptions{}) {
+		if err != nil {
+			return fmt.Errorf("search audit log: %w", err)
+		}
+		record, err := backend.QueryAuditLog(ctx, id)
+		if err != nil {
+			return fmt.Errorf("query audit %s: %w", id, err)
+		}
+		if err = backend.IndexAuditRecord(ctx, id, AuditIndexTerms(record)); err != nil {
+			return fmt.Errorf("index audit %s: %w", id, err)
+		}
+		count += 1
+	}
+	// A node that fails to index a record disables the rebuild marker before the index, so
+	// checking the marker both before and after enabling the index ensures that the index
+	// ends up disabled if any record failed to be indexed during the migration.
+	errRebuildInterrupted := errors.New("an audit record could not be indexed during " +
+		"the migration; run it again")
+	if !backend.HasFeature(ctx, FeatureAuditIndexRebuild) {
+		return errRebuildInterrupted
+	}
+	if err := backend.EnableFeature(ctx, FeatureAuditIndex); err != nil {
+		return err
+	}
+	if !backend.HasFeature(ctx, FeatureAuditIndexRebuild) {
+		return errors.Join(errRebuildInterrupted, backend.DisableFeature(ctx, FeatureAuditIndex))
+	}
+	if err := backend.DisableFeature(ctx, FeatureAuditIndexRebuild); err != nil {
+		return err
+	}
+	logc.Printf(ctx, "indexed %d audit records", count)
+	return nil
+}
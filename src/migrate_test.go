@@ -0,0 +1,86 @@
+package git_pages
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kankanreno/go-snowflake"
+)
+
+// Simulates another node failing to index an audit record while the migration is running.
+type interruptingIndexBackend struct {
+	Backend
+	interrupt bool
+}
+
+func (backend *interruptingIndexBackend) IndexAuditRecord(
+	ctx context.Context, id AuditID, terms []string,
+) error {
+	if backend.interrupt {
+		backend.interrupt = false
+		for _, feature := range []BackendFeature{FeatureAuditIndexRebuild, FeatureAuditIndex} {
+			if err := backend.Backend.DisableFeature(ctx, feature); err != nil {
+				return err
+			}
+		}
+	}
+	return backend.Backend.IndexAuditRecord(ctx, id, terms)
+}
+
+func TestIndexAuditLog(t *testing.T) {
+	ctx := context.Background()
+
+	savedSnapshot, savedBackend := currentConfigSnapshot.Load(), backend
+	defer func() {
+		currentConfigSnapshot.Store(savedSnapshot)
+		backend = savedBackend
+	}()
+
+	config, err := Configure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Audit.Collect = true
+	currentConfigSnapshot.Store(&configSnapshot{config: config})
+	snowflake.SetStartTime(AuditSnowflakeStartTime)
+	snowflake.SetMachineID(config.Audit.NodeID)
+
+	for _, test := range []struct {
+		name        string
+		interrupt   bool
+		expectIndex bool
+	}{
+		{"complete", false, true},
+		{"interrupted", true, false},
+	} {
+		fsBackend, err := NewFSBackend(ctx, &FSConfig{Root: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		interrupting := &interruptingIndexBackend{Backend: fsBackend}
+		backend = NewAuditedBackend(interrupting)
+
+		for _, action := range []string{"one", "two"} {
+			if err := AuditAdminAction(ctx, action); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+		}
+		if err := backend.DisableFeature(ctx, FeatureAuditIndex); err != nil {
+			t.Fatal(err)
+		}
+
+		interrupting.interrupt = test.interrupt
+		err = indexAuditLog(ctx)
+		if test.expectIndex && err != nil {
+			t.Errorf("%s: expect ok, got err %s", test.name, err)
+		} else if !test.expectIndex && err == nil {
+			t.Errorf("%s: expect err, got ok", test.name)
+		}
+		if hasIndex := backend.HasFeature(ctx, FeatureAuditIndex); hasIndex != test.expectIndex {
+			t.Errorf("%s: expect index %v, got %v", test.name, test.expectIndex, hasIndex)
+		}
+		if backend.HasFeature(ctx, FeatureAuditIndexRebuild) {
+			t.Errorf("%s: expect rebuild marker to be removed", test.name)
+		}
+	}
+}
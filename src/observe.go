@@ -43,6 +43,7 @@ var (
 var syslogHandler syslog.Handler
 
 func InitObservability() {
+	config := loadConfig()
 	debug.SetPanicOnFault(true)
 
 	logHandlers := []slog.Handler{}
@@ -214,6 +215,13 @@ func (backend *observedBackend) EnableFeature(ctx context.Context, feature Backe
 	return
 }
 
+func (backend *observedBackend) DisableFeature(ctx context.Context, feature BackendFeature) (err error) {
+	span, ctx := ObserveFunction(ctx, "DisableFeature")
+	err = backend.inner.DisableFeature(ctx, feature)
+	span.Finish()
+	return
+}
+
 func (backend *observedBackend) GetBlob(
 	ctx context.Context, name string,
 ) (
@@ -239,6 +247,13 @@ func (backend *observedBackend) PutBlob(ctx context.Context, name string, data [
 	return
 }
 
+func (backend *observedBackend) ReplaceBlob(ctx context.Context, name string, data []byte) (err error) {
+	span, ctx := ObserveFunction(ctx, "ReplaceBlob", "blob.name", name, "blob.size", len(data))
+	err = backend.inner.ReplaceBlob(ctx, name, data)
+	span.Finish()
+	return
+}
+
 func (backend *observedBackend) DeleteBlob(ctx context.Context, name string) (err error) {
 	span, ctx := ObserveFunction(ctx, "DeleteBlob", "blob.name", name)
 	err = backend.inner.DeleteBlob(ctx, name)
@@ -274,6 +289,17 @@ func (backend *observedBackend) GetManifest(
 	return
 }
 
+func (backend *observedBackend) GetManifestShard(
+	ctx context.Context, name string,
+) (
+	shard *Manifest, err error,
+) {
+	span, ctx := ObserveFunction(ctx, "GetManifestShard", "shard.name", name)
+	shard, err = backend.inner.GetManifestShard(ctx, name)
+	span.Finish()
+	return
+}
+
 func (backend *observedBackend) StageManifest(ctx context.Context, manifest *Manifest) (err error) {
 	span, ctx := ObserveFunction(ctx, "StageManifest")
 	err = backend.inner.StageManifest(ctx, manifest)
@@ -292,6 +318,13 @@ func (backend *observedBackend) CommitManifest(ctx context.Context, name string,
 	return
 }
 
+func (backend *observedBackend) CommitManifests(ctx context.Context, commits []ManifestCommit) (err error) {
+	span, ctx := ObserveFunction(ctx, "CommitManifests", "manifest.count", len(commits))
+	err = backend.inner.CommitManifests(ctx, commits)
+	span.Finish()
+	return
+}
+
 func (backend *observedBackend) DeleteManifest(ctx context.Context, name string, opts ModifyManifestOptions) (err error) {
 	span, ctx := ObserveFunction(ctx, "DeleteManifest", "manifest.name", name)
 	err = backend.inner.DeleteManifest(ctx, name, opts)
@@ -318,6 +351,18 @@ func (backend *observedBackend) EnumerateManifests(ctx context.Context) iter.Seq
 	}
 }
 
+func (backend *observedBackend) EnumerateStagedManifests(ctx context.Context) iter.Seq2[*ManifestMetadata, error] {
+	return func(yield func(*ManifestMetadata, error) bool) {
+		span, ctx := ObserveFunction(ctx, "EnumerateStagedManifests")
+		for metadata, err := range backend.inner.EnumerateStagedManifests(ctx) {
+			if !yield(metadata, err) {
+				break
+			}
+		}
+		span.Finish()
+	}
+}
+
 func (backend *observedBackend) GetAllManifests(ctx context.Context) iter.Seq2[tuple[*ManifestMetadata, *Manifest], error] {
 	return func(yield func(tuple[*ManifestMetadata, *Manifest], error) bool) {
 		span, ctx := ObserveFunction(ctx, "GetAllManifests")
@@ -365,6 +410,105 @@ func (backend *observedBackend) HasSiteListChanged(ctx context.Context, since ti
 	return
 }
 
+func (backend *observedBackend) AppendUpload(ctx context.Context, name string, offset int64, data []byte) (err error) {
+	span, ctx := ObserveFunction(ctx, "AppendUpload",
+		"upload.name", name, "upload.offset", offset, "upload.size", len(data))
+	err = backend.inner.AppendUpload(ctx, name, offset, data)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) StatUpload(ctx context.Context, name string) (metadata UploadMetadata, err error) {
+	span, ctx := ObserveFunction(ctx, "StatUpload", "upload.name", name)
+	metadata, err = backend.inner.StatUpload(ctx, name)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) GetUpload(ctx context.Context, name string) (reader io.ReadCloser, err error) {
+	span, ctx := ObserveFunction(ctx, "GetUpload", "upload.name", name)
+	reader, err = backend.inner.GetUpload(ctx, name)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) DeleteUpload(ctx context.Context, name string) (err error) {
+	span, ctx := ObserveFunction(ctx, "DeleteUpload", "upload.name", name)
+	err = backend.inner.DeleteUpload(ctx, name)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) EnumerateUploads(ctx context.Context) iter.Seq2[UploadMetadata, error] {
+	return func(yield func(UploadMetadata, error) bool) {
+		span, ctx := ObserveFunction(ctx, "EnumerateUploads")
+		for metadata, err := range backend.inner.EnumerateUploads(ctx) {
+			if !yield(metadata, err) {
+				break
+			}
+		}
+		span.Finish()
+	}
+}
+
+func (backend *observedBackend) GetTLSData(ctx context.Context, name string) (data []byte, err error) {
+	span, ctx := ObserveFunction(ctx, "GetTLSData", "tls.name", name)
+	data, err = backend.inner.GetTLSData(ctx, name)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) PutTLSData(ctx context.Context, name string, data []byte) (err error) {
+	span, ctx := ObserveFunction(ctx, "PutTLSData", "tls.name", name)
+	err = backend.inner.PutTLSData(ctx, name, data)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) DeleteTLSData(ctx context.Context, name string) (err error) {
+	span, ctx := ObserveFunction(ctx, "DeleteTLSData", "tls.name", name)
+	err = backend.inner.DeleteTLSData(ctx, name)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) EnumerateTLSData(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		span, ctx := ObserveFunction(ctx, "EnumerateTLSData")
+		for name, err := range backend.inner.EnumerateTLSData(ctx) {
+			if !yield(name, err) {
+				break
+			}
+		}
+		span.Finish()
+	}
+}
+
+func (backend *observedBackend) UpdateRateLimit(
+	ctx context.Context, name string, update func(state []byte) []byte,
+) (err error) {
+	span, ctx := ObserveFunction(ctx, "UpdateRateLimit", "ratelimit.name", name)
+	err = backend.inner.UpdateRateLimit(ctx, name, update)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) GetSiteStats(ctx context.Context, webRoot string) (data []byte, err error) {
+	span, ctx := ObserveFunction(ctx, "GetSiteStats", "stats.name", webRoot)
+	data, err = backend.inner.GetSiteStats(ctx, webRoot)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) UpdateSiteStats(
+	ctx context.Context, webRoot string, update func(data []byte) []byte,
+) (err error) {
+	span, ctx := ObserveFunction(ctx, "UpdateSiteStats", "stats.name", webRoot)
+	err = backend.inner.UpdateSiteStats(ctx, webRoot, update)
+	span.Finish()
+	return
+}
+
 func (backend *observedBackend) AppendAuditLog(ctx context.Context, id AuditID, record *AuditRecord) (err error) {
 	span, ctx := ObserveFunction(ctx, "AppendAuditLog", "audit.id", id)
 	err = backend.inner.AppendAuditLog(ctx, id, record)
@@ -372,6 +516,13 @@ func (backend *observedBackend) AppendAuditLog(ctx context.Context, id AuditID,
 	return
 }
 
+func (backend *observedBackend) ReplaceAuditRecord(ctx context.Context, id AuditID, record *AuditRecord) (err error) {
+	span, ctx := ObserveFunction(ctx, "ReplaceAuditRecord", "audit.id", id)
+	err = backend.inner.ReplaceAuditRecord(ctx, id, record)
+	span.Finish()
+	return
+}
+
 func (backend *observedBackend) QueryAuditLog(ctx context.Context, id AuditID) (record *AuditRecord, err error) {
 	span, ctx := ObserveFunction(ctx, "QueryAuditLog", "audit.id", id)
 	record, err = backend.inner.QueryAuditLog(ctx, id)
@@ -379,6 +530,13 @@ func (backend *observedBackend) QueryAuditLog(ctx context.Context, id AuditID) (
 	return
 }
 
+func (backend *observedBackend) IndexAuditRecord(ctx context.Context, id AuditID, terms []string) (err error) {
+	span, ctx := ObserveFunction(ctx, "IndexAuditRecord", "audit.id", id)
+	err = backend.inner.IndexAuditRecord(ctx, id, terms)
+	span.Finish()
+	return
+}
+
 func (backend *observedBackend) SearchAuditLog(
 	ctx context.Context, opts SearchAuditLogOptions,
 ) iter.Seq2[AuditID, error] {
@@ -386,6 +544,7 @@ func (backend *observedBackend) SearchAuditLog(
 		span, ctx := ObserveFunction(ctx, "SearchAuditLog",
 			"audit.search.since", opts.Since,
 			"audit.search.until", opts.Until,
+			"audit.search.terms", opts.indexTerms(),
 		)
 		for id, err := range backend.inner.SearchAuditLog(ctx, opts) {
 			if !yield(id, err) {
@@ -423,3 +582,19 @@ func (backend *observedBackend) ExpireAuditRecord(ctx context.Context, id AuditI
 	span.Finish()
 	return
 }
+
+func (backend *observedBackend) GetAuditChainHead(ctx context.Context, node int) (data []byte, err error) {
+	span, ctx := ObserveFunction(ctx, "GetAuditChainHead", "audit.node", node)
+	data, err = backend.inner.GetAuditChainHead(ctx, node)
+	span.Finish()
+	return
+}
+
+func (backend *observedBackend) UpdateAuditChainHead(
+	ctx context.Context, node int, update func(data []byte) []byte,
+) (err error) {
+	span, ctx := ObserveFunction(ctx, "UpdateAuditChainHead", "audit.node", node)
+	err = backend.inner.UpdateAuditChainHead(ctx, node, update)
+	span.Finish()
+	return
+}
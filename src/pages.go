@@ -18,11 +18,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/c2h5oh/datasize"
 	"github.com/klauspost/compress/zstd"
 	"github.com/pquerna/cachecontrol/cacheobject"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"google.golang.org/protobuf/proto"
 )
 
 const notFoundPage = "404.html"
@@ -33,6 +33,11 @@ var (
 		Help: "Count of blob transform vs negotiated encoding",
 	}, []string{"transform", "negotiated"})
 
+	staleResponsesCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "git_pages_stale_responses_count",
+		Help: "Count of responses served from the cache because the storage backend was unavailable",
+	})
+
 	siteUpdatesCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "git_pages_site_updates",
 		Help: "Count of site updates in total",
@@ -62,6 +67,8 @@ func observeSiteUpdate(via string, result *UpdateResult) {
 		siteUpdateOkCount.With(prometheus.Labels{"outcome": "replaced"}).Inc()
 	case UpdateDeleted:
 		siteUpdateOkCount.With(prometheus.Labels{"outcome": "deleted"}).Inc()
+	case UpdateStaged:
+		siteUpdateOkCount.With(prometheus.Labels{"outcome": "staged"}).Inc()
 	}
 }
 
@@ -98,6 +105,15 @@ func getWebRoot(r *http.Request) (string, error) {
 	return makeWebRoot(host, projectName), nil
 }
 
+// Marks a response as having been served from a cache that could not be revalidated because
+// the storage backend is unavailable.
+func markStaleResponse(w http.ResponseWriter) {
+	if w.Header().Get("Warning") == "" {
+		staleResponsesCount.Inc()
+		w.Header().Set("Warning", `111 - "Revalidation Failed"`)
+	}
+}
+
 func writeRedirect(w http.ResponseWriter, code int, path string) {
 	w.Header().Set("Location", path)
 	w.WriteHeader(code)
@@ -109,10 +125,12 @@ func writeRedirect(w http.ResponseWriter, code int, path string) {
 var zstdDecoder, _ = zstd.NewReader(nil)
 
 func getPage(w http.ResponseWriter, r *http.Request) error {
+	config := currentConfig(r.Context())
 	var err error
 	var sitePath string
 	var manifest *Manifest
 	var metadata ManifestMetadata
+	var webRoot string
 
 	cacheControl, err := cacheobject.ParseRequestCacheControl(r.Header.Get("Cache-Control"))
 	if err != nil {
@@ -131,6 +149,29 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 	}
 	host = normalizeHost(host)
 
+	// A staged version of the site is selected using a header that contains its preview token;
+	// the token is only known to those who have uploaded the staged version.
+	stagedToken := r.Header.Get("Staged-Preview-Token")
+	getManifest := func(webRoot string) (*Manifest, ManifestMetadata, error) {
+		opts := GetManifestOptions{BypassCache: bypassCache}
+		if stagedToken != "" {
+			return GetStagedManifest(r.Context(), webRoot, stagedToken, opts)
+		} else {
+			return backend.GetManifest(r.Context(), webRoot, opts)
+		}
+	}
+	checkSite := func(webRoot string) Existence {
+		if stagedToken != "" {
+			return ExistencePossible
+		} else {
+			return existenceCache.CheckSite(r.Context(), webRoot)
+		}
+	}
+	if stagedToken != "" {
+		// staged versions must never be stored in a shared cache
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
 	type indexManifestResult struct {
 		manifest *Manifest
 		metadata ManifestMetadata
@@ -139,43 +180,40 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 	indexManifestCh := make(chan indexManifestResult, 1)
 	go func() {
 		webRoot := makeWebRoot(host, ".index")
-		if existenceCache.CheckSite(r.Context(), webRoot).IsImpossible() {
+		if checkSite(webRoot).IsImpossible() {
 			close(indexManifestCh)
 			return
 		}
-		manifest, metadata, err := backend.GetManifest(
-			r.Context(), webRoot,
-			GetManifestOptions{BypassCache: bypassCache},
-		)
+		manifest, metadata, err := getManifest(webRoot)
 		indexManifestCh <- (indexManifestResult{manifest, metadata, err})
 	}()
 
 	err = nil
 	sitePath = strings.TrimPrefix(r.URL.Path, "/")
 	if projectName, projectPath, hasProjectSlash := strings.Cut(sitePath, "/"); projectName != "" {
-		webRoot := makeWebRoot(host, projectName)
+		projectWebRoot := makeWebRoot(host, projectName)
 		if ValidateProjectName(projectName) == nil &&
-			existenceCache.CheckSite(r.Context(), webRoot).IsPossible() {
+			checkSite(projectWebRoot).IsPossible() {
 			var projectManifest *Manifest
 			var projectMetadata ManifestMetadata
-			projectManifest, projectMetadata, err = backend.GetManifest(
-				r.Context(), webRoot,
-				GetManifestOptions{BypassCache: bypassCache},
-			)
+			projectManifest, projectMetadata, err = getManifest(projectWebRoot)
 			if err == nil {
+				setAccessSite(r.Context(), projectWebRoot)
 				if !hasProjectSlash {
 					writeRedirect(w, http.StatusFound, r.URL.Path+"/")
 					return nil
 				}
 				sitePath, manifest, metadata = projectPath, projectManifest, projectMetadata
+				webRoot = projectWebRoot
 			}
 		}
 	}
 	if manifest == nil && (err == nil || errors.Is(err, ErrObjectNotFound)) {
 		result := <-indexManifestCh
 		manifest, metadata, err = result.manifest, result.metadata, result.err
+		webRoot = makeWebRoot(host, ".index")
 		if manifest == nil && (err == nil || errors.Is(err, ErrObjectNotFound)) {
-			if fallback != nil {
+			if fallback := loadConfigSnapshot(r.Context()).fallback; fallback != nil {
 				logc.Printf(r.Context(), "fallback: %s via %s", host, config.Fallback.ProxyTo)
 				fallback.ServeHTTP(w, r)
 				return nil
@@ -186,12 +224,24 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 			}
 		}
 	}
-	if err != nil {
+	if errors.Is(err, ErrBackendUnavailable) {
+		ObserveError(err) // all storage errors must be reported
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "service unavailable (%s)\n", err)
+		return err
+	} else if err != nil {
 		ObserveError(err) // all storage errors must be reported
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "internal server error (%s)\n", err)
 		return err
 	}
+	if err = CheckReadRateLimit(r.Context(), webRoot); err != nil {
+		return err
+	}
+	if metadata.Stale {
+		markStaleResponse(w)
+	}
+	setAccessSite(r.Context(), webRoot)
 
 	if r.Header.Get("Origin") != "" {
 		// allow JavaScript code to access responses (including errors) even across origins
@@ -208,6 +258,11 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 		lastModified := metadata.LastModified.UTC().Format(http.TimeFormat)
 		switch {
 		case metadataPath == "health":
+			if IsShuttingDown() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "shutting down\n")
+				return nil
+			}
 			w.Header().Add("Last-Modified", lastModified)
 			w.Header().Add("ETag", fmt.Sprintf("\"%s\"", metadata.ETag))
 			w.WriteHeader(http.StatusOK)
@@ -222,6 +277,14 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 				return err
 			}
 
+			manifest, err := UnshardManifest(r.Context(), manifest)
+			if err != nil {
+				ObserveError(err) // all storage errors must be reported
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "internal server error (%s)\n", err)
+				return err
+			}
+
 			var contentType string
 			var content []byte
 			switch metadataPath {
@@ -240,6 +303,27 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 			w.Write(content)
 			return nil
 
+		case metadataPath == "stats.json" && config.Stats.Collect:
+			// same as above
+			_, err := AuthorizeMetadataRetrieval(r, ManifestHasBasicAuth(manifest))
+			if err != nil {
+				return err
+			}
+
+			stats, err := ReadSiteStats(r.Context(), webRoot)
+			if err != nil {
+				ObserveError(err) // all storage errors must be reported
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "internal server error (%s)\n", err)
+				return err
+			}
+
+			w.Header().Add("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "private, no-store")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(stats)
+			return nil
+
 		case metadataPath == "archive.tar":
 			// same as above
 			_, err := AuthorizeMetadataRetrieval(r, ManifestHasBasicAuth(manifest))
@@ -300,13 +384,15 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 	for {
 		endsInSlash := false
 		entryPath, endsInSlash = strings.CutSuffix(entryPath, "/")
-		entryPath, err = ExpandSymlinks(manifest, entryPath)
+		entryPath, err = ExpandSymlinks(r.Context(), manifest, entryPath)
+		if err == nil {
+			entry, err = GetManifestEntry(r.Context(), manifest, entryPath)
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintln(w, err)
 			return err
 		}
-		entry = manifest.Contents[entryPath]
 		if entry != nil && IsEntryRegularFile(entry) && endsInSlash {
 			entry = nil
 		}
@@ -355,6 +441,29 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 					return err
 				}
 				mtime = metadata.LastModified
+				if metadata.Stale {
+					markStaleResponse(w)
+				}
+				w.Header().Set("ETag", etag)
+			}
+		} else if entry.GetType() == Type_ChunkedFile {
+			etag := chunkedEntryETag(entry)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			} else {
+				var metadata BlobMetadata
+				reader, metadata, err = OpenChunkedFile(r.Context(), entry)
+				if err != nil {
+					ObserveError(err) // all storage errors must be reported
+					w.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprintf(w, "internal server error: %s\n", err)
+					return err
+				}
+				mtime = metadata.LastModified
+				if metadata.Stale {
+					markStaleResponse(w)
+				}
 				w.Header().Set("ETag", etag)
 			}
 		} else if entry.GetType() == Type_Directory {
@@ -468,6 +577,9 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 		// it's good enough to overwrite whatever was our builtin option (if any).
 		maps.Copy(w.Header(), customHeaders)
 	}
+	if stagedToken != "" {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
 
 	// decide on the HTTP status
 	if status != 200 {
@@ -505,6 +617,7 @@ func checkDryRun(w http.ResponseWriter, r *http.Request) bool {
 func getUpdateOptions(
 	w http.ResponseWriter, r *http.Request, auth *Authorization,
 ) (opts UpdateOptions, ok bool) {
+	config := currentConfig(r.Context())
 	var err error
 
 	if config.Feature("expiration") {
@@ -526,11 +639,86 @@ func getUpdateOptions(
 		}
 	}
 
+	switch r.Header.Get("Stage") {
+	case "", "no":
+		opts.stage = false
+	case "yes":
+		opts.stage = true
+	default:
+		http.Error(w, "malformed Stage: header", http.StatusBadRequest)
+		return
+	}
+
+	opts.reuseFrom, err = getReuseManifests(r)
+	if err != nil {
+		var authErr AuthError
+		if errors.As(err, &authErr) {
+			http.Error(w, prettyErrMsg(err), authErr.code)
+		} else {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+		return
+	}
+
 	ok = true
 	return
 }
 
+// Maximum number of sites that may be listed in a `Reuse-From:` header.
+const maxReuseFromSites = 16
+
+// Retrieves the manifests of the sites listed in the `Reuse-From:` header, whose entries may be
+// referenced from an uploaded archive. Each of the sites must be readable by the principal in
+// the same way as its `.git-pages/manifest.json` would be, or the request is rejected. This
+// ensures that the blob references can only be resolved against contents that the principal
+// could have enumerated anyway, and not used as a blob existence oracle.
+func getReuseManifests(r *http.Request) (manifests []*Manifest, err error) {
+	header := r.Header.Get("Reuse-From")
+	if header == "" {
+		return nil, nil
+	}
+
+	siteURLs := strings.Split(header, ",")
+	if len(siteURLs) > maxReuseFromSites {
+		return nil, AuthError{http.StatusBadRequest,
+			fmt.Sprintf("too many sites in Reuse-From: header (%d > %d)",
+				len(siteURLs), maxReuseFromSites)}
+	}
+	for _, siteURL := range siteURLs {
+		parsedURL, err := url.Parse(strings.TrimSpace(siteURL))
+		if err != nil {
+			return nil, AuthError{http.StatusBadRequest, "malformed Reuse-From: header"}
+		}
+
+		siteRequest := r.Clone(r.Context())
+		if parsedURL.Host != "" {
+			siteRequest.Host = parsedURL.Host
+		}
+		siteRequest.URL.Path = parsedURL.Path
+		webRoot, err := getWebRoot(siteRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		manifest, _, err := GetUnshardedManifest(r.Context(), webRoot, GetManifestOptions{})
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return nil, err
+		}
+		// Authorize even if the site does not exist, so that the response does not depend
+		// on its existence.
+		_, err = AuthorizeMetadataRetrieval(siteRequest, ManifestHasBasicAuth(manifest))
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			manifests = append(manifests, manifest)
+		}
+	}
+	return
+}
+
 func putPage(w http.ResponseWriter, r *http.Request) error {
+	config := currentConfig(r.Context())
 	var result UpdateResult
 
 	for _, header := range []string{
@@ -547,6 +735,10 @@ func putPage(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	if r.Header.Get("Stage") == "promote" {
+		return promotePage(w, r, webRoot)
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(config.Limits.UpdateTimeout))
 	defer cancel()
 
@@ -586,6 +778,10 @@ func putPage(w http.ResponseWriter, r *http.Request) error {
 			return nil
 		}
 
+		if err := CheckUpdateRateLimits(r, webRoot); err != nil {
+			return err
+		}
+
 		result = UpdateFromRepository(ctx, webRoot, repoURL, branch, opts)
 
 	default:
@@ -608,15 +804,129 @@ func putPage(w http.ResponseWriter, r *http.Request) error {
 			return nil
 		}
 
+		if err := CheckUpdateRateLimits(r, webRoot); err != nil {
+			return err
+		}
+
 		// request body contains archive
 		reader := http.MaxBytesReader(w, r.Body, int64(config.Limits.MaxSiteSize.Bytes()))
-		result = UpdateFromArchive(ctx, webRoot, repoURL, contentType, reader, opts)
+		result = UpdateFromArchive(ctx, webRoot, repoURL, r.Header.Get("Content-Type"),
+			reader, opts)
 	}
 
 	return reportUpdateResult(w, r, result)
 }
 
+func promotePage(w http.ResponseWriter, r *http.Request, webRoot string) error {
+	if r.Header.Get("Staged-Versions") != "" {
+		return promoteSitesPage(w, r)
+	}
+
+	auth, err := AuthorizeUpdateFromArchive(r)
+	if err != nil {
+		return err
+	}
+
+	principal := GetPrincipal(r.Context())
+	copyForgeAuthToPrincipal(principal, auth)
+
+	// Requiring the version to be specified ensures that the promoted version is the same one
+	// that has been previewed, even if another version is staged concurrently.
+	version := r.Header.Get("Staged-Version")
+	if version == "" {
+		http.Error(w, "must provide \"Staged-Version:\" header", http.StatusPreconditionRequired)
+		return nil
+	}
+
+	if checkDryRun(w, r) {
+		return nil
+	}
+
+	if err := CheckUpdateRateLimits(r, webRoot); err != nil {
+		return err
+	}
+
+	result := Promote(r.Context(), webRoot, version)
+	return reportUpdateResult(w, r, result)
+}
+
+// Promotes staged versions of several sites on the same domain as a single transaction.
+// The sites and versions are provided as `Staged-Versions: /=<version>, /project/=<version>`.
+func promoteSitesPage(w http.ResponseWriter, r *http.Request) error {
+	host, err := GetHost(r)
+	if err != nil {
+		return err
+	}
+
+	versions := map[string]string{}
+	sitePaths := map[string]string{}
+	for item := range strings.SplitSeq(r.Header.Get("Staged-Versions"), ",") {
+		sitePath, version, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found || version == "" {
+			http.Error(w, "malformed Staged-Versions: header", http.StatusBadRequest)
+			return nil
+		}
+
+		// Each site is authorized separately, exactly as if it were promoted on its own.
+		siteRequest := r.Clone(r.Context())
+		siteRequest.URL.Path = sitePath
+		projectName, err := GetProjectName(siteRequest)
+		if err != nil {
+			return err
+		}
+		auth, err := AuthorizeUpdateFromArchive(siteRequest)
+		if err != nil {
+			return err
+		}
+
+		principal := GetPrincipal(r.Context())
+		copyForgeAuthToPrincipal(principal, auth)
+
+		webRoot := makeWebRoot(host, projectName)
+		if _, exists := versions[webRoot]; exists {
+			http.Error(w, fmt.Sprintf("duplicate site %s", sitePath), http.StatusBadRequest)
+			return nil
+		}
+		versions[webRoot] = version
+		sitePaths[webRoot] = sitePath
+	}
+
+	if checkDryRun(w, r) {
+		return nil
+	}
+
+	if err := CheckUpdateRateLimits(r, slices.Sorted(maps.Keys(versions))...); err != nil {
+		return err
+	}
+
+	// If the transaction is only partially committed, the sites that have been updated are
+	// listed before the error.
+	outcomes, err := PromoteSites(r.Context(), versions)
+	if err != nil {
+		w.WriteHeader(updateErrorStatus(err))
+		observeSiteUpdate("rest", &UpdateResult{UpdateError, nil, err, nil})
+	}
+	for _, webRoot := range slices.Sorted(maps.Keys(outcomes)) {
+		observeSiteUpdate("rest", &UpdateResult{outcomes[webRoot], nil, nil, nil})
+		var result string
+		switch outcomes[webRoot] {
+		case UpdateCreated:
+			result = "created"
+		case UpdateReplaced:
+			result = "replaced"
+		case UpdateNoChange:
+			result = "no-change"
+		}
+		fmt.Fprintf(w, "%s %s\n", sitePaths[webRoot], result)
+	}
+	if err != nil {
+		fmt.Fprintln(w, err)
+	}
+	return nil
+}
+
 func patchPage(w http.ResponseWriter, r *http.Request) error {
+	config := currentConfig(r.Context())
 	for _, header := range []string{
 		"If-Modified-Since", "If-Unmodified-Since", "If-Match", "If-None-Match",
 	} {
@@ -642,6 +952,9 @@ func patchPage(w http.ResponseWriter, r *http.Request) error {
 	opts, ok := getUpdateOptions(w, r, auth)
 	if !ok {
 		return nil
+	} else if opts.stage {
+		http.Error(w, "staged partial updates unsupported", http.StatusBadRequest)
+		return nil
 	}
 
 	if checkDryRun(w, r) {
@@ -679,6 +992,10 @@ func patchPage(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	if err := CheckUpdateRateLimits(r, webRoot); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(config.Limits.UpdateTimeout))
 	defer cancel()
 
@@ -688,6 +1005,47 @@ func patchPage(w http.ResponseWriter, r *http.Request) error {
 	return reportUpdateResult(w, r, result)
 }
 
+func updateErrorStatus(err error) int {
+	var unresolvedRefErr UnresolvedRefError
+	var rateLimitErr *RateLimitError
+	if errors.Is(err, ErrSiteTooLarge) {
+		return http.StatusUnprocessableEntity
+	} else if errors.Is(err, ErrManifestTooLarge) {
+		return http.StatusUnprocessableEntity
+	} else if errors.Is(err, errArchiveFormat) {
+		return http.StatusUnsupportedMediaType
+	} else if errors.Is(err, ErrArchiveTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	} else if IsArchiveParseError(err) {
+		return http.StatusUnprocessableEntity
+	} else if errors.Is(err, ErrRepositoryTooLarge) {
+		return http.StatusUnprocessableEntity
+	} else if errors.Is(err, ErrMalformedPatch) {
+		return http.StatusUnprocessableEntity
+	} else if errors.Is(err, ErrPartialCommit) {
+		// checked first, since it also wraps the error that interrupted the transaction
+		return http.StatusInternalServerError
+	} else if errors.Is(err, ErrPreconditionFailed) {
+		return http.StatusPreconditionFailed
+	} else if errors.Is(err, ErrWriteConflict) {
+		return http.StatusConflict
+	} else if errors.Is(err, ErrDomainFrozen) {
+		return http.StatusForbidden
+	} else if errors.Is(err, ErrStagedVersionNotFound) {
+		return http.StatusNotFound
+	} else if errors.Is(err, errStageEmptySite) {
+		return http.StatusUnprocessableEntity
+	} else if errors.Is(err, ErrUploadNotFound) {
+		return http.StatusNotFound
+	} else if errors.As(err, &unresolvedRefErr) {
+		return http.StatusUnprocessableEntity
+	} else if errors.As(err, &rateLimitErr) {
+		return http.StatusTooManyRequests
+	} else {
+		return http.StatusServiceUnavailable
+	}
+}
+
 func reportUpdateResult(w http.ResponseWriter, r *http.Request, result UpdateResult) error {
 	var unresolvedRefErr UnresolvedRefError
 	if result.outcome == UpdateError && errors.As(result.err, &unresolvedRefErr) {
@@ -712,31 +1070,11 @@ func reportUpdateResult(w http.ResponseWriter, r *http.Request, result UpdateRes
 
 	switch result.outcome {
 	case UpdateError:
-		if errors.Is(result.err, ErrSiteTooLarge) {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-		} else if errors.Is(result.err, ErrManifestTooLarge) {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-		} else if errors.Is(result.err, errArchiveFormat) {
-			w.WriteHeader(http.StatusUnsupportedMediaType)
-		} else if errors.Is(result.err, ErrArchiveTooLarge) {
-			w.WriteHeader(http.StatusRequestEntityTooLarge)
-		} else if IsArchiveParseError(result.err) {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-		} else if errors.Is(result.err, ErrRepositoryTooLarge) {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-		} else if errors.Is(result.err, ErrMalformedPatch) {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-		} else if errors.Is(result.err, ErrPreconditionFailed) {
-			w.WriteHeader(http.StatusPreconditionFailed)
-		} else if errors.Is(result.err, ErrWriteConflict) {
-			w.WriteHeader(http.StatusConflict)
-		} else if errors.Is(result.err, ErrDomainFrozen) {
-			w.WriteHeader(http.StatusForbidden)
-		} else if errors.As(result.err, &unresolvedRefErr) {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
+		var rateLimitErr *RateLimitError
+		if errors.As(result.err, &rateLimitErr) {
+			setRetryAfter(w, rateLimitErr)
 		}
+		w.WriteHeader(updateErrorStatus(result.err))
 	case UpdateTimeout:
 		w.WriteHeader(http.StatusGatewayTimeout)
 	case UpdateNoChange:
@@ -747,6 +1085,10 @@ func reportUpdateResult(w http.ResponseWriter, r *http.Request, result UpdateRes
 		w.Header().Add("Update-Result", "replaced")
 	case UpdateDeleted:
 		w.Header().Add("Update-Result", "deleted")
+	case UpdateStaged:
+		w.Header().Add("Update-Result", "staged")
+		w.Header().Add("Staged-Version", StagedVersion(result.manifest))
+		w.Header().Add("Staged-Preview-Token", result.manifest.GetStagedToken())
 	}
 	if result.manifest != nil {
 		if result.manifest.Commit != nil {
@@ -754,6 +1096,9 @@ func reportUpdateResult(w http.ResponseWriter, r *http.Request, result UpdateRes
 		} else {
 			fmt.Fprintln(w, "(archive)")
 		}
+		if result.diff != nil {
+			fmt.Fprintf(w, "changes: %s\n", result.diff.Summary())
+		}
 		for _, problem := range GetProblemReport(result.manifest) {
 			fmt.Fprintln(w, problem)
 		}
@@ -780,11 +1125,31 @@ func deletePage(w http.ResponseWriter, r *http.Request) error {
 	principal := GetPrincipal(r.Context())
 	copyForgeAuthToPrincipal(principal, auth)
 
+	var discard bool
+	switch r.Header.Get("Stage") {
+	case "", "no":
+		discard = false
+	case "yes":
+		discard = true
+	default:
+		http.Error(w, "malformed Stage: header", http.StatusBadRequest)
+		return nil
+	}
+
 	if checkDryRun(w, r) {
 		return nil
 	}
 
-	if err = backend.DeleteManifest(r.Context(), webRoot, ModifyManifestOptions{}); err != nil {
+	if err := CheckUpdateRateLimits(r, webRoot); err != nil {
+		return err
+	}
+
+	if discard {
+		err = Discard(r.Context(), webRoot)
+	} else {
+		err = backend.DeleteManifest(r.Context(), webRoot, ModifyManifestOptions{})
+	}
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintln(w, err)
 	} else {
@@ -880,15 +1245,24 @@ func postPage(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	if err := CheckUpdateRateLimits(r, webRoot); err != nil {
+		return err
+	}
+
+	// The update continues after the response is sent if it takes too long; a graceful shutdown
+	// waits for it to finish.
 	resultChan := make(chan UpdateResult, 1)
-	go func(ctx context.Context) {
+	goBackground(func() {
+		config := currentConfig(r.Context())
+		ctx, cancelDetached := detachedContext(r.Context())
+		defer cancelDetached()
 		ctx, cancel := context.WithTimeout(ctx, time.Duration(config.Limits.UpdateTimeout))
 		defer cancel()
 
 		result := UpdateFromRepository(ctx, webRoot, repoURL, auth.branch, UpdateOptions{})
 		resultChan <- result
 		observeSiteUpdate("webhook", &result)
-	}(context.WithoutCancel(r.Context()))
+	})
 
 	var result UpdateResult
 	select {
@@ -915,6 +1289,9 @@ func postPage(w http.ResponseWriter, r *http.Request) error {
 	case UpdateDeleted:
 		fmt.Fprintln(w, "deleted")
 	}
+	if result.diff != nil {
+		fmt.Fprintf(w, "changes: %s\n", result.diff.Summary())
+	}
 	if result.manifest != nil {
 		report := GetProblemReport(result.manifest)
 		if len(report) > 0 {
@@ -927,11 +1304,218 @@ func postPage(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// Implements a resumable upload protocol (modelled after, but not compatible with, tus.io) for
+// archives that are too large or take too long to upload in a single request:
+//   - `POST /<site>/.git-pages/upload/` starts an upload and returns its URL in `Location:`;
+//   - `HEAD <upload>` returns the amount of data received so far in `Upload-Offset:`;
+//   - `PATCH <upload>` appends the request body at the `Upload-Offset:` given in the request;
+//   - `POST <upload>` updates the site from the uploaded archive, like `PUT /<site>/` would;
+//   - `DELETE <upload>` cancels the upload.
+func serveUpload(w http.ResponseWriter, r *http.Request, sitePath string, id string) error {
+	config := currentConfig(r.Context())
+	siteRequest := r.Clone(r.Context())
+	siteRequest.URL.Path = sitePath
+	webRoot, err := getWebRoot(siteRequest)
+	if err != nil {
+		return err
+	}
+
+	auth, err := AuthorizeUpdateFromArchive(siteRequest)
+	if err != nil {
+		return err
+	}
+
+	principal := GetPrincipal(r.Context())
+	copyForgeAuthToPrincipal(principal, auth)
+
+	maxSiteSize := int64(config.Limits.MaxSiteSize.Bytes())
+	w.Header().Set("Cache-Control", "no-store")
+	if id == "" {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return nil
+		}
+
+		if length := r.Header.Get("Upload-Length"); length != "" {
+			size, err := strconv.ParseInt(length, 10, 64)
+			if err != nil || size < 0 {
+				http.Error(w, "malformed Upload-Length: header", http.StatusBadRequest)
+				return nil
+			} else if size > maxSiteSize {
+				http.Error(w, fmt.Sprintf("upload too large (%s > %s)",
+					datasize.ByteSize(size).HR(), config.Limits.MaxSiteSize.HR()),
+					http.StatusRequestEntityTooLarge)
+				return nil
+			}
+		}
+
+		if checkDryRun(w, r) {
+			return nil
+		}
+
+		// Each upload is counted as a single update when it is started.
+		if err := CheckUpdateRateLimits(r, webRoot); err != nil {
+			return err
+		}
+
+		id, err := CreateUpload(r.Context(), webRoot)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return err
+		}
+		w.Header().Set("Location", path.Join(sitePath, ".git-pages/upload", id))
+		w.Header().Set("Upload-Offset", "0")
+		w.Header().Set("Upload-Max-Length", strconv.FormatInt(maxSiteSize, 10))
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	}
+
+	if err := ValidateUploadID(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+
+	switch r.Method {
+	case "HEAD":
+		offset, err := GetUploadOffset(r.Context(), webRoot, id)
+		if err != nil {
+			w.WriteHeader(updateErrorStatus(err))
+			return err
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.WriteHeader(http.StatusOK)
+		return nil
+
+	case "PATCH":
+		if getMediaType(r.Header.Get("Content-Type")) != "application/offset+octet-stream" {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return nil
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 || offset > maxSiteSize {
+			http.Error(w, "malformed Upload-Offset: header", http.StatusBadRequest)
+			return nil
+		}
+
+		if checkDryRun(w, r) {
+			return nil
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSiteSize-offset))
+		if err != nil {
+			return fmt.Errorf("body read: %w", err)
+		}
+		newOffset, err := AppendUpload(r.Context(), webRoot, id, offset, data)
+		if errors.Is(err, ErrPreconditionFailed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return err
+		} else if err != nil {
+			w.WriteHeader(updateErrorStatus(err))
+			fmt.Fprintln(w, err)
+			return err
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+
+	case "POST":
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(config.Limits.UpdateTimeout))
+		defer cancel()
+
+		// keep the parameters, since `multipart/form-data` archives require a boundary
+		contentType := r.Header.Get("Content-Type")
+		repoURL := auth.ForgeRepoURL()
+
+		opts, ok := getUpdateOptions(w, r, auth)
+		if !ok {
+			return nil
+		}
+
+		if checkDryRun(w, r) {
+			return nil
+		}
+
+		result := FinalizeUpload(ctx, webRoot, id, repoURL, contentType, opts)
+		return reportUpdateResult(w, r, result)
+
+	case "DELETE":
+		if checkDryRun(w, r) {
+			return nil
+		}
+
+		if err := CancelUpload(r.Context(), webRoot, id); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+
+	default:
+		w.Header().Set("Allow", "HEAD, PATCH, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+}
+
+// Responds with the subset of git hashes listed in the request body (one per line) that can be
+// referenced from an archive uploaded to the site at `sitePath` using `/git/blobs/<git-sha256>`
+// symlinks, given the same `Reuse-From:` header as the upload.
+func negotiatePage(w http.ResponseWriter, r *http.Request, sitePath string) error {
+	config := currentConfig(r.Context())
+	siteRequest := r.Clone(r.Context())
+	siteRequest.URL.Path = sitePath
+	webRoot, err := getWebRoot(siteRequest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := AuthorizeUpdateFromArchive(siteRequest); err != nil {
+		return err
+	}
+
+	if getMediaType(r.Header.Get("Content-Type")) != "text/plain" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return nil
+	}
+
+	reuseManifests, err := getReuseManifests(r)
+	if err != nil {
+		return err
+	}
+
+	oldManifest, _, err := GetUnshardedManifest(r.Context(), webRoot, GetManifestOptions{})
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return err
+	}
+	index := IndexManifestByGitHash(append([]*Manifest{oldManifest}, reuseManifests...)...)
+
+	// the request lists hashes of the files in an archive, so it's bounded similarly to a manifest
+	reader := http.MaxBytesReader(w, r.Body, int64(config.Limits.MaxManifestSize.Bytes()))
+	requestBody, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("body read: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	for hash := range strings.Lines(string(requestBody)) {
+		hash = strings.TrimSpace(hash)
+		if _, found := index[hash]; found {
+			fmt.Fprintln(w, hash)
+		}
+	}
+	return nil
+}
+
 func ServePages(w http.ResponseWriter, r *http.Request) {
 	r = r.WithContext(WithPrincipal(r.Context()))
-	if config.Audit.IncludeIPs != "" {
-		GetPrincipal(r.Context()).IpAddress = proto.String(r.RemoteAddr)
-	}
+	GetPrincipal(r.Context()).IpAddress = auditIPAddress(r)
 	switch r.Method {
 	case "PUT", "PATCH", "POST":
 		mediaType := r.Header.Get("Content-Type")
@@ -960,30 +1544,44 @@ func ServePages(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Allow", strings.Join(allowedMethods, ", "))
 	}
 	err := error(nil)
-	switch r.Method {
-	// REST API
-	case "OPTIONS":
-		// no preflight options
-	case "HEAD", "GET":
-		err = getPage(w, r)
-	case "PUT":
-		err = putPage(w, r)
-	case "PATCH":
-		err = patchPage(w, r)
-	case "DELETE":
-		err = deletePage(w, r)
-	// webhook API
-	case "POST":
-		err = postPage(w, r)
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		err = fmt.Errorf("method %s not allowed", r.Method)
+	if sitePath, found := strings.CutSuffix(r.URL.Path, "/.git-pages/negotiate"); found &&
+		r.Method == "POST" {
+		// blob reuse negotiation API
+		err = negotiatePage(w, r, sitePath+"/")
+	} else if sitePath, id, found := strings.Cut(r.URL.Path, "/.git-pages/upload/"); found {
+		// resumable upload API
+		err = serveUpload(w, r, sitePath+"/", id)
+	} else {
+		switch r.Method {
+		// REST API
+		case "OPTIONS":
+			// no preflight options
+		case "HEAD", "GET":
+			err = getPage(w, r)
+		case "PUT":
+			err = putPage(w, r)
+		case "PATCH":
+			err = patchPage(w, r)
+		case "DELETE":
+			err = deletePage(w, r)
+		// webhook API
+		case "POST":
+			err = postPage(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			err = fmt.Errorf("method %s not allowed", r.Method)
+		}
 	}
 	if err != nil {
 		var authErr AuthError
 		if errors.As(err, &authErr) {
 			http.Error(w, prettyErrMsg(err), authErr.code)
 		}
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			setRetryAfter(w, rateLimitErr)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		}
 		var tooLargeErr *http.MaxBytesError
 		if errors.As(err, &tooLargeErr) {
 			message := "request body too large"
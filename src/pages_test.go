@@ -23,7 +23,7 @@ func checkHost(t *testing.T, host string, expectOk string, expectErr string) {
 }
 
 func TestHelloName(t *testing.T) {
-	config = &Config{Features: []string{}}
+	currentConfigSnapshot.Store(&configSnapshot{config: &Config{Features: []string{}}})
 
 	checkHost(t, "foo.bar", "foo.bar", "")
 	checkHost(t, "foo-baz.bar", "foo-baz.bar", "")
@@ -43,7 +43,7 @@ func TestHelloName(t *testing.T) {
 	checkHost(t, "foo__baz.bar", "", "malformed host name")
 	checkHost(t, "*.foo.bar", "", "malformed host name")
 
-	config = &Config{Features: []string{"relaxed-idna"}}
+	currentConfigSnapshot.Store(&configSnapshot{config: &Config{Features: []string{"relaxed-idna"}}})
 
 	checkHost(t, "foo-.bar", "", "malformed host name")
 	checkHost(t, "-foo.bar", "", "malformed host name")
@@ -24,14 +24,18 @@ const (
 //     at a given path, this path and its entire subtree (if any) are removed from the manifest.
 //   - When a directory is placed at a given path, this path and its entire subtree (if any) are
 //     removed from the manifest and replaced with the contents of the directory.
-func ApplyTarPatch(manifest *Manifest, reader io.Reader, parents CreateParentsMode) error {
+//
+// Blob references are resolved against `manifest` first, and `reuseManifests` afterwards.
+func ApplyTarPatch(
+	manifest *Manifest, reader io.Reader, parents CreateParentsMode, reuseManifests ...*Manifest,
+) error {
 	type Node struct {
 		entry    *Entry
 		children map[string]*Node
 	}
 
 	// Index the manifest for incremental update operations.
-	index := IndexManifestByGitHash(manifest)
+	index := IndexManifestByGitHash(append([]*Manifest{manifest}, reuseManifests...)...)
 	missing := []string{}
 
 	// Extract the manifest contents (which is using a flat hash map) into a directory tree
@@ -0,0 +1,155 @@
+package git_pages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum time a trusted proxy may take to send the PROXY protocol header.
+const proxyProtocolHeaderTimeout = 10 * time.Second
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Accepts connections that start with a PROXY protocol (v1 or v2) header, and reports the client
+// address from the header as the remote address of the connection. The header is only read from
+// connections made by trusted proxies; other connections are used as-is, so a client connecting
+// directly cannot spoof its address.
+type proxyProtocolListener struct {
+	net.Listener
+	ctx context.Context
+}
+
+func (listener *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn, ctx: listener.ctx}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	ctx context.Context
+
+	// The header is read on first use rather than in `Accept`, since a slow proxy would
+	// otherwise stall the accept loop.
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	err        error
+}
+
+func (conn *proxyProtocolConn) readHeader() {
+	conn.once.Do(func() {
+		conn.reader = bufio.NewReader(conn.Conn)
+		conn.remoteAddr = conn.Conn.RemoteAddr()
+		if !isTrustedPeer(conn.remoteAddr.String()) {
+			return
+		}
+
+		conn.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+		conn.remoteAddr, conn.err = readProxyProtocolHeader(conn.reader, conn.remoteAddr)
+		conn.Conn.SetReadDeadline(time.Time{})
+		if conn.err != nil {
+			logc.Printf(conn.ctx, "proxy protocol err: %s: %s\n", conn.Conn.RemoteAddr(), conn.err)
+			conn.Conn.Close()
+		}
+	})
+}
+
+func (conn *proxyProtocolConn) Read(p []byte) (int, error) {
+	conn.readHeader()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+	return conn.reader.Read(p)
+}
+
+func (conn *proxyProtocolConn) RemoteAddr() net.Addr {
+	conn.readHeader()
+	return conn.remoteAddr
+}
+
+// Reads a PROXY protocol header and returns the client address it specifies, or `peerAddr`
+// if the header does not specify one (e.g. for health checks made by the proxy itself).
+func readProxyProtocolHeader(reader *bufio.Reader, peerAddr net.Addr) (net.Addr, error) {
+	signature, err := reader.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	var clientAddr netip.AddrPort
+	if bytes.Equal(signature, proxyProtocolV2Signature) {
+		header := make([]byte, len(proxyProtocolV2Signature)+4)
+		if _, err = io.ReadFull(reader, header); err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		versionCommand, family := header[12], header[13]
+		payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		if versionCommand>>4 != 2 {
+			return nil, fmt.Errorf("unsupported version %d", versionCommand>>4)
+		}
+		switch versionCommand & 0xf {
+		case 0x0: // LOCAL
+			return peerAddr, nil
+		case 0x1: // PROXY
+		default:
+			return nil, fmt.Errorf("unsupported command %d", versionCommand&0xf)
+		}
+		switch family >> 4 {
+		case 0x1: // AF_INET
+			if len(payload) < 12 {
+				return nil, fmt.Errorf("truncated address")
+			}
+			clientAddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])),
+				binary.BigEndian.Uint16(payload[8:10]))
+		case 0x2: // AF_INET6
+			if len(payload) < 36 {
+				return nil, fmt.Errorf("truncated address")
+			}
+			clientAddr = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])),
+				binary.BigEndian.Uint16(payload[32:34]))
+		default: // AF_UNSPEC, AF_UNIX
+			return peerAddr, nil
+		}
+	} else if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		// The longest possible v1 header is 107 bytes long.
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		} else if len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+			return nil, fmt.Errorf("malformed header")
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+			return peerAddr, nil
+		} else if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+			return nil, fmt.Errorf("malformed header")
+		}
+		addr, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("malformed header: %w", err)
+		}
+		port, err := strconv.ParseUint(fields[4], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("malformed header: %w", err)
+		}
+		clientAddr = netip.AddrPortFrom(addr, uint16(port))
+	} else {
+		return nil, fmt.Errorf("missing header")
+	}
+	return net.TCPAddrFromAddrPort(clientAddr), nil
+}
@@ -0,0 +1,84 @@
+package git_pages
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func proxyProtocolV2Header(command byte, family byte, payload []byte) string {
+	header := bytes.Clone(proxyProtocolV2Signature)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return string(append(header, payload...))
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	peerAddr := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:1234"))
+
+	inet4Payload := []byte{
+		198, 51, 100, 1, // source address
+		192, 0, 2, 1, // destination address
+		0x30, 0x39, // source port
+		0x01, 0xbb, // destination port
+	}
+	inet6Payload := make([]byte, 36)
+	copy(inet6Payload[0:16], netip.MustParseAddr("2001:db8::1").AsSlice())
+	copy(inet6Payload[16:32], netip.MustParseAddr("2001:db8::2").AsSlice())
+	binary.BigEndian.PutUint16(inet6Payload[32:34], 12345)
+	binary.BigEndian.PutUint16(inet6Payload[34:36], 443)
+
+	for _, test := range []struct {
+		name       string
+		header     string
+		expectAddr string
+		expectErr  string
+	}{
+		{"v1 tcp4", "PROXY TCP4 198.51.100.1 192.0.2.1 12345 443\r\n", "198.51.100.1:12345", ""},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n", "[2001:db8::1]:12345", ""},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "192.0.2.1:1234", ""},
+		{"v1 unknown with addresses",
+			"PROXY UNKNOWN 2001:db8::1 2001:db8::2 12345 443\r\n", "192.0.2.1:1234", ""},
+		{"v1 no crlf", "PROXY TCP4 198.51.100.1 192.0.2.1 12345 443\n", "", "malformed header"},
+		{"v1 bad protocol", "PROXY UDP4 198.51.100.1 192.0.2.1 12345 443\r\n", "", "malformed header"},
+		{"v1 bad address", "PROXY TCP4 198.51.100 192.0.2.1 12345 443\r\n", "", "malformed header"},
+		{"v1 bad port", "PROXY TCP4 198.51.100.1 192.0.2.1 123456 443\r\n", "", "malformed header"},
+		{"v1 missing fields", "PROXY TCP4 198.51.100.1 192.0.2.1\r\n", "", "malformed header"},
+		{"v1 too long", "PROXY TCP6 " + strings.Repeat("0", 100) + " :: 1 2\r\n", "", "malformed header"},
+		{"v2 inet", proxyProtocolV2Header(0x1, 0x11, inet4Payload), "198.51.100.1:12345", ""},
+		{"v2 inet6", proxyProtocolV2Header(0x1, 0x21, inet6Payload), "[2001:db8::1]:12345", ""},
+		{"v2 local", proxyProtocolV2Header(0x0, 0x00, nil), "192.0.2.1:1234", ""},
+		{"v2 unspec", proxyProtocolV2Header(0x1, 0x00, nil), "192.0.2.1:1234", ""},
+		{"v2 unix", proxyProtocolV2Header(0x1, 0x31, make([]byte, 216)), "192.0.2.1:1234", ""},
+		{"v2 truncated inet", proxyProtocolV2Header(0x1, 0x11, inet4Payload[:8]),
+			"", "truncated address"},
+		{"v2 truncated inet6", proxyProtocolV2Header(0x1, 0x21, inet6Payload[:32]),
+			"", "truncated address"},
+		{"v2 bad command", proxyProtocolV2Header(0x2, 0x11, inet4Payload), "", "unsupported command"},
+		{"v2 bad version", strings.Replace(proxyProtocolV2Header(0x1, 0x11, inet4Payload),
+			"\n\x21", "\n\x11", 1), "", "unsupported version"},
+		{"missing", "GET / HTTP/1.1\r\n", "", "missing header"},
+	} {
+		const request = "GET / HTTP/1.1\r\nHost: example.org\r\n\r\n"
+		reader := bufio.NewReader(strings.NewReader(test.header + request))
+		addr, err := readProxyProtocolHeader(reader, peerAddr)
+		if test.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+				t.Errorf("%s: expect err %s, got %v, err %v", test.name, test.expectErr, addr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expect ok %s, got err %s", test.name, test.expectAddr, err)
+		} else if addr.String() != test.expectAddr {
+			t.Errorf("%s: expect ok %s, got ok %s", test.name, test.expectAddr, addr)
+		} else if rest, _ := io.ReadAll(reader); string(rest) != request {
+			t.Errorf("%s: expect request to follow header, got %q", test.name, rest)
+		}
+	}
+}
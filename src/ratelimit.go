@@ -0,0 +1,193 @@
+package git_pages
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	rateLimitedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "git_pages_rate_limited_count",
+		Help: "Count of requests rejected because a rate limit was exceeded",
+	}, []string{"limit"})
+	rateLimitErrorCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "git_pages_rate_limit_errors_count",
+		Help: "Count of shared rate limit checks that failed and fell back to a local check",
+	}, []string{"limit"})
+)
+
+type RateLimitError struct {
+	Limit      string
+	RetryAfter time.Duration
+}
+
+// Returns the time after which the request may be retried, rounded up to whole seconds.
+func (err *RateLimitError) RetryAfterSeconds() int64 {
+	return int64(math.Ceil(err.RetryAfter.Seconds()))
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit %s exceeded, retry after %ds", err.Limit, err.RetryAfterSeconds())
+}
+
+func setRetryAfter(w http.ResponseWriter, err *RateLimitError) {
+	w.Header().Set("Retry-After", strconv.FormatInt(err.RetryAfterSeconds(), 10))
+}
+
+// Implements a token bucket using the generic cell rate algorithm, where the state is the time
+// at which the bucket will be full again. Returns the new state, or nil and the time after which
+// the request would be admitted if the bucket is empty.
+func admitRateLimit(
+	state []byte, limit RateLimit, now time.Time,
+) (
+	newState []byte, retryAfter time.Duration,
+) {
+	interval := limit.Period / time.Duration(limit.Count)
+	fullAt := now
+	if len(state) == 8 {
+		if storedFullAt := time.Unix(0, int64(binary.BigEndian.Uint64(state))); storedFullAt.After(now) {
+			fullAt = storedFullAt
+		}
+	}
+	newFullAt := fullAt.Add(interval)
+	if excess := newFullAt.Sub(now) - limit.Period; excess > 0 {
+		return nil, excess
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(newFullAt.UnixNano())), 0
+}
+
+var localRateLimits = struct {
+	sync.Mutex
+	states map[string][]byte
+}{states: map[string][]byte{}}
+
+// Bound the memory used by rate limiters keyed by e.g. IP addresses; an entry for a full bucket
+// carries no information and can be removed.
+const localRateLimitSweepInterval = time.Minute
+
+func updateLocalRateLimit(name string, update func(state []byte) []byte) {
+	localRateLimits.Lock()
+	defer localRateLimits.Unlock()
+
+	if newState := update(localRateLimits.states[name]); newState != nil {
+		localRateLimits.states[name] = newState
+	}
+}
+
+// Removes the entries of local rate limiters whose buckets are full at `now`, and returns how
+// many entries were removed.
+func sweepLocalRateLimits(now time.Time) (count int) {
+	localRateLimits.Lock()
+	defer localRateLimits.Unlock()
+
+	for stateName, state := range localRateLimits.states {
+		if time.Unix(0, int64(binary.BigEndian.Uint64(state))).Before(now) {
+			delete(localRateLimits.states, stateName)
+			count += 1
+		}
+	}
+	return
+}
+
+// Periodically removes the entries of local rate limiters whose buckets are full. Runs until
+// `ctx` is cancelled.
+func SweepLocalRateLimitsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(localRateLimitSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sweepLocalRateLimits(now)
+		}
+	}
+}
+
+// Takes a token from the bucket of rate limit `limitName` for `key`, and returns an error
+// if there are none left. If the shared state cannot be updated, the limit is checked locally
+// instead, since an unavailable backend should not also cause requests to be rejected.
+func checkRateLimit(
+	ctx context.Context, limitName string, limit RateLimit, key string, shared bool,
+) error {
+	if limit.Count == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var retryAfter time.Duration
+	update := func(state []byte) (newState []byte) {
+		newState, retryAfter = admitRateLimit(state, limit, now)
+		return
+	}
+	// Keys may contain characters that are not allowed in object names.
+	stateName := fmt.Sprintf("%s/%x", limitName, sha256.Sum256([]byte(key)))
+	if shared {
+		if err := backend.UpdateRateLimit(ctx, stateName, update); err != nil {
+			logc.Printf(ctx, "rate limit err: %s: %s\n", limitName, err)
+			rateLimitErrorCount.WithLabelValues(limitName).Inc()
+			shared = false
+		}
+	}
+	if !shared {
+		updateLocalRateLimit(stateName, update)
+	}
+
+	if retryAfter > 0 {
+		logc.Printf(ctx, "rate limit %s: %s exceeded\n", limitName, key)
+		rateLimitedCount.WithLabelValues(limitName).Inc()
+		return &RateLimitError{limitName, retryAfter}
+	}
+	return nil
+}
+
+func rateLimitPrincipalKey(r *http.Request) string {
+	if forgeUser := GetPrincipal(r.Context()).GetForgeUser(); forgeUser != nil {
+		return fmt.Sprintf("forge:%s:%d", forgeUser.GetOrigin(), forgeUser.GetId())
+	}
+	return fmt.Sprintf("ip:%s", r.RemoteAddr)
+}
+
+// Checks the update rate limits for an authorized update request affecting `webRoots`.
+func CheckUpdateRateLimits(r *http.Request, webRoots ...string) error {
+	config := currentConfig(r.Context())
+	limits := &config.RateLimits
+	err := checkRateLimit(r.Context(), "updates-per-principal", limits.UpdatesPerPrincipal,
+		rateLimitPrincipalKey(r), limits.Shared)
+	if err != nil {
+		return err
+	}
+	for _, webRoot := range webRoots {
+		err := checkRateLimit(r.Context(), "updates-per-site", limits.UpdatesPerSite,
+			webRoot, limits.Shared)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the fetch rate limit for a forge host (e.g. `codeberg.org`).
+func CheckFetchRateLimit(ctx context.Context, host string) error {
+	config := currentConfig(ctx)
+	limits := &config.RateLimits
+	return checkRateLimit(ctx, "fetches-per-forge-host", limits.FetchesPerForgeHost,
+		host, limits.Shared)
+}
+
+// Checks the read rate limit for a site.
+func CheckReadRateLimit(ctx context.Context, webRoot string) error {
+	config := currentConfig(ctx)
+	limits := &config.RateLimits
+	return checkRateLimit(ctx, "reads-per-site", limits.ReadsPerSite, webRoot, false)
+}
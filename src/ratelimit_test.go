@@ -0,0 +1,87 @@
+package git_pages
+
+import (
+	"testing"
+	"time"
+)
+
+// A request made at the offset `at` from the start of the test, and the delay after which it
+// may be retried (zero if it is admitted).
+type rateLimitStep struct {
+	at, retryAfter time.Duration
+}
+
+func TestAdmitRateLimit(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	for _, test := range []struct {
+		name  string
+		limit RateLimit
+		steps []rateLimitStep
+	}{
+		{"burst", RateLimit{3, 3 * time.Second}, []rateLimitStep{
+			{0, 0}, {0, 0}, {0, 0},
+			{0, time.Second},
+			{500 * time.Millisecond, 500 * time.Millisecond},
+		}},
+		{"replenish", RateLimit{3, 3 * time.Second}, []rateLimitStep{
+			{0, 0}, {0, 0}, {0, 0},
+			{time.Second, 0},
+			{time.Second, time.Second},
+			{3 * time.Second, 0},
+			{3 * time.Second, 0},
+			{3 * time.Second, time.Second},
+		}},
+		{"full after idle", RateLimit{2, time.Minute}, []rateLimitStep{
+			{0, 0}, {0, 0},
+			{0, 30 * time.Second},
+			{time.Hour, 0}, {time.Hour, 0},
+			{time.Hour, 30 * time.Second},
+		}},
+		{"steady", RateLimit{1, time.Second}, []rateLimitStep{
+			{0, 0},
+			{999 * time.Millisecond, time.Millisecond},
+			{time.Second, 0},
+			{2 * time.Second, 0},
+		}},
+	} {
+		var state []byte
+		for index, step := range test.steps {
+			newState, retryAfter := admitRateLimit(state, test.limit, start.Add(step.at))
+			if retryAfter != step.retryAfter {
+				t.Errorf("%s: step %d: expect retry after %s, got %s",
+					test.name, index, step.retryAfter, retryAfter)
+			}
+			if (newState == nil) != (retryAfter > 0) {
+				t.Errorf("%s: step %d: expect state only if admitted", test.name, index)
+			}
+			if newState != nil {
+				state = newState
+			}
+		}
+	}
+}
+
+func TestSweepLocalRateLimits(t *testing.T) {
+	now := time.Now()
+	limit := RateLimit{2, time.Minute}
+	admit := func(state []byte) []byte {
+		newState, _ := admitRateLimit(state, limit, now)
+		return newState
+	}
+	updateLocalRateLimit("test/full-soon", admit)
+	updateLocalRateLimit("test/full-later", admit)
+	updateLocalRateLimit("test/full-later", admit)
+
+	sweepLocalRateLimits(now.Add(45 * time.Second))
+	localRateLimits.Lock()
+	_, foundSoon := localRateLimits.states["test/full-soon"]
+	_, foundLater := localRateLimits.states["test/full-later"]
+	localRateLimits.Unlock()
+	if foundSoon {
+		t.Errorf("expect full bucket to be removed")
+	}
+	if !foundLater {
+		t.Errorf("expect bucket that is not full to be kept")
+	}
+	sweepLocalRateLimits(now.Add(2 * time.Minute)) // leave no state behind
+}
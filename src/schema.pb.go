@@ -35,6 +35,9 @@ const (
 	Type_ExternalFile Type = 3
 	// Symlink. `Blob.Data` contains relative path.
 	Type_Symlink Type = 4
+	// Chunked file. `Entry.chunks` contains references to blobs that, concatenated, form
+	// the file contents.
+	Type_ChunkedFile Type = 5
 )
 
 // Enum value maps for Type.
@@ -45,6 +48,7 @@ var (
 		2: "InlineFile",
 		3: "ExternalFile",
 		4: "Symlink",
+		5: "ChunkedFile",
 	}
 	Type_value = map[string]int32{
 		"InvalidEntry": 0,
@@ -52,6 +56,7 @@ var (
 		"InlineFile":   2,
 		"ExternalFile": 3,
 		"Symlink":      4,
+		"ChunkedFile":  5,
 	}
 )
 
@@ -146,6 +151,12 @@ const (
 	AuditEvent_FreezeDomain AuditEvent = 3
 	// A domain was thawed.
 	AuditEvent_UnfreezeDomain AuditEvent = 4
+	// Several manifests on one domain were committed as a single transaction.
+	AuditEvent_CommitManifests AuditEvent = 6
+	// An operator action that does not otherwise modify the store was performed.
+	AuditEvent_AdminAction AuditEvent = 7
+	// A signed checkpoint of the audit record chain was made.
+	AuditEvent_ChainCheckpoint AuditEvent = 8
 )
 
 // Enum value maps for AuditEvent.
@@ -157,14 +168,20 @@ var (
 		5: "ExpireManifest",
 		3: "FreezeDomain",
 		4: "UnfreezeDomain",
+		6: "CommitManifests",
+		7: "AdminAction",
+		8: "ChainCheckpoint",
 	}
 	AuditEvent_value = map[string]int32{
-		"InvalidEvent":   0,
-		"CommitManifest": 1,
-		"DeleteManifest": 2,
-		"ExpireManifest": 5,
-		"FreezeDomain":   3,
-		"UnfreezeDomain": 4,
+		"InvalidEvent":    0,
+		"CommitManifest":  1,
+		"DeleteManifest":  2,
+		"ExpireManifest":  5,
+		"FreezeDomain":    3,
+		"UnfreezeDomain":  4,
+		"CommitManifests": 6,
+		"AdminAction":     7,
+		"ChainCheckpoint": 8,
 	}
 )
 
@@ -198,33 +215,35 @@ func (AuditEvent) EnumDescriptor() ([]byte, []int) {
 type Entry struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	Type  *Type                  `protobuf:"varint,1,opt,name=type,enum=Type" json:"type,omitempty"`
-	// Only present for `type == InlineFile` and `type == ExternalFile`.
+	// Only present for `type == InlineFile`, `type == ExternalFile`, and `type == ChunkedFile`.
 	// For transformed entries, refers to the pre-transformation (decompressed) size; otherwise
 	// equal to `compressed_size`.
 	OriginalSize *int64 `protobuf:"varint,7,opt,name=original_size,json=originalSize" json:"original_size,omitempty"`
-	// Only present for `type == InlineFile` and `type == ExternalFile`.
+	// Only present for `type == InlineFile`, `type == ExternalFile`, and `type == ChunkedFile`.
 	// For transformed entries, refers to the post-transformation (compressed) size; otherwise
 	// equal to `original_size`.
 	CompressedSize *int64 `protobuf:"varint,2,opt,name=compressed_size,json=compressedSize" json:"compressed_size,omitempty"`
 	// Meaning depends on `type`:
-	//   - If `type == InlineFile`, contains file data.
-	//   - If `type == ExternalFile`, contains blob name (an otherwise unspecified
-	//     cryptographically secure content hash).
-	//   - If `type == Symlink`, contains link target.
-	//   - Otherwise not present.
+	//  * If `type == InlineFile`, contains file data.
+	//  * If `type == ExternalFile`, contains blob name (an otherwise unspecified
+	//    cryptographically secure content hash).
+	//  * If `type == Symlink`, contains link target.
+	//  * Otherwise not present.
 	Data []byte `protobuf:"bytes,3,opt,name=data" json:"data,omitempty"`
 	// Only present for `type == InlineFile` and `type == ExternalFile` that
 	// have been transformed.
 	Transform *Transform `protobuf:"varint,4,opt,name=transform,enum=Transform" json:"transform,omitempty"`
-	// Only present for `type == InlineFile` and `type == ExternalFile`.
+	// Only present for `type == InlineFile`, `type == ExternalFile`, and `type == ChunkedFile`.
 	// Currently, optional (not present on certain legacy manifests).
 	ContentType *string `protobuf:"bytes,5,opt,name=content_type,json=contentType" json:"content_type,omitempty"`
-	// May be present for `type == InlineFile` and `type == ExternalFile`.
+	// May be present for `type == InlineFile`, `type == ExternalFile`, and `type == ChunkedFile`.
 	// Used to reduce the amount of work being done during git checkouts.
 	// The type of hash used is determined by the length:
-	//   - 40 bytes: SHA1DC (as hex)
-	//   - 64 bytes: SHA256 (as hex)
-	GitHash       *string `protobuf:"bytes,6,opt,name=git_hash,json=gitHash" json:"git_hash,omitempty"`
+	//   * 40 bytes: SHA1DC (as hex)
+	//   * 64 bytes: SHA256 (as hex)
+	GitHash *string `protobuf:"bytes,6,opt,name=git_hash,json=gitHash" json:"git_hash,omitempty"`
+	// Only present for `type == ChunkedFile`. Chunked files are never transformed.
+	Chunks        []*Chunk `protobuf:"bytes,8,rep,name=chunks" json:"chunks,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -308,6 +327,68 @@ func (x *Entry) GetGitHash() string {
 	return ""
 }
 
+func (x *Entry) GetChunks() []*Chunk {
+	if x != nil {
+		return x.Chunks
+	}
+	return nil
+}
+
+// A part of a chunked file. Chunk boundaries are determined by file contents, so that changing
+// a part of a file only changes the chunks around the change.
+type Chunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Blob name (see `Entry.data`).
+	Blob          []byte `protobuf:"bytes,1,opt,name=blob" json:"blob,omitempty"`
+	Size          *int64 `protobuf:"varint,2,opt,name=size" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Chunk) Reset() {
+	*x = Chunk{}
+	mi := &file_schema_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Chunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Chunk) ProtoMessage() {}
+
+func (x *Chunk) ProtoReflect() protoreflect.Message {
+	mi := &file_schema_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Chunk.ProtoReflect.Descriptor instead.
+func (*Chunk) Descriptor() ([]byte, []int) {
+	return file_schema_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Chunk) GetBlob() []byte {
+	if x != nil {
+		return x.Blob
+	}
+	return nil
+}
+
+func (x *Chunk) GetSize() int64 {
+	if x != nil && x.Size != nil {
+		return *x.Size
+	}
+	return 0
+}
+
 // See https://docs.netlify.com/manage/routing/redirects/overview/ for details.
 // Only a subset of the Netlify specification is representable here.
 type RedirectRule struct {
@@ -322,7 +403,7 @@ type RedirectRule struct {
 
 func (x *RedirectRule) Reset() {
 	*x = RedirectRule{}
-	mi := &file_schema_proto_msgTypes[1]
+	mi := &file_schema_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -334,7 +415,7 @@ func (x *RedirectRule) String() string {
 func (*RedirectRule) ProtoMessage() {}
 
 func (x *RedirectRule) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[1]
+	mi := &file_schema_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -347,7 +428,7 @@ func (x *RedirectRule) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RedirectRule.ProtoReflect.Descriptor instead.
 func (*RedirectRule) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{1}
+	return file_schema_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *RedirectRule) GetFrom() string {
@@ -389,7 +470,7 @@ type Header struct {
 
 func (x *Header) Reset() {
 	*x = Header{}
-	mi := &file_schema_proto_msgTypes[2]
+	mi := &file_schema_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -401,7 +482,7 @@ func (x *Header) String() string {
 func (*Header) ProtoMessage() {}
 
 func (x *Header) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[2]
+	mi := &file_schema_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -414,7 +495,7 @@ func (x *Header) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Header.ProtoReflect.Descriptor instead.
 func (*Header) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{2}
+	return file_schema_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *Header) GetName() string {
@@ -441,7 +522,7 @@ type HeaderRule struct {
 
 func (x *HeaderRule) Reset() {
 	*x = HeaderRule{}
-	mi := &file_schema_proto_msgTypes[3]
+	mi := &file_schema_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -453,7 +534,7 @@ func (x *HeaderRule) String() string {
 func (*HeaderRule) ProtoMessage() {}
 
 func (x *HeaderRule) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[3]
+	mi := &file_schema_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -466,7 +547,7 @@ func (x *HeaderRule) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use HeaderRule.ProtoReflect.Descriptor instead.
 func (*HeaderRule) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{3}
+	return file_schema_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *HeaderRule) GetPath() string {
@@ -493,7 +574,7 @@ type BasicCredential struct {
 
 func (x *BasicCredential) Reset() {
 	*x = BasicCredential{}
-	mi := &file_schema_proto_msgTypes[4]
+	mi := &file_schema_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -505,7 +586,7 @@ func (x *BasicCredential) String() string {
 func (*BasicCredential) ProtoMessage() {}
 
 func (x *BasicCredential) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[4]
+	mi := &file_schema_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -518,7 +599,7 @@ func (x *BasicCredential) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BasicCredential.ProtoReflect.Descriptor instead.
 func (*BasicCredential) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{4}
+	return file_schema_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *BasicCredential) GetUsername() string {
@@ -545,7 +626,7 @@ type BasicAuthRule struct {
 
 func (x *BasicAuthRule) Reset() {
 	*x = BasicAuthRule{}
-	mi := &file_schema_proto_msgTypes[5]
+	mi := &file_schema_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -557,7 +638,7 @@ func (x *BasicAuthRule) String() string {
 func (*BasicAuthRule) ProtoMessage() {}
 
 func (x *BasicAuthRule) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[5]
+	mi := &file_schema_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -570,7 +651,7 @@ func (x *BasicAuthRule) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BasicAuthRule.ProtoReflect.Descriptor instead.
 func (*BasicAuthRule) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{5}
+	return file_schema_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *BasicAuthRule) GetPath() string {
@@ -597,7 +678,7 @@ type Problem struct {
 
 func (x *Problem) Reset() {
 	*x = Problem{}
-	mi := &file_schema_proto_msgTypes[6]
+	mi := &file_schema_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -609,7 +690,7 @@ func (x *Problem) String() string {
 func (*Problem) ProtoMessage() {}
 
 func (x *Problem) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[6]
+	mi := &file_schema_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -622,7 +703,7 @@ func (x *Problem) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Problem.ProtoReflect.Descriptor instead.
 func (*Problem) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{6}
+	return file_schema_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Problem) GetPath() string {
@@ -639,6 +720,72 @@ func (x *Problem) GetCause() string {
 	return ""
 }
 
+// A part of a manifest containing every entry under a directory (but not the entry for
+// the directory itself). Shards allow the number of files in a site to exceed what fits into
+// a single manifest.
+type ManifestShard struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Directory path.
+	Path *string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	// Blob name (see `Entry.data`). The blob contains a `Manifest` with only `contents`.
+	Blob []byte `protobuf:"bytes,2,opt,name=blob" json:"blob,omitempty"`
+	// Size of the blob.
+	Size          *int64 `protobuf:"varint,3,opt,name=size" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ManifestShard) Reset() {
+	*x = ManifestShard{}
+	mi := &file_schema_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ManifestShard) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ManifestShard) ProtoMessage() {}
+
+func (x *ManifestShard) ProtoReflect() protoreflect.Message {
+	mi := &file_schema_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ManifestShard.ProtoReflect.Descriptor instead.
+func (*ManifestShard) Descriptor() ([]byte, []int) {
+	return file_schema_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ManifestShard) GetPath() string {
+	if x != nil && x.Path != nil {
+		return *x.Path
+	}
+	return ""
+}
+
+func (x *ManifestShard) GetBlob() []byte {
+	if x != nil {
+		return x.Blob
+	}
+	return nil
+}
+
+func (x *ManifestShard) GetSize() int64 {
+	if x != nil && x.Size != nil {
+		return *x.Size
+	}
+	return 0
+}
+
 type Manifest struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Source metadata.
@@ -646,25 +793,33 @@ type Manifest struct {
 	Branch  *string `protobuf:"bytes,2,opt,name=branch" json:"branch,omitempty"`
 	Commit  *string `protobuf:"bytes,3,opt,name=commit" json:"commit,omitempty"`
 	// Site contents.
-	Contents       map[string]*Entry `protobuf:"bytes,4,rep,name=contents" json:"contents,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	OriginalSize   *int64            `protobuf:"varint,10,opt,name=original_size,json=originalSize" json:"original_size,omitempty"`      // sum of each `entry.original_size`
-	CompressedSize *int64            `protobuf:"varint,5,opt,name=compressed_size,json=compressedSize" json:"compressed_size,omitempty"` // sum of each `entry.compressed_size`
-	StoredSize     *int64            `protobuf:"varint,8,opt,name=stored_size,json=storedSize" json:"stored_size,omitempty"`             // sum of deduplicated `entry.compressed_size` for external files only
+	Contents map[string]*Entry `protobuf:"bytes,4,rep,name=contents" json:"contents,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Directory shards, sorted by path. Entries under the directory of a shard are stored in
+	// the shard and not in `contents`.
+	Shards         []*ManifestShard `protobuf:"bytes,14,rep,name=shards" json:"shards,omitempty"`
+	OriginalSize   *int64           `protobuf:"varint,10,opt,name=original_size,json=originalSize" json:"original_size,omitempty"`      // sum of each `entry.original_size`
+	CompressedSize *int64           `protobuf:"varint,5,opt,name=compressed_size,json=compressedSize" json:"compressed_size,omitempty"` // sum of each `entry.compressed_size`
+	StoredSize     *int64           `protobuf:"varint,8,opt,name=stored_size,json=storedSize" json:"stored_size,omitempty"`             // sum of deduplicated `entry.compressed_size` for external files only
 	// Netlify-style `_redirects` and `_headers` rules.
 	Redirects []*RedirectRule  `protobuf:"bytes,6,rep,name=redirects" json:"redirects,omitempty"`
 	Headers   []*HeaderRule    `protobuf:"bytes,9,rep,name=headers" json:"headers,omitempty"`
 	BasicAuth []*BasicAuthRule `protobuf:"bytes,11,rep,name=basic_auth,json=basicAuth" json:"basic_auth,omitempty"`
 	// Site expiration.
 	ExpiresAt *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=expires_at,json=expiresAt" json:"expires_at,omitempty"`
+	// Secret token for previewing a staged version (see `Stage`). Only present in staged
+	// manifests; removed when the staged version is promoted.
+	StagedToken *string `protobuf:"bytes,15,opt,name=staged_token,json=stagedToken" json:"staged_token,omitempty"`
 	// Diagnostics for non-fatal errors.
-	Problems      []*Problem `protobuf:"bytes,7,rep,name=problems" json:"problems,omitempty"`
+	Problems []*Problem `protobuf:"bytes,7,rep,name=problems" json:"problems,omitempty"`
+	// Encrypted manifest. If present, no other fields are present.
+	Encrypted     *EncryptedPayload `protobuf:"bytes,13,opt,name=encrypted" json:"encrypted,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Manifest) Reset() {
 	*x = Manifest{}
-	mi := &file_schema_proto_msgTypes[7]
+	mi := &file_schema_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -676,7 +831,7 @@ func (x *Manifest) String() string {
 func (*Manifest) ProtoMessage() {}
 
 func (x *Manifest) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[7]
+	mi := &file_schema_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -689,7 +844,7 @@ func (x *Manifest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Manifest.ProtoReflect.Descriptor instead.
 func (*Manifest) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{7}
+	return file_schema_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *Manifest) GetRepoUrl() string {
@@ -720,6 +875,13 @@ func (x *Manifest) GetContents() map[string]*Entry {
 	return nil
 }
 
+func (x *Manifest) GetShards() []*ManifestShard {
+	if x != nil {
+		return x.Shards
+	}
+	return nil
+}
+
 func (x *Manifest) GetOriginalSize() int64 {
 	if x != nil && x.OriginalSize != nil {
 		return *x.OriginalSize
@@ -769,6 +931,13 @@ func (x *Manifest) GetExpiresAt() *timestamppb.Timestamp {
 	return nil
 }
 
+func (x *Manifest) GetStagedToken() string {
+	if x != nil && x.StagedToken != nil {
+		return *x.StagedToken
+	}
+	return ""
+}
+
 func (x *Manifest) GetProblems() []*Problem {
 	if x != nil {
 		return x.Problems
@@ -776,6 +945,190 @@ func (x *Manifest) GetProblems() []*Problem {
 	return nil
 }
 
+func (x *Manifest) GetEncrypted() *EncryptedPayload {
+	if x != nil {
+		return x.Encrypted
+	}
+	return nil
+}
+
+// Changes made to a site by replacing one manifest with another (see `DiffManifests`).
+type ManifestDiff struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Paths of files and symlinks that were added, removed, or modified, in sorted order.
+	// Directories are not included.
+	Added    []string `protobuf:"bytes,1,rep,name=added" json:"added,omitempty"`
+	Removed  []string `protobuf:"bytes,2,rep,name=removed" json:"removed,omitempty"`
+	Modified []string `protobuf:"bytes,3,rep,name=modified" json:"modified,omitempty"`
+	// Redirect rules (in `_redirects` syntax) that were added or removed.
+	AddedRedirects   []string `protobuf:"bytes,4,rep,name=added_redirects,json=addedRedirects" json:"added_redirects,omitempty"`
+	RemovedRedirects []string `protobuf:"bytes,5,rep,name=removed_redirects,json=removedRedirects" json:"removed_redirects,omitempty"`
+	// Header rules (as `<path> <name>: <values>`) that were added or removed.
+	AddedHeaders   []string `protobuf:"bytes,6,rep,name=added_headers,json=addedHeaders" json:"added_headers,omitempty"`
+	RemovedHeaders []string `protobuf:"bytes,7,rep,name=removed_headers,json=removedHeaders" json:"removed_headers,omitempty"`
+	// Paths of basic authorization rules that were added, removed, or whose credentials were
+	// changed. The credentials themselves are not included.
+	ChangedBasicAuth []string `protobuf:"bytes,8,rep,name=changed_basic_auth,json=changedBasicAuth" json:"changed_basic_auth,omitempty"`
+	// Difference between the `original_size` of the manifests.
+	SizeDelta     *int64 `protobuf:"varint,9,opt,name=size_delta,json=sizeDelta" json:"size_delta,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ManifestDiff) Reset() {
+	*x = ManifestDiff{}
+	mi := &file_schema_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ManifestDiff) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ManifestDiff) ProtoMessage() {}
+
+func (x *ManifestDiff) ProtoReflect() protoreflect.Message {
+	mi := &file_schema_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ManifestDiff.ProtoReflect.Descriptor instead.
+func (*ManifestDiff) Descriptor() ([]byte, []int) {
+	return file_schema_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ManifestDiff) GetAdded() []string {
+	if x != nil {
+		return x.Added
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetRemoved() []string {
+	if x != nil {
+		return x.Removed
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetModified() []string {
+	if x != nil {
+		return x.Modified
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetAddedRedirects() []string {
+	if x != nil {
+		return x.AddedRedirects
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetRemovedRedirects() []string {
+	if x != nil {
+		return x.RemovedRedirects
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetAddedHeaders() []string {
+	if x != nil {
+		return x.AddedHeaders
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetRemovedHeaders() []string {
+	if x != nil {
+		return x.RemovedHeaders
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetChangedBasicAuth() []string {
+	if x != nil {
+		return x.ChangedBasicAuth
+	}
+	return nil
+}
+
+func (x *ManifestDiff) GetSizeDelta() int64 {
+	if x != nil && x.SizeDelta != nil {
+		return *x.SizeDelta
+	}
+	return 0
+}
+
+// A payload encrypted with AES-256-GCM using one of the configured storage encryption keys.
+type EncryptedPayload struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         *string                `protobuf:"bytes,1,opt,name=key_id,json=keyId" json:"key_id,omitempty"`
+	Nonce         []byte                 `protobuf:"bytes,2,opt,name=nonce" json:"nonce,omitempty"`
+	Ciphertext    []byte                 `protobuf:"bytes,3,opt,name=ciphertext" json:"ciphertext,omitempty"` // includes authentication tag
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EncryptedPayload) Reset() {
+	*x = EncryptedPayload{}
+	mi := &file_schema_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EncryptedPayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncryptedPayload) ProtoMessage() {}
+
+func (x *EncryptedPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_schema_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncryptedPayload.ProtoReflect.Descriptor instead.
+func (*EncryptedPayload) Descriptor() ([]byte, []int) {
+	return file_schema_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *EncryptedPayload) GetKeyId() string {
+	if x != nil && x.KeyId != nil {
+		return *x.KeyId
+	}
+	return ""
+}
+
+func (x *EncryptedPayload) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *EncryptedPayload) GetCiphertext() []byte {
+	if x != nil {
+		return x.Ciphertext
+	}
+	return nil
+}
+
 type AuditRecord struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Audit event metadata.
@@ -787,14 +1140,33 @@ type AuditRecord struct {
 	Domain  *string `protobuf:"bytes,10,opt,name=domain" json:"domain,omitempty"`
 	Project *string `protobuf:"bytes,11,opt,name=project" json:"project,omitempty"` // only for `*Manifest` events
 	// Snapshot of site manifest.
-	Manifest      *Manifest `protobuf:"bytes,12,opt,name=manifest" json:"manifest,omitempty"` // only for `*Manifest` events
+	Manifest *Manifest `protobuf:"bytes,12,opt,name=manifest" json:"manifest,omitempty"` // only for `*Manifest` events
+	// Snapshots of site manifests, by project.
+	Manifests map[string]*Manifest `protobuf:"bytes,13,rep,name=manifests" json:"manifests,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // only for `*Manifests` events
+	// Encrypted audit record, without `id`, `timestamp`, `event`, and the manifest snapshots
+	// (which are encrypted individually). If present, `principal`, `domain`, `project`, and
+	// `action` are not present.
+	Encrypted *EncryptedPayload `protobuf:"bytes,14,opt,name=encrypted" json:"encrypted,omitempty"`
+	// Name of the operator action.
+	Action *string `protobuf:"bytes,15,opt,name=action" json:"action,omitempty"` // only for `AdminAction` events
+	// Previous audit record made by the same node, and the hash of its metadata (see
+	// `AuditChainHash`). Absent for the first audit record in the chain.
+	PreviousId   *int64 `protobuf:"varint,16,opt,name=previous_id,json=previousId" json:"previous_id,omitempty"`
+	PreviousHash []byte `protobuf:"bytes,17,opt,name=previous_hash,json=previousHash" json:"previous_hash,omitempty"`
+	// Ed25519 signature of the hash of this audit record without the signature.
+	Signature []byte `protobuf:"bytes,18,opt,name=signature" json:"signature,omitempty"` // only for `ChainCheckpoint` events
+	// Changes made to the site by the manifest snapshot. Unlike the snapshots, these are kept
+	// when the audit record is detached.
+	Diff *ManifestDiff `protobuf:"bytes,19,opt,name=diff" json:"diff,omitempty"` // only for `CommitManifest` events
+	// Changes made to each site by the manifest snapshots, by project.
+	Diffs         map[string]*ManifestDiff `protobuf:"bytes,20,rep,name=diffs" json:"diffs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // only for `CommitManifests` events
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *AuditRecord) Reset() {
 	*x = AuditRecord{}
-	mi := &file_schema_proto_msgTypes[8]
+	mi := &file_schema_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -806,7 +1178,7 @@ func (x *AuditRecord) String() string {
 func (*AuditRecord) ProtoMessage() {}
 
 func (x *AuditRecord) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[8]
+	mi := &file_schema_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -819,7 +1191,7 @@ func (x *AuditRecord) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AuditRecord.ProtoReflect.Descriptor instead.
 func (*AuditRecord) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{8}
+	return file_schema_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *AuditRecord) GetId() int64 {
@@ -871,19 +1243,138 @@ func (x *AuditRecord) GetManifest() *Manifest {
 	return nil
 }
 
-type Principal struct {
+func (x *AuditRecord) GetManifests() map[string]*Manifest {
+	if x != nil {
+		return x.Manifests
+	}
+	return nil
+}
+
+func (x *AuditRecord) GetEncrypted() *EncryptedPayload {
+	if x != nil {
+		return x.Encrypted
+	}
+	return nil
+}
+
+func (x *AuditRecord) GetAction() string {
+	if x != nil && x.Action != nil {
+		return *x.Action
+	}
+	return ""
+}
+
+func (x *AuditRecord) GetPreviousId() int64 {
+	if x != nil && x.PreviousId != nil {
+		return *x.PreviousId
+	}
+	return 0
+}
+
+func (x *AuditRecord) GetPreviousHash() []byte {
+	if x != nil {
+		return x.PreviousHash
+	}
+	return nil
+}
+
+func (x *AuditRecord) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *AuditRecord) GetDiff() *ManifestDiff {
+	if x != nil {
+		return x.Diff
+	}
+	return nil
+}
+
+func (x *AuditRecord) GetDiffs() map[string]*ManifestDiff {
+	if x != nil {
+		return x.Diffs
+	}
+	return nil
+}
+
+// The last audit record in the chain of records made by a node.
+type AuditChainHead struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	IpAddress     *string                `protobuf:"bytes,1,opt,name=ip_address,json=ipAddress" json:"ip_address,omitempty"`
-	CliAdmin      *bool                  `protobuf:"varint,2,opt,name=cli_admin,json=cliAdmin" json:"cli_admin,omitempty"`
-	ForgeUser     *ForgeUser             `protobuf:"bytes,3,opt,name=forge_user,json=forgeUser" json:"forge_user,omitempty"`
-	RepoUrl       *string                `protobuf:"bytes,4,opt,name=repo_url,json=repoUrl" json:"repo_url,omitempty"`
+	Id            *int64                 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Hash          []byte                 `protobuf:"bytes,2,opt,name=hash" json:"hash,omitempty"`
+	Event         *AuditEvent            `protobuf:"varint,3,opt,name=event,enum=AuditEvent" json:"event,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
+func (x *AuditChainHead) Reset() {
+	*x = AuditChainHead{}
+	mi := &file_schema_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditChainHead) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditChainHead) ProtoMessage() {}
+
+func (x *AuditChainHead) ProtoReflect() protoreflect.Message {
+	mi := &file_schema_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditChainHead.ProtoReflect.Descriptor instead.
+func (*AuditChainHead) Descriptor() ([]byte, []int) {
+	return file_schema_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *AuditChainHead) GetId() int64 {
+	if x != nil && x.Id != nil {
+		return *x.Id
+	}
+	return 0
+}
+
+func (x *AuditChainHead) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+func (x *AuditChainHead) GetEvent() AuditEvent {
+	if x != nil && x.Event != nil {
+		return *x.Event
+	}
+	return AuditEvent_InvalidEvent
+}
+
+type Principal struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	IpAddress *string                `protobuf:"bytes,1,opt,name=ip_address,json=ipAddress" json:"ip_address,omitempty"`
+	CliAdmin  *bool                  `protobuf:"varint,2,opt,name=cli_admin,json=cliAdmin" json:"cli_admin,omitempty"`
+	ForgeUser *ForgeUser             `protobuf:"bytes,3,opt,name=forge_user,json=forgeUser" json:"forge_user,omitempty"`
+	RepoUrl   *string                `protobuf:"bytes,4,opt,name=repo_url,json=repoUrl" json:"repo_url,omitempty"`
+	// Name of the admin API credential (token name or client certificate subject).
+	AdminCredential *string `protobuf:"bytes,5,opt,name=admin_credential,json=adminCredential" json:"admin_credential,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
 func (x *Principal) Reset() {
 	*x = Principal{}
-	mi := &file_schema_proto_msgTypes[9]
+	mi := &file_schema_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -895,7 +1386,7 @@ func (x *Principal) String() string {
 func (*Principal) ProtoMessage() {}
 
 func (x *Principal) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[9]
+	mi := &file_schema_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -908,7 +1399,7 @@ func (x *Principal) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Principal.ProtoReflect.Descriptor instead.
 func (*Principal) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{9}
+	return file_schema_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *Principal) GetIpAddress() string {
@@ -939,6 +1430,13 @@ func (x *Principal) GetRepoUrl() string {
 	return ""
 }
 
+func (x *Principal) GetAdminCredential() string {
+	if x != nil && x.AdminCredential != nil {
+		return *x.AdminCredential
+	}
+	return ""
+}
+
 type ForgeUser struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Origin        *string                `protobuf:"bytes,1,opt,name=origin" json:"origin,omitempty"`
@@ -950,7 +1448,7 @@ type ForgeUser struct {
 
 func (x *ForgeUser) Reset() {
 	*x = ForgeUser{}
-	mi := &file_schema_proto_msgTypes[10]
+	mi := &file_schema_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -962,7 +1460,7 @@ func (x *ForgeUser) String() string {
 func (*ForgeUser) ProtoMessage() {}
 
 func (x *ForgeUser) ProtoReflect() protoreflect.Message {
-	mi := &file_schema_proto_msgTypes[10]
+	mi := &file_schema_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -975,7 +1473,7 @@ func (x *ForgeUser) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ForgeUser.ProtoReflect.Descriptor instead.
 func (*ForgeUser) Descriptor() ([]byte, []int) {
-	return file_schema_proto_rawDescGZIP(), []int{10}
+	return file_schema_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *ForgeUser) GetOrigin() string {
@@ -1003,7 +1501,7 @@ var File_schema_proto protoreflect.FileDescriptor
 
 const file_schema_proto_rawDesc = "" +
 	"\n" +
-	"\fschema.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xec\x01\n" +
+	"\fschema.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8c\x02\n" +
 	"\x05Entry\x12\x19\n" +
 	"\x04type\x18\x01 \x01(\x0e2\x05.TypeR\x04type\x12#\n" +
 	"\roriginal_size\x18\a \x01(\x03R\foriginalSize\x12'\n" +
@@ -1012,7 +1510,11 @@ const file_schema_proto_rawDesc = "" +
 	"\ttransform\x18\x04 \x01(\x0e2\n" +
 	".TransformR\ttransform\x12!\n" +
 	"\fcontent_type\x18\x05 \x01(\tR\vcontentType\x12\x19\n" +
-	"\bgit_hash\x18\x06 \x01(\tR\agitHash\"`\n" +
+	"\bgit_hash\x18\x06 \x01(\tR\agitHash\x12\x1e\n" +
+	"\x06chunks\x18\b \x03(\v2\x06.ChunkR\x06chunks\"/\n" +
+	"\x05Chunk\x12\x12\n" +
+	"\x04blob\x18\x01 \x01(\fR\x04blob\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\"`\n" +
 	"\fRedirectRule\x12\x12\n" +
 	"\x04from\x18\x01 \x01(\tR\x04from\x12\x0e\n" +
 	"\x02to\x18\x02 \x01(\tR\x02to\x12\x16\n" +
@@ -1034,12 +1536,17 @@ const file_schema_proto_rawDesc = "" +
 	"\vcredentials\x18\x02 \x03(\v2\x10.BasicCredentialR\vcredentials\"3\n" +
 	"\aProblem\x12\x12\n" +
 	"\x04path\x18\x01 \x01(\tR\x04path\x12\x14\n" +
-	"\x05cause\x18\x02 \x01(\tR\x05cause\"\xa2\x04\n" +
+	"\x05cause\x18\x02 \x01(\tR\x05cause\"K\n" +
+	"\rManifestShard\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04blob\x18\x02 \x01(\fR\x04blob\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\"\x9e\x05\n" +
 	"\bManifest\x12\x19\n" +
 	"\brepo_url\x18\x01 \x01(\tR\arepoUrl\x12\x16\n" +
 	"\x06branch\x18\x02 \x01(\tR\x06branch\x12\x16\n" +
 	"\x06commit\x18\x03 \x01(\tR\x06commit\x123\n" +
-	"\bcontents\x18\x04 \x03(\v2\x17.Manifest.ContentsEntryR\bcontents\x12#\n" +
+	"\bcontents\x18\x04 \x03(\v2\x17.Manifest.ContentsEntryR\bcontents\x12&\n" +
+	"\x06shards\x18\x0e \x03(\v2\x0e.ManifestShardR\x06shards\x12#\n" +
 	"\roriginal_size\x18\n" +
 	" \x01(\x03R\foriginalSize\x12'\n" +
 	"\x0fcompressed_size\x18\x05 \x01(\x03R\x0ecompressedSize\x12\x1f\n" +
@@ -1050,11 +1557,30 @@ const file_schema_proto_rawDesc = "" +
 	"\n" +
 	"basic_auth\x18\v \x03(\v2\x0e.BasicAuthRuleR\tbasicAuth\x129\n" +
 	"\n" +
-	"expires_at\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12$\n" +
-	"\bproblems\x18\a \x03(\v2\b.ProblemR\bproblems\x1aC\n" +
+	"expires_at\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12!\n" +
+	"\fstaged_token\x18\x0f \x01(\tR\vstagedToken\x12$\n" +
+	"\bproblems\x18\a \x03(\v2\b.ProblemR\bproblems\x12/\n" +
+	"\tencrypted\x18\r \x01(\v2\x11.EncryptedPayloadR\tencrypted\x1aC\n" +
 	"\rContentsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x1c\n" +
-	"\x05value\x18\x02 \x01(\v2\x06.EntryR\x05value:\x028\x01\"\xfd\x01\n" +
+	"\x05value\x18\x02 \x01(\v2\x06.EntryR\x05value:\x028\x01\"\xcb\x02\n" +
+	"\fManifestDiff\x12\x14\n" +
+	"\x05added\x18\x01 \x03(\tR\x05added\x12\x18\n" +
+	"\aremoved\x18\x02 \x03(\tR\aremoved\x12\x1a\n" +
+	"\bmodified\x18\x03 \x03(\tR\bmodified\x12'\n" +
+	"\x0fadded_redirects\x18\x04 \x03(\tR\x0eaddedRedirects\x12+\n" +
+	"\x11removed_redirects\x18\x05 \x03(\tR\x10removedRedirects\x12#\n" +
+	"\radded_headers\x18\x06 \x03(\tR\faddedHeaders\x12'\n" +
+	"\x0fremoved_headers\x18\a \x03(\tR\x0eremovedHeaders\x12,\n" +
+	"\x12changed_basic_auth\x18\b \x03(\tR\x10changedBasicAuth\x12\x1d\n" +
+	"\n" +
+	"size_delta\x18\t \x01(\x03R\tsizeDelta\"_\n" +
+	"\x10EncryptedPayload\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x14\n" +
+	"\x05nonce\x18\x02 \x01(\fR\x05nonce\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x03 \x01(\fR\n" +
+	"ciphertext\"\xc9\x05\n" +
 	"\vAuditRecord\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x03R\x02id\x128\n" +
 	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12!\n" +
@@ -1064,7 +1590,27 @@ const file_schema_proto_rawDesc = "" +
 	"\x06domain\x18\n" +
 	" \x01(\tR\x06domain\x12\x18\n" +
 	"\aproject\x18\v \x01(\tR\aproject\x12%\n" +
-	"\bmanifest\x18\f \x01(\v2\t.ManifestR\bmanifest\"\x8d\x01\n" +
+	"\bmanifest\x18\f \x01(\v2\t.ManifestR\bmanifest\x129\n" +
+	"\tmanifests\x18\r \x03(\v2\x1b.AuditRecord.ManifestsEntryR\tmanifests\x12/\n" +
+	"\tencrypted\x18\x0e \x01(\v2\x11.EncryptedPayloadR\tencrypted\x12\x16\n" +
+	"\x06action\x18\x0f \x01(\tR\x06action\x12\x1f\n" +
+	"\vprevious_id\x18\x10 \x01(\x03R\n" +
+	"previousId\x12#\n" +
+	"\rprevious_hash\x18\x11 \x01(\fR\fpreviousHash\x12\x1c\n" +
+	"\tsignature\x18\x12 \x01(\fR\tsignature\x12!\n" +
+	"\x04diff\x18\x13 \x01(\v2\r.ManifestDiffR\x04diff\x12-\n" +
+	"\x05diffs\x18\x14 \x03(\v2\x17.AuditRecord.DiffsEntryR\x05diffs\x1aG\n" +
+	"\x0eManifestsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x1f\n" +
+	"\x05value\x18\x02 \x01(\v2\t.ManifestR\x05value:\x028\x01\x1aG\n" +
+	"\n" +
+	"DiffsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12#\n" +
+	"\x05value\x18\x02 \x01(\v2\r.ManifestDiffR\x05value:\x028\x01\"W\n" +
+	"\x0eAuditChainHead\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04hash\x18\x02 \x01(\fR\x04hash\x12!\n" +
+	"\x05event\x18\x03 \x01(\x0e2\v.AuditEventR\x05event\"\xb8\x01\n" +
 	"\tPrincipal\x12\x1d\n" +
 	"\n" +
 	"ip_address\x18\x01 \x01(\tR\tipAddress\x12\x1b\n" +
@@ -1072,21 +1618,23 @@ const file_schema_proto_rawDesc = "" +
 	"\n" +
 	"forge_user\x18\x03 \x01(\v2\n" +
 	".ForgeUserR\tforgeUser\x12\x19\n" +
-	"\brepo_url\x18\x04 \x01(\tR\arepoUrl\"G\n" +
+	"\brepo_url\x18\x04 \x01(\tR\arepoUrl\x12)\n" +
+	"\x10admin_credential\x18\x05 \x01(\tR\x0fadminCredential\"G\n" +
 	"\tForgeUser\x12\x16\n" +
 	"\x06origin\x18\x01 \x01(\tR\x06origin\x12\x0e\n" +
 	"\x02id\x18\x02 \x01(\x03R\x02id\x12\x12\n" +
-	"\x04name\x18\x03 \x01(\tR\x04name*V\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name*g\n" +
 	"\x04Type\x12\x10\n" +
 	"\fInvalidEntry\x10\x00\x12\r\n" +
 	"\tDirectory\x10\x01\x12\x0e\n" +
 	"\n" +
 	"InlineFile\x10\x02\x12\x10\n" +
 	"\fExternalFile\x10\x03\x12\v\n" +
-	"\aSymlink\x10\x04*#\n" +
+	"\aSymlink\x10\x04\x12\x0f\n" +
+	"\vChunkedFile\x10\x05*#\n" +
 	"\tTransform\x12\f\n" +
 	"\bIdentity\x10\x00\x12\b\n" +
-	"\x04Zstd\x10\x01*\x80\x01\n" +
+	"\x04Zstd\x10\x01*\xbb\x01\n" +
 	"\n" +
 	"AuditEvent\x12\x10\n" +
 	"\fInvalidEvent\x10\x00\x12\x12\n" +
@@ -1094,7 +1642,10 @@ const file_schema_proto_rawDesc = "" +
 	"\x0eDeleteManifest\x10\x02\x12\x12\n" +
 	"\x0eExpireManifest\x10\x05\x12\x10\n" +
 	"\fFreezeDomain\x10\x03\x12\x12\n" +
-	"\x0eUnfreezeDomain\x10\x04B,Z*codeberg.org/git-pages/git-pages/git_pagesb\beditionsp\xe8\a"
+	"\x0eUnfreezeDomain\x10\x04\x12\x13\n" +
+	"\x0fCommitManifests\x10\x06\x12\x0f\n" +
+	"\vAdminAction\x10\a\x12\x13\n" +
+	"\x0fChainCheckpoint\x10\bB,Z*codeberg.org/git-pages/git-pages/git_pagesb\beditionsp\xe8\a"
 
 var (
 	file_schema_proto_rawDescOnce sync.Once
@@ -1109,47 +1660,64 @@ func file_schema_proto_rawDescGZIP() []byte {
 }
 
 var file_schema_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
-var file_schema_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_schema_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
 var file_schema_proto_goTypes = []any{
 	(Type)(0),                     // 0: Type
 	(Transform)(0),                // 1: Transform
 	(AuditEvent)(0),               // 2: AuditEvent
 	(*Entry)(nil),                 // 3: Entry
-	(*RedirectRule)(nil),          // 4: RedirectRule
-	(*Header)(nil),                // 5: Header
-	(*HeaderRule)(nil),            // 6: HeaderRule
-	(*BasicCredential)(nil),       // 7: BasicCredential
-	(*BasicAuthRule)(nil),         // 8: BasicAuthRule
-	(*Problem)(nil),               // 9: Problem
-	(*Manifest)(nil),              // 10: Manifest
-	(*AuditRecord)(nil),           // 11: AuditRecord
-	(*Principal)(nil),             // 12: Principal
-	(*ForgeUser)(nil),             // 13: ForgeUser
-	nil,                           // 14: Manifest.ContentsEntry
-	(*timestamppb.Timestamp)(nil), // 15: google.protobuf.Timestamp
+	(*Chunk)(nil),                 // 4: Chunk
+	(*RedirectRule)(nil),          // 5: RedirectRule
+	(*Header)(nil),                // 6: Header
+	(*HeaderRule)(nil),            // 7: HeaderRule
+	(*BasicCredential)(nil),       // 8: BasicCredential
+	(*BasicAuthRule)(nil),         // 9: BasicAuthRule
+	(*Problem)(nil),               // 10: Problem
+	(*ManifestShard)(nil),         // 11: ManifestShard
+	(*Manifest)(nil),              // 12: Manifest
+	(*ManifestDiff)(nil),          // 13: ManifestDiff
+	(*EncryptedPayload)(nil),      // 14: EncryptedPayload
+	(*AuditRecord)(nil),           // 15: AuditRecord
+	(*AuditChainHead)(nil),        // 16: AuditChainHead
+	(*Principal)(nil),             // 17: Principal
+	(*ForgeUser)(nil),             // 18: ForgeUser
+	nil,                           // 19: Manifest.ContentsEntry
+	nil,                           // 20: AuditRecord.ManifestsEntry
+	nil,                           // 21: AuditRecord.DiffsEntry
+	(*timestamppb.Timestamp)(nil), // 22: google.protobuf.Timestamp
 }
 var file_schema_proto_depIdxs = []int32{
 	0,  // 0: Entry.type:type_name -> Type
 	1,  // 1: Entry.transform:type_name -> Transform
-	5,  // 2: HeaderRule.header_map:type_name -> Header
-	7,  // 3: BasicAuthRule.credentials:type_name -> BasicCredential
-	14, // 4: Manifest.contents:type_name -> Manifest.ContentsEntry
-	4,  // 5: Manifest.redirects:type_name -> RedirectRule
-	6,  // 6: Manifest.headers:type_name -> HeaderRule
-	8,  // 7: Manifest.basic_auth:type_name -> BasicAuthRule
-	15, // 8: Manifest.expires_at:type_name -> google.protobuf.Timestamp
-	9,  // 9: Manifest.problems:type_name -> Problem
-	15, // 10: AuditRecord.timestamp:type_name -> google.protobuf.Timestamp
-	2,  // 11: AuditRecord.event:type_name -> AuditEvent
-	12, // 12: AuditRecord.principal:type_name -> Principal
-	10, // 13: AuditRecord.manifest:type_name -> Manifest
-	13, // 14: Principal.forge_user:type_name -> ForgeUser
-	3,  // 15: Manifest.ContentsEntry.value:type_name -> Entry
-	16, // [16:16] is the sub-list for method output_type
-	16, // [16:16] is the sub-list for method input_type
-	16, // [16:16] is the sub-list for extension type_name
-	16, // [16:16] is the sub-list for extension extendee
-	0,  // [0:16] is the sub-list for field type_name
+	4,  // 2: Entry.chunks:type_name -> Chunk
+	6,  // 3: HeaderRule.header_map:type_name -> Header
+	8,  // 4: BasicAuthRule.credentials:type_name -> BasicCredential
+	19, // 5: Manifest.contents:type_name -> Manifest.ContentsEntry
+	11, // 6: Manifest.shards:type_name -> ManifestShard
+	5,  // 7: Manifest.redirects:type_name -> RedirectRule
+	7,  // 8: Manifest.headers:type_name -> HeaderRule
+	9,  // 9: Manifest.basic_auth:type_name -> BasicAuthRule
+	22, // 10: Manifest.expires_at:type_name -> google.protobuf.Timestamp
+	10, // 11: Manifest.problems:type_name -> Problem
+	14, // 12: Manifest.encrypted:type_name -> EncryptedPayload
+	22, // 13: AuditRecord.timestamp:type_name -> google.protobuf.Timestamp
+	2,  // 14: AuditRecord.event:type_name -> AuditEvent
+	17, // 15: AuditRecord.principal:type_name -> Principal
+	12, // 16: AuditRecord.manifest:type_name -> Manifest
+	20, // 17: AuditRecord.manifests:type_name -> AuditRecord.ManifestsEntry
+	14, // 18: AuditRecord.encrypted:type_name -> EncryptedPayload
+	13, // 19: AuditRecord.diff:type_name -> ManifestDiff
+	21, // 20: AuditRecord.diffs:type_name -> AuditRecord.DiffsEntry
+	2,  // 21: AuditChainHead.event:type_name -> AuditEvent
+	18, // 22: Principal.forge_user:type_name -> ForgeUser
+	3,  // 23: Manifest.ContentsEntry.value:type_name -> Entry
+	12, // 24: AuditRecord.ManifestsEntry.value:type_name -> Manifest
+	13, // 25: AuditRecord.DiffsEntry.value:type_name -> ManifestDiff
+	26, // [26:26] is the sub-list for method output_type
+	26, // [26:26] is the sub-list for method input_type
+	26, // [26:26] is the sub-list for extension type_name
+	26, // [26:26] is the sub-list for extension extendee
+	0,  // [0:26] is the sub-list for field type_name
 }
 
 func init() { file_schema_proto_init() }
@@ -1163,7 +1731,7 @@ func file_schema_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_schema_proto_rawDesc), len(file_schema_proto_rawDesc)),
 			NumEnums:      3,
-			NumMessages:   12,
+			NumMessages:   19,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
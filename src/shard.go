@@ -0,0 +1,177 @@
+package git_pages
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Retrieves a manifest shard from the blob store, without caching.
+func getManifestShardBlob(ctx context.Context, backend Backend, name string) (*Manifest, error) {
+	reader, _, err := backend.GetBlob(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeManifest(data)
+}
+
+// Approximate size of an entry within an encoded manifest.
+func manifestEntrySize(name string, entry *Entry) int64 {
+	return int64(len(name) + proto.Size(entry) + 8)
+}
+
+// Moves entries of the largest directories of `manifest` into shards until the encoded
+// manifest is no larger than `maxSize`. Only directories whose entries all fit into a shard
+// of `maxSize` are considered, so a manifest with many files in a single directory may still
+// exceed `maxSize` after sharding. Returns the encoded shards by blob name.
+func ShardManifest(manifest *Manifest, maxSize int64) map[string][]byte {
+	rootSize := int64(proto.Size(manifest))
+	if rootSize <= maxSize {
+		return nil
+	}
+
+	// Compute the size of the entries under each directory.
+	dirSizes := map[string]int64{}
+	for name, entry := range manifest.Contents {
+		size := manifestEntrySize(name, entry)
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirSizes[dir] += size
+		}
+	}
+
+	// Only the outermost directories that fit into a shard are candidates.
+	var candidates []string
+	for dir, size := range dirSizes {
+		parent := path.Dir(dir)
+		if size <= maxSize && (parent == "." || dirSizes[parent] > maxSize) {
+			candidates = append(candidates, dir)
+		}
+	}
+	slices.SortFunc(candidates, func(a, b string) int {
+		return cmp.Or(-cmp.Compare(dirSizes[a], dirSizes[b]), strings.Compare(a, b))
+	})
+
+	shards := map[string][]byte{}
+	for _, dir := range candidates {
+		if rootSize <= maxSize {
+			break
+		}
+		shard := &Manifest{Contents: map[string]*Entry{}}
+		for name, entry := range manifest.Contents {
+			if strings.HasPrefix(name, dir+"/") {
+				shard.Contents[name] = entry
+				delete(manifest.Contents, name)
+			}
+		}
+		shardData := EncodeManifest(shard)
+		shardName := fmt.Sprintf("sha256-%x", sha256.Sum256(shardData))
+		shards[shardName] = shardData
+		manifest.Shards = append(manifest.Shards, &ManifestShard{
+			Path: proto.String(dir),
+			Blob: []byte(shardName),
+			Size: proto.Int64(int64(len(shardData))),
+		})
+		rootSize -= dirSizes[dir]
+		rootSize += int64(proto.Size(manifest.Shards[len(manifest.Shards)-1]) + 8)
+	}
+	slices.SortFunc(manifest.Shards, func(a, b *ManifestShard) int {
+		return strings.Compare(a.GetPath(), b.GetPath())
+	})
+	return shards
+}
+
+// Returns the shard that contains the entry for `name`, or nil if the entry is stored in
+// the manifest itself.
+func findManifestShard(manifest *Manifest, name string) *ManifestShard {
+	if len(manifest.GetShards()) == 0 {
+		return nil
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		index, found := slices.BinarySearchFunc(manifest.Shards, dir,
+			func(shard *ManifestShard, dir string) int {
+				return strings.Compare(shard.GetPath(), dir)
+			})
+		if found {
+			return manifest.Shards[index]
+		}
+	}
+	return nil
+}
+
+// Returns the entry for `name`, retrieving the shard that contains it if needed. Returns nil
+// if there is no such entry.
+func GetManifestEntry(ctx context.Context, manifest *Manifest, name string) (*Entry, error) {
+	if shard := findManifestShard(manifest, name); shard != nil {
+		shardManifest, err := backend.GetManifestShard(ctx, string(shard.GetBlob()))
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard.GetPath(), err)
+		}
+		return shardManifest.Contents[name], nil
+	}
+	return manifest.Contents[name], nil
+}
+
+// Returns a copy of the manifest with the entries from all of its shards included in `contents`.
+// A manifest that is not sharded is returned as-is.
+func UnshardManifest(ctx context.Context, manifest *Manifest) (*Manifest, error) {
+	if len(manifest.GetShards()) == 0 {
+		return manifest, nil
+	}
+	unsharded := &Manifest{}
+	proto.Merge(unsharded, manifest)
+	unsharded.Shards = nil
+	for _, shard := range manifest.Shards {
+		shardManifest, err := backend.GetManifestShard(ctx, string(shard.GetBlob()))
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard.GetPath(), err)
+		}
+		maps.Copy(unsharded.Contents, shardManifest.Contents)
+	}
+	return unsharded, nil
+}
+
+// Retrieves a manifest together with the entries from all of its shards.
+func GetUnshardedManifest(
+	ctx context.Context, name string, opts GetManifestOptions,
+) (
+	manifest *Manifest, metadata ManifestMetadata, err error,
+) {
+	manifest, metadata, err = backend.GetManifest(ctx, name, opts)
+	if err == nil {
+		manifest, err = UnshardManifest(ctx, manifest)
+	}
+	return
+}
+
+// Returns the names and sizes of blobs a manifest refers to, including its shards and
+// the blobs their entries refer to. If the shards cannot be retrieved, the shards themselves
+// are still returned along with the error.
+func ManifestBlobs(ctx context.Context, manifest *Manifest) (map[string]int64, error) {
+	blobs := map[string]int64{}
+	for _, shard := range manifest.GetShards() {
+		blobs[string(shard.GetBlob())] = shard.GetSize()
+	}
+	manifest, err := UnshardManifest(ctx, manifest)
+	if err != nil {
+		return blobs, err
+	}
+	for _, entry := range manifest.GetContents() {
+		maps.Copy(blobs, EntryBlobs(entry))
+	}
+	return blobs, nil
+}
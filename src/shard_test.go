@@ -0,0 +1,116 @@
+package git_pages
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestShardManifest(t *testing.T) {
+	makeManifest := func(counts map[string]int) *Manifest {
+		manifest := &Manifest{Contents: map[string]*Entry{}}
+		for dir, count := range counts {
+			for index := range count {
+				manifest.Contents[path.Join(dir, fmt.Sprintf("file%03d.html", index))] = &Entry{
+					Type: Type_InlineFile.Enum(),
+					Data: []byte(strings.Repeat("x", 32)),
+				}
+			}
+		}
+		return manifest
+	}
+
+	for _, test := range []struct {
+		name    string
+		counts  map[string]int
+		maxSize int64
+		// Directories moved into shards, in order. Entries are about 60 bytes large.
+		shards []string
+	}{
+		{"small", map[string]int{"a": 10}, 100000, nil},
+		{"largest first", map[string]int{"a": 10, "b": 100, "c": 50}, 6000, []string{"b"}},
+		{"several", map[string]int{"a": 10, "b": 40, "c": 40}, 2400, []string{"b", "c"}},
+		{"outermost", map[string]int{".": 20, "a/b": 50, "a/c": 50}, 6100, []string{"a"}},
+		{"nested", map[string]int{".": 40, "a/b": 50, "a/c": 50}, 4000, []string{"a/b", "a/c"}},
+		{"similar names", map[string]int{".": 20, "a": 50, "ab": 50}, 3000, []string{"a", "ab"}},
+		{"single directory", map[string]int{"a": 100}, 1000, nil},
+	} {
+		manifest := makeManifest(test.counts)
+		original := maps.Clone(manifest.Contents)
+		shards := ShardManifest(manifest, test.maxSize)
+
+		var shardPaths []string
+		for _, shard := range manifest.Shards {
+			shardPaths = append(shardPaths, shard.GetPath())
+		}
+		if !slices.Equal(shardPaths, test.shards) {
+			t.Errorf("%s: expect shards %q, got %q", test.name, test.shards, shardPaths)
+			continue
+		}
+
+		// Every entry must be in exactly one of the manifest and its shards, and must be found
+		// by `findManifestShard`.
+		for name, entry := range original {
+			shard := findManifestShard(manifest, name)
+			var contents map[string]*Entry
+			if shard == nil {
+				contents = manifest.Contents
+			} else if shardData, found := shards[string(shard.GetBlob())]; !found {
+				t.Errorf("%s: shard %s of %s not returned", test.name, shard.GetPath(), name)
+				continue
+			} else if shardManifest, err := DecodeManifest(shardData); err != nil {
+				t.Errorf("%s: shard %s: %s", test.name, shard.GetPath(), err)
+				continue
+			} else {
+				contents = shardManifest.Contents
+				if _, found := manifest.Contents[name]; found {
+					t.Errorf("%s: %s in both manifest and shard %s",
+						test.name, name, shard.GetPath())
+				}
+			}
+			if !proto.Equal(contents[name], entry) {
+				t.Errorf("%s: %s not found", test.name, name)
+			}
+		}
+		if len(test.shards) > 0 && int64(proto.Size(manifest)) > test.maxSize {
+			t.Errorf("%s: expect size at most %d, got %d",
+				test.name, test.maxSize, proto.Size(manifest))
+		}
+	}
+}
+
+func TestFindManifestShard(t *testing.T) {
+	manifest := &Manifest{Shards: []*ManifestShard{
+		{Path: proto.String("a")},
+		{Path: proto.String("b/c")},
+		{Path: proto.String("b/cd")},
+	}}
+	for _, test := range []struct {
+		name  string
+		shard string
+	}{
+		{"index.html", ""},
+		{"a", ""},
+		{"a/index.html", "a"},
+		{"a/b/index.html", "a"},
+		{"ab/index.html", ""},
+		{"b/index.html", ""},
+		{"b/c/index.html", "b/c"},
+		{"b/cd/index.html", "b/cd"},
+		{"b/ce/index.html", ""},
+	} {
+		shard := findManifestShard(manifest, test.name)
+		if shard.GetPath() != test.shard {
+			t.Errorf("%s: expect shard %q, got %q", test.name, test.shard, shard.GetPath())
+		}
+	}
+
+	if shard := findManifestShard(&Manifest{}, "a/index.html"); shard != nil {
+		t.Errorf("unsharded: expect no shard, got %q", shard.GetPath())
+	}
+}
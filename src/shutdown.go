@@ -0,0 +1,208 @@
+package git_pages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// Set once a graceful shutdown begins; the server reports itself as unhealthy from then on.
+	shuttingDown atomic.Bool
+
+	// Goroutines that outlive the request that started them, e.g. webhook updates and audit
+	// notifications. These are waited for during a graceful shutdown.
+	backgroundTasks sync.WaitGroup
+
+	// Cancelled once a graceful shutdown has taken longer than `[server].drain-timeout`;
+	// background tasks that are still running at that point must stop.
+	abandonCtx, abandonBackgroundTasks = context.WithCancel(context.Background())
+
+	httpServersMutex sync.Mutex
+	httpServers      []*http.Server
+)
+
+func IsShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// Returns a context that is not cancelled together with `ctx`, but is cancelled if a graceful
+// shutdown times out. Used for work that must not be interrupted by the client going away.
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(abandonCtx, cancel)
+	return ctx, func() { stop(); cancel() }
+}
+
+// Runs `task` in a goroutine that a graceful shutdown waits for.
+func goBackground(task func()) {
+	backgroundTasks.Go(task)
+}
+
+// Serves metrics in the Prometheus format, and a `/health` endpoint that fails once a graceful
+// shutdown begins.
+func MetricsHandler() http.Handler {
+	router := http.NewServeMux()
+	router.Handle("/", promhttp.Handler())
+	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if IsShuttingDown() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "shutting down")
+		} else {
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	return router
+}
+
+func registerHTTPServer(server *http.Server) {
+	httpServersMutex.Lock()
+	defer httpServersMutex.Unlock()
+	httpServers = append(httpServers, server)
+}
+
+// Reports the process as unhealthy, waits for `[server].shutdown-delay` for load balancers to
+// notice, then stops accepting connections and waits up to `[server].drain-timeout` for requests
+// and background tasks to finish, storing the traffic statistics collected in the meantime.
+// Background tasks that do not finish in time are abandoned.
+func Shutdown(ctx context.Context) {
+	config := currentConfig(ctx)
+	shuttingDown.Store(true)
+	if delay := time.Duration(config.Server.ShutdownDelay); delay > 0 {
+		logc.Printf(ctx, "shutdown: unhealthy, waiting %s", delay)
+		time.Sleep(delay)
+	}
+
+	drainCtx, cancel := context.WithTimeout(ctx, time.Duration(config.Server.DrainTimeout))
+	defer cancel()
+
+	logc.Println(ctx, "shutdown: draining")
+	httpServersMutex.Lock()
+	servers := slices.Clone(httpServers)
+	httpServersMutex.Unlock()
+	wg := sync.WaitGroup{}
+	for _, server := range servers {
+		wg.Go(func() {
+			if err := server.Shutdown(drainCtx); err != nil {
+				logc.Printf(ctx, "shutdown: %s", err)
+				server.Close()
+			}
+		})
+	}
+	wg.Wait()
+
+	if err := FlushSiteStats(drainCtx); err != nil {
+		logc.Printf(ctx, "shutdown: %s", err)
+	}
+	if err := CheckpointAuditChain(drainCtx); err != nil {
+		logc.Printf(ctx, "shutdown: %s", err)
+	}
+	DrainBackgroundTasks(drainCtx)
+}
+
+// Waits for background tasks to finish until `ctx` is done, then abandons the rest of them
+// and saves the audit notifications that have not been delivered.
+func DrainBackgroundTasks(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		backgroundTasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logc.Println(ctx, "shutdown: abandoning background tasks")
+		abandonBackgroundTasks()
+		<-done
+	}
+
+	if err := savePendingAuditNotifications(); err != nil {
+		logc.Printf(ctx, "shutdown: %s", err)
+	}
+}
+
+var pendingAuditNotifications = struct {
+	sync.Mutex
+	ids map[AuditID]struct{}
+	// Whether each notification sent to a subscriber is stored in the outbox.
+	deliveries map[auditDelivery]bool
+}{ids: map[AuditID]struct{}{}, deliveries: map[auditDelivery]bool{}}
+
+func savePendingAuditNotifications() error {
+	config := loadConfig()
+	pendingAuditNotifications.Lock()
+	defer pendingAuditNotifications.Unlock()
+
+	var deliveries []string
+	for delivery, stored := range pendingAuditNotifications.deliveries {
+		if !stored {
+			deliveries = append(deliveries, delivery.String())
+		}
+	}
+	slices.Sort(deliveries)
+	var deliveriesErr error
+	if len(deliveries) > 0 {
+		deliveriesErr = fmt.Errorf("audit notifications not delivered: %s",
+			strings.Join(deliveries, ", "))
+	}
+	if len(pendingAuditNotifications.ids) == 0 {
+		return deliveriesErr
+	}
+
+	var ids []string
+	for id := range pendingAuditNotifications.ids {
+		ids = append(ids, id.String())
+	}
+	slices.Sort(ids)
+	if config.Audit.PendingNotifyFile == "" {
+		return errors.Join(deliveriesErr,
+			fmt.Errorf("audit notifications not delivered: %s", strings.Join(ids, ", ")))
+	}
+
+	file, err := os.OpenFile(config.Audit.PendingNotifyFile,
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Join(deliveriesErr, fmt.Errorf("audit notify: %w", err))
+	}
+	_, err = file.WriteString(strings.Join(ids, "\n") + "\n")
+	return errors.Join(deliveriesErr, err, file.Close())
+}
+
+// Resumes delivery of the audit notifications saved during the previous shutdown, and of those
+// left in the outbox.
+func ResumePendingAuditNotifications(ctx context.Context) error {
+	return errors.Join(resumePendingNotifyFile(ctx), resumeAuditOutbox(ctx))
+}
+
+func resumePendingNotifyFile(ctx context.Context) error {
+	config := currentConfig(ctx)
+	if config.Audit.PendingNotifyFile == "" || config.Audit.NotifyURL == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(config.Audit.PendingNotifyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("audit notify: %w", err)
+	}
+	for _, line := range strings.Fields(string(data)) {
+		id, err := ParseAuditID(line)
+		if err != nil {
+			return fmt.Errorf("audit notify: %s: %w", config.Audit.PendingNotifyFile, err)
+		}
+		logc.Printf(ctx, "audit notify %s: resuming", id)
+		notifyAudit(ctx, id)
+	}
+	return os.Remove(config.Audit.PendingNotifyFile)
+}
@@ -0,0 +1,324 @@
+package git_pages
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrStagedVersionNotFound = errors.New("staged version not found")
+
+var errStageEmptySite = errors.New("cannot stage an empty site")
+
+// Returns the name of the manifest holding the staged version of the site `webRoot`. Project
+// names starting with `.` are reserved and cannot be requested by clients; such manifests are
+// skipped by `EnumerateManifests`, so the staged version is never served on its own, and are
+// instead enumerated by `EnumerateStagedManifests` (e.g. for garbage collection and expiry).
+func stagedSiteName(webRoot string) string {
+	domain, project, _ := strings.Cut(webRoot, "/")
+	return fmt.Sprintf("%s/.staged.%s", domain, project)
+}
+
+// Returns whether the project name `project` (of a manifest) is that of a staged version.
+func isStagedProjectName(project string) bool {
+	return strings.HasPrefix(project, ".staged.")
+}
+
+// Iterates through contents of all staged manifests. Since `GetAllManifests` skips them, this
+// must be used together with it wherever every stored manifest has to be considered. Staged
+// manifests that are promoted or replaced while iterating are skipped.
+func GetAllStagedManifests(ctx context.Context) iter.Seq2[tuple[*ManifestMetadata, *Manifest], error] {
+	return func(yield func(tuple[*ManifestMetadata, *Manifest], error) bool) {
+		for metadata, err := range backend.EnumerateStagedManifests(ctx) {
+			var item tuple[*ManifestMetadata, *Manifest]
+			if err == nil {
+				var manifest *Manifest
+				manifest, _, err = backend.GetManifest(ctx, metadata.Name,
+					GetManifestOptions{BypassCache: true})
+				if errors.Is(err, ErrObjectNotFound) {
+					continue
+				}
+				item = tuple[*ManifestMetadata, *Manifest]{metadata, manifest}
+			}
+			if !yield(item, err) {
+				break
+			}
+		}
+	}
+}
+
+// Returns the version identifier of a staged manifest, which is a hash of its contents. Anyone
+// who can rebuild the same contents (e.g. from a public repository) can compute it, so it is
+// only used to make sure that the version being promoted is the one that was staged; previewing
+// the staged site requires its secret `staged_token` instead.
+func StagedVersion(manifest *Manifest) string {
+	if manifest.StagedToken != nil {
+		manifest = unstageManifest(manifest)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(EncodeManifest(manifest)))
+}
+
+// Returns a copy of the staged manifest `manifest` to be deployed, without its preview token.
+func unstageManifest(manifest *Manifest) *Manifest {
+	manifest = proto.CloneOf(manifest)
+	manifest.StagedToken = nil
+	return manifest
+}
+
+// Retrieves the staged version of the site `webRoot`, but only if its preview token matches
+// `token`. Otherwise, behaves as if the site does not exist.
+func GetStagedManifest(
+	ctx context.Context, webRoot string, token string, opts GetManifestOptions,
+) (
+	*Manifest, ManifestMetadata, error,
+) {
+	manifest, metadata, err := backend.GetManifest(ctx, stagedSiteName(webRoot), opts)
+	if err != nil {
+		return nil, ManifestMetadata{}, err
+	}
+	if manifest.GetStagedToken() == "" ||
+		subtle.ConstantTimeCompare([]byte(manifest.GetStagedToken()), []byte(token)) != 1 {
+		return nil, ManifestMetadata{}, fmt.Errorf("%w: staged version", ErrObjectNotFound)
+	}
+	return manifest, metadata, nil
+}
+
+// Stores `newManifest` as the staged version of the site `webRoot`, replacing any previously
+// staged version, together with a new random token for previewing it. The deployed version of
+// the site is not affected.
+func Stage(ctx context.Context, webRoot string, newManifest *Manifest) UpdateResult {
+	var err error
+	var storedManifest *Manifest
+
+	outcome := UpdateError
+	if IsManifestEmpty(newManifest) {
+		err = errStageEmptySite
+	} else if err = PrepareManifest(ctx, newManifest); err == nil {
+		newManifest.StagedToken = proto.String(rand.Text())
+		storedManifest, err = StoreManifest(ctx, stagedSiteName(webRoot), newManifest,
+			ModifyManifestOptions{})
+		if err == nil {
+			outcome = UpdateStaged
+		}
+	}
+
+	if err == nil {
+		logc.Printf(ctx, "stage %s ok: %s", webRoot, StagedVersion(storedManifest))
+	} else {
+		logc.Printf(ctx, "stage %s err: %s", webRoot, err)
+	}
+
+	return UpdateResult{outcome, storedManifest, err, nil}
+}
+
+// Atomically replaces the deployed version of the site `webRoot` with its staged version,
+// provided that the staged version is still `version`.
+func Promote(ctx context.Context, webRoot string, version string) (result UpdateResult) {
+	span, ctx := ObserveFunction(ctx, "Promote", "manifest.name", webRoot)
+	defer span.Finish()
+	defer observeUpdateResult(result)
+
+	stagedName := stagedSiteName(webRoot)
+	stagedManifest, stagedMetadata, err := backend.GetManifest(ctx, stagedName,
+		GetManifestOptions{BypassCache: true})
+	if errors.Is(err, ErrObjectNotFound) {
+		err = ErrStagedVersionNotFound
+	} else if err == nil && StagedVersion(stagedManifest) != version {
+		err = fmt.Errorf("%w: Staged-Version", ErrPreconditionFailed)
+	}
+	if err != nil {
+		logc.Printf(ctx, "promote %s err: %s", webRoot, err)
+		result = UpdateResult{UpdateError, nil, err, nil}
+		return
+	}
+	stagedManifest = unstageManifest(stagedManifest)
+
+	oldManifest, oldMetadata, err := backend.GetManifest(ctx, webRoot,
+		GetManifestOptions{BypassCache: true})
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		logc.Printf(ctx, "promote %s err: %s", webRoot, err)
+		result = UpdateResult{UpdateError, nil, err, nil}
+		return
+	}
+
+	// Make sure the site hasn't changed between retrieving it above and committing it below,
+	// so that the outcome and the changes reported are the ones actually made.
+	opts := ModifyManifestOptions{}
+	if oldManifest != nil {
+		opts.IfUnmodifiedSince = oldMetadata.LastModified
+		opts.IfMatch = oldMetadata.ETag
+	}
+
+	// The changes are determined when the manifest is committed if audit records are collected,
+	// and otherwise after it is committed.
+	var diff *ManifestDiff
+	ctx = WithCommitDiff(ctx, webRoot, func(manifest *Manifest) *ManifestDiff {
+		diff = diffUpdate(ctx, webRoot, oldManifest, manifest)
+		return diff
+	})
+
+	outcome := UpdateError
+	if oldManifest != nil && oldManifest.ExpiresAt == nil && stagedManifest.ExpiresAt != nil {
+		err = errExpireExistingSite
+	} else if err = backend.StageManifest(ctx, stagedManifest); err != nil {
+		err = fmt.Errorf("stage manifest: %w", err)
+	} else if err = backend.CommitManifest(ctx, webRoot, stagedManifest, opts); err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			err = ErrWriteConflict
+		} else if !errors.Is(err, ErrDomainFrozen) {
+			err = fmt.Errorf("commit manifest: %w", err)
+		}
+	} else {
+		domain, _, _ := strings.Cut(webRoot, "/")
+		err = backend.CreateDomain(ctx, domain)
+		existenceCache.AddSite(ctx, webRoot)
+	}
+	if err == nil {
+		if oldManifest == nil {
+			outcome = UpdateCreated
+		} else if CompareManifest(oldManifest, stagedManifest) {
+			outcome = UpdateNoChange
+		} else {
+			outcome = UpdateReplaced
+		}
+
+		// The staged version may have been replaced by another upload after it was promoted;
+		// in that case, the newly staged version is retained.
+		err := backend.DeleteManifest(ctx, stagedName,
+			ModifyManifestOptions{IfMatch: stagedMetadata.ETag})
+		if err != nil {
+			logc.Printf(ctx, "promote %s: cleanup err: %s", webRoot, err)
+		}
+
+		logc.Printf(ctx, "promote %s ok: %s", webRoot, version)
+	} else {
+		logc.Printf(ctx, "promote %s err: %s", webRoot, err)
+	}
+
+	if outcome != UpdateCreated && outcome != UpdateReplaced {
+		diff = nil
+	} else if diff == nil {
+		diff = diffUpdate(ctx, webRoot, oldManifest, stagedManifest)
+	}
+	result = UpdateResult{outcome, stagedManifest, err, diff}
+	return
+}
+
+// Replaces the deployed versions of several sites on one domain with their staged versions as
+// one transaction, provided that the staged version of each site `webRoot` is still
+// `versions[webRoot]`. See `CommitManifests` for the guarantees the transaction provides. If
+// the transaction is only partially committed, returns the outcomes for the sites that have
+// been updated together with an error wrapping `ErrPartialCommit`.
+func PromoteSites(ctx context.Context, versions map[string]string) (
+	outcomes map[string]UpdateOutcome, err error,
+) {
+	span, ctx := ObserveFunction(ctx, "PromoteSites", "manifest.count", len(versions))
+	defer span.Finish()
+	defer func() { observeUpdateResult(UpdateResult{err: err}) }()
+
+	webRoots := slices.Sorted(maps.Keys(versions))
+	stagedMetadatas := map[string]ManifestMetadata{}
+	commits := []ManifestCommit{}
+	outcomes = map[string]UpdateOutcome{}
+	for _, webRoot := range webRoots {
+		stagedManifest, stagedMetadata, err := backend.GetManifest(ctx, stagedSiteName(webRoot),
+			GetManifestOptions{BypassCache: true})
+		if errors.Is(err, ErrObjectNotFound) {
+			err = fmt.Errorf("%w: %s", ErrStagedVersionNotFound, webRoot)
+		} else if err == nil && StagedVersion(stagedManifest) != versions[webRoot] {
+			err = fmt.Errorf("%w: Staged-Version of %s", ErrPreconditionFailed, webRoot)
+		}
+		if err != nil {
+			logc.Printf(ctx, "promote %s err: %s", webRoot, err)
+			return nil, err
+		}
+		stagedManifest = unstageManifest(stagedManifest)
+
+		oldManifest, oldMetadata, err := backend.GetManifest(ctx, webRoot,
+			GetManifestOptions{BypassCache: true})
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			logc.Printf(ctx, "promote %s err: %s", webRoot, err)
+			return nil, err
+		}
+
+		if oldManifest != nil && oldManifest.ExpiresAt == nil && stagedManifest.ExpiresAt != nil {
+			return nil, errExpireExistingSite
+		} else if err = backend.StageManifest(ctx, stagedManifest); err != nil {
+			return nil, fmt.Errorf("stage manifest: %w", err)
+		}
+
+		// Make sure none of the sites have changed between retrieving them above and committing
+		// the transaction below, so that the entire transaction is applied to a consistent state.
+		opts := ModifyManifestOptions{}
+		if oldManifest != nil {
+			opts.IfUnmodifiedSince = oldMetadata.LastModified
+			opts.IfMatch = oldMetadata.ETag
+		}
+		commits = append(commits, ManifestCommit{webRoot, stagedManifest, opts})
+		stagedMetadatas[webRoot] = stagedMetadata
+
+		if oldManifest == nil {
+			outcomes[webRoot] = UpdateCreated
+		} else if CompareManifest(oldManifest, stagedManifest) {
+			outcomes[webRoot] = UpdateNoChange
+		} else {
+			outcomes[webRoot] = UpdateReplaced
+		}
+	}
+
+	committed := webRoots
+	if err = backend.CommitManifests(ctx, commits); err != nil {
+		partialErr := (*PartialCommitError)(nil)
+		if errors.As(err, &partialErr) {
+			// The sites that have been updated must be reported and cleaned up, just like
+			// the sites of a transaction that has been committed in full.
+			committed = partialErr.Committed
+			err = fmt.Errorf("commit manifests: %w", err)
+		} else if errors.Is(err, ErrPreconditionFailed) {
+			err = ErrWriteConflict
+		} else if !errors.Is(err, ErrDomainFrozen) {
+			err = fmt.Errorf("commit manifests: %w", err)
+		}
+		logc.Printf(ctx, "promote %s err: %s", strings.Join(webRoots, ", "), err)
+		if partialErr == nil {
+			return nil, err
+		}
+		for _, webRoot := range webRoots {
+			if !slices.Contains(committed, webRoot) {
+				delete(outcomes, webRoot)
+			}
+		}
+	}
+
+	for _, webRoot := range committed {
+		domain, _, _ := strings.Cut(webRoot, "/")
+		if err := backend.CreateDomain(ctx, domain); err != nil {
+			return nil, err
+		}
+		existenceCache.AddSite(ctx, webRoot)
+
+		err := backend.DeleteManifest(ctx, stagedSiteName(webRoot),
+			ModifyManifestOptions{IfMatch: stagedMetadatas[webRoot].ETag})
+		if err != nil {
+			logc.Printf(ctx, "promote %s: cleanup err: %s", webRoot, err)
+		}
+
+		logc.Printf(ctx, "promote %s ok: %s", webRoot, versions[webRoot])
+	}
+
+	return outcomes, err
+}
+
+// Removes the staged version of the site `webRoot`, if any.
+func Discard(ctx context.Context, webRoot string) error {
+	return backend.DeleteManifest(ctx, stagedSiteName(webRoot), ModifyManifestOptions{})
+}
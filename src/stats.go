@@ -0,0 +1,220 @@
+package git_pages
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Traffic statistics of a site, as stored in the backend and returned by
+// `.git-pages/stats.json`.
+type SiteStats struct {
+	Site string          `json:"site"`
+	Days []*SiteStatsDay `json:"days"`
+}
+
+type SiteStatsDay struct {
+	Date     string          `json:"date"` // in UTC, e.g. `2006-01-02`
+	Requests uint64          `json:"requests"`
+	Bytes    uint64          `json:"bytes"`
+	NotFound uint64          `json:"notFound"`
+	TopPaths []SiteStatsPath `json:"topPaths"`
+}
+
+type SiteStatsPath struct {
+	Path     string `json:"path"`
+	Requests uint64 `json:"requests"`
+}
+
+const siteStatsDateFormat = "2006-01-02"
+
+// Bound the memory used by statistics of sites requested at many different paths (e.g. by
+// a vulnerability scanner); requests for other paths are counted, but not per path.
+const maxPendingSiteStatsPaths = 1024
+
+type pendingSiteStats struct {
+	requests uint64
+	bytes    uint64
+	notFound uint64
+	paths    map[string]uint64
+}
+
+func (pending *pendingSiteStats) add(other *pendingSiteStats) {
+	pending.requests += other.requests
+	pending.bytes += other.bytes
+	pending.notFound += other.notFound
+	for path, count := range other.paths {
+		if _, found := pending.paths[path]; found || len(pending.paths) < maxPendingSiteStatsPaths {
+			pending.paths[path] += count
+		}
+	}
+}
+
+// Statistics collected since the last flush, by web root and then by date.
+var pendingStats = struct {
+	sync.Mutex
+	sites map[string]map[string]*pendingSiteStats
+}{sites: map[string]map[string]*pendingSiteStats{}}
+
+func addPendingSiteStats(webRoot string, date string, other *pendingSiteStats) {
+	pendingStats.Lock()
+	defer pendingStats.Unlock()
+
+	days := pendingStats.sites[webRoot]
+	if days == nil {
+		days = map[string]*pendingSiteStats{}
+		pendingStats.sites[webRoot] = days
+	}
+	pending := days[date]
+	if pending == nil {
+		pending = &pendingSiteStats{paths: map[string]uint64{}}
+		days[date] = pending
+	}
+	pending.add(other)
+}
+
+func recordSiteStats(webRoot string, urlPath string, status int, bytes int64, now time.Time) {
+	// The `.git-pages/` metadata endpoints are used by tooling rather than by visitors.
+	if strings.Contains(urlPath+"/", "/.git-pages/") {
+		return
+	}
+
+	request := &pendingSiteStats{requests: 1, bytes: uint64(max(bytes, 0))}
+	if status == http.StatusNotFound {
+		request.notFound = 1
+	}
+	request.paths = map[string]uint64{urlPath: 1}
+	addPendingSiteStats(webRoot, now.UTC().Format(siteStatsDateFormat), request)
+}
+
+func (stats *SiteStats) add(date string, pending *pendingSiteStats, topPaths int) {
+	index := slices.IndexFunc(stats.Days, func(day *SiteStatsDay) bool { return day.Date == date })
+	if index == -1 {
+		stats.Days = append(stats.Days, &SiteStatsDay{Date: date})
+		index = len(stats.Days) - 1
+	}
+	day := stats.Days[index]
+	day.Requests += pending.requests
+	day.Bytes += pending.bytes
+	day.NotFound += pending.notFound
+
+	// Only the top paths of every day are stored, so the counts of paths that were not among
+	// them before are approximate.
+	counts := map[string]uint64{}
+	for _, path := range day.TopPaths {
+		counts[path.Path] = path.Requests
+	}
+	for path, count := range pending.paths {
+		counts[path] += count
+	}
+	day.TopPaths = []SiteStatsPath{}
+	for _, path := range slices.Sorted(maps.Keys(counts)) {
+		day.TopPaths = append(day.TopPaths, SiteStatsPath{path, counts[path]})
+	}
+	slices.SortStableFunc(day.TopPaths, func(a, b SiteStatsPath) int {
+		return cmp.Compare(b.Requests, a.Requests)
+	})
+	day.TopPaths = day.TopPaths[:min(len(day.TopPaths), topPaths)]
+}
+
+// Removes the statistics for days past `[stats].retention-days`, and sorts the rest by date.
+func (stats *SiteStats) prune(now time.Time) {
+	config := loadConfig()
+	oldestDate := now.UTC().AddDate(0, 0, 1-int(config.Stats.RetentionDays)).
+		Format(siteStatsDateFormat)
+	stats.Days = slices.DeleteFunc(stats.Days, func(day *SiteStatsDay) bool {
+		return day.Date < oldestDate
+	})
+	slices.SortFunc(stats.Days, func(a, b *SiteStatsDay) int {
+		return cmp.Compare(a.Date, b.Date)
+	})
+}
+
+func decodeSiteStats(webRoot string, data []byte) (*SiteStats, error) {
+	stats := &SiteStats{}
+	if data != nil {
+		if err := json.Unmarshal(data, stats); err != nil {
+			return nil, fmt.Errorf("malformed: %w", err)
+		}
+	}
+	stats.Site = webRoot
+	if stats.Days == nil {
+		stats.Days = []*SiteStatsDay{}
+	}
+	return stats, nil
+}
+
+// Retrieves the statistics of a site stored in the backend. Statistics collected since
+// the last flush are not included.
+func ReadSiteStats(ctx context.Context, webRoot string) (*SiteStats, error) {
+	data, err := backend.GetSiteStats(ctx, webRoot)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+	stats, err := decodeSiteStats(webRoot, data)
+	if err != nil {
+		return nil, fmt.Errorf("stats: %s: %w", webRoot, err)
+	}
+	stats.prune(time.Now())
+	return stats, nil
+}
+
+// Adds the statistics collected since the last flush to the ones stored in the backend.
+// Statistics that could not be stored are kept to be flushed again later.
+func FlushSiteStats(ctx context.Context) error {
+	pendingStats.Lock()
+	sites := pendingStats.sites
+	pendingStats.sites = map[string]map[string]*pendingSiteStats{}
+	pendingStats.Unlock()
+
+	var errs []error
+	for webRoot, days := range sites {
+		var decodeErr error
+		err := backend.UpdateSiteStats(ctx, webRoot, func(data []byte) []byte {
+			config := currentConfig(ctx)
+			stats, err := decodeSiteStats(webRoot, data)
+			if err != nil {
+				// Replacing malformed statistics would discard them; leave them for inspection.
+				decodeErr = err
+				return nil
+			}
+			for date, pending := range days {
+				stats.add(date, pending, int(config.Stats.TopPaths))
+			}
+			stats.prune(time.Now())
+			data, err = json.Marshal(stats)
+			if err != nil {
+				panic(err)
+			}
+			return data
+		})
+		if err = errors.Join(err, decodeErr); err != nil {
+			errs = append(errs, fmt.Errorf("stats: %s: %w", webRoot, err))
+			for date, pending := range days {
+				addPendingSiteStats(webRoot, date, pending)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func FlushSiteStatsPeriodically(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(max(time.Duration(currentConfig(ctx).Stats.FlushInterval), time.Second)):
+		}
+		if err := FlushSiteStats(ctx); err != nil {
+			logc.Printf(ctx, "stats: flush err: %s", err)
+		}
+	}
+}
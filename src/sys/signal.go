@@ -9,6 +9,17 @@ import (
 	"syscall"
 )
 
+// Calls `handler` (sequentially) every time the process receives SIGHUP.
+func OnHangup(handler func()) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			handler()
+		}
+	}()
+}
+
 func WaitForInterrupt() {
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
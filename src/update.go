@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"strings"
 	"time"
 
@@ -24,6 +25,10 @@ func (err UnresolvedRefError) Error() string {
 
 type UpdateOptions struct {
 	expiresAt time.Time
+	stage     bool
+	// Manifests of other sites the principal is authorized to read, whose entries may be
+	// referenced from an uploaded archive in addition to the entries of the site being updated.
+	reuseFrom []*Manifest
 }
 
 func (opts *UpdateOptions) Apply(manifest *Manifest) {
@@ -41,12 +46,27 @@ const (
 	UpdateReplaced
 	UpdateDeleted
 	UpdateNoChange
+	UpdateStaged
 )
 
 type UpdateResult struct {
 	outcome  UpdateOutcome
 	manifest *Manifest
 	err      error
+	// Changes made to the site, if it was created, replaced, or deleted.
+	diff *ManifestDiff
+}
+
+// Returns the changes made to the site by an update, or nil if they could not be determined
+// (which is not considered an error, since the update itself succeeded).
+func diffUpdate(
+	ctx context.Context, webRoot string, oldManifest, newManifest *Manifest,
+) *ManifestDiff {
+	diff, err := DiffManifests(ctx, oldManifest, newManifest)
+	if err != nil {
+		logc.Printf(ctx, "update %s: diff err: %s", webRoot, err)
+	}
+	return diff
 }
 
 var errExpireExistingSite = fmt.Errorf("cannot expire an existing site")
@@ -58,6 +78,14 @@ func Update(
 	var err error
 	var storedManifest *Manifest
 
+	// The changes are determined when the manifest is committed if audit records are collected,
+	// and otherwise after it is committed.
+	var diff *ManifestDiff
+	ctx = WithCommitDiff(ctx, webRoot, func(manifest *Manifest) *ManifestDiff {
+		diff = diffUpdate(ctx, webRoot, oldManifest, manifest)
+		return diff
+	})
+
 	outcome := UpdateError
 	if oldManifest != nil && oldManifest.ExpiresAt == nil && newManifest.ExpiresAt != nil {
 		err = errExpireExistingSite
@@ -112,7 +140,17 @@ func Update(
 		logc.Printf(ctx, "update %s err: %s", webRoot, err)
 	}
 
-	return UpdateResult{outcome, storedManifest, err}
+	switch outcome {
+	case UpdateCreated, UpdateReplaced:
+		if diff == nil {
+			diff = diffUpdate(ctx, webRoot, oldManifest, storedManifest)
+		}
+	case UpdateDeleted:
+		diff = diffUpdate(ctx, webRoot, oldManifest, nil)
+	default:
+		diff = nil
+	}
+	return UpdateResult{outcome, storedManifest, err, diff}
 }
 
 func UpdateFromRepository(
@@ -131,18 +169,28 @@ func UpdateFromRepository(
 	oldManifest, _, err := backend.GetManifest(ctx, webRoot, GetManifestOptions{})
 	if err != nil && !errors.Is(err, ErrObjectNotFound) {
 		logc.Printf(ctx, "update %s err: %s", webRoot, err)
-		result = UpdateResult{UpdateError, nil, err}
+		result = UpdateResult{UpdateError, nil, err, nil}
+		return
+	}
+	unshardedOldManifest, err := UnshardManifest(ctx, oldManifest)
+	if err != nil {
+		logc.Printf(ctx, "update %s err: %s", webRoot, err)
+		result = UpdateResult{UpdateError, nil, err, nil}
 		return
 	}
 
-	newManifest, err := FetchRepository(ctx, repoURL, branch, oldManifest)
+	newManifest, err := FetchRepository(ctx, repoURL, branch, unshardedOldManifest)
 	if errors.Is(err, context.DeadlineExceeded) {
-		result = UpdateResult{UpdateTimeout, nil, fmt.Errorf("update timeout")}
+		result = UpdateResult{UpdateTimeout, nil, fmt.Errorf("update timeout"), nil}
 	} else if err != nil {
-		result = UpdateResult{UpdateError, nil, err}
+		result = UpdateResult{UpdateError, nil, err, nil}
 	} else {
 		opts.Apply(newManifest)
-		result = Update(ctx, webRoot, oldManifest, newManifest, ModifyManifestOptions{})
+		if opts.stage {
+			result = Stage(ctx, webRoot, newManifest)
+		} else {
+			result = Update(ctx, webRoot, oldManifest, newManifest, ModifyManifestOptions{})
+		}
 	}
 
 	return
@@ -159,23 +207,29 @@ func UpdateFromArchive(
 	opts UpdateOptions,
 ) (result UpdateResult) {
 	span, ctx := ObserveFunction(ctx, "UpdateFromArchive",
-		"repo.url", repoURL, "archive.type", contentType)
+		"repo.url", repoURL, "archive.type", getMediaType(contentType))
 	defer span.Finish()
 	defer observeUpdateResult(result)
 
 	oldManifest, _, err := backend.GetManifest(ctx, webRoot, GetManifestOptions{})
 	if err != nil && !errors.Is(err, ErrObjectNotFound) {
 		logc.Printf(ctx, "update %s err: %s", webRoot, err)
-		result = UpdateResult{UpdateError, nil, err}
+		result = UpdateResult{UpdateError, nil, err, nil}
+		return
+	}
+	unshardedOldManifest, err := UnshardManifest(ctx, oldManifest)
+	if err != nil {
+		logc.Printf(ctx, "update %s err: %s", webRoot, err)
+		result = UpdateResult{UpdateError, nil, err, nil}
 		return
 	}
 
 	extractTar := func(ctx context.Context, reader io.Reader) (*Manifest, error) {
-		return ExtractTar(ctx, reader, oldManifest)
+		return ExtractTar(ctx, reader, unshardedOldManifest, opts.reuseFrom...)
 	}
 
 	var newManifest *Manifest
-	switch contentType {
+	switch getMediaType(contentType) {
 	case "application/x-tar":
 		logc.Printf(ctx, "update %s: (tar)", webRoot)
 		newManifest, err = extractTar(ctx, reader) // yellow?
@@ -185,22 +239,37 @@ func UpdateFromArchive(
 	case "application/x-tar+zstd":
 		logc.Printf(ctx, "update %s: (tar.zst)", webRoot)
 		newManifest, err = ExtractZstd(ctx, reader, extractTar)
+	case "application/x-tar+xz":
+		logc.Printf(ctx, "update %s: (tar.xz)", webRoot)
+		newManifest, err = ExtractXz(ctx, reader, extractTar)
+	case "application/x-tar+bzip2":
+		logc.Printf(ctx, "update %s: (tar.bz2)", webRoot)
+		newManifest, err = ExtractBzip2(ctx, reader, extractTar)
 	case "application/zip":
 		logc.Printf(ctx, "update %s: (zip)", webRoot)
-		newManifest, err = ExtractZip(ctx, reader, oldManifest)
+		newManifest, err = ExtractZip(ctx, reader, unshardedOldManifest, opts.reuseFrom...)
+	case "multipart/form-data":
+		logc.Printf(ctx, "update %s: (multipart)", webRoot)
+		_, params, _ := mime.ParseMediaType(contentType)
+		newManifest, err = ExtractMultipart(ctx, reader, params["boundary"],
+			unshardedOldManifest, opts.reuseFrom...)
 	default:
 		err = errArchiveFormat
 	}
 
 	if err != nil {
 		logc.Printf(ctx, "update %s err: %s", webRoot, err)
-		result = UpdateResult{UpdateError, nil, err}
+		result = UpdateResult{UpdateError, nil, err, nil}
 	} else {
 		if repoURL != "" {
 			newManifest.RepoUrl = &repoURL
 		}
 		opts.Apply(newManifest)
-		result = Update(ctx, webRoot, oldManifest, newManifest, ModifyManifestOptions{})
+		if opts.stage {
+			result = Stage(ctx, webRoot, newManifest)
+		} else {
+			result = Update(ctx, webRoot, oldManifest, newManifest, ModifyManifestOptions{})
+		}
 	}
 	return
 }
@@ -224,7 +293,13 @@ func PartialUpdateFromArchive(
 		GetManifestOptions{BypassCache: true})
 	if err != nil {
 		logc.Printf(ctx, "patch %s err: %s", webRoot, err)
-		result = UpdateResult{UpdateError, nil, err}
+		result = UpdateResult{UpdateError, nil, err, nil}
+		return
+	}
+	unshardedOldManifest, err := UnshardManifest(ctx, oldManifest)
+	if err != nil {
+		logc.Printf(ctx, "patch %s err: %s", webRoot, err)
+		result = UpdateResult{UpdateError, nil, err, nil}
 		return
 	}
 
@@ -232,11 +307,11 @@ func PartialUpdateFromArchive(
 		// Clone the manifest before starting to mutate it. `GetManifest` may return cached
 		// `*Manifest` objects, which should never be mutated.
 		newManifest := &Manifest{}
-		proto.Merge(newManifest, oldManifest)
+		proto.Merge(newManifest, unshardedOldManifest)
 		newManifest.RepoUrl = nil
 		newManifest.Branch = nil
 		newManifest.Commit = nil
-		if err := ApplyTarPatch(newManifest, reader, parents); err != nil {
+		if err := ApplyTarPatch(newManifest, reader, parents, opts.reuseFrom...); err != nil {
 			return nil, err
 		} else {
 			return newManifest, nil
@@ -254,13 +329,19 @@ func PartialUpdateFromArchive(
 	case "application/x-tar+zstd":
 		logc.Printf(ctx, "patch %s: (tar.zst)", webRoot)
 		newManifest, err = ExtractZstd(ctx, reader, applyTarPatch)
+	case "application/x-tar+xz":
+		logc.Printf(ctx, "patch %s: (tar.xz)", webRoot)
+		newManifest, err = ExtractXz(ctx, reader, applyTarPatch)
+	case "application/x-tar+bzip2":
+		logc.Printf(ctx, "patch %s: (tar.bz2)", webRoot)
+		newManifest, err = ExtractBzip2(ctx, reader, applyTarPatch)
 	default:
 		err = errArchiveFormat
 	}
 
 	if err != nil {
 		logc.Printf(ctx, "patch %s err: %s", webRoot, err)
-		result = UpdateResult{UpdateError, nil, err}
+		result = UpdateResult{UpdateError, nil, err, nil}
 	} else {
 		opts.Apply(newManifest)
 		result = Update(ctx, webRoot, oldManifest, newManifest,
@@ -281,10 +362,13 @@ func PartialUpdateFromArchive(
 
 func observeUpdateResult(result UpdateResult) {
 	var unresolvedRefErr UnresolvedRefError
+	var rateLimitErr *RateLimitError
 	if errors.As(result.err, &unresolvedRefErr) {
 		// This error is an expected outcome of an incremental update's probe phase.
 	} else if errors.Is(result.err, ErrWriteConflict) {
 		// This error is an expected outcome of an incremental update losing a race.
+	} else if errors.As(result.err, &rateLimitErr) {
+		// This error is an expected outcome of a forge being fetched from too often.
 	} else if result.err != nil {
 		ObserveError(result.err)
 	}
@@ -0,0 +1,133 @@
+package git_pages
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+)
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+var uploadIDPattern = regexp.MustCompile(`^[A-Z2-7]{26}$`)
+
+// Returns the name of the resumable upload `id` for the site `webRoot`. Uploads are namespaced
+// by site, so that an upload started for one site can never be finalized into another one.
+func uploadName(webRoot string, id string) string {
+	return path.Join(webRoot, id)
+}
+
+// Checks whether `id` is a well-formed upload identifier. Identifiers are generated by
+// `CreateUpload` and are only known to the client that created the upload.
+func ValidateUploadID(id string) error {
+	if !uploadIDPattern.MatchString(id) {
+		return fmt.Errorf("%w: malformed upload ID", ErrUploadNotFound)
+	}
+	return nil
+}
+
+// Starts a new resumable upload for the site `webRoot` and returns its identifier.
+func CreateUpload(ctx context.Context, webRoot string) (string, error) {
+	id := rand.Text()
+	if err := backend.AppendUpload(ctx, uploadName(webRoot, id), 0, nil); err != nil {
+		return "", err
+	}
+	logc.Printf(ctx, "upload %s: created %s", webRoot, id)
+	return id, nil
+}
+
+// Returns the amount of data received so far for the resumable upload `id`.
+func GetUploadOffset(ctx context.Context, webRoot string, id string) (int64, error) {
+	metadata, err := backend.StatUpload(ctx, uploadName(webRoot, id))
+	if errors.Is(err, ErrObjectNotFound) {
+		return 0, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	} else if err != nil {
+		return 0, err
+	}
+	return metadata.Size, nil
+}
+
+// Appends `data` to the resumable upload `id`, provided that exactly `offset` bytes have been
+// received before. Returns the new offset.
+func AppendUpload(
+	ctx context.Context, webRoot string, id string, offset int64, data []byte,
+) (int64, error) {
+	err := backend.AppendUpload(ctx, uploadName(webRoot, id), offset, data)
+	if errors.Is(err, ErrObjectNotFound) {
+		return 0, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	} else if err != nil {
+		return 0, err
+	}
+	return offset + int64(len(data)), nil
+}
+
+// Removes the resumable upload `id`, if it exists.
+func CancelUpload(ctx context.Context, webRoot string, id string) error {
+	return backend.DeleteUpload(ctx, uploadName(webRoot, id))
+}
+
+// Updates the site `webRoot` from the archive assembled by the resumable upload `id`. The upload
+// is removed if the update succeeds, and retained (so that it may be finalized again) otherwise.
+func FinalizeUpload(
+	ctx context.Context,
+	webRoot string,
+	id string,
+	repoURL string,
+	contentType string,
+	opts UpdateOptions,
+) UpdateResult {
+	name := uploadName(webRoot, id)
+	reader, err := backend.GetUpload(ctx, name)
+	if errors.Is(err, ErrObjectNotFound) {
+		return UpdateResult{UpdateError, nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id), nil}
+	} else if err != nil {
+		return UpdateResult{UpdateError, nil, err, nil}
+	}
+	defer reader.Close()
+
+	result := UpdateFromArchive(ctx, webRoot, repoURL, contentType, reader, opts)
+	if result.err == nil {
+		if err := backend.DeleteUpload(ctx, name); err != nil {
+			logc.Printf(ctx, "upload %s: cleanup err: %s", webRoot, err)
+		}
+	}
+	return result
+}
+
+// Removes resumable uploads that have not received any data for longer than `maxAge`.
+func ExpireUploads(ctx context.Context, maxAge time.Duration) (count int, err error) {
+	for metadata, err := range backend.EnumerateUploads(ctx) {
+		if err != nil {
+			return count, err
+		}
+		if time.Since(metadata.LastModified) > maxAge {
+			if err := backend.DeleteUpload(ctx, metadata.Name); err != nil {
+				return count, err
+			}
+			logc.Printf(ctx, "upload: expired %s", metadata.Name)
+			count += 1
+		}
+	}
+	return count, nil
+}
+
+// Periodically removes stale resumable uploads. Runs until `ctx` is cancelled.
+func ExpireUploadsPeriodically(ctx context.Context, maxAge time.Duration) {
+	ticker := time.NewTicker(maxAge / 24)
+	defer ticker.Stop()
+	for {
+		if count, err := ExpireUploads(ctx, maxAge); err != nil {
+			logc.Printf(ctx, "upload: expire err: %s", err)
+		} else if count > 0 {
+			logc.Printf(ctx, "upload: expired %d uploads", count)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
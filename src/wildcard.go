@@ -85,6 +85,7 @@ func (pattern *WildcardPattern) ApplyTemplate(userName string, projectName strin
 }
 
 func TranslateWildcards(wildcardConfigs []WildcardConfig) ([]*WildcardPattern, error) {
+	config := loadConfig()
 	var wildcardPatterns []*WildcardPattern
 	for _, wildcardConfig := range wildcardConfigs {
 		cloneURLTemplate, err := fasttemplate.NewTemplate(wildcardConfig.CloneURL, "<", ">")